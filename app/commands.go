@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/engine"
+	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var errUnknownCommand = errors.New("unknown command or invalid arguments")
+
+// splitDotCommand breaks a dot-command line into arguments the way the
+// sqlite3 shell does: on whitespace, with single or double quotes grouping.
+func splitDotCommand(line string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+func optionalArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+
+	return ""
+}
+
+var textEncodingNames = map[uint32]string{
+	1: " (utf8)",
+	2: " (utf16le)",
+	3: " (utf16be)",
+}
+
+// printDbInfo mirrors the layout of sqlite3's .dbinfo: every label is padded
+// to 20 characters.
+func printDbInfo(out io.Writer, db *engine.DB) error {
+	header := db.Header()
+
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%-20s %d\n", "database page size:", header.PageSizeBytes())
+	fmt.Fprintf(out, "%-20s %d\n", "write format:", header.WriteVersion)
+	fmt.Fprintf(out, "%-20s %d\n", "read format:", header.ReadVersion)
+	fmt.Fprintf(out, "%-20s %d\n", "reserved bytes:", header.ReservedSpace)
+
+	fields := []struct {
+		name  string
+		value uint32
+	}{
+		{"file change counter:", header.FileChangeCounter},
+		{"database page count:", header.DatabaseSize},
+		{"freelist page count:", header.TotalFreelistPages},
+		{"schema cookie:", header.SchemaCookie},
+		{"schema format:", header.SchemaFormatNumber},
+		{"default cache size:", header.DefaultPageCacheSize},
+		{"autovacuum top root:", header.LargestRootBTree},
+		{"incremental vacuum:", header.IncrementalVacuum},
+		{"text encoding:", header.TextEncoding},
+		{"user version:", header.UserVersion},
+		{"application id:", header.ApplicationId},
+		{"software version:", header.SQLiteVersionNumber},
+	}
+
+	for _, field := range fields {
+		fmt.Fprintf(out, "%-20s %d", field.name, field.value)
+
+		if field.name == "text encoding:" {
+			fmt.Fprint(out, textEncodingNames[field.value])
+		}
+
+		fmt.Fprintln(out)
+	}
+
+	counts := make(map[string]int)
+	schemaSize := 0
+
+	for _, pointer := range schema {
+		counts[pointer.PageType]++
+		schemaSize += utf8.RuneCountInString(pointer.CreateStatement)
+	}
+
+	fmt.Fprintf(out, "%-20s %d\n", "number of tables:", counts["table"])
+	fmt.Fprintf(out, "%-20s %d\n", "number of indexes:", counts["index"])
+	fmt.Fprintf(out, "%-20s %d\n", "number of triggers:", counts["trigger"])
+	fmt.Fprintf(out, "%-20s %d\n", "number of views:", counts["view"])
+	fmt.Fprintf(out, "%-20s %d\n", "schema size:", schemaSize)
+
+	return nil
+}
+
+// printStats reports what a statement cost after .stats on, with labels
+// padded to 36 characters as sqlite3 pads those of its own statistics.
+func printStats(out io.Writer, stats engine.Stats) {
+	fields := []struct {
+		name  string
+		value int64
+	}{
+		{"Elapsed Time (microseconds):", stats.Elapsed.Microseconds()},
+		{"Pages Read From Disk:", stats.PagesRead},
+		{"Pages Read From Cache:", stats.CacheHits},
+		{"Cells Decoded:", stats.CellsDecoded},
+		{"Overflow Pages Followed:", stats.OverflowPages},
+		{"Fullscan Steps:", stats.FullScanSteps},
+		{"Rows Scanned:", stats.RowsScanned},
+		{"Rows Returned:", stats.RowsReturned},
+		{"Index Seeks:", stats.IndexSeeks},
+	}
+
+	for _, field := range fields {
+		fmt.Fprintf(out, "%-36s %d\n", field.name, field.value)
+	}
+}
+
+// printTables lists tables and views, leaving out sqlite's internal tables.
+func printTables(out io.Writer, db *engine.DB, pattern string) error {
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for _, pointer := range schema {
+		if pointer.PageType != "table" && pointer.PageType != "view" {
+			continue
+		}
+
+		if helper.Like("sqlite_%", pointer.ObjName, 0) {
+			continue
+		}
+
+		if pattern != "" && !helper.Like(pattern, pointer.ObjName, 0) {
+			continue
+		}
+
+		names = append(names, pointer.ObjName)
+	}
+
+	printInColumns(out, names)
+
+	return nil
+}
+
+func printIndexes(out io.Writer, db *engine.DB, tablePattern string) error {
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for _, pointer := range schema {
+		if pointer.PageType != "index" {
+			continue
+		}
+
+		if tablePattern != "" && !helper.Like(tablePattern, pointer.TableName, 0) {
+			continue
+		}
+
+		names = append(names, pointer.ObjName)
+	}
+
+	printInColumns(out, names)
+
+	return nil
+}
+
+// printInColumns sorts the names and lays them out column-major in as many
+// columns as fit in 80 characters.
+func printInColumns(out io.Writer, names []string) {
+	sort.Strings(names)
+
+	width := 0
+
+	for _, name := range names {
+		width = max(width, utf8.RuneCountInString(name))
+	}
+
+	columns := max(80/(width+2), 1)
+	rows := (len(names) + columns - 1) / columns
+
+	for i := 0; i < rows; i++ {
+		var line strings.Builder
+
+		for j := i; j < len(names); j += rows {
+			if j >= rows {
+				line.WriteString("  ")
+			}
+
+			line.WriteString(names[j])
+			line.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(names[j])))
+		}
+
+		fmt.Fprintln(out, line.String())
+	}
+}
+
+const schemaTableStatement = `CREATE TABLE sqlite_schema (
+  type text,
+  name text,
+  tbl_name text,
+  rootpage integer,
+  sql text
+);`
+
+// printSchema prints the CREATE statements of every object whose table name
+// matches the pattern, which is a GLOB when it has wildcards and LIKE otherwise.
+func printSchema(out io.Writer, db *engine.DB, pattern string) error {
+	lowerPattern := strings.ToLower(pattern)
+
+	if lowerPattern == "sqlite_schema" || lowerPattern == "sqlite_master" {
+		fmt.Fprintln(out, schemaTableStatement)
+		return nil
+	}
+
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	isGlob := strings.ContainsAny(pattern, "*?[")
+
+	for _, pointer := range schema {
+		if pointer.CreateStatement == "" {
+			continue
+		}
+
+		tableName := strings.ToLower(pointer.TableName)
+
+		if pattern != "" && isGlob && !helper.Glob(pattern, tableName) {
+			continue
+		}
+
+		if pattern != "" && !isGlob && !helper.Like(pattern, tableName, '\\') {
+			continue
+		}
+
+		comment := ""
+
+		if pointer.PageType == "view" {
+			comment = viewComment(db, pointer.ObjName)
+		}
+
+		printSchemaLine(out, pointer.CreateStatement, comment)
+	}
+
+	return nil
+}
+
+// viewComment describes a view by its name and column names, which sqlite3
+// prints in a comment after the CREATE VIEW statement. It is empty when the
+// view can't be compiled.
+func viewComment(db *engine.DB, name string) string {
+	view, err := db.View(name)
+
+	if err != nil {
+		return ""
+	}
+
+	columns := make([]string, len(view.Definition.Columns))
+
+	for i, column := range view.Definition.Columns {
+		columns[i] = parser.QuoteIdentifier(column.Name)
+	}
+
+	return fmt.Sprintf("%s(%s)", parser.QuoteIdentifier(name), strings.Join(columns, ","))
+}
+
+// printFullSchema prints the user schema followed by the statistics tables
+// ANALYZE has collected, as INSERT statements that restore them.
+func printFullSchema(out io.Writer, db *engine.DB) error {
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	statTables := make(map[string]bool)
+
+	for _, pointer := range schema {
+		if pointer.PageType == "table" && helper.Glob("sqlite_stat[134]", pointer.ObjName) {
+			statTables[pointer.ObjName] = true
+		}
+
+		if pointer.CreateStatement == "" || helper.Like("sqlite_%", pointer.ObjName, 0) {
+			continue
+		}
+
+		printSchemaLine(out, pointer.CreateStatement, "")
+	}
+
+	if len(statTables) == 0 {
+		fmt.Fprintln(out, "/* No STAT tables available */")
+		return nil
+	}
+
+	fmt.Fprintln(out, "ANALYZE sqlite_schema;")
+
+	for _, statTable := range []string{"sqlite_stat1", "sqlite_stat4"} {
+		if !statTables[statTable] {
+			continue
+		}
+
+		err := db.EachRow(statTable, func(row engine.Row) error {
+			printInsert(out, statTable, row.Values)
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(out, "ANALYZE sqlite_schema;")
+
+	return nil
+}
+
+func printInsert(out io.Writer, tableName string, values []page.Value) {
+	literals := make([]string, len(values))
+
+	for i, value := range values {
+		literals[i] = sqlLiteral(value)
+	}
+
+	fmt.Fprintf(out, "INSERT INTO %s VALUES(%s);\n", tableName, strings.Join(literals, ","))
+}
+
+// printSchemaLine terminates a stored CREATE statement with a semicolon. A
+// trailing comment would swallow the semicolon, so it's closed first, and
+// tables with quoted names get IF NOT EXISTS, as sqlite3 prints them. A
+// comment, when given, goes on a line of its own before the semicolon.
+func printSchemaLine(out io.Writer, statement string, comment string) {
+	lines := strings.Split(statement, "\n")
+	lastLine := lines[len(lines)-1]
+
+	if strings.Contains(lastLine, "--") {
+		statement += "\n"
+	} else if strings.LastIndex(statement, "/*") > strings.LastIndex(statement, "*/") && comment == "" {
+		statement += "*/"
+	}
+
+	if comment != "" {
+		if !strings.HasSuffix(statement, "\n") {
+			statement += "\n"
+		}
+
+		statement += "/* " + comment + " */"
+	}
+
+	if strings.HasPrefix(statement, "CREATE TABLE '") || strings.HasPrefix(statement, `CREATE TABLE "`) {
+		statement = "CREATE TABLE IF NOT EXISTS " + statement[len("CREATE TABLE "):]
+	}
+
+	fmt.Fprintf(out, "%s;\n", statement)
+}
+
+// printDump writes a script that recreates the database, or just the tables
+// whose names match one of the patterns: tables and their rows first, with
+// sqlite_sequence last so AUTOINCREMENT counters aren't overwritten, then
+// views, triggers and indexes.
+func printDump(out io.Writer, db *engine.DB, patterns []string) error {
+	schema, err := db.Schema()
+
+	if err != nil {
+		return err
+	}
+
+	matches := func(name string) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+
+		for _, pattern := range patterns {
+			if helper.Like(pattern, name, '\\') {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var tables, others []page.RootPagePointer
+
+	for _, pointer := range schema {
+		if pointer.CreateStatement == "" || !matches(pointer.ObjName) {
+			continue
+		}
+
+		if pointer.PageType == "table" {
+			tables = append(tables, pointer)
+		} else {
+			others = append(others, pointer)
+		}
+	}
+
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].ObjName != "sqlite_sequence" && tables[j].ObjName == "sqlite_sequence"
+	})
+
+	sort.SliceStable(others, func(i, j int) bool {
+		return others[i].PageType > others[j].PageType
+	})
+
+	fmt.Fprintln(out, "PRAGMA foreign_keys=OFF;")
+	fmt.Fprintln(out, "BEGIN TRANSACTION;")
+
+	writableSchema := false
+
+	for _, table := range tables {
+		switch {
+		case table.ObjName == "sqlite_sequence":
+		case helper.Glob("sqlite_stat?", table.ObjName):
+			fmt.Fprintln(out, "ANALYZE sqlite_schema;")
+		case strings.HasPrefix(table.ObjName, "sqlite_"):
+			continue
+		case strings.HasPrefix(table.CreateStatement, "CREATE VIRTUAL TABLE"):
+			if !writableSchema {
+				fmt.Fprintln(out, "PRAGMA writable_schema=ON;")
+				writableSchema = true
+			}
+
+			fmt.Fprintf(out, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table',%s,%s,0,%s);\n",
+				quoteText(table.ObjName), quoteText(table.ObjName), quoteText(table.CreateStatement))
+
+			continue
+		default:
+			printSchemaLine(out, table.CreateStatement, "")
+		}
+
+		name := parser.QuoteIdentifier(table.ObjName)
+
+		err := db.EachRow(table.ObjName, func(row engine.Row) error {
+			printInsert(out, name, row.Values)
+			return nil
+		})
+
+		if err != nil {
+			fmt.Fprintln(out, "ROLLBACK; -- due to errors")
+			return err
+		}
+	}
+
+	for _, other := range others {
+		printSchemaLine(out, other.CreateStatement, "")
+	}
+
+	if writableSchema {
+		fmt.Fprintln(out, "PRAGMA writable_schema=OFF;")
+	}
+
+	fmt.Fprintln(out, "COMMIT;")
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"time"
+)
+
+// cpuTime reports no CPU time where the system doesn't say, so .timer only
+// has the real time to show.
+func cpuTime() (user time.Duration, system time.Duration) {
+	return 0, 0
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the user and system CPU time the process has used so far.
+func cpuTime() (user time.Duration, system time.Duration) {
+	var usage syscall.Rusage
+
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+
+	return time.Duration(usage.Utime.Nano()), time.Duration(usage.Stime.Nano())
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// applyAffinity converts a value about to be stored in a column the way
+// SQLite does: numeric columns turn text that looks like a number into one,
+// and text columns turn numbers into text.
+func applyAffinity(value page.Value, affinity parser.Affinity) page.Value {
+	switch affinity {
+	case parser.TextAffinity:
+		if value.Type == page.IntegerValue || value.Type == page.FloatValue {
+			return page.Value{Type: page.TextValue, Bytes: []byte(value.String())}
+		}
+	case parser.NumericAffinity, parser.IntegerAffinity:
+		if value.Type == page.TextValue {
+			if number, ok := parseNumber(string(value.Bytes)); ok {
+				return number
+			}
+		}
+
+		if value.Type == page.FloatValue {
+			if integer, ok := exactInteger(value.Float); ok {
+				return page.Value{Type: page.IntegerValue, Int: integer}
+			}
+		}
+	case parser.RealAffinity:
+		if value.Type == page.TextValue {
+			if number, ok := parseNumber(string(value.Bytes)); ok {
+				value = number
+			}
+		}
+
+		if value.Type == page.IntegerValue {
+			return page.Value{Type: page.FloatValue, Float: float64(value.Int)}
+		}
+	}
+
+	return value
+}
+
+// parseNumber reads text that is entirely a decimal number, allowing
+// surrounding spaces. Reals that hold an integer value come back as integers.
+func parseNumber(text string) (page.Value, bool) {
+	text = strings.Trim(text, " \t\n\r\f\v")
+
+	if !isNumericLiteral(text) {
+		return page.Value{}, false
+	}
+
+	if integer, err := strconv.ParseInt(strings.TrimPrefix(text, "+"), 10, 64); err == nil {
+		return page.Value{Type: page.IntegerValue, Int: integer}, true
+	}
+
+	real, err := strconv.ParseFloat(text, 64)
+
+	if err != nil && !math.IsInf(real, 0) {
+		return page.Value{}, false
+	}
+
+	if integer, ok := exactInteger(real); ok {
+		return page.Value{Type: page.IntegerValue, Int: integer}, true
+	}
+
+	return page.Value{Type: page.FloatValue, Float: real}, true
+}
+
+// isNumericLiteral accepts an optional sign, digits with an optional decimal
+// point, and an optional exponent. Hex, "inf" and "nan" don't count.
+func isNumericLiteral(text string) bool {
+	i := 0
+
+	if i < len(text) && (text[i] == '+' || text[i] == '-') {
+		i++
+	}
+
+	digits := 0
+
+	for ; i < len(text) && text[i] >= '0' && text[i] <= '9'; i++ {
+		digits++
+	}
+
+	if i < len(text) && text[i] == '.' {
+		i++
+
+		for ; i < len(text) && text[i] >= '0' && text[i] <= '9'; i++ {
+			digits++
+		}
+	}
+
+	if digits == 0 {
+		return false
+	}
+
+	if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+		i++
+
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+
+		exponentDigits := 0
+
+		for ; i < len(text) && text[i] >= '0' && text[i] <= '9'; i++ {
+			exponentDigits++
+		}
+
+		if exponentDigits == 0 {
+			return false
+		}
+	}
+
+	return i == len(text)
+}
+
+func exactInteger(real float64) (int64, bool) {
+	if real != math.Trunc(real) || real < -9223372036854775808.0 || real >= 9223372036854775808.0 {
+		return 0, false
+	}
+
+	return int64(real), true
+}
@@ -0,0 +1,435 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"math"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// aggregator accumulates the rows of one group for an aggregate call.
+type aggregator interface {
+	step(args []page.Value) error
+	result() (page.Value, error)
+}
+
+// inverter is an aggregator that can take back a row it stepped, which lets
+// a window function's frame slide along the rows rather than be computed
+// afresh for each.
+type inverter interface {
+	inverse(args []page.Value) error
+}
+
+// aggregateCall is an aggregate function in the select list, HAVING or
+// ORDER BY. Its arguments are evaluated on each joined row of a group.
+type aggregateCall struct {
+	name string
+	args []*expr
+	new  func() aggregator
+	// extremum is set for min() and max(), whose row supplies the values of
+	// bare columns when it is the only aggregate
+	extremum bool
+	// distinct is set when the call only steps the first of equal arguments,
+	// which compare by the argument's collation
+	distinct bool
+	equality rowEquality
+}
+
+// aggregates collects the aggregate calls of a query. Once a group is
+// complete, their results take up the slots after the joined row's.
+type aggregates struct {
+	base  int
+	calls []*aggregateCall
+}
+
+// isAggregate tells aggregate functions apart from scalar ones; min and max
+// are aggregates only with a single argument.
+func isAggregate(name string, argumentCount int) bool {
+	switch name {
+	case "count", "sum", "total", "avg", "group_concat", "string_agg":
+		return true
+	case "min", "max":
+		return argumentCount == 1
+	}
+
+	return false
+}
+
+// aggregate compiles an aggregate call into a reference to the slot its
+// result will be in.
+func (s *scope) aggregate(name string, exprs sqlparser.SelectExprs, distinct bool) (*expr, error) {
+	set := s.aggregates
+
+	if set == nil && s.aggregating {
+		return nil, fmt.Errorf("misuse of aggregate function %s()", name)
+	}
+
+	if set == nil {
+		return nil, fmt.Errorf("misuse of aggregate: %s()", name)
+	}
+
+	// arguments are evaluated on joined rows, where aggregates and windows
+	// don't exist yet
+	windows := s.windows
+	s.aggregates = nil
+	s.aggregating = true
+	s.windows = nil
+
+	defer func() {
+		s.aggregates = set
+		s.aggregating = false
+		s.windows = windows
+	}()
+
+	call, err := s.aggregateCall(name, exprs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if distinct {
+		if len(call.args) != 1 {
+			return nil, errors.New("DISTINCT aggregates must have exactly one argument")
+		}
+
+		call.distinct = true
+
+		if call.equality, err = s.db.rowEquality([]string{call.args[0].collation}); err != nil {
+			return nil, err
+		}
+	}
+
+	slot := set.base + len(set.calls)
+	set.calls = append(set.calls, call)
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			return row[slot], nil
+		},
+		column: -1,
+	}, nil
+}
+
+// aggregateCall compiles the arguments of an aggregate and checks there are
+// as many as it takes.
+func (s *scope) aggregateCall(name string, exprs sqlparser.SelectExprs) (*aggregateCall, error) {
+	var args []*expr
+	star := false
+
+	for _, selectExpr := range exprs {
+		switch selectExpr := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			if name != "count" || len(exprs) != 1 || !selectExpr.TableName.IsEmpty() {
+				return nil, wrongArgumentCount(name)
+			}
+
+			star = true
+		case *sqlparser.AliasedExpr:
+			arg, err := compileExpr(s, selectExpr.Expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, arg)
+		default:
+			return nil, wrongArgumentCount(name)
+		}
+	}
+
+	call := &aggregateCall{name: name, args: args}
+
+	switch name {
+	case "count":
+		if len(args) > 1 {
+			return nil, wrongArgumentCount(name)
+		}
+
+		call.new = func() aggregator { return &counter{star: star || len(args) == 0} }
+	case "sum", "total", "avg":
+		if len(args) != 1 {
+			return nil, wrongArgumentCount(name)
+		}
+
+		call.new = func() aggregator { return &summer{kind: name} }
+	case "min", "max":
+		compare, err := s.db.collation(functionCollation(args))
+
+		if err != nil {
+			return nil, err
+		}
+
+		call.extremum = true
+		call.new = func() aggregator { return &extremum{max: name == "max", compare: compare} }
+	case "group_concat", "string_agg":
+		if len(args) < 1 || len(args) > 2 || (name == "string_agg" && len(args) != 2) {
+			return nil, wrongArgumentCount(name)
+		}
+
+		call.new = func() aggregator { return &concatenation{} }
+	}
+
+	return call, nil
+}
+
+// counter implements count(x) and count(*).
+type counter struct {
+	star  bool
+	count int64
+}
+
+func (c *counter) step(args []page.Value) error {
+	if c.star || args[0].Type != page.NullValue {
+		c.count++
+	}
+
+	return nil
+}
+
+func (c *counter) inverse(args []page.Value) error {
+	if c.star || args[0].Type != page.NullValue {
+		c.count--
+	}
+
+	return nil
+}
+
+func (c *counter) result() (page.Value, error) {
+	return page.Value{Type: page.IntegerValue, Int: c.count}, nil
+}
+
+// summer implements sum, total and avg. Integers are added exactly until a
+// real shows up or the sum overflows; reals are added with the
+// Kahan-Babuska-Neumaier correction, as SQLite does.
+type summer struct {
+	kind        string
+	count       int64
+	integer     int64
+	sum, err    float64
+	approximate bool
+	overflow    bool
+}
+
+// summand is the value a row adds: text that is a number counts as one,
+// keeping reals written as 1.0.
+func summand(value page.Value) page.Value {
+	if value.Type == page.TextValue || value.Type == page.BlobValue {
+		if _, ok := parseNumber(string(value.Bytes)); ok {
+			return textToNumber(string(value.Bytes))
+		}
+	}
+
+	return value
+}
+
+func (s *summer) step(args []page.Value) error {
+	value := summand(args[0])
+
+	if value.Type == page.NullValue {
+		return nil
+	}
+
+	s.count++
+
+	if value.Type == page.IntegerValue {
+		if s.approximate {
+			s.addInteger(value.Int)
+		} else if sum := s.integer + value.Int; (sum > s.integer) == (value.Int > 0) {
+			s.integer = sum
+		} else {
+			s.overflow = true
+			s.approximate = true
+			s.startApproximating()
+			s.addInteger(value.Int)
+		}
+
+		return nil
+	}
+
+	if !s.approximate {
+		s.approximate = true
+		s.startApproximating()
+	}
+
+	s.add(toFloat(value))
+
+	return nil
+}
+
+// inverse subtracts a value added before. Once the sum is approximate it
+// stays so, as in SQLite.
+func (s *summer) inverse(args []page.Value) error {
+	value := summand(args[0])
+
+	if value.Type == page.NullValue {
+		return nil
+	}
+
+	s.count--
+
+	switch {
+	case value.Type != page.IntegerValue:
+		s.add(-toFloat(value))
+	case s.approximate:
+		s.add(-float64(value.Int))
+	default:
+		if difference := s.integer - value.Int; (difference < s.integer) == (value.Int > 0) {
+			s.integer = difference
+		} else {
+			s.overflow = true
+			s.approximate = true
+			s.startApproximating()
+			s.add(-float64(value.Int))
+		}
+	}
+
+	return nil
+}
+
+func (s *summer) startApproximating() {
+	s.sum, s.err = splitInteger(s.integer)
+}
+
+// splitInteger divides an integer too large for a real's precision into a
+// rounded part and a small remainder, each exact as a real.
+func splitInteger(integer int64) (float64, float64) {
+	if integer <= -4503599627370496 || integer >= 4503599627370496 {
+		small := integer % 16384
+
+		return float64(integer - small), float64(small)
+	}
+
+	return float64(integer), 0
+}
+
+func (s *summer) addInteger(integer int64) {
+	large, small := splitInteger(integer)
+
+	s.add(large)
+
+	if small != 0 {
+		s.add(small)
+	}
+}
+
+func (s *summer) add(real float64) {
+	total := s.sum + real
+
+	if math.Abs(s.sum) > math.Abs(real) {
+		s.err += (s.sum - total) + real
+	} else {
+		s.err += (real - total) + s.sum
+	}
+
+	s.sum = total
+}
+
+func (s *summer) total() float64 {
+	if !s.approximate {
+		return float64(s.integer)
+	}
+
+	if math.IsInf(s.err, 0) || math.IsNaN(s.err) {
+		return s.sum
+	}
+
+	return s.sum + s.err
+}
+
+func (s *summer) result() (page.Value, error) {
+	switch s.kind {
+	case "total":
+		return floatValue(s.total()), nil
+	case "avg":
+		if s.count == 0 {
+			return nullValue, nil
+		}
+
+		return floatValue(s.total() / float64(s.count)), nil
+	}
+
+	switch {
+	case s.count == 0:
+		return nullValue, nil
+	case s.overflow:
+		return nullValue, errIntegerOverflow
+	case s.approximate:
+		return floatValue(s.total()), nil
+	}
+
+	return page.Value{Type: page.IntegerValue, Int: s.integer}, nil
+}
+
+// extremum implements the aggregate min and max, which skip NULLs.
+type extremum struct {
+	max     bool
+	compare page.Collation
+	best    page.Value
+	// changed reports whether the last row stepped became the extremum, or
+	// was NULL with no value seen before it, which sqlite3 also counts
+	changed bool
+}
+
+func (e *extremum) step(args []page.Value) error {
+	value := args[0]
+	e.changed = false
+
+	if value.Type == page.NullValue {
+		e.changed = e.best.Type == page.NullValue
+		return nil
+	}
+
+	if e.best.Type != page.NullValue {
+		c := page.CompareValues(value, e.best, e.compare)
+
+		if (e.max && c <= 0) || (!e.max && c >= 0) {
+			return nil
+		}
+	}
+
+	e.best = value
+	e.changed = true
+
+	return nil
+}
+
+func (e *extremum) result() (page.Value, error) {
+	return e.best, nil
+}
+
+// concatenation implements group_concat and string_agg. Each value after
+// the first is preceded by the separator given on its own row.
+type concatenation struct {
+	text strings.Builder
+	any  bool
+}
+
+func (c *concatenation) step(args []page.Value) error {
+	if args[0].Type == page.NullValue {
+		return nil
+	}
+
+	if c.any {
+		if len(args) == 2 {
+			c.text.WriteString(textOf(args[1]))
+		} else {
+			c.text.WriteByte(',')
+		}
+	}
+
+	c.any = true
+	c.text.WriteString(textOf(args[0]))
+
+	return nil
+}
+
+func (c *concatenation) result() (page.Value, error) {
+	if !c.any {
+		return nullValue, nil
+	}
+
+	return textValue(c.text.String()), nil
+}
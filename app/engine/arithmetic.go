@@ -0,0 +1,381 @@
+package engine
+
+import (
+	"errors"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errIntegerOverflow = errors.New("integer overflow")
+
+// numeric converts a value used as a number. Text and blobs become the
+// number they start with, or 0 when they don't start with one.
+func numeric(value page.Value) page.Value {
+	switch value.Type {
+	case page.TextValue, page.BlobValue:
+		return textToNumber(string(value.Bytes))
+	}
+
+	return value
+}
+
+// textToNumber reads the longest prefix of text that is a number, after any
+// leading spaces. An integer that fits in 64 bits stays an integer.
+func textToNumber(text string) page.Value {
+	text = strings.TrimLeft(text, " \t\n\r\f\v")
+	end, integer := numberPrefix(text)
+
+	if end == 0 {
+		return page.Value{Type: page.IntegerValue, Int: 0}
+	}
+
+	if integer {
+		if value, err := strconv.ParseInt(text[:end], 10, 64); err == nil {
+			return page.Value{Type: page.IntegerValue, Int: value}
+		}
+	}
+
+	real, _ := strconv.ParseFloat(text[:end], 64)
+
+	return page.Value{Type: page.FloatValue, Float: real}
+}
+
+// numberPrefix measures the number text starts with: a sign, digits with an
+// optional decimal point, and an exponent. integer is set when there is
+// neither a decimal point nor an exponent.
+func numberPrefix(text string) (end int, integer bool) {
+	i := 0
+
+	if i < len(text) && (text[i] == '+' || text[i] == '-') {
+		i++
+	}
+
+	digits := 0
+
+	for ; i < len(text) && text[i] >= '0' && text[i] <= '9'; i++ {
+		digits++
+	}
+
+	integer = true
+
+	if i < len(text) && text[i] == '.' {
+		fraction := i + 1
+
+		for ; fraction < len(text) && text[fraction] >= '0' && text[fraction] <= '9'; fraction++ {
+			digits++
+		}
+
+		if digits > 0 {
+			i = fraction
+			integer = false
+		}
+	}
+
+	if digits == 0 {
+		return 0, false
+	}
+
+	if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+		exponent := i + 1
+
+		if exponent < len(text) && (text[exponent] == '+' || text[exponent] == '-') {
+			exponent++
+		}
+
+		if exponent < len(text) && text[exponent] >= '0' && text[exponent] <= '9' {
+			for exponent < len(text) && text[exponent] >= '0' && text[exponent] <= '9' {
+				exponent++
+			}
+
+			i = exponent
+			integer = false
+		}
+	}
+
+	return i, integer
+}
+
+// toInteger converts a value the way CAST(x AS INTEGER) does: reals are
+// truncated and clamped to the 64-bit range, and text is read up to the end
+// of the integer it starts with.
+func toInteger(value page.Value) int64 {
+	switch value.Type {
+	case page.IntegerValue:
+		return value.Int
+	case page.FloatValue:
+		return clampToInteger(value.Float)
+	case page.TextValue, page.BlobValue:
+		text := strings.TrimLeft(string(value.Bytes), " \t\n\r\f\v")
+		i := 0
+
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+
+		for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+			i++
+		}
+
+		integer, err := strconv.ParseInt(text[:i], 10, 64)
+
+		if err != nil && errors.Is(err, strconv.ErrRange) {
+			if strings.HasPrefix(text, "-") {
+				return math.MinInt64
+			}
+
+			return math.MaxInt64
+		}
+
+		return integer
+	}
+
+	return 0
+}
+
+func clampToInteger(real float64) int64 {
+	switch {
+	case math.IsNaN(real):
+		return 0
+	case real <= -9223372036854775808.0:
+		return math.MinInt64
+	case real >= 9223372036854775807.0:
+		return math.MaxInt64
+	}
+
+	return int64(real)
+}
+
+// toFloat converts a value the way CAST(x AS REAL) does.
+func toFloat(value page.Value) float64 {
+	switch value.Type {
+	case page.IntegerValue:
+		return float64(value.Int)
+	case page.FloatValue:
+		return value.Float
+	case page.TextValue, page.BlobValue:
+		number := textToNumber(string(value.Bytes))
+
+		if number.Type == page.IntegerValue {
+			return float64(number.Int)
+		}
+
+		return number.Float
+	}
+
+	return 0
+}
+
+// textOf renders a value as TEXT, the way concatenation and text functions
+// see it.
+func textOf(value page.Value) string {
+	switch value.Type {
+	case page.TextValue, page.BlobValue:
+		return string(value.Bytes)
+	case page.NullValue:
+		return ""
+	}
+
+	return value.String()
+}
+
+func textValue(text string) page.Value {
+	return page.Value{Type: page.TextValue, Bytes: []byte(text)}
+}
+
+func floatValue(real float64) page.Value {
+	// NaN can't be stored, and SQLite turns it into NULL
+	if math.IsNaN(real) {
+		return nullValue
+	}
+
+	return page.Value{Type: page.FloatValue, Float: real}
+}
+
+// arithmetic applies + - * / or %. Integer results that overflow become
+// reals, and dividing by zero gives NULL.
+func arithmetic(operator string, a page.Value, b page.Value) page.Value {
+	if a.Type == page.NullValue || b.Type == page.NullValue {
+		return nullValue
+	}
+
+	a, b = numeric(a), numeric(b)
+
+	if a.Type == page.IntegerValue && b.Type == page.IntegerValue {
+		x, y := a.Int, b.Int
+
+		switch operator {
+		case "+":
+			if sum := x + y; (sum > x) == (y > 0) {
+				return page.Value{Type: page.IntegerValue, Int: sum}
+			}
+		case "-":
+			if difference := x - y; (difference < x) == (y > 0) {
+				return page.Value{Type: page.IntegerValue, Int: difference}
+			}
+		case "*":
+			if product, ok := multiply(x, y); ok {
+				return page.Value{Type: page.IntegerValue, Int: product}
+			}
+		case "/":
+			if y == 0 {
+				return nullValue
+			}
+
+			if x != math.MinInt64 || y != -1 {
+				return page.Value{Type: page.IntegerValue, Int: x / y}
+			}
+		case "%":
+			if y == 0 {
+				return nullValue
+			}
+
+			if y == -1 {
+				return page.Value{Type: page.IntegerValue, Int: 0}
+			}
+
+			return page.Value{Type: page.IntegerValue, Int: x % y}
+		}
+	}
+
+	x, y := toFloat(a), toFloat(b)
+
+	switch operator {
+	case "+":
+		return floatValue(x + y)
+	case "-":
+		return floatValue(x - y)
+	case "*":
+		return floatValue(x * y)
+	case "/":
+		if y == 0 {
+			return nullValue
+		}
+
+		return floatValue(x / y)
+	}
+
+	// the remainder of reals is taken on their integer parts
+	divisor := clampToInteger(y)
+
+	if divisor == 0 {
+		return nullValue
+	}
+
+	if divisor == -1 {
+		return floatValue(0)
+	}
+
+	return floatValue(float64(clampToInteger(x) % divisor))
+}
+
+func multiply(x int64, y int64) (int64, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+
+	product := x * y
+
+	if product/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+		return 0, false
+	}
+
+	return product, true
+}
+
+// bitwise applies & | << or >> to the values taken as integers. Shifting
+// by a negative amount shifts the other way.
+func bitwise(operator string, a page.Value, b page.Value) page.Value {
+	if a.Type == page.NullValue || b.Type == page.NullValue {
+		return nullValue
+	}
+
+	x, y := toInteger(numeric(a)), toInteger(numeric(b))
+
+	switch operator {
+	case "&":
+		return page.Value{Type: page.IntegerValue, Int: x & y}
+	case "|":
+		return page.Value{Type: page.IntegerValue, Int: x | y}
+	case ">>":
+		operator = "<<"
+
+		if y == math.MinInt64 {
+			y = math.MaxInt64
+		} else {
+			y = -y
+		}
+	}
+
+	switch {
+	case y >= 64:
+		x = 0
+	case y >= 0:
+		x <<= uint(y)
+	case y <= -64:
+		if x < 0 {
+			x = -1
+		} else {
+			x = 0
+		}
+	default:
+		x >>= uint(-y)
+	}
+
+	return page.Value{Type: page.IntegerValue, Int: x}
+}
+
+// negate flips the sign of a value used as a number. Negating the smallest
+// integer overflows into a real.
+func negate(value page.Value) page.Value {
+	value = numeric(value)
+
+	switch value.Type {
+	case page.IntegerValue:
+		if value.Int == math.MinInt64 {
+			return page.Value{Type: page.FloatValue, Float: -float64(value.Int)}
+		}
+
+		return page.Value{Type: page.IntegerValue, Int: -value.Int}
+	case page.FloatValue:
+		return page.Value{Type: page.FloatValue, Float: -value.Float}
+	}
+
+	return value
+}
+
+// castValue converts a value to the affinity of a type name, as
+// CAST(x AS type) does.
+func castValue(value page.Value, typeName string) page.Value {
+	if value.Type == page.NullValue {
+		return value
+	}
+
+	switch parser.ColumnAffinity(typeName) {
+	case parser.TextAffinity:
+		return textValue(textOf(value))
+	case parser.BlobAffinity:
+		return page.Value{Type: page.BlobValue, Bytes: []byte(textOf(value))}
+	case parser.IntegerAffinity:
+		return page.Value{Type: page.IntegerValue, Int: toInteger(value)}
+	case parser.RealAffinity:
+		return page.Value{Type: page.FloatValue, Float: toFloat(value)}
+	}
+
+	if value.Type != page.TextValue && value.Type != page.BlobValue {
+		return value
+	}
+
+	// text becomes an integer when its number has an integer value
+	value = numeric(value)
+
+	if value.Type == page.FloatValue {
+		if integer, ok := exactInteger(value.Float); ok {
+			return page.Value{Type: page.IntegerValue, Int: integer}
+		}
+	}
+
+	return value
+}
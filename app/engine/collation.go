@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"strings"
+)
+
+// builtinCollations are the collating sequences every SQLite build has.
+// BINARY depends on the database's text encoding and isn't listed.
+var builtinCollations = map[string]page.Collation{
+	"NOCASE": compareNoCase,
+	"RTRIM":  compareRtrim,
+}
+
+// RegisterCollation makes a collating sequence available by name to the
+// queries and indexes of this database, in place of any built-in or earlier
+// one of the same name. compare orders two strings the way strings.Compare
+// does; nil removes the collation again. BINARY can't be replaced.
+func (db *DB) RegisterCollation(name string, compare func(a string, b string) int) error {
+	if name == "" {
+		return errors.New("a collation needs a name")
+	}
+
+	if strings.EqualFold(name, "BINARY") {
+		return errors.New("the BINARY collation can't be replaced")
+	}
+
+	db.collationsMu.Lock()
+	defer db.collationsMu.Unlock()
+
+	if compare == nil {
+		delete(db.collations, strings.ToUpper(name))
+		return nil
+	}
+
+	if db.collations == nil {
+		db.collations = make(map[string]page.Collation)
+	}
+
+	db.collations[strings.ToUpper(name)] = compare
+
+	return nil
+}
+
+// collation looks up a collating sequence by case-insensitive name. An empty
+// name means BINARY, which compares text as stored in the database.
+func (db *DB) collation(name string) (page.Collation, error) {
+	if name == "" || strings.EqualFold(name, "BINARY") {
+		return page.BinaryCollation(db.pager.TextEncoding()), nil
+	}
+
+	db.collationsMu.RLock()
+	compare, ok := db.collations[strings.ToUpper(name)]
+	db.collationsMu.RUnlock()
+
+	if ok {
+		return compare, nil
+	}
+
+	compare, ok = builtinCollations[strings.ToUpper(name)]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchCollation, name)
+	}
+
+	return compare, nil
+}
+
+// collationKey maps text to a form that is the same exactly when a built-in
+// collation finds two texts equal, which lets grouping hash them. A
+// registered collation can only compare, and has no key.
+func (db *DB) collationKey(name string) (func(text string) string, bool) {
+	upper := strings.ToUpper(name)
+
+	db.collationsMu.RLock()
+	_, registered := db.collations[upper]
+	db.collationsMu.RUnlock()
+
+	if registered {
+		return nil, false
+	}
+
+	switch upper {
+	case "NOCASE":
+		return func(text string) string { return mapASCII(text, 'A', 'Z', 'a'-'A') }, true
+	case "RTRIM":
+		return func(text string) string { return strings.TrimRight(text, " ") }, true
+	}
+
+	return nil, true
+}
+
+// compareNoCase folds only ASCII letters, as SQLite's NOCASE does.
+func compareNoCase(a string, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := foldASCII(a[i]), foldASCII(b[i])
+
+		if ca != cb {
+			return int(ca) - int(cb)
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+func foldASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
+
+// compareRtrim ignores trailing spaces.
+func compareRtrim(a string, b string) int {
+	return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+}
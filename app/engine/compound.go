@@ -0,0 +1,479 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// resultPlan is a planned SELECT or compound SELECT.
+type resultPlan interface {
+	queryPlan
+	// heading returns the names of the result columns, and expressions
+	// that give their affinities and collations
+	heading() (names []string, results []*expr)
+}
+
+func (p *selectPlan) heading() ([]string, []*expr) {
+	return p.columns, p.results
+}
+
+// compileQuery plans a SELECT or a compound SELECT of the statement planning
+// describes.
+func (db *DB) compileQuery(node sqlparser.SelectStatement, planning *planning, outer *correlation) (resultPlan, error) {
+	defer planning.enter(node)()
+
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		plan, err := db.compileSelect(node, planning, outer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return plan, nil
+	case *sqlparser.Union:
+		plan, err := db.compileCompound(node, planning, outer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return plan, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+}
+
+// compoundPlan runs a compound SELECT: the rows of left combined with those
+// of right by operator, then sorted and limited as a whole. UNION, INTERSECT
+// and EXCEPT drop duplicate rows, and give the rest in order.
+type compoundPlan struct {
+	operator string
+	left     resultPlan
+	right    *selectPlan
+	results  []*expr
+	equality rowEquality
+	orderBy  []orderTerm
+	limit    *expr
+	offset   *expr
+}
+
+func (c *compoundPlan) heading() ([]string, []*expr) {
+	names, _ := c.left.heading()
+
+	return names, c.results
+}
+
+// compoundOperator names the operator of a compound SELECT, which Translate
+// marks on the SELECT after it for INTERSECT and EXCEPT.
+func compoundOperator(node *sqlparser.Union) (string, error) {
+	switch node.Type {
+	case sqlparser.UnionAllStr:
+		return "UNION ALL", nil
+	case sqlparser.UnionStr:
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, strings.ToUpper(node.Type))
+	}
+
+	if right, ok := node.Right.(*sqlparser.Select); ok {
+		for _, comment := range right.Comments {
+			switch string(comment) {
+			case parser.IntersectComment:
+				return "INTERSECT", nil
+			case parser.ExceptComment:
+				return "EXCEPT", nil
+			}
+		}
+	}
+
+	return "UNION", nil
+}
+
+func (db *DB) compileCompound(node *sqlparser.Union, planning *planning, outer *correlation) (*compoundPlan, error) {
+	left, err := db.compileQuery(node.Left, planning, outer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rightNode, ok := node.Right.(*sqlparser.Select)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node.Right))
+	}
+
+	right, err := db.compileSelect(rightNode, planning, outer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return db.combine(node, left, right, planning, outer)
+}
+
+// combine finishes planning a compound SELECT once its sides are planned.
+// Each column compares by the collation of the left side's column, or else
+// of the right side's.
+func (db *DB) combine(node *sqlparser.Union, left resultPlan, right *selectPlan, planning *planning, outer *correlation) (*compoundPlan, error) {
+	operator, err := compoundOperator(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if leftNode, ok := node.Left.(*sqlparser.Select); ok && (len(leftNode.OrderBy) > 0 || leftNode.Limit != nil) {
+		clause := "LIMIT"
+
+		if len(leftNode.OrderBy) > 0 {
+			clause = "ORDER BY"
+		}
+
+		return nil, fmt.Errorf("%s clause should come after %s not before", clause, operator)
+	}
+
+	_, leftResults := left.heading()
+
+	if len(leftResults) != len(right.results) {
+		return nil, fmt.Errorf("SELECTs to the left and right of %s do not have the same number of result columns", operator)
+	}
+
+	plan := &compoundPlan{operator: operator, left: left, right: right}
+	collations := make([]string, len(leftResults))
+
+	for i, result := range leftResults {
+		collations[i] = result.collation
+
+		if collations[i] == "" {
+			collations[i] = right.results[i].collation
+		}
+
+		plan.results = append(plan.results, &expr{affinity: result.affinity, collation: collations[i], column: -1})
+	}
+
+	if plan.equality, err = db.rowEquality(collations); err != nil {
+		return nil, err
+	}
+
+	if plan.orderBy, err = plan.compileOrderBy(db, node.OrderBy); err != nil {
+		return nil, err
+	}
+
+	if plan.limit, plan.offset, err = compileLimit(&scope{db: db, planning: planning, outer: outer}, node.Limit); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// selects lists the SELECTs of the compound, leftmost first.
+func (c *compoundPlan) selects() []*selectPlan {
+	var selects []*selectPlan
+
+	switch left := c.left.(type) {
+	case *selectPlan:
+		selects = append(selects, left)
+	case *compoundPlan:
+		selects = left.selects()
+	}
+
+	return append(selects, c.right)
+}
+
+// compileOrderBy compiles the ORDER BY of a compound, whose terms must each
+// name a result column: by number, by the alias of a column in one of the
+// SELECTs, or by being the same expression as one.
+func (c *compoundPlan) compileOrderBy(db *DB, orderBy sqlparser.OrderBy) ([]orderTerm, error) {
+	var terms []orderTerm
+
+	for i, order := range orderBy {
+		node := order.Expr
+		collationName := ""
+
+		if collated, ok := node.(*sqlparser.CollateExpr); ok {
+			node = collated.Expr
+			collationName = collated.Charset
+		}
+
+		index, ok, err := resultReference(node, "ORDER BY", i+1, make([]string, len(c.results)))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			if index, ok = c.matchColumn(node); !ok {
+				return nil, fmt.Errorf("%s ORDER BY term does not match any column in the result set", ordinal(i+1))
+			}
+		}
+
+		if collationName == "" {
+			collationName = c.results[index].collation
+		}
+
+		compare, err := db.collation(collationName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		terms = append(terms, orderTerm{
+			result: index,
+			key:    page.SortKey{Descending: order.Direction == sqlparser.DescScr, Collation: compare},
+		})
+	}
+
+	return terms, nil
+}
+
+// matchColumn finds the result column an ORDER BY term of a compound names,
+// looking through the SELECTs from the leftmost.
+func (c *compoundPlan) matchColumn(node sqlparser.Expr) (int, bool) {
+	column, isColumn := node.(*sqlparser.ColName)
+	text := sqlparser.String(node)
+
+	for _, plan := range c.selects() {
+		for i := range plan.results {
+			switch {
+			case isColumn && column.Qualifier.IsEmpty() && strings.EqualFold(plan.aliases[i], column.Name.String()):
+			case plan.nodes[i] != nil && strings.EqualFold(sqlparser.String(plan.nodes[i]), text):
+			case plan.nodes[i] == nil && isColumn && strings.EqualFold(plan.results[i].name, column.Name.String()):
+				// a column of *
+			default:
+				continue
+			}
+
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *compoundPlan) run(db *DB, emit func(row []page.Value) error) error {
+	limit, offset, err := evalLimits(c.limit, c.offset)
+
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	reached := false
+
+	output := func(row []page.Value) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+
+		if err := emit(row); err != nil {
+			return err
+		}
+
+		if limit--; limit == 0 {
+			reached = true
+			return errLimitReached
+		}
+
+		return nil
+	}
+
+	if len(c.orderBy) == 0 {
+		err = c.combinedRows(db, output)
+	} else {
+		// the sorter's rows are the sort keys followed by the row
+		sorted := db.newSorter(sortKeys(c.orderBy))
+		defer sorted.close()
+
+		err = c.combinedRows(db, func(row []page.Value) error {
+			keys, err := orderKeys(c.orderBy, row, row)
+
+			if err != nil {
+				return err
+			}
+
+			return sorted.add(append(keys, row...))
+		})
+
+		if err == nil {
+			err = sorted.each(func(row []page.Value) error {
+				return output(row[len(c.orderBy):])
+			})
+		}
+	}
+
+	if reached && errors.Is(err, errLimitReached) {
+		return nil
+	}
+
+	return err
+}
+
+// combinedRows runs both sides and visits the rows they combine to. UNION
+// ALL passes rows on as they come; the other operators gather them first
+// and give them in order.
+func (c *compoundPlan) combinedRows(db *DB, visit func(row []page.Value) error) error {
+	if c.operator == "UNION ALL" {
+		if err := c.left.run(db, visit); err != nil {
+			return err
+		}
+
+		return c.right.run(db, visit)
+	}
+
+	left := c.equality.newSet()
+
+	if err := c.left.run(db, left.collect); err != nil {
+		return err
+	}
+
+	var right *rowSet
+
+	if c.operator == "UNION" {
+		if err := c.right.run(db, left.collect); err != nil {
+			return err
+		}
+	} else {
+		right = c.equality.newSet()
+
+		if err := c.right.run(db, right.collect); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range left.sorted() {
+		if right != nil && right.contains(row) != (c.operator == "INTERSECT") {
+			continue
+		}
+
+		if err := visit(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rowEquality is how rows compare when duplicates are dropped: by the
+// collation of each column.
+type rowEquality struct {
+	keys  []page.SortKey
+	folds []func(text string) string
+	// search is set when a registered collation leaves no way to hash, and
+	// rows are found by comparing them instead
+	search bool
+}
+
+func (db *DB) rowEquality(collations []string) (rowEquality, error) {
+	var equality rowEquality
+
+	for _, name := range collations {
+		compare, err := db.collation(name)
+
+		if err != nil {
+			return rowEquality{}, err
+		}
+
+		fold, ok := db.collationKey(name)
+		equality.keys = append(equality.keys, page.SortKey{Collation: compare})
+		equality.folds = append(equality.folds, fold)
+		equality.search = equality.search || !ok
+	}
+
+	return equality, nil
+}
+
+func (e rowEquality) newSet() *rowSet {
+	return &rowSet{equality: e, hashed: make(map[string]int)}
+}
+
+// rowSet collects distinct rows.
+type rowSet struct {
+	equality rowEquality
+	// hashed maps the key of each row to its place in rows
+	hashed map[string]int
+	// rows holds the rows in the order they came, or in order when they are
+	// searched
+	rows [][]page.Value
+}
+
+// add adds a row that isn't in the set yet, and reports whether it did.
+func (s *rowSet) add(row []page.Value) bool {
+	position, found := s.find(row)
+
+	if found {
+		return false
+	}
+
+	s.insert(position, row)
+
+	return true
+}
+
+// collect adds a row, or replaces the one it equals, as SELECTs combined by
+// UNION give the last of equal rows. It is an emit callback.
+func (s *rowSet) collect(row []page.Value) error {
+	position, found := s.find(row)
+
+	if found {
+		s.rows[position] = row
+	} else {
+		s.insert(position, row)
+	}
+
+	return nil
+}
+
+// find returns where row is in the set, or else where it goes.
+func (s *rowSet) find(row []page.Value) (int, bool) {
+	if s.equality.search {
+		return s.search(row)
+	}
+
+	position, found := s.hashed[groupKey(row, s.equality.folds)]
+
+	if !found {
+		position = len(s.rows)
+	}
+
+	return position, found
+}
+
+func (s *rowSet) insert(position int, row []page.Value) {
+	if !s.equality.search {
+		s.hashed[groupKey(row, s.equality.folds)] = position
+	}
+
+	s.rows = append(s.rows, nil)
+	copy(s.rows[position+1:], s.rows[position:])
+	s.rows[position] = row
+}
+
+func (s *rowSet) contains(row []page.Value) bool {
+	_, found := s.find(row)
+
+	return found
+}
+
+func (s *rowSet) search(row []page.Value) (int, bool) {
+	position := sort.Search(len(s.rows), func(i int) bool {
+		return page.CompareRecords(s.rows[i], row, s.equality.keys) >= 0
+	})
+
+	return position, position < len(s.rows) && page.CompareRecords(s.rows[position], row, s.equality.keys) == 0
+}
+
+// sorted returns the rows in order.
+func (s *rowSet) sorted() [][]page.Value {
+	if !s.equality.search {
+		sort.SliceStable(s.rows, func(i, j int) bool {
+			return page.CompareRecords(s.rows[i], s.rows[j], s.equality.keys) < 0
+		})
+	}
+
+	return s.rows
+}
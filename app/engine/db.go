@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"context"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DB is a handle on a single database file. It can be used from several
+// goroutines at once: queries run side by side on one page cache, while a
+// change waits for the queries of this handle to finish and keeps new ones
+// out until it is done. Each statement runs in a session of its own, a DB
+// that shares everything but the state of the running statement.
+type DB struct {
+	*database
+	// pager reads the file for this session, with its own interrupt and
+	// statistics
+	pager *page.Pager
+	// expanding holds the views being compiled, by lower-case name, to catch
+	// one that reads itself
+	expanding map[string]bool
+	// runs counts the statements run, which tells the rows a plan keeps for
+	// the length of one run from those of an earlier one
+	runs uint64
+	// rowsScanned counts the rows joins have read, and fullScanSteps the
+	// rows full scans of tables have stepped through, for Stats
+	rowsScanned   int64
+	fullScanSteps int64
+	// ctx is the context of the running statement, and base the one every
+	// read and change of the handle runs under, set by WithContext
+	ctx  context.Context
+	base context.Context
+}
+
+// database is what the sessions of a handle share.
+type database struct {
+	root *page.Pager
+	// mu is held to read for queries and alone for changes
+	mu sync.RWMutex
+	// collations holds the collating sequences registered with
+	// RegisterCollation, by upper-case name
+	collations   map[string]page.Collation
+	collationsMu sync.RWMutex
+	// sortMemory is how many bytes of rows a sort or a DISTINCT keeps in
+	// memory before it spills to temporary files
+	sortMemory atomic.Int64
+	// timeout is how long a statement may run, or 0 for no limit
+	timeout atomic.Int64
+	// threads is how many worker goroutines a scan of a whole table may
+	// split its work among, or 0 to read it on its own goroutine
+	threads atomic.Int64
+}
+
+// Open opens a database for reading and, when the file permits, writing.
+func Open(path string) (*DB, error) {
+	pager, err := page.OpenPager(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared := &database{root: pager}
+	shared.sortMemory.Store(defaultSortMemory)
+
+	return &DB{database: shared, pager: pager}, nil
+}
+
+// session returns a DB for one statement, on the same file and settings.
+func (db *DB) session() *DB {
+	return &DB{database: db.database, pager: db.root.Share(), base: db.base}
+}
+
+// WithContext returns a handle on the same database whose statements, and
+// every other read and change made through it, fail with ErrInterrupted
+// once ctx is done. QueryContext runs under the context it is given too.
+func (db *DB) WithContext(ctx context.Context) *DB {
+	return &DB{database: db.database, pager: db.root.Share(), base: ctx}
+}
+
+func (db *DB) Close() error {
+	return db.root.Close()
+}
+
+// SetBusyTimeout sets how long a lock another connection holds on the file
+// is waited for before a statement fails with page.ErrBusy, 0 being not at
+// all, which is the default. PRAGMA busy_timeout sets it too, in
+// milliseconds.
+func (db *DB) SetBusyTimeout(timeout time.Duration) {
+	db.root.SetBusyTimeout(timeout)
+}
+
+func (db *DB) BusyTimeout() time.Duration {
+	return db.root.BusyTimeout()
+}
+
+// read runs fn as a query: alongside other queries, but not alongside a
+// change made through this handle, and under a SHARED lock on the file,
+// which keeps other connections from writing it meanwhile.
+func (db *DB) read(fn func() error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.locked(fn)
+}
+
+// write runs fn as a change, once the queries running have finished. The
+// transaction it makes takes the locks it needs on the file.
+func (db *DB) write(fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.locked(fn)
+}
+
+func (db *DB) locked(fn func() error) error {
+	// outside a statement, which sets up its own, the handle's context
+	// interrupts the work
+	if db.ctx == nil && db.base != nil {
+		db.ctx = db.base
+		db.pager.SetInterrupt(db.interrupted)
+
+		defer func() {
+			db.ctx = nil
+			db.pager.SetInterrupt(nil)
+		}()
+
+		if err := db.interrupted(); err != nil {
+			return err
+		}
+	}
+
+	if err := db.pager.BeginRead(); err != nil {
+		return err
+	}
+
+	err := fn()
+
+	if endErr := db.pager.EndRead(); err == nil {
+		err = endErr
+	}
+
+	return err
+}
+
+// Header returns the database header. It is read again when another
+// connection has changed the file, and when the file can't be read for now,
+// the header read last is returned.
+func (db *DB) Header() page.DatabaseHeader {
+	s := db.session()
+	s.read(func() error { return nil })
+
+	return s.pager.Header()
+}
+
+func (db *DB) PageHeader(pageNumber int) (page.PageHeader, error) {
+	var header page.PageHeader
+
+	s := db.session()
+	err := s.read(func() (err error) {
+		header, err = page.PeakPageHeader(s.pager, pageNumber)
+		return err
+	})
+
+	return header, err
+}
+
+// Schema returns every row of sqlite_schema in storage order.
+func (db *DB) Schema() ([]page.RootPagePointer, error) {
+	var pointers []page.RootPagePointer
+
+	s := db.session()
+	err := s.read(func() (err error) {
+		pointers, err = s.schema()
+		return err
+	})
+
+	return pointers, err
+}
+
+func (db *DB) schema() ([]page.RootPagePointer, error) {
+	cells, err := page.ReadFullTree(db.pager, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []page.RootPagePointer
+
+	for _, cell := range cells {
+		pointer, err := page.UnmarshalRootPagePointer(cell.Columns)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pointers = append(pointers, pointer)
+	}
+
+	return pointers, nil
+}
+
+// Row is a decoded table row.
+type Row struct {
+	RowID  int64
+	Values []page.Value
+}
+
+// EachRow visits every row of the named table in storage order, with
+// values laid out in declared column order, as it is read. Rows of a
+// WITHOUT ROWID table come in primary key order and have a rowid of 0. An
+// error from visit ends the walk and is returned.
+func (db *DB) EachRow(tableName string, visit func(Row) error) error {
+	s := db.session()
+
+	return s.read(func() error {
+		table, err := s.table(tableName)
+
+		if err != nil {
+			return err
+		}
+
+		return s.walkRows(table, func(rowID page.Value, values []page.Value) error {
+			return visit(Row{RowID: rowID.Int, Values: values})
+		})
+	})
+}
+
+// walkRows visits every row of a table in storage order, with its values in
+// declared column order. The rows of a WITHOUT ROWID table have no rowid and
+// are given NULL.
+func (db *DB) walkRows(table *Table, visit func(rowID page.Value, values []page.Value) error) error {
+	if table.primaryKey != nil {
+		return page.ScanIndex(db.pager, table.RootPage, nil, table.primaryKey.Order, func(record []page.Value) error {
+			db.fullScanSteps++
+
+			return visit(nullValue, table.rowValues(0, record))
+		})
+	}
+
+	return page.WalkTable(db.pager, table.RootPage, func(cell page.Cell) error {
+		db.fullScanSteps++
+
+		values, err := cell.Values()
+
+		if err != nil {
+			return err
+		}
+
+		rowID := int64(cell.CellIdx)
+
+		return visit(page.Value{Type: page.IntegerValue, Int: rowID}, table.rowValues(rowID, values))
+	})
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"errors"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+)
+
+// spillPageSize is the page size of the temporary files sets spill to.
+const spillPageSize = 4096
+
+// distinctSet tells whether a row is the first of those equal to it, for
+// SELECT DISTINCT and aggregates over DISTINCT arguments. Rows are kept in
+// a rowSet until they take up the connection's sort memory, then in an
+// index b-tree of a temporary file, which close deletes.
+type distinctSet struct {
+	equality rowEquality
+	limit    int
+	memory   *rowSet
+	size     int
+	// spill holds the rows once they no longer fit in memory
+	spill *page.Pager
+	root  int
+}
+
+func (db *DB) newDistinctSet(equality rowEquality) *distinctSet {
+	return &distinctSet{equality: equality, limit: db.SortMemory(), memory: equality.newSet()}
+}
+
+// add adds a row that isn't in the set yet, and reports whether it did.
+func (s *distinctSet) add(row []page.Value) (bool, error) {
+	if s.spill != nil {
+		return s.insert(row)
+	}
+
+	if !s.memory.add(row) {
+		return false, nil
+	}
+
+	if s.size += rowSize(row); s.size > s.limit {
+		if err := s.spillRows(); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// spillRows moves the rows held in memory to a temporary file.
+func (s *distinctSet) spillRows() error {
+	pager, err := page.CreateTemp(spillPageSize)
+
+	if err != nil {
+		return err
+	}
+
+	s.spill = pager
+
+	if err := pager.Begin(); err != nil {
+		return err
+	}
+
+	if s.root, err = page.CreateBTree(pager, true); err != nil {
+		return err
+	}
+
+	for _, row := range s.memory.rows {
+		if _, err := s.insert(row); err != nil {
+			return err
+		}
+	}
+
+	s.memory = nil
+
+	return nil
+}
+
+// insert adds a row to the spilled rows unless an equal one is there.
+func (s *distinctSet) insert(row []page.Value) (bool, error) {
+	err := page.InsertIndexEntry(s.spill, s.root, page.EncodeRecord(row, false), s.equality.keys)
+
+	if errors.Is(err, page.ErrDuplicateKey) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// close deletes the temporary file, if there is one.
+func (s *distinctSet) close() error {
+	if s.spill == nil {
+		return nil
+	}
+
+	return s.spill.Close()
+}
+
+// collationsOf lists the collations of results.
+func collationsOf(results []*expr) []string {
+	collations := make([]string, len(results))
+
+	for i, result := range results {
+		collations[i] = result.collation
+	}
+
+	return collations
+}
+
+// rowSize estimates the memory a row takes up.
+func rowSize(row []page.Value) int {
+	size := 64
+
+	for _, value := range row {
+		size += 40 + len(value.Bytes)
+	}
+
+	return size
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoSuchTable  = errors.New("no such table")
+	ErrNoSuchColumn = errors.New("no such column")
+	ErrParse        = errors.New("syntax error")
+	ErrUnsupported  = errors.New("not supported")
+	ErrExists       = errors.New("already exists")
+	ErrConstraint   = errors.New("constraint failed")
+	ErrMismatch     = errors.New("datatype mismatch")
+	ErrRange        = errors.New("column index out of range")
+	// ErrInterrupted stops a statement whose context is done, and wraps the
+	// context's error as well
+	ErrInterrupted = errors.New("interrupted")
+
+	ErrNoSuchCollation = errors.New("no such collation sequence")
+)
+
+// ConstraintError reports a row rejected by a constraint, with the message
+// SQLite gives for it.
+type ConstraintError struct {
+	Message string
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Message
+}
+
+func (e *ConstraintError) Is(target error) bool {
+	return target == ErrConstraint
+}
+
+func constraintFailed(kind string, table string, columns ...string) error {
+	qualified := make([]string, len(columns))
+
+	for i, column := range columns {
+		qualified[i] = table + "." + column
+	}
+
+	return &ConstraintError{Message: fmt.Sprintf("%s constraint failed: %s", kind, strings.Join(qualified, ", "))}
+}
+
+func alreadyExists(kind string, name string) error {
+	return fmt.Errorf("%s %s %w", kind, name, ErrExists)
+}
+
+// ParseError reports where in the SQL text the parser gave up.
+type ParseError struct {
+	Position int
+	Near     string
+}
+
+func (e *ParseError) Error() string {
+	if e.Near == "" {
+		return fmt.Sprintf("incomplete input at position %d: %v", e.Position, ErrParse)
+	}
+
+	return fmt.Sprintf("near %q: %v at position %d", e.Near, ErrParse, e.Position)
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrParse
+}
+
+var parserErrorPattern = regexp.MustCompile(`at position (\d+)(?: near '(.*)')?`)
+
+// newParseError converts sqlparser's error text, which is the only place the
+// position is exposed, into a ParseError.
+func newParseError(err error) error {
+	matches := parserErrorPattern.FindStringSubmatch(err.Error())
+
+	if matches == nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	position, _ := strconv.Atoi(matches[1])
+
+	return &ParseError{
+		Position: position,
+		Near:     matches[2],
+	}
+}
+
+func noSuchTable(name string) error {
+	return fmt.Errorf("%w: %s", ErrNoSuchTable, name)
+}
+
+func noSuchColumn(name string) error {
+	return fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+}
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strings"
+)
+
+// explainColumns are the columns of the result of EXPLAIN QUERY PLAN, as in
+// SQLite. Each row is a step of the plan, whose parent is the step it is
+// part of, or 0.
+var explainColumns = []string{"id", "parent", "notused", "detail"}
+
+// explainedQuery recognizes EXPLAIN QUERY PLAN and returns the statement it
+// explains, with the prefix blanked out so that positions in it are still
+// positions in sql.
+func explainedQuery(sql string) (string, bool) {
+	tokens, err := parser.Tokenize(sql)
+
+	if err != nil || len(tokens) < 3 || !tokens[0].Is("EXPLAIN") || !tokens[1].Is("QUERY") || !tokens[2].Is("PLAN") {
+		return sql, false
+	}
+
+	end := tokens[2].End()
+
+	return strings.Repeat(" ", end) + sql[end:], true
+}
+
+// explainer describes a plan in the words sqlite3 uses: how each source of a
+// join is read, in the order of the loops, and the temporary b-trees, views
+// and subqueries the query needs.
+type explainer struct {
+	rows [][]page.Value
+	// subqueries numbers the subqueries of expressions as they are listed
+	subqueries int
+}
+
+func (e *explainer) add(parent int, detail string) int {
+	id := len(e.rows) + 1
+	e.rows = append(e.rows, []page.Value{
+		{Type: page.IntegerValue, Int: int64(id)},
+		{Type: page.IntegerValue, Int: int64(parent)},
+		{Type: page.IntegerValue, Int: 0},
+		textValue(detail),
+	})
+
+	return id
+}
+
+func (e *explainer) query(plan queryPlan, parent int) {
+	switch plan := plan.(type) {
+	case *selectPlan:
+		e.selectPlan(plan, parent)
+	case *compoundPlan:
+		e.compound(plan, parent)
+	case *recursivePlan:
+		e.query(plan.initial, e.add(parent, "SETUP"))
+		e.query(plan.step, e.add(parent, "RECURSIVE STEP"))
+	}
+}
+
+func (e *explainer) selectPlan(p *selectPlan, parent int) {
+	for _, call := range p.calls {
+		if call.distinct {
+			e.add(parent, fmt.Sprintf("USE TEMP B-TREE FOR %s(DISTINCT)", call.name))
+		}
+	}
+
+	if len(p.join.scope.sources) == 0 {
+		e.add(parent, "SCAN CONSTANT ROW")
+	}
+
+	for _, source := range p.join.scope.sources {
+		e.source(source, parent)
+	}
+
+	for _, sub := range p.join.scope.subqueries {
+		e.subqueries++
+		detail := fmt.Sprintf("SCALAR SUBQUERY %d", e.subqueries)
+
+		// only IN reads every row
+		if sub.limit == 0 {
+			detail = fmt.Sprintf("LIST SUBQUERY %d", e.subqueries)
+		}
+
+		if sub.outer.correlated {
+			detail = "CORRELATED " + detail
+		}
+
+		e.query(sub.plan, e.add(parent, detail))
+	}
+
+	if len(p.groupBy) > 0 {
+		e.add(parent, "USE TEMP B-TREE FOR GROUP BY")
+	}
+
+	if p.distinct {
+		e.add(parent, "USE TEMP B-TREE FOR DISTINCT")
+	}
+
+	if len(p.orderBy) > 0 {
+		e.add(parent, "USE TEMP B-TREE FOR ORDER BY")
+	}
+}
+
+// source describes how scanSource reads a source. A view is run as the
+// first source is read, and run ahead and kept for the others, except for
+// the reference of a recursive query to itself.
+func (e *explainer) source(source *source, parent int) {
+	table := source.table
+	detail := "SCAN " + source.name
+
+	switch {
+	case table.view != nil:
+		if _, ok := table.view.(*currentRow); !ok {
+			how := "MATERIALIZE "
+
+			if source.offset == 0 {
+				how = "CO-ROUTINE "
+			}
+
+			e.query(table.view, e.add(parent, how+source.name))
+		}
+	case source.rowid != nil:
+		detail = "SEARCH " + source.name + " USING INTEGER PRIMARY KEY (rowid=?)"
+	case source.index != nil:
+		terms := make([]string, len(source.key))
+
+		for i := range source.key {
+			terms[i] = table.Definition.Columns[source.index.Columns[i]].Name + "=?"
+		}
+
+		using := "INDEX " + source.index.Name
+
+		if source.index == table.primaryKey {
+			using = "PRIMARY KEY"
+		}
+
+		detail = fmt.Sprintf("SEARCH %s USING %s (%s)", source.name, using, strings.Join(terms, " AND "))
+	}
+
+	if source.left {
+		detail += " LEFT-JOIN"
+	}
+
+	e.add(parent, detail)
+}
+
+// compound describes a compound SELECT as its SELECTs, leftmost first, each
+// under the operator that combines it with the ones before.
+func (e *explainer) compound(c *compoundPlan, parent int) {
+	id := e.add(parent, "COMPOUND QUERY")
+	e.compoundParts(c, id)
+
+	if len(c.orderBy) > 0 {
+		e.add(parent, "USE TEMP B-TREE FOR ORDER BY")
+	}
+}
+
+func (e *explainer) compoundParts(c *compoundPlan, parent int) {
+	if left, ok := c.left.(*compoundPlan); ok {
+		e.compoundParts(left, parent)
+	} else {
+		e.query(c.left, e.add(parent, "LEFT-MOST SUBQUERY"))
+	}
+
+	detail := c.operator + " USING TEMP B-TREE"
+
+	if c.operator == "UNION ALL" {
+		detail = c.operator
+	}
+
+	e.query(c.right, e.add(parent, detail))
+}
+
+// explainPlan returns the plan of the statement as EXPLAIN QUERY PLAN does.
+func (s *Stmt) explainPlan() *Result {
+	e := &explainer{}
+	e.query(s.plan, 0)
+
+	return &Result{Columns: explainColumns, Rows: e.rows}
+}
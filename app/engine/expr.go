@@ -0,0 +1,727 @@
+package engine
+
+import (
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// evaluator computes an expression for the row currently being looked at,
+// which holds the values of every source of the query side by side.
+type evaluator func(row []page.Value) (page.Value, error)
+
+// expr is a compiled expression, along with what comparisons need to know
+// about its operands.
+type expr struct {
+	eval     evaluator
+	affinity parser.Affinity // BlobAffinity when the expression has none
+	// collation names the collating sequence the expression carries: the
+	// column's own, or one given with COLLATE, which is then explicit
+	collation         string
+	explicitCollation bool
+	column            int    // slot of a plain column reference, or -1
+	name              string // the declared name of a plain column reference
+	sources           uint64 // one bit for each source the expression reads
+	// compared keeps the operands of a comparison, which lookups search by
+	compared *comparedOperands
+}
+
+type comparedOperands struct {
+	operator    string
+	left, right *expr
+}
+
+var (
+	nullValue  = page.Value{Type: page.NullValue}
+	falseValue = page.Value{Type: page.IntegerValue, Int: 0}
+	trueValue  = page.Value{Type: page.IntegerValue, Int: 1}
+)
+
+func booleanValue(b bool) page.Value {
+	if b {
+		return trueValue
+	}
+
+	return falseValue
+}
+
+func constant(value page.Value) *expr {
+	return &expr{
+		eval: func([]page.Value) (page.Value, error) {
+			return value, nil
+		},
+		column: -1,
+	}
+}
+
+// compileExpr turns a parsed expression into an evaluator, resolving column
+// references against scope.
+func compileExpr(scope *scope, node sqlparser.Expr) (*expr, error) {
+	switch node := node.(type) {
+	case *sqlparser.SQLVal:
+		if node.Type == sqlparser.ValArg {
+			return scope.parameter(node)
+		}
+
+		value, err := literal(node)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return constant(value), nil
+	case *sqlparser.NullVal:
+		return constant(nullValue), nil
+	case sqlparser.BoolVal:
+		return constant(booleanValue(bool(node))), nil
+	case *sqlparser.ColName:
+		return scope.resolve(node)
+	case *sqlparser.ParenExpr:
+		return compileExpr(scope, node.Expr)
+	case *sqlparser.CollateExpr:
+		inner, err := compileExpr(scope, node.Expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := scope.db.collation(node.Charset); err != nil {
+			return nil, err
+		}
+
+		collated := *inner
+		collated.collation = node.Charset
+		collated.explicitCollation = true
+
+		return &collated, nil
+	case *sqlparser.AndExpr:
+		return compileLogical(scope, node.Left, node.Right, true)
+	case *sqlparser.OrExpr:
+		return compileLogical(scope, node.Left, node.Right, false)
+	case *sqlparser.NotExpr:
+		operand, err := compileExpr(scope, node.Expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return derived(func(row []page.Value) (page.Value, error) {
+			value, err := operand.eval(row)
+
+			if err != nil || value.Type == page.NullValue {
+				return value, err
+			}
+
+			return booleanValue(!truth(value)), nil
+		}, operand), nil
+	case *sqlparser.IsExpr:
+		return compileIs(scope, node)
+	case *sqlparser.ComparisonExpr:
+		return compileComparison(scope, node)
+	case *sqlparser.UnaryExpr:
+		return compileUnary(scope, node)
+	case *sqlparser.BinaryExpr:
+		return compileBinary(scope, node)
+	case *sqlparser.RangeCond:
+		between := sqlparser.Expr(&sqlparser.AndExpr{
+			Left:  &sqlparser.ComparisonExpr{Operator: sqlparser.GreaterEqualStr, Left: node.Left, Right: node.From},
+			Right: &sqlparser.ComparisonExpr{Operator: sqlparser.LessEqualStr, Left: node.Left, Right: node.To},
+		})
+
+		if node.Operator == sqlparser.NotBetweenStr {
+			between = &sqlparser.NotExpr{Expr: between}
+		}
+
+		return compileExpr(scope, between)
+	case *sqlparser.CaseExpr:
+		return compileCase(scope, node)
+	case *sqlparser.FuncExpr:
+		return compileFunction(scope, node)
+	case *sqlparser.Subquery:
+		return compileScalarSubquery(scope, node)
+	case *sqlparser.ExistsExpr:
+		return compileExists(scope, node)
+	case *sqlparser.GroupConcatExpr:
+		if len(node.OrderBy) > 0 || node.Separator != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+		}
+
+		return compileFunction(scope, &sqlparser.FuncExpr{
+			Name:     sqlparser.NewColIdent("group_concat"),
+			Distinct: node.Distinct != "",
+			Exprs:    node.Exprs,
+		})
+	}
+
+	return nil, fmt.Errorf("%w: expression %s", ErrUnsupported, sqlparser.String(node))
+}
+
+// derived builds an expression computed from operands, which has no affinity
+// or collation of its own.
+func derived(eval evaluator, operands ...*expr) *expr {
+	result := &expr{eval: eval, column: -1}
+
+	for _, operand := range operands {
+		result.sources |= operand.sources
+	}
+
+	return result
+}
+
+// literal converts a literal as sqlparser read it. Integers too large for
+// 64 bits become reals, as they do in SQLite.
+func literal(node *sqlparser.SQLVal) (page.Value, error) {
+	text := string(node.Val)
+
+	switch node.Type {
+	case sqlparser.StrVal:
+		return page.Value{Type: page.TextValue, Bytes: node.Val}, nil
+	case sqlparser.IntVal:
+		if integer, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return page.Value{Type: page.IntegerValue, Int: integer}, nil
+		}
+
+		real, _ := strconv.ParseFloat(text, 64)
+
+		return page.Value{Type: page.FloatValue, Float: real}, nil
+	case sqlparser.FloatVal:
+		real, err := strconv.ParseFloat(text, 64)
+
+		if err != nil && !math.IsInf(real, 0) {
+			return page.Value{}, fmt.Errorf("%w: malformed number %s", ErrParse, text)
+		}
+
+		return page.Value{Type: page.FloatValue, Float: real}, nil
+	case sqlparser.HexNum:
+		integer, err := strconv.ParseUint(text[2:], 16, 64)
+
+		if err != nil {
+			return page.Value{}, fmt.Errorf("hex literal too big: %s", text)
+		}
+
+		return page.Value{Type: page.IntegerValue, Int: int64(integer)}, nil
+	case sqlparser.HexVal:
+		blob := make([]byte, len(node.Val)/2)
+
+		for i := range blob {
+			b, err := strconv.ParseUint(text[2*i:2*i+2], 16, 8)
+
+			if err != nil {
+				return page.Value{}, fmt.Errorf("%w: malformed blob literal", ErrParse)
+			}
+
+			blob[i] = byte(b)
+		}
+
+		return page.Value{Type: page.BlobValue, Bytes: blob}, nil
+	}
+
+	return page.Value{}, fmt.Errorf("%w: literal %s", ErrUnsupported, sqlparser.String(node))
+}
+
+// truth decides whether a non-NULL value counts as true: numbers when they
+// aren't zero, and text by the number it starts with.
+func truth(value page.Value) bool {
+	if value.Type == page.IntegerValue {
+		return value.Int != 0
+	}
+
+	return toFloat(value) != 0
+}
+
+// compileLogical builds AND or OR with SQL's three-valued logic.
+func compileLogical(scope *scope, leftNode sqlparser.Expr, rightNode sqlparser.Expr, and bool) (*expr, error) {
+	left, err := compileExpr(scope, leftNode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := compileExpr(scope, rightNode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// AND stops at the first false operand, OR at the first true one
+	decisive := !and
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		a, err := left.eval(row)
+
+		if err != nil {
+			return a, err
+		}
+
+		if a.Type != page.NullValue && truth(a) == decisive {
+			return booleanValue(decisive), nil
+		}
+
+		b, err := right.eval(row)
+
+		if err != nil {
+			return b, err
+		}
+
+		if b.Type != page.NullValue && truth(b) == decisive {
+			return booleanValue(decisive), nil
+		}
+
+		if a.Type == page.NullValue || b.Type == page.NullValue {
+			return nullValue, nil
+		}
+
+		return booleanValue(!decisive), nil
+	}, left, right), nil
+}
+
+func compileIs(scope *scope, node *sqlparser.IsExpr) (*expr, error) {
+	operand, err := compileExpr(scope, node.Expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var test func(page.Value) bool
+
+	switch node.Operator {
+	case sqlparser.IsNullStr:
+		test = func(v page.Value) bool { return v.Type == page.NullValue }
+	case sqlparser.IsNotNullStr:
+		test = func(v page.Value) bool { return v.Type != page.NullValue }
+	case sqlparser.IsTrueStr:
+		test = func(v page.Value) bool { return v.Type != page.NullValue && truth(v) }
+	case sqlparser.IsNotTrueStr:
+		test = func(v page.Value) bool { return v.Type == page.NullValue || !truth(v) }
+	case sqlparser.IsFalseStr:
+		test = func(v page.Value) bool { return v.Type != page.NullValue && !truth(v) }
+	case sqlparser.IsNotFalseStr:
+		test = func(v page.Value) bool { return v.Type == page.NullValue || truth(v) }
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, node.Operator)
+	}
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		value, err := operand.eval(row)
+
+		if err != nil {
+			return value, err
+		}
+
+		return booleanValue(test(value)), nil
+	}, operand), nil
+}
+
+func compileUnary(scope *scope, node *sqlparser.UnaryExpr) (*expr, error) {
+	// fold a minus sign into a numeric literal, so -9223372036854775808
+	// stays an integer
+	if literalNode, ok := node.Expr.(*sqlparser.SQLVal); ok && node.Operator == sqlparser.UMinusStr && literalNode.Type == sqlparser.IntVal {
+		if integer, err := strconv.ParseInt("-"+string(literalNode.Val), 10, 64); err == nil {
+			return constant(page.Value{Type: page.IntegerValue, Int: integer}), nil
+		}
+	}
+
+	operand, err := compileExpr(scope, node.Expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Operator {
+	case sqlparser.UPlusStr:
+		return operand, nil
+	case sqlparser.UMinusStr:
+		return derived(func(row []page.Value) (page.Value, error) {
+			value, err := operand.eval(row)
+
+			if err != nil {
+				return value, err
+			}
+
+			return negate(value), nil
+		}, operand), nil
+	case sqlparser.TildaStr:
+		return derived(func(row []page.Value) (page.Value, error) {
+			value, err := operand.eval(row)
+
+			if err != nil || value.Type == page.NullValue {
+				return value, err
+			}
+
+			return page.Value{Type: page.IntegerValue, Int: ^toInteger(numeric(value))}, nil
+		}, operand), nil
+	}
+
+	return nil, fmt.Errorf("%w: operator %s", ErrUnsupported, strings.TrimSpace(node.Operator))
+}
+
+// comparison is how two operands are compared: the affinity each side is
+// converted by first, and the collating sequence for text.
+type comparison struct {
+	leftAffinity  parser.Affinity
+	rightAffinity parser.Affinity
+	collation     string
+}
+
+func isNumericAffinity(affinity parser.Affinity) bool {
+	return affinity == parser.NumericAffinity || affinity == parser.IntegerAffinity || affinity == parser.RealAffinity
+}
+
+// comparisonOf applies SQLite's rules: a side with numeric affinity makes the
+// other side numeric, a TEXT side makes a side without affinity text, and the
+// collation comes from an explicit COLLATE, then from a column on the left,
+// then on the right.
+func comparisonOf(left *expr, right *expr) comparison {
+	var c comparison
+
+	switch {
+	case isNumericAffinity(left.affinity) && !isNumericAffinity(right.affinity):
+		c.rightAffinity = parser.NumericAffinity
+	case isNumericAffinity(right.affinity) && !isNumericAffinity(left.affinity):
+		c.leftAffinity = parser.NumericAffinity
+	case left.affinity == parser.TextAffinity && right.affinity == parser.BlobAffinity:
+		c.rightAffinity = parser.TextAffinity
+	case right.affinity == parser.TextAffinity && left.affinity == parser.BlobAffinity:
+		c.leftAffinity = parser.TextAffinity
+	}
+
+	switch {
+	case left.explicitCollation:
+		c.collation = left.collation
+	case right.explicitCollation:
+		c.collation = right.collation
+	case left.collation != "":
+		c.collation = left.collation
+	default:
+		c.collation = right.collation
+	}
+
+	return c
+}
+
+// convertForComparison applies a comparison affinity. Unlike storing into a
+// column, numeric affinity here keeps integers and reals as they are.
+func convertForComparison(value page.Value, affinity parser.Affinity) page.Value {
+	switch affinity {
+	case parser.BlobAffinity:
+		return value
+	case parser.TextAffinity:
+		return applyAffinity(value, affinity)
+	}
+
+	if value.Type == page.TextValue {
+		if number, ok := parseNumber(string(value.Bytes)); ok {
+			return number
+		}
+	}
+
+	return value
+}
+
+// compileBinary builds the arithmetic, bitwise and concatenation operators.
+// Translate turned || into ^, which SQLite doesn't otherwise have.
+func compileBinary(scope *scope, node *sqlparser.BinaryExpr) (*expr, error) {
+	var apply func(a page.Value, b page.Value) page.Value
+
+	switch node.Operator {
+	case sqlparser.PlusStr, sqlparser.MinusStr, sqlparser.MultStr, sqlparser.DivStr, sqlparser.ModStr:
+		apply = func(a page.Value, b page.Value) page.Value { return arithmetic(node.Operator, a, b) }
+	case sqlparser.BitAndStr, sqlparser.BitOrStr, sqlparser.ShiftLeftStr, sqlparser.ShiftRightStr:
+		apply = func(a page.Value, b page.Value) page.Value { return bitwise(node.Operator, a, b) }
+	case sqlparser.BitXorStr:
+		apply = func(a page.Value, b page.Value) page.Value {
+			if a.Type == page.NullValue || b.Type == page.NullValue {
+				return nullValue
+			}
+
+			return textValue(textOf(a) + textOf(b))
+		}
+	default:
+		return nil, fmt.Errorf("%w: operator %s", ErrUnsupported, node.Operator)
+	}
+
+	left, err := compileExpr(scope, node.Left)
+
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := compileExpr(scope, node.Right)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		a, err := left.eval(row)
+
+		if err != nil {
+			return a, err
+		}
+
+		b, err := right.eval(row)
+
+		if err != nil {
+			return b, err
+		}
+
+		return apply(a, b), nil
+	}, left, right), nil
+}
+
+// compileCase builds both forms of CASE. With an operand, each WHEN value
+// is compared with it as = would.
+func compileCase(scope *scope, node *sqlparser.CaseExpr) (*expr, error) {
+	var operands []*expr
+	conditions := make([]*expr, len(node.Whens))
+	results := make([]*expr, len(node.Whens))
+
+	for i, when := range node.Whens {
+		condition := when.Cond
+
+		if node.Expr != nil {
+			condition = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: node.Expr, Right: when.Cond}
+		}
+
+		compiled, err := compileExpr(scope, condition)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := compileExpr(scope, when.Val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conditions[i], results[i] = compiled, result
+		operands = append(operands, compiled, result)
+	}
+
+	otherwise := constant(nullValue)
+
+	if node.Else != nil {
+		compiled, err := compileExpr(scope, node.Else)
+
+		if err != nil {
+			return nil, err
+		}
+
+		otherwise = compiled
+		operands = append(operands, compiled)
+	}
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		for i, condition := range conditions {
+			value, err := condition.eval(row)
+
+			if err != nil {
+				return value, err
+			}
+
+			if value.Type != page.NullValue && truth(value) {
+				return results[i].eval(row)
+			}
+		}
+
+		return otherwise.eval(row)
+	}, operands...), nil
+}
+
+func compileComparison(scope *scope, node *sqlparser.ComparisonExpr) (*expr, error) {
+	switch node.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		return compileIn(scope, node)
+	case sqlparser.LikeStr, sqlparser.NotLikeStr, sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+		return compileMatch(scope, node)
+	}
+
+	var test func(int) bool
+
+	switch node.Operator {
+	case sqlparser.EqualStr:
+		test = func(c int) bool { return c == 0 }
+	case sqlparser.NotEqualStr:
+		test = func(c int) bool { return c != 0 }
+	case sqlparser.LessThanStr:
+		test = func(c int) bool { return c < 0 }
+	case sqlparser.LessEqualStr:
+		test = func(c int) bool { return c <= 0 }
+	case sqlparser.GreaterThanStr:
+		test = func(c int) bool { return c > 0 }
+	case sqlparser.GreaterEqualStr:
+		test = func(c int) bool { return c >= 0 }
+	case sqlparser.NullSafeEqualStr:
+		// x IS y, which Translate wrote as x <=> y
+		test = func(c int) bool { return c == 0 }
+	default:
+		return nil, fmt.Errorf("%w: operator %s", ErrUnsupported, node.Operator)
+	}
+
+	left, err := compileExpr(scope, node.Left)
+
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := compileExpr(scope, node.Right)
+
+	if err != nil {
+		return nil, err
+	}
+
+	how := comparisonOf(left, right)
+
+	compare, err := scope.db.collation(how.collation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := derived(func(row []page.Value) (page.Value, error) {
+		a, err := left.eval(row)
+
+		if err != nil {
+			return a, err
+		}
+
+		b, err := right.eval(row)
+
+		if err != nil {
+			return b, err
+		}
+
+		if a.Type == page.NullValue || b.Type == page.NullValue {
+			if node.Operator == sqlparser.NullSafeEqualStr {
+				return booleanValue(a.Type == b.Type), nil
+			}
+
+			return nullValue, nil
+		}
+
+		a = convertForComparison(a, how.leftAffinity)
+		b = convertForComparison(b, how.rightAffinity)
+
+		return booleanValue(test(page.CompareValues(a, b, compare))), nil
+	}, left, right)
+
+	result.compared = &comparedOperands{operator: node.Operator, left: left, right: right}
+
+	return result, nil
+}
+
+// compileIn builds x IN (list): true when x equals an element, otherwise NULL
+// when x or an element is NULL, otherwise false. Each element is compared as
+// x = element would be.
+func compileIn(scope *scope, node *sqlparser.ComparisonExpr) (*expr, error) {
+	if subquery, ok := node.Right.(*sqlparser.Subquery); ok {
+		return compileInSubquery(scope, node, subquery)
+	}
+
+	list, ok := node.Right.(sqlparser.ValTuple)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+	}
+
+	left, err := compileExpr(scope, node.Left)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type element struct {
+		value   *expr
+		how     comparison
+		compare page.Collation
+	}
+
+	elements := make([]element, len(list))
+	operands := []*expr{left}
+
+	for i, item := range list {
+		value, err := compileExpr(scope, item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		how := comparisonOf(left, value)
+		compare, err := scope.db.collation(how.collation)
+
+		if err != nil {
+			return nil, err
+		}
+
+		elements[i] = element{value: value, how: how, compare: compare}
+		operands = append(operands, value)
+	}
+
+	negated := node.Operator == sqlparser.NotInStr
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		a, err := left.eval(row)
+
+		if err != nil || a.Type == page.NullValue {
+			return nullValue, err
+		}
+
+		sawNull := false
+
+		for _, element := range elements {
+			b, err := element.value.eval(row)
+
+			if err != nil {
+				return b, err
+			}
+
+			if b.Type == page.NullValue {
+				sawNull = true
+				continue
+			}
+
+			converted := convertForComparison(a, element.how.leftAffinity)
+			b = convertForComparison(b, element.how.rightAffinity)
+
+			if page.CompareValues(converted, b, element.compare) == 0 {
+				return booleanValue(!negated), nil
+			}
+		}
+
+		if sawNull {
+			return nullValue, nil
+		}
+
+		return booleanValue(negated), nil
+	}, operands...), nil
+}
+
+// compileMatch builds LIKE and GLOB, which Translate wrote as REGEXP, as
+// calls of like() and glob() with the pattern first.
+func compileMatch(scope *scope, node *sqlparser.ComparisonExpr) (*expr, error) {
+	name := "like"
+
+	if node.Operator == sqlparser.RegexpStr || node.Operator == sqlparser.NotRegexpStr {
+		name = "glob"
+	}
+
+	args := sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: node.Right}, &sqlparser.AliasedExpr{Expr: node.Left}}
+
+	if node.Escape != nil {
+		args = append(args, &sqlparser.AliasedExpr{Expr: node.Escape})
+	}
+
+	var match sqlparser.Expr = &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(name), Exprs: args}
+
+	if node.Operator == sqlparser.NotLikeStr || node.Operator == sqlparser.NotRegexpStr {
+		match = &sqlparser.NotExpr{Expr: match}
+	}
+
+	return compileExpr(scope, match)
+}
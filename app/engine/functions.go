@@ -0,0 +1,607 @@
+package engine
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// scalarFunction is a built-in function that computes a value from its
+// evaluated arguments.
+type scalarFunction struct {
+	minArgs int
+	maxArgs int // -1 for no limit
+	call    func(args []page.Value) (page.Value, error)
+}
+
+var scalarFunctions = map[string]scalarFunction{
+	"abs":       {1, 1, strict(abs)},
+	"char":      {0, -1, char},
+	"format":    {1, -1, printf},
+	"glob":      {2, 2, strict(glob)},
+	"instr":     {2, 2, strict(instr)},
+	"length":    {1, 1, strict(length)},
+	"like":      {2, 3, like},
+	"lower":     {1, 1, strict(lower)},
+	"ltrim":     {1, 2, strict(trimmer(strings.TrimLeft))},
+	"printf":    {1, -1, printf},
+	"quote":     {1, 1, quote},
+	"replace":   {3, 3, strict(replace)},
+	"round":     {1, 2, strict(round)},
+	"rtrim":     {1, 2, strict(trimmer(strings.TrimRight))},
+	"substr":    {2, 3, strict(substr)},
+	"substring": {2, 3, strict(substr)},
+	"trim":      {1, 2, strict(trimmer(strings.Trim))},
+	"typeof":    {1, 1, typeOf},
+	"unicode":   {1, 1, strict(unicodeFunction)},
+	"upper":     {1, 1, strict(upper)},
+	"zeroblob":  {1, 1, zeroblob},
+}
+
+// strict wraps a function whose result is NULL when any argument is.
+func strict(call func(args []page.Value) (page.Value, error)) func(args []page.Value) (page.Value, error) {
+	return func(args []page.Value) (page.Value, error) {
+		for _, arg := range args {
+			if arg.Type == page.NullValue {
+				return nullValue, nil
+			}
+		}
+
+		return call(args)
+	}
+}
+
+func wrongArgumentCount(name string) error {
+	return fmt.Errorf("wrong number of arguments to function %s()", name)
+}
+
+// compileFunction compiles a function call. Aggregates and window
+// functions are handed to the scope; the functions that must not evaluate
+// every argument, or that depend on the database, are built here; the rest
+// come from scalarFunctions.
+func compileFunction(scope *scope, node *sqlparser.FuncExpr) (*expr, error) {
+	if node.Name.Lowered() == parser.WindowFunction {
+		return scope.window(node)
+	}
+
+	name := strings.TrimPrefix(node.Name.Lowered(), parser.FunctionPrefix)
+
+	if !node.Qualifier.IsEmpty() {
+		return nil, fmt.Errorf("%w: function %s", ErrUnsupported, sqlparser.String(node))
+	}
+
+	if isAggregate(name, len(node.Exprs)) {
+		return scope.aggregate(name, node.Exprs, node.Distinct)
+	}
+
+	if _, ok := windowFunctions[name]; ok {
+		return nil, fmt.Errorf("misuse of window function %s()", name)
+	}
+
+	if node.Distinct {
+		return nil, fmt.Errorf("DISTINCT aggregates must have exactly one argument")
+	}
+
+	args := make([]*expr, len(node.Exprs))
+
+	for i, selectExpr := range node.Exprs {
+		aliased, ok := selectExpr.(*sqlparser.AliasedExpr)
+
+		if !ok {
+			return nil, wrongArgumentCount(name)
+		}
+
+		arg, err := compileExpr(scope, aliased.Expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = arg
+	}
+
+	switch name {
+	case "coalesce", "ifnull":
+		if len(args) < 2 || (name == "ifnull" && len(args) != 2) {
+			return nil, wrongArgumentCount(name)
+		}
+
+		return derived(func(row []page.Value) (page.Value, error) {
+			for _, arg := range args {
+				value, err := arg.eval(row)
+
+				if err != nil || value.Type != page.NullValue {
+					return value, err
+				}
+			}
+
+			return nullValue, nil
+		}, args...), nil
+	case "iif":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, wrongArgumentCount(name)
+		}
+
+		return derived(func(row []page.Value) (page.Value, error) {
+			condition, err := args[0].eval(row)
+
+			if err != nil {
+				return condition, err
+			}
+
+			if condition.Type != page.NullValue && truth(condition) {
+				return args[1].eval(row)
+			}
+
+			if len(args) == 3 {
+				return args[2].eval(row)
+			}
+
+			return nullValue, nil
+		}, args...), nil
+	case "cast":
+		typeName, ok := node.Exprs[len(node.Exprs)-1].(*sqlparser.AliasedExpr).Expr.(*sqlparser.SQLVal)
+
+		if len(args) != 2 || !ok || typeName.Type != sqlparser.StrVal {
+			return nil, fmt.Errorf("%w: malformed CAST", ErrParse)
+		}
+
+		result := derived(func(row []page.Value) (page.Value, error) {
+			value, err := args[0].eval(row)
+
+			if err != nil {
+				return value, err
+			}
+
+			return castValue(value, string(typeName.Val)), nil
+		}, args[0])
+
+		result.affinity = parser.ColumnAffinity(string(typeName.Val))
+
+		return result, nil
+	case "hex":
+		if len(args) != 1 {
+			return nil, wrongArgumentCount(name)
+		}
+
+		encoding := scope.db.pager.TextEncoding()
+
+		return derived(func(row []page.Value) (page.Value, error) {
+			value, err := args[0].eval(row)
+
+			if err != nil {
+				return value, err
+			}
+
+			return hexFunction(encoding, value), nil
+		}, args...), nil
+	case "nullif", "min", "max":
+		if (name == "nullif" && len(args) != 2) || len(args) < 2 {
+			return nil, wrongArgumentCount(name)
+		}
+
+		compare, err := scope.db.collation(functionCollation(args))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return derived(func(row []page.Value) (page.Value, error) {
+			values, err := evalAll(args, row)
+
+			if err != nil {
+				return nullValue, err
+			}
+
+			if name == "nullif" {
+				if values[1].Type != page.NullValue && page.CompareValues(values[0], values[1], compare) == 0 {
+					return nullValue, nil
+				}
+
+				return values[0], nil
+			}
+
+			best := values[0]
+
+			for _, value := range values {
+				if value.Type == page.NullValue {
+					return nullValue, nil
+				}
+
+				c := page.CompareValues(value, best, compare)
+
+				if (name == "min" && c < 0) || (name == "max" && c > 0) {
+					best = value
+				}
+			}
+
+			return best, nil
+		}, args...), nil
+	}
+
+	function, ok := scalarFunctions[name]
+
+	if !ok {
+		return nil, fmt.Errorf("no such function: %s", name)
+	}
+
+	if len(args) < function.minArgs || (function.maxArgs != -1 && len(args) > function.maxArgs) {
+		return nil, wrongArgumentCount(name)
+	}
+
+	return derived(func(row []page.Value) (page.Value, error) {
+		values, err := evalAll(args, row)
+
+		if err != nil {
+			return nullValue, err
+		}
+
+		return function.call(values)
+	}, args...), nil
+}
+
+func evalAll(exprs []*expr, row []page.Value) ([]page.Value, error) {
+	values := make([]page.Value, len(exprs))
+
+	for i, e := range exprs {
+		value, err := e.eval(row)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// functionCollation is the collating sequence a function compares its
+// arguments with: that of the first argument that carries one.
+func functionCollation(args []*expr) string {
+	for _, arg := range args {
+		if arg.explicitCollation {
+			return arg.collation
+		}
+	}
+
+	for _, arg := range args {
+		if arg.collation != "" {
+			return arg.collation
+		}
+	}
+
+	return ""
+}
+
+// isText reports whether a function should treat a value as characters
+// rather than bytes.
+func isText(value page.Value) bool {
+	return value.Type != page.BlobValue
+}
+
+func length(args []page.Value) (page.Value, error) {
+	if !isText(args[0]) {
+		return page.Value{Type: page.IntegerValue, Int: int64(len(args[0].Bytes))}, nil
+	}
+
+	text := textOf(args[0])
+
+	// the length of text stops at its first NUL character
+	if end := strings.IndexByte(text, 0); end != -1 {
+		text = text[:end]
+	}
+
+	return page.Value{Type: page.IntegerValue, Int: int64(utf8.RuneCountInString(text))}, nil
+}
+
+// lower and upper only fold ASCII letters, like SQLite without ICU.
+func lower(args []page.Value) (page.Value, error) {
+	return textValue(mapASCII(textOf(args[0]), 'A', 'Z', 'a'-'A')), nil
+}
+
+func upper(args []page.Value) (page.Value, error) {
+	return textValue(mapASCII(textOf(args[0]), 'a', 'z', 'A'-'a')), nil
+}
+
+func mapASCII(text string, from byte, to byte, shift int) string {
+	converted := []byte(text)
+
+	for i, c := range converted {
+		if c >= from && c <= to {
+			converted[i] = byte(int(c) + shift)
+		}
+	}
+
+	return string(converted)
+}
+
+// substr follows SQLite: positions count from 1, a negative start counts
+// from the end, and a negative length takes characters before the start.
+// Blobs are cut by bytes and everything else by characters.
+func substr(args []page.Value) (page.Value, error) {
+	start := toInteger(numeric(args[1]))
+	count := int64(math.MaxInt32)
+	before := false
+
+	if len(args) == 3 {
+		count = toInteger(numeric(args[2]))
+
+		if count < 0 {
+			count = -count
+			before = true
+		}
+	}
+
+	var units []string
+	blob := !isText(args[0])
+
+	if blob {
+		units = make([]string, len(args[0].Bytes))
+
+		for i, b := range args[0].Bytes {
+			units[i] = string(b)
+		}
+	} else {
+		units = strings.Split(textOf(args[0]), "")
+	}
+
+	size := int64(len(units))
+
+	switch {
+	case start < 0:
+		start += size
+
+		if start < 0 {
+			count += start
+			start = 0
+
+			if count < 0 {
+				count = 0
+			}
+		}
+	case start > 0:
+		start--
+	case count > 0:
+		count--
+	}
+
+	if before {
+		start -= count
+
+		if start < 0 {
+			count += start
+			start = 0
+		}
+	}
+
+	start = min(start, size)
+	count = min(count, size-start)
+
+	result := []byte(strings.Join(units[start:start+count], ""))
+
+	if blob {
+		return page.Value{Type: page.BlobValue, Bytes: result}, nil
+	}
+
+	return page.Value{Type: page.TextValue, Bytes: result}, nil
+}
+
+// trimmer builds trim, ltrim and rtrim, which remove spaces or any of the
+// characters given as the second argument.
+func trimmer(trim func(string, string) string) func(args []page.Value) (page.Value, error) {
+	return func(args []page.Value) (page.Value, error) {
+		characters := " "
+
+		if len(args) == 2 {
+			characters = textOf(args[1])
+		}
+
+		return textValue(trim(textOf(args[0]), characters)), nil
+	}
+}
+
+func replace(args []page.Value) (page.Value, error) {
+	text, search := textOf(args[0]), textOf(args[1])
+
+	if search == "" {
+		return textValue(text), nil
+	}
+
+	return textValue(strings.ReplaceAll(text, search, textOf(args[2]))), nil
+}
+
+// instr finds the first occurrence of the second argument in the first, by
+// character position, or by byte position when both are blobs.
+func instr(args []page.Value) (page.Value, error) {
+	haystack, needle := textOf(args[0]), textOf(args[1])
+	index := strings.Index(haystack, needle)
+
+	if index == -1 {
+		return falseValue, nil
+	}
+
+	if args[0].Type != page.BlobValue || args[1].Type != page.BlobValue {
+		index = utf8.RuneCountInString(haystack[:index])
+	}
+
+	return page.Value{Type: page.IntegerValue, Int: int64(index) + 1}, nil
+}
+
+// abs keeps integers integers. Anything else becomes a real, with text
+// that isn't a number as 0.0.
+func abs(args []page.Value) (page.Value, error) {
+	value := args[0]
+
+	if value.Type == page.IntegerValue {
+		if value.Int == math.MinInt64 {
+			return nullValue, errIntegerOverflow
+		}
+
+		if value.Int < 0 {
+			value.Int = -value.Int
+		}
+
+		return value, nil
+	}
+
+	return floatValue(math.Abs(toFloat(value))), nil
+}
+
+// round rounds half away from zero to n decimal places, on the exact value
+// of the real as SQLite does, so round(2.675, 2) is 2.67. The result is
+// always REAL.
+func round(args []page.Value) (page.Value, error) {
+	real := toFloat(args[0])
+	places := int64(0)
+
+	if len(args) == 2 {
+		places = min(max(toInteger(numeric(args[1])), 0), 30)
+	}
+
+	switch {
+	case real < -4503599627370496.0 || real > 4503599627370496.0:
+		// too large to have a fractional part
+	case places == 0:
+		if real < 0 {
+			real = float64(int64(real - 0.5))
+		} else {
+			real = float64(int64(real + 0.5))
+		}
+	default:
+		text := formatFloat(real, 'f', printfSpec{exact: true, precision: int(places)})
+		real, _ = strconv.ParseFloat(text, 64)
+	}
+
+	return floatValue(real), nil
+}
+
+// hexFunction implements hex(), which encodes the bytes of a value: text as
+// the database stores it, so in UTF-16 in a UTF-16 database, and numbers as
+// their UTF-8 text.
+func hexFunction(encoding uint32, value page.Value) page.Value {
+	content := []byte(textOf(value))
+
+	if value.Type == page.TextValue {
+		content = page.EncodeText(encoding, value.Bytes)
+	}
+
+	return textValue(strings.ToUpper(hex.EncodeToString(content)))
+}
+
+func typeOf(args []page.Value) (page.Value, error) {
+	names := map[page.ValueType]string{
+		page.NullValue:    "null",
+		page.IntegerValue: "integer",
+		page.FloatValue:   "real",
+		page.TextValue:    "text",
+		page.BlobValue:    "blob",
+	}
+
+	return textValue(names[args[0].Type]), nil
+}
+
+// quote renders a value as an SQL literal.
+func quote(args []page.Value) (page.Value, error) {
+	return textValue(quoteValue(args[0])), nil
+}
+
+func quoteValue(value page.Value) string {
+	switch value.Type {
+	case page.NullValue:
+		return "NULL"
+	case page.IntegerValue:
+		return strconv.FormatInt(value.Int, 10)
+	case page.FloatValue:
+		// 15 significant digits, unless it takes more to read back the same
+		// real
+		text := formatFloat(value.Float, 'g', printfSpec{exact: true, precision: 15})
+
+		if parsed, err := strconv.ParseFloat(text, 64); err == nil && parsed != value.Float {
+			text = formatFloat(value.Float, 'e', printfSpec{exact: true, precision: 20})
+		}
+
+		return text
+	case page.BlobValue:
+		return "X'" + strings.ToUpper(hex.EncodeToString(value.Bytes)) + "'"
+	}
+
+	return "'" + strings.ReplaceAll(string(value.Bytes), "'", "''") + "'"
+}
+
+// char builds text from code points. NULL arguments count as 0.
+func char(args []page.Value) (page.Value, error) {
+	var text strings.Builder
+
+	for _, arg := range args {
+		code := toInteger(numeric(arg))
+
+		if code < 0 || code > 0x10ffff {
+			code = 0xfffd
+		}
+
+		text.WriteRune(rune(code))
+	}
+
+	return textValue(text.String()), nil
+}
+
+func unicodeFunction(args []page.Value) (page.Value, error) {
+	text := textOf(args[0])
+
+	if text == "" {
+		return nullValue, nil
+	}
+
+	character, _ := utf8.DecodeRuneInString(text)
+
+	return page.Value{Type: page.IntegerValue, Int: int64(character)}, nil
+}
+
+func zeroblob(args []page.Value) (page.Value, error) {
+	size := max(toInteger(numeric(args[0])), 0)
+
+	if size > 1_000_000_000 {
+		return nullValue, fmt.Errorf("string or blob too big")
+	}
+
+	return page.Value{Type: page.BlobValue, Bytes: make([]byte, size)}, nil
+}
+
+// like implements like(pattern, value, escape), which is what the LIKE
+// operator calls with its operands swapped.
+func like(args []page.Value) (page.Value, error) {
+	for _, arg := range args {
+		if arg.Type == page.NullValue {
+			return nullValue, nil
+		}
+	}
+
+	var escape rune
+
+	if len(args) == 3 {
+		text := textOf(args[2])
+
+		if utf8.RuneCountInString(text) != 1 {
+			return nullValue, fmt.Errorf("ESCAPE expression must be a single character")
+		}
+
+		escape, _ = utf8.DecodeRuneInString(text)
+	}
+
+	return booleanValue(helper.Like(textOf(args[0]), textOf(args[1]), escape)), nil
+}
+
+// glob implements glob(pattern, value), which is what the GLOB operator
+// calls with its operands swapped.
+func glob(args []page.Value) (page.Value, error) {
+	return booleanValue(helper.Glob(textOf(args[0]), textOf(args[1]))), nil
+}
@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+)
+
+// Index is an index from sqlite_schema resolved against its table.
+type Index struct {
+	Name     string
+	RootPage int
+	Unique   bool
+	Columns  []int // positions of the indexed columns in the table
+	// Collations names the collating sequence of each indexed column
+	Collations []string
+	// Order has a key for every indexed column and then for what ends each
+	// entry: the rowid, or the primary key columns of a WITHOUT ROWID table
+	// that aren't already indexed.
+	Order []page.SortKey
+	// primaryKey gives, for an index on a WITHOUT ROWID table, where each
+	// primary key column is in an entry
+	primaryKey []int
+}
+
+const autoindexPrefix = "sqlite_autoindex_"
+
+// Indexes lists the indexes on a table, including the automatic ones behind
+// PRIMARY KEY and UNIQUE constraints.
+func (db *DB) Indexes(table *Table) ([]*Index, error) {
+	var indexes []*Index
+
+	s := db.session()
+	err := s.read(func() (err error) {
+		indexes, err = s.indexes(table)
+		return err
+	})
+
+	return indexes, err
+}
+
+func (db *DB) indexes(table *Table) ([]*Index, error) {
+	schema, err := db.schema()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []*Index
+
+	for _, pointer := range schema {
+		if pointer.PageType != "index" || !strings.EqualFold(pointer.TableName, table.Name) {
+			continue
+		}
+
+		index, err := db.loadIndex(table, pointer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// searchableIndexes lists the indexes a query can look rows up through,
+// leaving out the kinds that can't be resolved yet.
+func (db *DB) searchableIndexes(table *Table) ([]*Index, error) {
+	schema, err := db.schema()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []*Index
+
+	// a WITHOUT ROWID table is searched by its primary key first
+	if table.primaryKey != nil {
+		indexes = append(indexes, table.primaryKey)
+	}
+
+	for _, pointer := range schema {
+		if pointer.PageType != "index" || !strings.EqualFold(pointer.TableName, table.Name) {
+			continue
+		}
+
+		index, err := db.loadIndex(table, pointer)
+
+		// an index in a collation that isn't registered can't be searched
+		if errors.Is(err, ErrUnsupported) || errors.Is(err, ErrNoSuchCollation) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+func (db *DB) loadIndex(table *Table, pointer page.RootPagePointer) (*Index, error) {
+	if pointer.CreateStatement == "" {
+		columns, err := autoindexColumns(table, pointer.ObjName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return db.resolveIndex(table, pointer, columns, true)
+	}
+
+	definition, err := parser.ParseCreateIndex(pointer.CreateStatement)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if definition.Where != "" {
+		return nil, fmt.Errorf("%w: partial index %s", ErrUnsupported, pointer.ObjName)
+	}
+
+	return db.resolveIndex(table, pointer, definition.Columns, definition.Unique)
+}
+
+// autoindexColumns finds the constraint behind sqlite_autoindex_TABLE_N,
+// which is the Nth PRIMARY KEY or UNIQUE constraint that needed an index.
+func autoindexColumns(table *Table, name string) ([]parser.IndexedColumn, error) {
+	number, err := strconv.Atoi(name[strings.LastIndex(name, "_")+1:])
+
+	if err != nil || !strings.HasPrefix(name, autoindexPrefix) {
+		return nil, fmt.Errorf("%w: index %s has no definition", page.ErrCorruptPage, name)
+	}
+
+	keys := autoindexKeys(table.Definition)
+
+	if number < 1 || number > len(keys) {
+		return nil, fmt.Errorf("%w: no constraint matches index %s", page.ErrCorruptPage, name)
+	}
+
+	return keys[number-1], nil
+}
+
+// autoindexKeys lists the constraints that get an automatic index: all of
+// them except a rowid alias, and except repeats of an earlier one.
+func autoindexKeys(definition *parser.CreateTable) [][]parser.IndexedColumn {
+	var keys [][]parser.IndexedColumn
+
+	rowidColumn := definition.RowidColumn()
+
+	for _, key := range definition.Keys {
+		if len(key) == 1 && rowidColumn != -1 && definition.ColumnIndex(key[0].Name) == rowidColumn {
+			continue
+		}
+
+		if definition.WithoutRowid && sameKey(key, definition.PrimaryKeyColumns()) {
+			continue
+		}
+
+		duplicate := false
+
+		for _, earlier := range keys {
+			duplicate = duplicate || sameKey(key, earlier)
+		}
+
+		if !duplicate {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func sameKey(a []parser.IndexedColumn, b []parser.IndexedColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !strings.EqualFold(a[i].Name, b[i].Name) || !strings.EqualFold(a[i].Collate, b[i].Collate) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (db *DB) resolveIndex(table *Table, pointer page.RootPagePointer, columns []parser.IndexedColumn, unique bool) (*Index, error) {
+	index := &Index{
+		Name:     pointer.ObjName,
+		RootPage: int(pointer.PageNumber),
+		Unique:   unique,
+	}
+
+	for _, column := range columns {
+		if column.Name == "" {
+			return nil, fmt.Errorf("%w: index %s is on an expression", ErrUnsupported, index.Name)
+		}
+
+		position := table.Definition.ColumnIndex(column.Name)
+
+		if position == -1 {
+			return nil, noSuchColumn(column.Name)
+		}
+
+		collationName := column.Collate
+
+		if collationName == "" {
+			collationName = table.Definition.Columns[position].Collate
+		}
+
+		compare, err := db.collation(collationName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		index.Columns = append(index.Columns, position)
+		index.Collations = append(index.Collations, collationName)
+		index.Order = append(index.Order, page.SortKey{Descending: column.Descending, Collation: compare})
+	}
+
+	switch {
+	case !table.Definition.WithoutRowid:
+		index.Order = append(index.Order, page.SortKey{})
+	case table.primaryKey == nil:
+		// this is the primary key the table's own b-tree is ordered by
+		for i := range index.Columns {
+			index.primaryKey = append(index.primaryKey, i)
+		}
+	default:
+		addPrimaryKey(index, table.primaryKey)
+	}
+
+	return index, nil
+}
+
+// addPrimaryKey finds the primary key columns in the entries of an index on a
+// WITHOUT ROWID table, which end with those that aren't indexed already.
+func addPrimaryKey(index *Index, primaryKey *Index) {
+	indexed := len(index.Columns)
+
+	for i, position := range primaryKey.Columns {
+		at := -1
+
+		for k := 0; k < indexed; k++ {
+			if index.Columns[k] == position && sameCollation(index.Collations[k], primaryKey.Collations[i]) {
+				at = k
+				break
+			}
+		}
+
+		if at == -1 {
+			at = len(index.Order)
+			index.Order = append(index.Order, primaryKey.Order[i])
+		}
+
+		index.primaryKey = append(index.primaryKey, at)
+	}
+}
+
+// primaryKeyIndex describes the b-tree of a WITHOUT ROWID table, which is
+// keyed by its primary key. A column repeated in the key only counts once.
+func (db *DB) primaryKeyIndex(table *Table) (*Index, error) {
+	var columns []parser.IndexedColumn
+
+	for _, column := range table.Definition.PrimaryKeyColumns() {
+		duplicate := false
+
+		for _, earlier := range columns {
+			duplicate = duplicate || strings.EqualFold(earlier.Name, column.Name)
+		}
+
+		if !duplicate {
+			columns = append(columns, column)
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%w: WITHOUT ROWID table %s has no PRIMARY KEY", page.ErrCorruptPage, table.Name)
+	}
+
+	pointer := page.RootPagePointer{ObjName: table.Name, TableName: table.Name, PageNumber: int64(table.RootPage)}
+
+	return db.resolveIndex(table, pointer, columns, true)
+}
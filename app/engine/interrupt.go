@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetQueryTimeout sets how long a statement may run before it is
+// interrupted, 0 being no limit, which is the default. PRAGMA query_timeout
+// sets it too, in milliseconds.
+func (db *DB) SetQueryTimeout(timeout time.Duration) {
+	db.timeout.Store(int64(max(timeout, 0)))
+}
+
+func (db *DB) QueryTimeout() time.Duration {
+	return time.Duration(db.timeout.Load())
+}
+
+// interruptible runs a statement under ctx, and the handle's own context,
+// limited to the query timeout. The statement fails with ErrInterrupted once
+// either is done, which is noticed before each page is read and each row is
+// scanned.
+func (db *DB) interruptible(ctx context.Context, run func() (*Result, error)) (*Result, error) {
+	if db.base != nil && db.base != ctx {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer context.AfterFunc(db.base, cancel)()
+		defer cancel()
+	}
+
+	if timeout := db.QueryTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	db.ctx = ctx
+	db.pager.SetInterrupt(db.interrupted)
+
+	defer func() {
+		db.ctx = nil
+		db.pager.SetInterrupt(nil)
+	}()
+
+	if err := db.interrupted(); err != nil {
+		return nil, err
+	}
+
+	return run()
+}
+
+// interrupted returns ErrInterrupted once the context of the running
+// statement is done.
+func (db *DB) interrupted() error {
+	if db.ctx == nil {
+		return nil
+	}
+
+	if err := db.ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInterrupted, err)
+	}
+
+	return nil
+}
+
+// canceled returns ErrInterrupted once the context of a handle made by
+// WithContext is done.
+func (db *DB) canceled() error {
+	if db.base == nil {
+		return nil
+	}
+
+	if err := db.base.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInterrupted, err)
+	}
+
+	return nil
+}
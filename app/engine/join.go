@@ -0,0 +1,814 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math/bits"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// maxJoinSources is how many tables one FROM clause may join, the same limit
+// SQLite has. Expressions track the sources they read in a 64-bit mask.
+const maxJoinSources = 64
+
+// source is one table of a FROM clause. Its values take up a run of slots
+// in the joined row: one per declared column, then the rowid.
+type source struct {
+	name   string // the alias, or the table name
+	table  *Table
+	offset int
+	// left is set for the right side of a LEFT JOIN, which gets a row of
+	// NULLs when nothing matches its constraints
+	left bool
+	// hidden marks columns that were joined with USING, which unqualified
+	// names resolve to on the left side instead
+	hidden map[int]bool
+	// on holds the constraints of a LEFT JOIN, which decide whether a row
+	// matches, and filters the terms that drop joined rows once this source
+	// has its row
+	on      []*expr
+	filters []*expr
+	// access is how rows are found: a rowid lookup, an index search, or a
+	// scan of the whole table when both are nil
+	rowid *seekKey
+	index *Index
+	key   []*seekKey
+	// rows holds the rows of a view once it has been run in the statement
+	// run numbered run, unless it is a correlated subquery
+	rows       [][]page.Value
+	run        uint64
+	correlated bool
+	// split is set when the source is read by a scan of a whole table that
+	// worker goroutines may share
+	split *splitScan
+}
+
+func (s *source) width() int {
+	return len(s.table.Definition.Columns) + 1
+}
+
+func (s *source) rowidSlot() int {
+	return s.offset + len(s.table.Definition.Columns)
+}
+
+// seekKey computes the value a lookup searches for, converted the way the
+// comparison it comes from would convert it.
+type seekKey struct {
+	value    *expr
+	affinity parser.Affinity
+}
+
+func (k *seekKey) eval(row []page.Value) (page.Value, error) {
+	value, err := k.value.eval(row)
+
+	if err != nil {
+		return value, err
+	}
+
+	return convertForComparison(value, k.affinity), nil
+}
+
+// scope resolves column names against the sources of a query.
+type scope struct {
+	db       *DB
+	planning *planning
+	sources  []*source
+	// outer is where names go that no source has, in a subquery
+	outer *correlation
+	// aggregates collects aggregate calls where they are allowed, and is nil
+	// elsewhere; aggregating is set within an aggregate's arguments
+	aggregates  *aggregates
+	aggregating bool
+	// windows collects window function calls where they are allowed, which
+	// is the select list and ORDER BY, and is nil elsewhere
+	windows *windows
+	// subqueries are those of the expressions compiled in the scope, which
+	// EXPLAIN QUERY PLAN lists
+	subqueries []*subquery
+	// aliases are the select list's names, which WHERE, GROUP BY, HAVING and
+	// ORDER BY fall back on for names that aren't columns. resolving guards
+	// against an alias that refers to itself.
+	aliases   map[string]sqlparser.Expr
+	resolving map[string]bool
+}
+
+// resolve finds the column a name refers to. An unqualified name must
+// belong to exactly one source.
+func (s *scope) resolve(node *sqlparser.ColName) (*expr, error) {
+	name := node.Name.String()
+	qualifier := node.Qualifier.Name.String()
+
+	var found *expr
+
+	for i, source := range s.sources {
+		if qualifier != "" && !strings.EqualFold(source.name, qualifier) {
+			continue
+		}
+
+		position := source.table.Definition.ColumnIndex(name)
+
+		if position != -1 && qualifier == "" && source.hidden[position] {
+			continue
+		}
+
+		// views and WITHOUT ROWID tables have no rowid to refer to
+		if position == -1 && (!isRowidName(name) || !source.table.hasRowid()) {
+			continue
+		}
+
+		if found != nil {
+			return nil, fmt.Errorf("ambiguous column name: %s", name)
+		}
+
+		switch {
+		case position != -1:
+			found = columnExpr(i, source, position)
+		case source.table.RowidColumn != -1:
+			found = columnExpr(i, source, source.table.RowidColumn)
+		default:
+			found = rowidExpr(i, source)
+		}
+	}
+
+	if found == nil && qualifier == "" {
+		key := strings.ToLower(name)
+
+		if aliased, ok := s.aliases[key]; ok && !s.resolving[key] {
+			if s.resolving == nil {
+				s.resolving = make(map[string]bool)
+			}
+
+			s.resolving[key] = true
+			defer delete(s.resolving, key)
+
+			return compileExpr(s, aliased)
+		}
+	}
+
+	if found == nil && s.outer != nil {
+		return s.outer.resolve(node)
+	}
+
+	if found == nil {
+		if qualifier != "" {
+			return nil, noSuchColumn(qualifier + "." + name)
+		}
+
+		return nil, noSuchColumn(name)
+	}
+
+	return found, nil
+}
+
+func columnExpr(sourceNumber int, source *source, position int) *expr {
+	column := source.table.Definition.Columns[position]
+	slot := source.offset + position
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			return row[slot], nil
+		},
+		affinity:  parser.ColumnAffinity(column.Type),
+		collation: column.Collate,
+		column:    slot,
+		name:      column.Name,
+		sources:   1 << sourceNumber,
+	}
+}
+
+// isRowidName reports whether a name refers to the rowid of a table that
+// has no column by that name.
+func isRowidName(name string) bool {
+	return strings.EqualFold(name, "rowid") || strings.EqualFold(name, "oid") || strings.EqualFold(name, "_rowid_")
+}
+
+// rowidExpr refers to the rowid of a table without an INTEGER PRIMARY KEY
+// column to alias it.
+func rowidExpr(sourceNumber int, source *source) *expr {
+	slot := source.rowidSlot()
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			return row[slot], nil
+		},
+		affinity: parser.IntegerAffinity,
+		column:   slot,
+		name:     "rowid",
+		sources:  1 << sourceNumber,
+	}
+}
+
+// join is a FROM clause planned as nested loops, one per source in the
+// order they were written.
+type join struct {
+	scope *scope
+	width int
+	// constant holds the terms that read no source, which are checked once
+	constant []*expr
+}
+
+// fromItem is a table of a FROM clause with how it joins the ones before it.
+type fromItem struct {
+	table     *sqlparser.AliasedTableExpr
+	kind      string
+	condition sqlparser.JoinCondition
+}
+
+// flattenFrom lists the tables of a FROM clause left to right. Commas and
+// nested joins on the left fold into one sequence; a parenthesized join on
+// the right of another join isn't supported.
+func flattenFrom(tables sqlparser.TableExprs) ([]fromItem, error) {
+	var items []fromItem
+
+	for _, table := range tables {
+		flattened, err := flattenTableExpr(table)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, flattened...)
+	}
+
+	return items, nil
+}
+
+func flattenTableExpr(table sqlparser.TableExpr) ([]fromItem, error) {
+	switch table := table.(type) {
+	case *sqlparser.AliasedTableExpr:
+		return []fromItem{{table: table, kind: sqlparser.JoinStr}}, nil
+	case *sqlparser.ParenTableExpr:
+		return flattenFrom(table.Exprs)
+	case *sqlparser.JoinTableExpr:
+		switch table.Join {
+		case sqlparser.JoinStr, sqlparser.LeftJoinStr, sqlparser.NaturalJoinStr, sqlparser.NaturalLeftJoinStr:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, strings.ToUpper(table.Join))
+		}
+
+		items, err := flattenTableExpr(table.LeftExpr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := flattenTableExpr(table.RightExpr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(right) != 1 {
+			return nil, fmt.Errorf("%w: a join nested on the right of %s", ErrUnsupported, strings.ToUpper(table.Join))
+		}
+
+		right[0].kind = table.Join
+		right[0].condition = table.Condition
+
+		return append(items, right[0]), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(table))
+}
+
+// planJoin resolves the tables of a FROM clause into scope, assigns each
+// term of the WHERE clause and the join constraints to the innermost loop it
+// needs, and picks how each table's rows are looked up.
+func (db *DB) planJoin(scope *scope, from sqlparser.TableExprs, where *sqlparser.Where) (*join, error) {
+	items, err := flattenFrom(from)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) > maxJoinSources {
+		return nil, fmt.Errorf("at most %d tables in a join", maxJoinSources)
+	}
+
+	j := &join{scope: scope}
+
+	// terms are placed once every source is known, since WHERE may name any
+	// of them
+	var terms []*expr
+
+	for i, item := range items {
+		var table *Table
+		correlated := false
+
+		switch expr := item.table.Expr.(type) {
+		case sqlparser.TableName:
+			table, correlated, err = db.namedTable(scope.planning, expr)
+		case *sqlparser.Subquery:
+			table, correlated, err = db.derivedTable(scope, expr, item.table.As.String())
+		default:
+			err = fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(item.table.Expr))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		source := &source{
+			name:       table.Name,
+			table:      table,
+			offset:     j.width,
+			left:       item.kind == sqlparser.LeftJoinStr || item.kind == sqlparser.NaturalLeftJoinStr,
+			hidden:     make(map[int]bool),
+			correlated: correlated,
+		}
+
+		if !item.table.As.IsEmpty() {
+			source.name = item.table.As.String()
+		}
+
+		if i == 0 && (item.condition.On != nil || len(item.condition.Using) > 0) {
+			return nil, errors.New("a JOIN clause is required before ON and USING")
+		}
+
+		j.scope.sources = append(j.scope.sources, source)
+		j.width += source.width()
+
+		constraints, err := joinConstraints(j.scope, item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, constraint := range constraints {
+			// constraints may only name this source and the ones before it,
+			// which is all the scope holds so far
+			compiled, err := compileExpr(j.scope, constraint)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if source.left {
+				source.on = append(source.on, compiled)
+			} else {
+				terms = append(terms, compiled)
+			}
+		}
+	}
+
+	if where != nil {
+		for _, term := range conjuncts(where.Expr) {
+			compiled, err := compileExpr(j.scope, term)
+
+			if err != nil {
+				return nil, err
+			}
+
+			terms = append(terms, compiled)
+		}
+	}
+
+	for _, term := range terms {
+		if term.sources == 0 {
+			j.constant = append(j.constant, term)
+			continue
+		}
+
+		level := bits.Len64(term.sources) - 1
+		j.scope.sources[level].filters = append(j.scope.sources[level].filters, term)
+	}
+
+	for level, source := range j.scope.sources {
+		if err := db.chooseAccess(level, source); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(j.scope.sources) > 0 {
+		j.scope.sources[0].split = newSplitScan(j.scope, j.scope.sources[0])
+	}
+
+	return j, nil
+}
+
+// joinConstraints turns the ON, USING or NATURAL clause of a join into
+// terms. Columns joined with USING are then only reachable unqualified
+// through the left side.
+func joinConstraints(scope *scope, item fromItem) ([]sqlparser.Expr, error) {
+	right := scope.sources[len(scope.sources)-1]
+	left := scope.sources[:len(scope.sources)-1]
+	using := item.condition.Using
+
+	if item.kind == sqlparser.NaturalJoinStr || item.kind == sqlparser.NaturalLeftJoinStr {
+		if item.condition.On != nil || len(using) > 0 {
+			return nil, errors.New("a NATURAL join may not have an ON or USING clause")
+		}
+
+		for _, column := range right.table.Definition.Columns {
+			if leftColumnSource(left, column.Name) != nil {
+				using = append(using, sqlparser.NewColIdent(column.Name))
+			}
+		}
+	}
+
+	var constraints []sqlparser.Expr
+
+	if item.condition.On != nil {
+		constraints = conjuncts(item.condition.On)
+	}
+
+	for _, column := range using {
+		name := column.String()
+		position := right.table.Definition.ColumnIndex(name)
+		leftSource := leftColumnSource(left, name)
+
+		if position == -1 || leftSource == nil {
+			return nil, fmt.Errorf("cannot join using column %s - column not present in both tables", name)
+		}
+
+		constraints = append(constraints, &sqlparser.ComparisonExpr{
+			Operator: sqlparser.EqualStr,
+			Left:     &sqlparser.ColName{Name: column, Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(leftSource.name)}},
+			Right:    &sqlparser.ColName{Name: column, Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(right.name)}},
+		})
+
+		right.hidden[position] = true
+	}
+
+	return constraints, nil
+}
+
+// leftColumnSource finds the first source with a column that an unqualified
+// name could reach.
+func leftColumnSource(sources []*source, name string) *source {
+	for _, source := range sources {
+		position := source.table.Definition.ColumnIndex(name)
+
+		if position != -1 && !source.hidden[position] {
+			return source
+		}
+	}
+
+	return nil
+}
+
+// conjuncts splits an expression on its top-level ANDs.
+func conjuncts(node sqlparser.Expr) []sqlparser.Expr {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		return append(conjuncts(node.Left), conjuncts(node.Right)...)
+	case *sqlparser.ParenExpr:
+		if _, ok := node.Expr.(*sqlparser.AndExpr); ok {
+			return conjuncts(node.Expr)
+		}
+	}
+
+	return []sqlparser.Expr{node}
+}
+
+// equality is a term of the form column = value that a lookup can use,
+// where value only depends on sources of outer loops.
+type equality struct {
+	position  int
+	value     *expr
+	affinity  parser.Affinity // applied to value before searching
+	collation string
+}
+
+// chooseAccess picks how the source at level finds its rows. A rowid
+// lookup wins over an index, and among indexes the one whose leading
+// columns are covered by the most equalities wins.
+func (db *DB) chooseAccess(level int, source *source) error {
+	terms := source.filters
+
+	// filters of a LEFT JOIN also see the row of NULLs, so only the ON
+	// constraints can narrow down which rows are read
+	if source.left {
+		terms = source.on
+	}
+
+	var equalities []equality
+
+	for _, term := range terms {
+		if found, ok := term.equality(level, source); ok {
+			equalities = append(equalities, found)
+		}
+	}
+
+	for _, found := range equalities {
+		if found.position == source.table.RowidColumn || found.position == len(source.table.Definition.Columns) {
+			source.rowid = &seekKey{value: found.value, affinity: found.affinity}
+			return nil
+		}
+	}
+
+	if len(equalities) == 0 {
+		return nil
+	}
+
+	indexes, err := db.searchableIndexes(source.table)
+
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		var key []*seekKey
+
+		for i, position := range index.Columns {
+			match := -1
+
+			for k, found := range equalities {
+				if found.position == position && sameCollation(found.collation, index.Collations[i]) {
+					match = k
+					break
+				}
+			}
+
+			if match == -1 {
+				break
+			}
+
+			key = append(key, &seekKey{value: equalities[match].value, affinity: equalities[match].affinity})
+		}
+
+		if len(key) > len(source.key) {
+			source.index = index
+			source.key = key
+		}
+	}
+
+	return nil
+}
+
+func sameCollation(a string, b string) bool {
+	if a == "" {
+		a = "BINARY"
+	}
+
+	if b == "" {
+		b = "BINARY"
+	}
+
+	return strings.EqualFold(a, b)
+}
+
+// equality recognizes a term comparing a column of the source at level for
+// equality with an expression of outer sources. The column side must not
+// need converting, since the stored values are searched as they are.
+func (e *expr) equality(level int, source *source) (equality, bool) {
+	if e.compared == nil || e.compared.operator != sqlparser.EqualStr {
+		return equality{}, false
+	}
+
+	left, right := e.compared.left, e.compared.right
+	how := comparisonOf(left, right)
+	own := uint64(1) << level
+
+	if left.column != -1 && left.sources == own && right.sources < own && how.leftAffinity == parser.BlobAffinity {
+		return equality{position: left.column - source.offset, value: right, affinity: how.rightAffinity, collation: how.collation}, true
+	}
+
+	if right.column != -1 && right.sources == own && left.sources < own && how.rightAffinity == parser.BlobAffinity {
+		return equality{position: right.column - source.offset, value: left, affinity: how.leftAffinity, collation: how.collation}, true
+	}
+
+	return equality{}, false
+}
+
+// run calls emit with every joined row that passes the filters. The row is
+// reused between calls.
+func (j *join) run(db *DB, emit func(row []page.Value) error) error {
+	row := make([]page.Value, j.width)
+
+	if ok, err := passes(j.constant, row); err != nil || !ok {
+		return err
+	}
+
+	return j.loop(db, 0, row, emit)
+}
+
+func (j *join) loop(db *DB, level int, row []page.Value, emit func([]page.Value) error) error {
+	if level == len(j.scope.sources) {
+		return emit(row)
+	}
+
+	source := j.scope.sources[level]
+	matched := false
+
+	err := db.scanSource(source, row, func() error {
+		ok, err := passes(source.on, row)
+
+		if err != nil || !ok {
+			return err
+		}
+
+		matched = true
+
+		ok, err = passes(source.filters, row)
+
+		if err != nil || !ok {
+			return err
+		}
+
+		return j.loop(db, level+1, row, emit)
+	})
+
+	if err != nil || matched || !source.left {
+		return err
+	}
+
+	for i := source.offset; i < source.offset+source.width(); i++ {
+		row[i] = nullValue
+	}
+
+	ok, err := passes(source.filters, row)
+
+	if err != nil || !ok {
+		return err
+	}
+
+	return j.loop(db, level+1, row, emit)
+}
+
+// passes reports whether every term is true for the row.
+func passes(terms []*expr, row []page.Value) (bool, error) {
+	for _, term := range terms {
+		value, err := term.eval(row)
+
+		if err != nil {
+			return false, err
+		}
+
+		if value.Type == page.NullValue || !truth(value) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// scanSource fills the source's slots with each row it finds and calls
+// visit for it.
+func (db *DB) scanSource(source *source, row []page.Value, visit func() error) error {
+	table := source.table
+
+	fill := func(rowID page.Value, values []page.Value) error {
+		if err := db.interrupted(); err != nil {
+			return err
+		}
+
+		db.rowsScanned++
+		copy(row[source.offset:], values)
+		row[source.rowidSlot()] = rowID
+
+		return visit()
+	}
+
+	load := func(cell page.Cell) error {
+		values, err := cell.Values()
+
+		if err != nil {
+			return err
+		}
+
+		rowID := int64(cell.CellIdx)
+
+		return fill(page.Value{Type: page.IntegerValue, Int: rowID}, table.rowValues(rowID, values))
+	}
+
+	switch {
+	case table.view != nil && source.offset == 0:
+		// the first source is read once, so its rows are passed on as they
+		// are made, which lets LIMIT end a query that wouldn't end by itself
+		return table.view.run(db, func(values []page.Value) error {
+			return fill(nullValue, values)
+		})
+	case table.view != nil:
+		rows, err := db.viewRows(source)
+
+		if err != nil {
+			return err
+		}
+
+		for _, values := range rows {
+			if err := fill(nullValue, values); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case source.rowid != nil:
+		key, err := source.rowid.eval(row)
+
+		if err != nil {
+			return err
+		}
+
+		rowID, ok := integerKey(key)
+
+		if !ok {
+			return nil
+		}
+
+		cell, found, err := page.FindRow(db.pager, table.RootPage, rowID)
+
+		if err != nil || !found {
+			return err
+		}
+
+		return load(cell)
+	case source.index != nil:
+		key := make([]page.Value, len(source.key))
+
+		for i, part := range source.key {
+			value, err := part.eval(row)
+
+			if err != nil {
+				return err
+			}
+
+			// nothing is equal to NULL
+			if value.Type == page.NullValue {
+				return nil
+			}
+
+			key[i] = value
+		}
+
+		return page.ScanIndex(db.pager, source.index.RootPage, key, source.index.Order, func(record []page.Value) error {
+			if table.primaryKey != nil {
+				return db.findByPrimaryKey(table, source.index, record, func(values []page.Value) error {
+					return fill(nullValue, values)
+				})
+			}
+
+			if len(record) == 0 || record[len(record)-1].Type != page.IntegerValue {
+				return fmt.Errorf("%w: index %s entry has no rowid", page.ErrCorruptPage, source.index.Name)
+			}
+
+			rowID := record[len(record)-1]
+			cell, found, err := page.FindRow(db.pager, table.RootPage, rowID.Int)
+
+			if err != nil {
+				return err
+			}
+
+			if !found {
+				return fmt.Errorf("%w: index %s points at missing row %d", page.ErrCorruptPage, source.index.Name, rowID.Int)
+			}
+
+			return load(cell)
+		})
+	}
+
+	if source.split != nil && db.Threads() > 0 {
+		return db.splitScan(source, len(row), fill)
+	}
+
+	return db.walkRows(table, fill)
+}
+
+// findByPrimaryKey reads the row of a WITHOUT ROWID table that an index entry
+// points at through its primary key columns. An entry of the primary key
+// itself is the row.
+func (db *DB) findByPrimaryKey(table *Table, index *Index, entry []page.Value, visit func(values []page.Value) error) error {
+	if index == table.primaryKey {
+		return visit(table.rowValues(0, entry))
+	}
+
+	key := make([]page.Value, len(index.primaryKey))
+
+	for i, at := range index.primaryKey {
+		if at >= len(entry) {
+			return fmt.Errorf("%w: index %s entry has no primary key", page.ErrCorruptPage, index.Name)
+		}
+
+		key[i] = entry[at]
+	}
+
+	found := false
+
+	err := page.ScanIndex(db.pager, table.RootPage, key, table.primaryKey.Order, func(record []page.Value) error {
+		found = true
+		return visit(table.rowValues(0, record))
+	})
+
+	if err == nil && !found {
+		return fmt.Errorf("%w: index %s points at a missing row", page.ErrCorruptPage, index.Name)
+	}
+
+	return err
+}
+
+// integerKey reads a rowid to look up. Values that aren't integers, or reals
+// holding one, can't equal any rowid.
+func integerKey(value page.Value) (int64, bool) {
+	switch value.Type {
+	case page.IntegerValue:
+		return value.Int, true
+	case page.FloatValue:
+		return exactInteger(value.Float)
+	}
+
+	return 0, false
+}
@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"errors"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// subtreesPerThread is how many subtrees a split scan aims for per
+	// worker, so that a worker done early takes on more of the table
+	subtreesPerThread = 4
+	// scanBatchSize is how many rows a worker hands over at a time
+	scanBatchSize = 256
+)
+
+// errScanAbandoned stops the workers of a split scan once the statement no
+// longer wants its rows.
+var errScanAbandoned = errors.New("scan abandoned")
+
+// SetThreads sets how many worker goroutines a query may read a large table
+// with, 0 being none, which is the default. PRAGMA threads sets it too, as
+// in SQLite. The workers also check the filters of the rows they read, so
+// the collations those use must be safe to call from several goroutines.
+func (db *DB) SetThreads(threads int) {
+	db.threads.Store(int64(max(threads, 0)))
+}
+
+func (db *DB) Threads() int {
+	return int(db.threads.Load())
+}
+
+// splitScan describes a scan of a whole rowid table that worker goroutines
+// share by splitting the b-tree into subtrees. Only the outermost source of
+// a join is split, since the others are read again for each of its rows.
+type splitScan struct {
+	// filters are the terms the workers check for the join, which leaves
+	// out any that a subquery might be among: a subquery keeps its rows
+	// for the length of a run, which isn't safe from several goroutines
+	filters []*expr
+	// ordered is set when the rows have to come in rowid order, as they do
+	// from a scan on one goroutine
+	ordered bool
+}
+
+func newSplitScan(scope *scope, source *source) *splitScan {
+	table := source.table
+
+	if table.view != nil || table.primaryKey != nil || source.rowid != nil || source.index != nil || source.left {
+		return nil
+	}
+
+	split := &splitScan{ordered: true}
+
+	if len(scope.subqueries) == 0 {
+		split.filters = source.filters
+	}
+
+	return split
+}
+
+// scanBatch is a run of rows a worker read, with the number of rows it
+// left out since the run before and the error it stopped at, if any.
+type scanBatch struct {
+	rows    []scannedRow
+	dropped int64
+	err     error
+}
+
+type scannedRow struct {
+	rowID  int64
+	values []page.Value
+}
+
+// splitScan reads the table of source on worker goroutines, each walking a
+// subtree at a time, and passes its rows to fill on this one. In rowid
+// order, a subtree's rows wait for those of the subtrees before it; in any
+// order, they are passed on as they come. The rows that pass the workers'
+// filters are checked again by the join, which costs little next to reading
+// and decoding them.
+func (db *DB) splitScan(source *source, width int, fill func(rowID page.Value, values []page.Value) error) error {
+	table := source.table
+	threads := db.Threads()
+	subtrees, err := page.TableSubtrees(db.pager, table.RootPage, threads*subtreesPerThread)
+
+	if err != nil {
+		return err
+	}
+
+	if len(subtrees) < 2 {
+		return db.walkRows(table, fill)
+	}
+
+	ordered := source.split.ordered
+	done := make(chan struct{})
+	shared := make(chan scanBatch, threads)
+	batches := make([]chan scanBatch, len(subtrees))
+
+	for i := range batches {
+		batches[i] = shared
+
+		if ordered {
+			batches[i] = make(chan scanBatch, 2)
+		}
+	}
+
+	var next atomic.Int64
+	var workers sync.WaitGroup
+
+	workers.Add(threads)
+
+	for range threads {
+		go func() {
+			defer workers.Done()
+
+			for {
+				i := int(next.Add(1) - 1)
+
+				if i >= len(subtrees) {
+					return
+				}
+
+				ok := db.scanSubtree(source, width, subtrees[i], batches[i], done)
+
+				if ordered {
+					close(batches[i])
+				}
+
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
+
+	if !ordered {
+		go func() {
+			workers.Wait()
+			close(shared)
+		}()
+	}
+
+	// the workers are done before the read ends
+	defer func() {
+		close(done)
+		workers.Wait()
+	}()
+
+	deliver := func(batch scanBatch) error {
+		db.rowsScanned += batch.dropped
+		db.fullScanSteps += batch.dropped + int64(len(batch.rows))
+
+		for _, row := range batch.rows {
+			if err := fill(page.Value{Type: page.IntegerValue, Int: row.rowID}, row.values); err != nil {
+				return err
+			}
+		}
+
+		return batch.err
+	}
+
+	if !ordered {
+		for batch := range shared {
+			if err := deliver(batch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, subtree := range batches {
+		for batch := range subtree {
+			if err := deliver(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scanSubtree reads the rows of the subtree at root on a worker and sends
+// them out in batches. It reports whether the worker should go on to the
+// next subtree, which it shouldn't once the scan failed or was abandoned.
+func (db *DB) scanSubtree(source *source, width int, root int, out chan<- scanBatch, done <-chan struct{}) bool {
+	table := source.table
+	filters := source.split.filters
+	row := make([]page.Value, width)
+	batch := scanBatch{}
+
+	send := func() error {
+		select {
+		case out <- batch:
+			batch = scanBatch{}
+			return nil
+		case <-done:
+			return errScanAbandoned
+		}
+	}
+
+	err := page.WalkTable(db.pager, root, func(cell page.Cell) error {
+		stored, err := cell.Values()
+
+		if err != nil {
+			return err
+		}
+
+		rowID := int64(cell.CellIdx)
+		values := table.rowValues(rowID, stored)
+
+		if len(filters) > 0 {
+			copy(row[source.offset:], values)
+			row[source.rowidSlot()] = page.Value{Type: page.IntegerValue, Int: rowID}
+
+			ok, err := passes(filters, row)
+
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				batch.dropped++
+				return nil
+			}
+		}
+
+		batch.rows = append(batch.rows, scannedRow{rowID: rowID, values: values})
+
+		if len(batch.rows) < scanBatchSize {
+			return nil
+		}
+
+		return send()
+	})
+
+	if errors.Is(err, errScanAbandoned) {
+		return false
+	}
+
+	batch.err = err
+
+	return send() == nil && err == nil
+}
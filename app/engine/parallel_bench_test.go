@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// scanRows is how many rows the table the scans read holds, enough for
+// its b-tree to split into subtrees for eight workers.
+const scanRows = 100_000
+
+const scanQuery = "SELECT count(*), sum(c) FROM t WHERE a % 7 = 3 AND b LIKE '%5%'"
+
+// openScanDB creates a database holding table t with scanRows generated
+// rows.
+func openScanDB(tb testing.TB) *DB {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "scan.db")
+
+	// an empty database: the header, and an empty sqlite_schema after it
+	header := page.DatabaseHeader{
+		PageSize:            4096,
+		WriteVersion:        1,
+		ReadVersion:         1,
+		MaxPayloadFraction:  64,
+		MinPayloadFraction:  32,
+		LeafPayloadFraction: 32,
+		DatabaseSize:        1,
+		SchemaFormatNumber:  4,
+		TextEncoding:        page.UTF8,
+	}
+	copy(header.HeaderString[:], "SQLite format 3\x00")
+
+	first := make([]byte, 4096)
+	page.MarshalDbHeader(header, first)
+	first[100] = page.LeafTablePage
+	binary.BigEndian.PutUint16(first[105:107], 4096)
+
+	if err := os.WriteFile(path, first, 0644); err != nil {
+		tb.Fatal(err)
+	}
+
+	db, err := Open(path)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { db.Close() })
+
+	if err := db.CreateTable("CREATE TABLE t(id INTEGER PRIMARY KEY, a INTEGER, b TEXT, c REAL)"); err != nil {
+		tb.Fatal(err)
+	}
+
+	inserter, err := db.NewInserter("t")
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := db.Begin(); err != nil {
+		tb.Fatal(err)
+	}
+
+	for i := 0; i < scanRows; i++ {
+		_, err := inserter.Insert([]page.Value{
+			nullValue,
+			{Type: page.IntegerValue, Int: int64(i * 7919 % 1000)},
+			textValue(fmt.Sprintf("row %d of the generated table", i)),
+			floatValue(float64(i) / 4),
+		})
+
+		if err != nil {
+			db.Rollback()
+			tb.Fatal(err)
+		}
+	}
+
+	if err := db.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+func benchmarkScan(b *testing.B, threads int) {
+	db := openScanDB(b)
+	db.SetThreads(threads)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Query(scanQuery); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanSequential(b *testing.B) { benchmarkScan(b, 0) }
+func BenchmarkScanThreads2(b *testing.B)   { benchmarkScan(b, 2) }
+func BenchmarkScanThreads4(b *testing.B)   { benchmarkScan(b, 4) }
+func BenchmarkScanThreads8(b *testing.B)   { benchmarkScan(b, 8) }
+
+type scanRow struct {
+	rowID  page.Value
+	values []page.Value
+}
+
+// TestSplitScanMatchesWalkRows checks that a split scan reads the rows a
+// scan on one goroutine does: in the same order when it has to keep rowid
+// order, and the same rows in some order when it doesn't.
+func TestSplitScanMatchesWalkRows(t *testing.T) {
+	db := openScanDB(t)
+	db.SetThreads(4)
+
+	s := db.session()
+
+	var want []scanRow
+
+	err := s.read(func() error {
+		table, err := s.table("t")
+
+		if err != nil {
+			return err
+		}
+
+		// the workers only share the scan when there are several subtrees
+		subtrees, err := page.TableSubtrees(s.pager, table.RootPage, s.Threads()*subtreesPerThread)
+
+		if err != nil {
+			return err
+		}
+
+		if len(subtrees) < 2 {
+			return fmt.Errorf("table t splits into %d subtrees", len(subtrees))
+		}
+
+		return s.walkRows(table, func(rowID page.Value, values []page.Value) error {
+			want = append(want, scanRow{rowID, values})
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(want) != scanRows {
+		t.Fatalf("walkRows read %d rows, want %d", len(want), scanRows)
+	}
+
+	for _, ordered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			var got []scanRow
+
+			err := s.read(func() error {
+				table, err := s.table("t")
+
+				if err != nil {
+					return err
+				}
+
+				source := &source{name: "t", table: table, split: &splitScan{ordered: ordered}}
+
+				return s.splitScan(source, source.width(), func(rowID page.Value, values []page.Value) error {
+					got = append(got, scanRow{rowID, values})
+					return nil
+				})
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ordered {
+				sort.Slice(got, func(i, j int) bool { return got[i].rowID.Int < got[j].rowID.Int })
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("split scan read %d rows, want %d", len(got), len(want))
+			}
+
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Fatalf("row %d: got %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pragma runs a PRAGMA statement. As in SQLite, a pragma that isn't known
+// is ignored. The ones known are:
+//
+//   - sort_memory: the bytes of rows a sort or a DISTINCT keeps in memory
+//     before it spills to temporary files
+//   - query_timeout: the milliseconds a statement may run before it is
+//     interrupted, 0 being no limit
+//   - busy_timeout: the milliseconds a lock held by another connection is
+//     waited for, which SQLite reports as a column named timeout
+//   - threads: the worker goroutines a scan of a whole table may use
+func (db *DB) Pragma(sql string) (*Result, error) {
+	pragma, err := parser.ParsePragma(sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	name := strings.ToLower(pragma.Name)
+
+	switch name {
+	case "sort_memory":
+		if pragma.Value == "" {
+			return pragmaResult(name, int64(db.SortMemory())), nil
+		}
+
+		bytes, err := strconv.Atoi(pragma.Value)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: PRAGMA %s = %s", ErrMismatch, name, pragma.Value)
+		}
+
+		db.SetSortMemory(bytes)
+	case "query_timeout":
+		if pragma.Value == "" {
+			return pragmaResult(name, db.QueryTimeout().Milliseconds()), nil
+		}
+
+		milliseconds, err := strconv.Atoi(pragma.Value)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: PRAGMA %s = %s", ErrMismatch, name, pragma.Value)
+		}
+
+		db.SetQueryTimeout(time.Duration(milliseconds) * time.Millisecond)
+	case "threads":
+		if pragma.Value != "" {
+			threads, err := strconv.Atoi(pragma.Value)
+
+			if err != nil {
+				return nil, fmt.Errorf("%w: PRAGMA %s = %s", ErrMismatch, name, pragma.Value)
+			}
+
+			db.SetThreads(threads)
+		}
+
+		return pragmaResult(name, int64(db.Threads())), nil
+	case "busy_timeout":
+		if pragma.Value != "" {
+			milliseconds, err := strconv.Atoi(pragma.Value)
+
+			if err != nil {
+				return nil, fmt.Errorf("%w: PRAGMA %s = %s", ErrMismatch, name, pragma.Value)
+			}
+
+			db.SetBusyTimeout(time.Duration(milliseconds) * time.Millisecond)
+		}
+
+		return pragmaResult("timeout", db.BusyTimeout().Milliseconds()), nil
+	}
+
+	return &Result{}, nil
+}
+
+// pragmaResult is the row a pragma that reads a setting returns.
+func pragmaResult(name string, value int64) *Result {
+	return &Result{
+		Columns: []string{name},
+		Rows:    [][]page.Value{{{Type: page.IntegerValue, Int: value}}},
+	}
+}
@@ -0,0 +1,537 @@
+package engine
+
+import (
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// printfSpec is one conversion of a printf format, such as %-8.2f.
+type printfSpec struct {
+	left, plus, space, alternate, exact, zero, thousands bool
+	width                                                int
+	precision                                            int // -1 when not given
+}
+
+// printf implements printf() and format(): C-style formatting of the
+// remaining arguments, with SQLite's %q, %Q and %w for quoting. Missing
+// arguments count as NULL, and an unknown conversion ends the output.
+func printf(args []page.Value) (page.Value, error) {
+	if args[0].Type == page.NullValue {
+		return nullValue, nil
+	}
+
+	format := textOf(args[0])
+	args = args[1:]
+
+	next := func() page.Value {
+		if len(args) == 0 {
+			return nullValue
+		}
+
+		value := args[0]
+		args = args[1:]
+
+		return value
+	}
+
+	at := func(i int) byte {
+		if i < len(format) {
+			return format[i]
+		}
+
+		return 0
+	}
+
+	var out strings.Builder
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		i++
+
+		if i == len(format) {
+			out.WriteByte('%')
+			break
+		}
+
+		spec := printfSpec{precision: -1}
+
+	flags:
+		for ; ; i++ {
+			switch at(i) {
+			case '-':
+				spec.left = true
+			case '+':
+				spec.plus = true
+			case ' ':
+				spec.space = true
+			case '#':
+				spec.alternate = true
+			case '!':
+				spec.exact = true
+			case '0':
+				spec.zero = true
+			case ',':
+				spec.thousands = true
+			default:
+				break flags
+			}
+		}
+
+		if at(i) == '*' {
+			width := toInteger(next())
+
+			if width < 0 {
+				spec.left = true
+				width = -width
+			}
+
+			spec.width = int(min(width, math.MaxInt32))
+			i++
+		} else {
+			for ; at(i) >= '0' && at(i) <= '9'; i++ {
+				spec.width = min(spec.width*10+int(at(i)-'0'), math.MaxInt32)
+			}
+		}
+
+		if at(i) == '.' {
+			i++
+			spec.precision = 0
+
+			if at(i) == '*' {
+				precision := toInteger(next())
+
+				if precision < 0 {
+					precision = -precision
+				}
+
+				spec.precision = int(min(precision, math.MaxInt32))
+				i++
+			} else {
+				for ; at(i) >= '0' && at(i) <= '9'; i++ {
+					spec.precision = min(spec.precision*10+int(at(i)-'0'), math.MaxInt32)
+				}
+			}
+		}
+
+		for at(i) == 'l' {
+			i++
+		}
+
+		var text string
+		numeric := false
+
+		switch conversion := at(i); conversion {
+		case 'd', 'i', 'u':
+			text, numeric = formatInteger(toInteger(next()), conversion == 'u', spec), true
+		case 'x', 'X', 'o', 'p':
+			text, numeric = formatUnsigned(uint64(toInteger(next())), conversion, spec), true
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			text, numeric = formatFloat(toFloat(next()), conversion, spec), true
+		case 's', 'z':
+			text = truncateText(textOf(next()), spec)
+		case 'q', 'Q', 'w':
+			value := next()
+
+			switch {
+			case value.Type == page.NullValue && conversion == 'Q':
+				text = "NULL"
+			case value.Type == page.NullValue:
+				text = "(NULL)"
+			case conversion == 'w':
+				text = strings.ReplaceAll(truncateText(textOf(value), spec), `"`, `""`)
+			default:
+				text = strings.ReplaceAll(truncateText(textOf(value), spec), "'", "''")
+
+				if conversion == 'Q' {
+					text = "'" + text + "'"
+				}
+			}
+		case 'c':
+			character, _ := utf8.DecodeRuneInString(textOf(next()))
+			count := 1
+
+			if spec.precision > 1 {
+				count = spec.precision
+			}
+
+			if character != utf8.RuneError {
+				text = strings.Repeat(string(character), count)
+			}
+		case '%':
+			text = "%"
+		case 'n':
+			continue
+		default:
+			return textValue(out.String()), nil
+		}
+
+		out.WriteString(pad(text, numeric, spec))
+	}
+
+	return textValue(out.String()), nil
+}
+
+// pad brings text to the width of spec. Numbers are padded with zeros after
+// their sign when the 0 flag is given.
+func pad(text string, numeric bool, spec printfSpec) string {
+	length := len(text)
+
+	if spec.exact {
+		length = utf8.RuneCountInString(text)
+	}
+
+	if length >= spec.width {
+		return text
+	}
+
+	padding := spec.width - length
+
+	switch {
+	case spec.left:
+		return text + strings.Repeat(" ", padding)
+	case spec.zero && numeric:
+		sign := 0
+
+		if len(text) > 0 && (text[0] == '-' || text[0] == '+' || text[0] == ' ') {
+			sign = 1
+		}
+
+		if strings.HasPrefix(text[sign:], "0x") || strings.HasPrefix(text[sign:], "0X") {
+			sign += 2
+		}
+
+		return text[:sign] + strings.Repeat("0", padding) + text[sign:]
+	}
+
+	return strings.Repeat(" ", padding) + text
+}
+
+// truncateText applies the precision of %s, which counts bytes unless the !
+// flag asks for characters.
+func truncateText(text string, spec printfSpec) string {
+	if spec.precision < 0 || spec.precision >= len(text) {
+		return text
+	}
+
+	if !spec.exact {
+		return text[:spec.precision]
+	}
+
+	characters := 0
+
+	for i := range text {
+		if characters == spec.precision {
+			return text[:i]
+		}
+
+		characters++
+	}
+
+	return text
+}
+
+func signOf(negative bool, spec printfSpec) string {
+	switch {
+	case negative:
+		return "-"
+	case spec.plus:
+		return "+"
+	case spec.space:
+		return " "
+	}
+
+	return ""
+}
+
+func formatInteger(integer int64, unsigned bool, spec printfSpec) string {
+	negative := integer < 0 && !unsigned
+	magnitude := uint64(integer)
+
+	if negative {
+		magnitude = -magnitude
+	}
+
+	digits := strconv.FormatUint(magnitude, 10)
+
+	if len(digits) < spec.precision {
+		digits = strings.Repeat("0", spec.precision-len(digits)) + digits
+	}
+
+	if spec.thousands {
+		var grouped strings.Builder
+
+		for i, digit := range digits {
+			if i > 0 && (len(digits)-i)%3 == 0 {
+				grouped.WriteByte(',')
+			}
+
+			grouped.WriteRune(digit)
+		}
+
+		digits = grouped.String()
+	}
+
+	return signOf(negative, spec) + digits
+}
+
+func formatUnsigned(integer uint64, conversion byte, spec printfSpec) string {
+	var digits, prefix string
+
+	switch conversion {
+	case 'o':
+		digits = strconv.FormatUint(integer, 8)
+
+		if spec.alternate && integer != 0 {
+			prefix = "0"
+		}
+	case 'X':
+		digits = strings.ToUpper(strconv.FormatUint(integer, 16))
+
+		if spec.alternate && integer != 0 {
+			prefix = "0X"
+		}
+	default:
+		digits = strconv.FormatUint(integer, 16)
+
+		if spec.alternate && integer != 0 {
+			prefix = "0x"
+		}
+	}
+
+	if len(digits) < spec.precision {
+		digits = strings.Repeat("0", spec.precision-len(digits)) + digits
+	}
+
+	return prefix + digits
+}
+
+// decimal is a real in decimal, as d.ddd × 10^exponent. No digits means zero.
+type decimal struct {
+	digits   []byte
+	exponent int
+}
+
+// significantDigits is how many digits printf shows of a real before
+// continuing with zeros, as SQLite does. The ! flag raises it.
+func significantDigits(spec printfSpec) int {
+	if spec.exact {
+		return 26
+	}
+
+	return 16
+}
+
+// newDecimal expands the magnitude of a real into the 19 significant digits
+// SQLite works from, cut off rather than rounded.
+func newDecimal(real float64) decimal {
+	if real == 0 {
+		return decimal{}
+	}
+
+	text := strconv.FormatFloat(math.Abs(real), 'e', 40, 64)
+	mantissa, exponentText, _ := strings.Cut(text, "e")
+	exponent, _ := strconv.Atoi(exponentText)
+	digits := strings.Replace(mantissa, ".", "", 1)[:19]
+
+	return decimal{digits: []byte(strings.TrimRight(digits, "0")), exponent: exponent}
+}
+
+// round keeps the first count significant digits, rounding half away from
+// zero.
+func (d *decimal) round(count int) {
+	if len(d.digits) <= count {
+		return
+	}
+
+	if count < 0 {
+		d.digits = nil
+		return
+	}
+
+	up := d.digits[count] >= '5'
+	d.digits = d.digits[:count]
+
+	if !up {
+		d.digits = []byte(strings.TrimRight(string(d.digits), "0"))
+		return
+	}
+
+	for i := count - 1; i >= 0; i-- {
+		if d.digits[i] != '9' {
+			d.digits[i]++
+			d.digits = []byte(strings.TrimRight(string(d.digits[:i+1]), "0"))
+
+			return
+		}
+	}
+
+	// every digit carried, as in 9.99 becoming 10.0
+	d.digits = []byte{'1'}
+	d.exponent++
+}
+
+func (d *decimal) digit(i int) byte {
+	if i >= 0 && i < len(d.digits) {
+		return d.digits[i]
+	}
+
+	return '0'
+}
+
+// fixed renders the digits with a decimal point and precision decimals.
+func (d *decimal) fixed(precision int, point bool) string {
+	var text strings.Builder
+
+	if d.exponent < 0 || len(d.digits) == 0 {
+		text.WriteByte('0')
+	} else {
+		for i := 0; i <= d.exponent; i++ {
+			text.WriteByte(d.digit(i))
+		}
+	}
+
+	if precision > 0 || point {
+		text.WriteByte('.')
+	}
+
+	for i := 1; i <= precision; i++ {
+		if len(d.digits) == 0 {
+			text.WriteByte('0')
+		} else {
+			text.WriteByte(d.digit(d.exponent + i))
+		}
+	}
+
+	return text.String()
+}
+
+// scientific renders the digits as d.ddde+XX.
+func (d *decimal) scientific(precision int, point bool, conversion byte) string {
+	var text strings.Builder
+
+	text.WriteByte(d.digit(0))
+
+	if precision > 0 || point {
+		text.WriteByte('.')
+	}
+
+	for i := 1; i <= precision; i++ {
+		text.WriteByte(d.digit(i))
+	}
+
+	exponent := d.exponent
+
+	if len(d.digits) == 0 {
+		exponent = 0
+	}
+
+	text.WriteByte(conversion)
+
+	if exponent < 0 {
+		text.WriteByte('-')
+		exponent = -exponent
+	} else {
+		text.WriteByte('+')
+	}
+
+	if exponent < 10 {
+		text.WriteByte('0')
+	}
+
+	text.WriteString(strconv.Itoa(exponent))
+
+	return text.String()
+}
+
+func formatFloat(real float64, conversion byte, spec printfSpec) string {
+	sign := signOf(math.Signbit(real) && real != 0, spec)
+
+	switch {
+	case math.IsInf(real, 0):
+		return sign + "Inf"
+	case math.IsNaN(real):
+		return "NaN"
+	}
+
+	precision := spec.precision
+
+	if precision < 0 {
+		precision = 6
+	}
+
+	d := newDecimal(real)
+	limit := significantDigits(spec)
+	point := spec.alternate || spec.exact
+	var text string
+
+	// trailing zeros are removed by %g unless # is given, and by the others
+	// when ! is
+	trimZeros := spec.exact
+
+	switch conversion {
+	case 'f', 'F':
+		d.round(min(d.exponent+1+precision, limit))
+		text = d.fixed(precision, point)
+	case 'e', 'E':
+		d.round(min(precision+1, limit))
+		text = d.scientific(precision, point, conversion)
+	default:
+		if precision == 0 {
+			precision = 1
+		}
+
+		d.round(min(precision, limit))
+
+		exponent := d.exponent
+
+		if len(d.digits) == 0 {
+			exponent = 0
+		}
+
+		if exponent < -4 || exponent >= precision {
+			text = d.scientific(precision-1, point, conversion+'e'-'g')
+		} else {
+			text = d.fixed(precision-1-exponent, point)
+		}
+
+		trimZeros = !spec.alternate
+	}
+
+	if trimZeros {
+		letter := "e"
+
+		if conversion == 'E' || conversion == 'G' {
+			letter = "E"
+		}
+
+		mantissa, exponent, scientific := strings.Cut(text, letter)
+
+		if strings.Contains(mantissa, ".") {
+			mantissa = strings.TrimRight(mantissa, "0")
+
+			// ! keeps a digit after the point
+			if strings.HasSuffix(mantissa, ".") {
+				if spec.exact {
+					mantissa += "0"
+				} else {
+					mantissa = strings.TrimSuffix(mantissa, ".")
+				}
+			}
+		}
+
+		text = mantissa
+
+		if scientific {
+			text += letter + exponent
+		}
+	}
+
+	return sign + text
+}
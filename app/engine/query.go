@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Result holds the column names and typed rows a statement produced.
+type Result struct {
+	Columns []string
+	Rows    [][]page.Value
+	stats   Stats
+}
+
+// Query runs a statement once. Its parameters, if it has any, are NULL. The
+// statistics of the result include planning it.
+func (db *DB) Query(query string) (*Result, error) {
+	return db.QueryContext(context.Background(), query)
+}
+
+// QueryContext runs a statement once, and stops it with ErrInterrupted once
+// ctx is done.
+func (db *DB) QueryContext(ctx context.Context, query string) (*Result, error) {
+	s := db.session()
+
+	return s.interruptible(ctx, func() (*Result, error) {
+		return s.measure(func() (*Result, error) {
+			stmt, err := s.prepareStatement(query)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return stmt.run()
+		})
+	})
+}
+
+// schemaStatement reports whether a statement changes the schema, or is a
+// PRAGMA, which execute runs.
+func schemaStatement(sql string) bool {
+	switch keywords := leadingKeywords(sql); {
+	case keywords == "CREATE INDEX", keywords == "CREATE UNIQUE", keywords == "CREATE VIEW", keywords == "DROP VIEW":
+		return true
+	default:
+		return strings.HasPrefix(keywords, "PRAGMA ")
+	}
+}
+
+func (db *DB) execute(sql string) (*Result, error) {
+	switch keywords := leadingKeywords(sql); {
+	case keywords == "CREATE INDEX" || keywords == "CREATE UNIQUE":
+		return emptyResult(db.CreateIndex(sql))
+	case keywords == "CREATE VIEW":
+		return emptyResult(db.CreateView(sql))
+	case keywords == "DROP VIEW":
+		return emptyResult(db.DropView(sql))
+	}
+
+	return db.Pragma(sql)
+}
+
+// emptyResult is what a statement that changes the schema returns.
+func emptyResult(err error) (*Result, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{}, nil
+}
+
+// parseStatement parses a statement written in SQLite's dialect.
+func parseStatement(sql string) (sqlparser.Statement, error) {
+	translated, err := parser.Translate(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := sqlparser.Parse(translated)
+
+	if err != nil {
+		return nil, newParseError(err)
+	}
+
+	return statement, nil
+}
+
+// leadingKeywords names the kind of statement by its first two keywords in
+// upper case, such as "CREATE VIEW", looking past TEMP after CREATE.
+func leadingKeywords(sql string) string {
+	tokens, err := parser.Tokenize(sql)
+
+	if err != nil || len(tokens) < 2 {
+		return ""
+	}
+
+	if tokens[0].Is("CREATE") && len(tokens) > 2 && (tokens[1].Is("TEMP") || tokens[1].Is("TEMPORARY")) {
+		tokens = append([]parser.Token{tokens[0]}, tokens[2:]...)
+	}
+
+	return strings.ToUpper(tokens[0].Text) + " " + strings.ToUpper(tokens[1].Text)
+}
@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+)
+
+// Table is a table from sqlite_schema with its parsed definition.
+type Table struct {
+	Name        string
+	RootPage    int
+	Definition  *parser.CreateTable
+	RowidColumn int // position of the INTEGER PRIMARY KEY column, or -1
+	// real marks REAL columns, which store reals holding an integer as
+	// integers and read them back as reals
+	real []bool
+	// primaryKey is set for a WITHOUT ROWID table, whose b-tree is an index
+	// keyed by it. Its records start with the primary key columns and go on
+	// with the others in declared order; recordPosition maps each declared
+	// column to where it is in a record.
+	primaryKey     *Index
+	recordPosition []int
+	// view produces the rows of a view, or of a subquery or WITH table read
+	// like one, which has no b-tree
+	view queryPlan
+}
+
+// Table looks up a table by case-insensitive name.
+func (db *DB) Table(name string) (*Table, error) {
+	var table *Table
+
+	s := db.session()
+	err := s.read(func() (err error) {
+		table, err = s.table(name)
+		return err
+	})
+
+	return table, err
+}
+
+func (db *DB) table(name string) (*Table, error) {
+	schema, err := db.schema()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pointer := range schema {
+		if pointer.PageType != "table" || !strings.EqualFold(pointer.ObjName, name) {
+			continue
+		}
+
+		definition, err := parser.ParseCreateTable(pointer.CreateStatement)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: table %s: %v", page.ErrCorruptPage, pointer.ObjName, err)
+		}
+
+		real := make([]bool, len(definition.Columns))
+
+		for i, column := range definition.Columns {
+			real[i] = parser.ColumnAffinity(column.Type) == parser.RealAffinity
+		}
+
+		table := &Table{
+			Name:        pointer.ObjName,
+			RootPage:    int(pointer.PageNumber),
+			Definition:  definition,
+			RowidColumn: definition.RowidColumn(),
+			real:        real,
+		}
+
+		if definition.WithoutRowid {
+			if err := db.resolvePrimaryKey(table); err != nil {
+				return nil, err
+			}
+		}
+
+		return table, nil
+	}
+
+	return nil, noSuchTable(name)
+}
+
+// hasRowid reports whether rows have a rowid, which views and WITHOUT
+// ROWID tables don't.
+func (t *Table) hasRowid() bool {
+	return t.primaryKey == nil && t.view == nil
+}
+
+// ColumnNames lists the declared columns in order.
+func (t *Table) ColumnNames() []string {
+	names := make([]string, len(t.Definition.Columns))
+
+	for i, column := range t.Definition.Columns {
+		names[i] = column.Name
+	}
+
+	return names
+}
+
+// resolvePrimaryKey lays out the records of a WITHOUT ROWID table.
+func (db *DB) resolvePrimaryKey(t *Table) error {
+	primaryKey, err := db.primaryKeyIndex(t)
+
+	if err != nil {
+		return err
+	}
+
+	t.primaryKey = primaryKey
+	t.recordPosition = make([]int, len(t.Definition.Columns))
+
+	for i := range t.recordPosition {
+		t.recordPosition[i] = -1
+	}
+
+	for i, position := range primaryKey.Columns {
+		t.recordPosition[position] = i
+	}
+
+	next := len(primaryKey.Columns)
+
+	for i := range t.recordPosition {
+		if t.recordPosition[i] == -1 {
+			t.recordPosition[i] = next
+			next++
+		}
+	}
+
+	return nil
+}
+
+// rowValues maps a stored record onto the declared columns: the rowid alias
+// is stored as NULL and takes the rowid, the columns of a WITHOUT ROWID table
+// are put back in declared order, integers in REAL columns become reals, and
+// records written before an ALTER TABLE ADD COLUMN are padded with the column
+// defaults.
+func (t *Table) rowValues(rowID int64, stored []page.Value) []page.Value {
+	values := make([]page.Value, len(t.Definition.Columns))
+
+	for i := range values {
+		at := i
+
+		if t.recordPosition != nil {
+			at = t.recordPosition[i]
+		}
+
+		switch {
+		case i == t.RowidColumn:
+			values[i] = page.Value{Type: page.IntegerValue, Int: rowID}
+		case at < len(stored) && t.real[i] && stored[at].Type == page.IntegerValue:
+			values[i] = page.Value{Type: page.FloatValue, Float: float64(stored[at].Int)}
+		case at < len(stored):
+			values[i] = stored[at]
+		default:
+			values[i] = literalValue(t.Definition.Columns[i].Default)
+		}
+	}
+
+	return values
+}
+
+// literalValue evaluates a constant DEFAULT clause. Anything that isn't a
+// plain literal evaluates to NULL.
+func literalValue(text string) page.Value {
+	text = strings.TrimSpace(text)
+
+	for strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+
+	tokens, err := parser.Tokenize(text)
+
+	if err != nil || len(tokens) == 0 || len(tokens) > 2 {
+		return page.Value{Type: page.NullValue}
+	}
+
+	negative := false
+
+	if len(tokens) == 2 {
+		if !tokens[0].Is("-") && !tokens[0].Is("+") {
+			return page.Value{Type: page.NullValue}
+		}
+
+		negative = tokens[0].Is("-")
+		tokens = tokens[1:]
+	}
+
+	token := tokens[0]
+
+	switch token.Kind {
+	case parser.NumberToken:
+		if integer, err := strconv.ParseInt(token.Text, 0, 64); err == nil {
+			if negative {
+				integer = -integer
+			}
+
+			return page.Value{Type: page.IntegerValue, Int: integer}
+		}
+
+		if real, err := strconv.ParseFloat(token.Text, 64); err == nil {
+			if negative {
+				real = -real
+			}
+
+			return page.Value{Type: page.FloatValue, Float: real}
+		}
+	case parser.StringToken:
+		if !negative {
+			return page.Value{Type: page.TextValue, Bytes: []byte(token.Value())}
+		}
+	case parser.BlobToken:
+		if blob, err := hex.DecodeString(token.Value()); err == nil {
+			return page.Value{Type: page.BlobValue, Bytes: blob}
+		}
+	case parser.IdentToken:
+		switch {
+		case token.Is("TRUE"):
+			return page.Value{Type: page.IntegerValue, Int: 1}
+		case token.Is("FALSE"):
+			return page.Value{Type: page.IntegerValue, Int: 0}
+		}
+	}
+
+	return page.Value{Type: page.NullValue}
+}
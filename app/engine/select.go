@@ -0,0 +1,796 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// selectPlan is a compiled SELECT: the join producing rows, and how each
+// row becomes a result row.
+type selectPlan struct {
+	join    *join
+	columns []string
+	results []*expr
+	// distinct is set for SELECT DISTINCT, which drops a result row equal to
+	// one before it by the collations of the result columns
+	distinct bool
+	equality rowEquality
+	// aliases holds the AS name of each result column, or "", and nodes the
+	// expression it was compiled from, or nil for a column of *
+	aliases []string
+	nodes   []sqlparser.Expr
+	// aggregated marks the result columns that hold an aggregate
+	aggregated []bool
+	// aggregate queries first gather the joined rows into groups, then
+	// evaluate results, HAVING and ORDER BY on a row of one group that is
+	// extended with the aggregates' values
+	aggregate bool
+	calls     []*aggregateCall
+	groupBy   []*expr
+	groupKeys []page.SortKey
+	having    *expr
+	// window function values take up the slots after the aggregates', and
+	// are computed in passes over the rows results are computed from
+	passes  []*windowPass
+	width   int
+	orderBy []orderTerm
+	limit   *expr
+	offset  *expr
+}
+
+// orderTerm is a term of ORDER BY: a result column, or an expression of the
+// row the results are computed from.
+type orderTerm struct {
+	result int // index into results, or -1
+	value  *expr
+	key    page.SortKey
+}
+
+// errLimitReached stops the scan once LIMIT rows have been produced. A query
+// only takes it for its own when it has reached its limit, since an emit
+// callback may return it to stop a query around this one.
+var errLimitReached = errors.New("limit reached")
+
+// compileSelect plans a SELECT of the statement planning describes. outer
+// connects a subquery to the query it is part of, and is nil otherwise.
+func (db *DB) compileSelect(node *sqlparser.Select, planning *planning, outer *correlation) (*selectPlan, error) {
+	names := &scope{db: db, planning: planning, outer: outer, aliases: make(map[string]sqlparser.Expr)}
+
+	for _, selectExpr := range node.SelectExprs {
+		if aliased, ok := selectExpr.(*sqlparser.AliasedExpr); ok && !aliased.As.IsEmpty() {
+			names.aliases[aliased.As.Lowered()] = aliased.Expr
+		}
+	}
+
+	join, err := db.planJoin(names, fromClause(db, node.From), node.Where)
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := &aggregates{base: join.width}
+	calls := &windows{}
+	plan := &selectPlan{join: join}
+
+	// the select list sees neither aliases nor, until grouping is known,
+	// anything but aggregates and columns
+	aliases := names.aliases
+	names.aliases = nil
+	names.aggregates = set
+	names.windows = calls
+
+	for i, selectExpr := range node.SelectExprs {
+		switch selectExpr := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			if err := plan.expandStar(names, selectExpr); err != nil {
+				return nil, err
+			}
+		case *sqlparser.AliasedExpr:
+			calls := len(set.calls)
+			result, err := compileExpr(names, selectExpr.Expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			plan.columns = append(plan.columns, resultName(selectExpr, result, planning.texts[node], i, len(node.SelectExprs)))
+			plan.results = append(plan.results, result)
+			plan.aliases = append(plan.aliases, selectExpr.As.String())
+			plan.nodes = append(plan.nodes, selectExpr.Expr)
+			plan.aggregated = append(plan.aggregated, len(set.calls) > calls)
+		default:
+			return nil, fmt.Errorf("%w: %s in the select list", ErrUnsupported, sqlparser.String(selectExpr))
+		}
+	}
+
+	names.aliases = aliases
+	names.windows = nil
+
+	if node.Distinct != "" {
+		plan.distinct = true
+
+		if plan.equality, err = db.rowEquality(collationsOf(plan.results)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := plan.compileGroupBy(names, node.GroupBy); err != nil {
+		return nil, err
+	}
+
+	if node.Having != nil {
+		if plan.having, err = compileExpr(names, node.Having.Expr); err != nil {
+			return nil, err
+		}
+	}
+
+	names.windows = calls
+
+	if err := plan.compileOrderBy(names, node.OrderBy); err != nil {
+		return nil, err
+	}
+
+	names.windows = nil
+	plan.calls = set.calls
+	calls.base = join.width + len(set.calls)
+	plan.passes = windowPasses(calls)
+	plan.width = calls.base + len(calls.calls)
+	plan.aggregate = len(plan.calls) > 0 || len(plan.groupBy) > 0
+
+	if node.Having != nil && !plan.aggregate {
+		return nil, errors.New("HAVING clause on a non-aggregate query")
+	}
+
+	constant := &scope{db: db, planning: planning, outer: outer}
+
+	if plan.limit, plan.offset, err = compileLimit(constant, node.Limit); err != nil {
+		return nil, err
+	}
+
+	names.subqueries = append(names.subqueries, constant.subqueries...)
+
+	// rows that are all sorted may be read in any order, unless what is
+	// made of them depends on which comes first
+	if len(join.scope.sources) > 0 && join.scope.sources[0].split != nil {
+		join.scope.sources[0].split.ordered = len(plan.orderBy) == 0 || plan.aggregate || plan.distinct || len(plan.passes) > 0
+	}
+
+	return plan, nil
+}
+
+// compileLimit compiles LIMIT and OFFSET, which are nil when absent.
+func compileLimit(scope *scope, node *sqlparser.Limit) (limit *expr, offset *expr, err error) {
+	if node == nil {
+		return nil, nil, nil
+	}
+
+	if limit, err = compileExpr(scope, node.Rowcount); err != nil {
+		return nil, nil, err
+	}
+
+	if node.Offset != nil {
+		if offset, err = compileExpr(scope, node.Offset); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// expandStar adds the columns * or table.* stands for. An unqualified *
+// leaves out the columns a USING or NATURAL join merged into the left side.
+func (p *selectPlan) expandStar(scope *scope, star *sqlparser.StarExpr) error {
+	qualifier := star.TableName.Name.String()
+
+	if len(scope.sources) == 0 {
+		return errors.New("no tables specified")
+	}
+
+	found := false
+
+	for i, source := range scope.sources {
+		if qualifier != "" && !strings.EqualFold(source.name, qualifier) {
+			continue
+		}
+
+		found = true
+
+		for position := range source.table.Definition.Columns {
+			if qualifier == "" && source.hidden[position] {
+				continue
+			}
+
+			column := columnExpr(i, source, position)
+
+			p.columns = append(p.columns, column.name)
+			p.results = append(p.results, column)
+			p.aliases = append(p.aliases, "")
+			p.nodes = append(p.nodes, nil)
+			p.aggregated = append(p.aggregated, false)
+		}
+	}
+
+	if !found {
+		return noSuchTable(qualifier)
+	}
+
+	return nil
+}
+
+// resultName is the heading of the i-th of count result columns: its alias,
+// the declared name of a column it refers to, or else its source text.
+func resultName(aliased *sqlparser.AliasedExpr, result *expr, texts []string, i int, count int) string {
+	_, column := aliased.Expr.(*sqlparser.ColName)
+
+	switch {
+	case !aliased.As.IsEmpty():
+		return aliased.As.String()
+	case column && result.name != "":
+		return result.name
+	case len(texts) == count:
+		return texts[i]
+	}
+
+	return sqlparser.String(aliased.Expr)
+}
+
+// fromClause drops the FROM dual sqlparser adds to a SELECT without one,
+// unless there is a table by that name.
+func fromClause(db *DB, from sqlparser.TableExprs) sqlparser.TableExprs {
+	if len(from) != 1 || sqlparser.String(from) != "dual" {
+		return from
+	}
+
+	if _, err := db.table("dual"); err == nil {
+		return from
+	}
+
+	return nil
+}
+
+// resultReference finds the result column the position-th term of an
+// ORDER BY or GROUP BY names by number, or, for ORDER BY, by alias.
+func resultReference(node sqlparser.Expr, clause string, position int, aliases []string) (int, bool, error) {
+	switch node := node.(type) {
+	case *sqlparser.SQLVal:
+		if node.Type != sqlparser.IntVal {
+			return 0, false, nil
+		}
+
+		number, err := strconv.Atoi(string(node.Val))
+
+		if err != nil || number < 1 || number > len(aliases) {
+			return 0, false, fmt.Errorf("%s %s term out of range - should be between 1 and %d", ordinal(position), clause, len(aliases))
+		}
+
+		return number - 1, true, nil
+	case *sqlparser.ColName:
+		if clause != "ORDER BY" || !node.Qualifier.IsEmpty() {
+			return 0, false, nil
+		}
+
+		for i, alias := range aliases {
+			if alias != "" && strings.EqualFold(alias, node.Name.String()) {
+				return i, true, nil
+			}
+		}
+	}
+
+	return 0, false, nil
+}
+
+// compileGroupBy compiles the grouping terms, which are evaluated on joined
+// rows and so can't hold aggregates. A number names a result column.
+func (p *selectPlan) compileGroupBy(scope *scope, groupBy sqlparser.GroupBy) error {
+	set := scope.aggregates
+	scope.aggregates = nil
+	defer func() { scope.aggregates = set }()
+
+	for i, node := range groupBy {
+		index, ok, err := resultReference(node, "GROUP BY", i+1, p.aliases)
+
+		if err != nil {
+			return err
+		}
+
+		var value *expr
+
+		if ok {
+			if p.aggregated[index] {
+				return errors.New("aggregate functions are not allowed in the GROUP BY clause")
+			}
+
+			value = p.results[index]
+		} else if value, err = compileExpr(scope, node); err != nil {
+			if strings.HasPrefix(err.Error(), "misuse of aggregate") {
+				return errors.New("aggregate functions are not allowed in the GROUP BY clause")
+			}
+
+			return err
+		}
+
+		p.groupBy = append(p.groupBy, value)
+		compare, err := scope.db.collation(value.collation)
+
+		if err != nil {
+			return err
+		}
+
+		p.groupKeys = append(p.groupKeys, page.SortKey{Collation: compare})
+	}
+
+	return nil
+}
+
+// compileOrderBy compiles ORDER BY. A number or an alias names a result
+// column; anything else is an expression, sorted by its own collation.
+func (p *selectPlan) compileOrderBy(scope *scope, orderBy sqlparser.OrderBy) (err error) {
+	p.orderBy, err = compileOrderTerms(scope, orderBy, p.results, p.aliases)
+
+	return err
+}
+
+// compileOrderTerms compiles the terms of an ORDER BY over results, whose
+// aliases are given.
+func compileOrderTerms(scope *scope, orderBy sqlparser.OrderBy, results []*expr, aliases []string) ([]orderTerm, error) {
+	var terms []orderTerm
+
+	for i, order := range orderBy {
+		node := order.Expr
+		collationName := ""
+		explicit := false
+
+		if collated, ok := node.(*sqlparser.CollateExpr); ok {
+			node = collated.Expr
+			collationName = collated.Charset
+			explicit = true
+		}
+
+		term := orderTerm{result: -1}
+		index, ok, err := resultReference(node, "ORDER BY", i+1, aliases)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			term.result = index
+
+			if !explicit {
+				collationName = results[index].collation
+			}
+		} else {
+			if term.value, err = compileExpr(scope, order.Expr); err != nil {
+				return nil, err
+			}
+
+			collationName = term.value.collation
+		}
+
+		compare, err := scope.db.collation(collationName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		term.key = page.SortKey{Descending: order.Direction == sqlparser.DescScr, Collation: compare}
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// orderKeys evaluates the ORDER BY terms for a row and its results.
+func orderKeys(terms []orderTerm, row []page.Value, results []page.Value) ([]page.Value, error) {
+	keys := make([]page.Value, len(terms))
+
+	for i, term := range terms {
+		if term.result != -1 {
+			keys[i] = results[term.result]
+			continue
+		}
+
+		value, err := term.value.eval(row)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys[i] = value
+	}
+
+	return keys, nil
+}
+
+// sortKeys is how the values orderKeys returns compare.
+func sortKeys(terms []orderTerm) []page.SortKey {
+	keys := make([]page.SortKey, len(terms))
+
+	for i, term := range terms {
+		keys[i] = term.key
+	}
+
+	return keys
+}
+
+// ordinal spells out a term's position as SQLite's messages do: 1st, 2nd...
+func ordinal(position int) string {
+	suffix := "th"
+
+	switch {
+	case position%100 >= 11 && position%100 <= 13:
+	case position%10 == 1:
+		suffix = "st"
+	case position%10 == 2:
+		suffix = "nd"
+	case position%10 == 3:
+		suffix = "rd"
+	}
+
+	return strconv.Itoa(position) + suffix
+}
+
+// limits evaluates LIMIT and OFFSET. A negative limit means no limit.
+func (p *selectPlan) limits() (limit int64, offset int64, err error) {
+	return evalLimits(p.limit, p.offset)
+}
+
+// evalLimits evaluates the expressions of LIMIT and OFFSET, either of which
+// may be nil.
+func evalLimits(limitExpr *expr, offsetExpr *expr) (limit int64, offset int64, err error) {
+	limit = -1
+
+	evaluate := func(e *expr) (int64, error) {
+		value, err := e.eval(nil)
+
+		if err != nil {
+			return 0, err
+		}
+
+		value = applyAffinity(value, parser.NumericAffinity)
+
+		if value.Type != page.IntegerValue {
+			return 0, ErrMismatch
+		}
+
+		return value.Int, nil
+	}
+
+	if limitExpr != nil {
+		if limit, err = evaluate(limitExpr); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if offsetExpr != nil {
+		if offset, err = evaluate(offsetExpr); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return limit, max(offset, 0), nil
+}
+
+// run calls emit with each result row, in order and within the limits.
+func (p *selectPlan) run(db *DB, emit func(row []page.Value) error) error {
+	limit, offset, err := p.limits()
+
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	var seen *distinctSet
+	var sorted *sorter
+
+	if p.distinct {
+		seen = db.newDistinctSet(p.equality)
+		defer seen.close()
+	}
+
+	// ORDER BY sorts rows of the sort keys followed by the results
+	if len(p.orderBy) > 0 {
+		sorted = db.newSorter(sortKeys(p.orderBy))
+		defer sorted.close()
+	}
+
+	reached := false
+
+	output := func(results []page.Value) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+
+		if err := emit(results); err != nil {
+			return err
+		}
+
+		if limit--; limit == 0 {
+			reached = true
+			return errLimitReached
+		}
+
+		return nil
+	}
+
+	produce := func(row []page.Value) error {
+		results, err := evalAll(p.results, row)
+
+		if err != nil {
+			return err
+		}
+
+		if seen != nil {
+			if first, err := seen.add(results); err != nil || !first {
+				return err
+			}
+		}
+
+		if sorted == nil {
+			return output(results)
+		}
+
+		keys, err := orderKeys(p.orderBy, row, results)
+
+		if err != nil {
+			return err
+		}
+
+		return sorted.add(append(keys, results...))
+	}
+
+	// rows go through the window passes before they are produced
+	feed := produce
+	var windowed *windowing
+
+	if len(p.passes) > 0 {
+		if windowed, err = db.newWindowing(p.passes, p.width, produce); err != nil {
+			return err
+		}
+
+		defer windowed.close()
+		feed = windowed.add
+	}
+
+	if p.aggregate {
+		err = p.runGroups(db, feed)
+	} else {
+		err = p.join.run(db, feed)
+	}
+
+	if err == nil && windowed != nil {
+		err = windowed.finish()
+	}
+
+	if err == nil && sorted != nil {
+		err = sorted.each(func(row []page.Value) error {
+			return output(row[len(p.orderBy):])
+		})
+	}
+
+	if reached && errors.Is(err, errLimitReached) {
+		return nil
+	}
+
+	return err
+}
+
+// sortedRow is a result row waiting for ORDER BY, with its sort keys.
+type sortedRow struct {
+	keys    []page.Value
+	results []page.Value
+}
+
+// group is the state of one GROUP BY group: its key, the row its bare
+// columns come from, and each aggregate's accumulator.
+type group struct {
+	key    []page.Value
+	row    []page.Value
+	states []aggregator
+	// distinct holds the arguments each DISTINCT aggregate has seen, and is
+	// nil for the others
+	distinct []*distinctSet
+	seen     bool // whether a row has joined the group yet
+}
+
+func (p *selectPlan) newGroup(db *DB, key []page.Value) *group {
+	g := &group{key: key, row: make([]page.Value, p.join.width)}
+
+	for i := range g.row {
+		g.row[i] = nullValue
+	}
+
+	for _, call := range p.calls {
+		g.states = append(g.states, call.new())
+
+		var seen *distinctSet
+
+		if call.distinct {
+			seen = db.newDistinctSet(call.equality)
+		}
+
+		g.distinct = append(g.distinct, seen)
+	}
+
+	return g
+}
+
+// close deletes the temporary files of the group's DISTINCT aggregates.
+func (g *group) close() {
+	for _, seen := range g.distinct {
+		if seen != nil {
+			seen.close()
+		}
+	}
+}
+
+// runGroups gathers the joined rows into groups and calls produce with a
+// row for each group, in the order of the GROUP BY key. The joined rows are
+// sorted by their key, which brings the rows of each group together. Bare
+// columns take their values from the first row of the group, or from the
+// row that decided a min() or max() when that is the only aggregate.
+func (p *selectPlan) runGroups(db *DB, produce func(row []page.Value) error) error {
+	var g *group
+
+	defer func() {
+		if g != nil {
+			g.close()
+		}
+	}()
+
+	if len(p.groupBy) == 0 {
+		// without GROUP BY there is one group, even when no row joins
+		g = p.newGroup(db, nil)
+
+		err := p.join.run(db, func(row []page.Value) error {
+			return p.step(g, row)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return p.finish(g, produce)
+	}
+
+	// the sorter's rows are the key followed by the joined row
+	sorted := db.newSorter(p.groupKeys)
+	defer sorted.close()
+
+	err := p.join.run(db, func(row []page.Value) error {
+		key, err := evalAll(p.groupBy, row)
+
+		if err != nil {
+			return err
+		}
+
+		return sorted.add(append(key, row...))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	width := len(p.groupBy)
+
+	err = sorted.each(func(row []page.Value) error {
+		key := row[:width]
+
+		if g != nil && page.CompareRecords(g.key, key, p.groupKeys) != 0 {
+			finished := g
+			g = nil
+
+			if err := p.finish(finished, produce); err != nil {
+				return err
+			}
+		}
+
+		if g == nil {
+			g = p.newGroup(db, key)
+		}
+
+		return p.step(g, row[width:])
+	})
+
+	if err != nil || g == nil {
+		return err
+	}
+
+	return p.finish(g, produce)
+}
+
+// step adds a joined row to its group.
+func (p *selectPlan) step(g *group, row []page.Value) error {
+	for i, call := range p.calls {
+		args, err := evalAll(call.args, row)
+
+		if err != nil {
+			return err
+		}
+
+		if g.distinct[i] != nil {
+			if first, err := g.distinct[i].add(args); err != nil {
+				return err
+			} else if !first {
+				continue
+			}
+		}
+
+		if err := g.states[i].step(args); err != nil {
+			return err
+		}
+	}
+
+	// bare columns come from the first row, or from the row that decided a
+	// min() or max() that is the only aggregate
+	take := !g.seen
+
+	if len(p.calls) == 1 && p.calls[0].extremum {
+		take = take || g.states[0].(*extremum).changed
+	}
+
+	if take {
+		copy(g.row, row)
+	}
+
+	g.seen = true
+
+	return nil
+}
+
+// finish calls produce with the row of a complete group, extended with the
+// aggregates' values, unless HAVING rejects it.
+func (p *selectPlan) finish(g *group, produce func(row []page.Value) error) error {
+	defer g.close()
+
+	row := make([]page.Value, p.join.width+len(p.calls))
+	copy(row, g.row)
+
+	for i, state := range g.states {
+		value, err := state.result()
+
+		if err != nil {
+			return err
+		}
+
+		row[p.join.width+i] = value
+	}
+
+	if p.having != nil {
+		if ok, err := passes([]*expr{p.having}, row); err != nil || !ok {
+			return err
+		}
+	}
+
+	return produce(row)
+}
+
+// groupKey encodes a row so that values the collations of its columns
+// consider equal encode the same: numbers by value, and text folded the way
+// its collation compares it.
+func groupKey(key []page.Value, folds []func(text string) string) string {
+	normalized := make([]page.Value, len(key))
+
+	for i, value := range key {
+		switch value.Type {
+		case page.FloatValue:
+			if integer, ok := exactInteger(value.Float); ok {
+				value = page.Value{Type: page.IntegerValue, Int: integer}
+			}
+		case page.TextValue:
+			if folds[i] != nil {
+				value = textValue(folds[i](string(value.Bytes)))
+			}
+		}
+
+		normalized[i] = value
+	}
+
+	return string(page.EncodeRecord(normalized, false))
+}
@@ -0,0 +1,331 @@
+package engine
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"io"
+	"os"
+	"sort"
+)
+
+// defaultSortMemory is the memory limit of a new connection's sorts.
+const defaultSortMemory = 64 << 20
+
+// SetSortMemory sets how many bytes of rows a sort, or a DISTINCT, keeps in
+// memory before it writes them to temporary files. PRAGMA sort_memory sets
+// it too.
+func (db *DB) SetSortMemory(bytes int) {
+	db.sortMemory.Store(int64(max(bytes, 0)))
+}
+
+func (db *DB) SortMemory() int {
+	return int(db.sortMemory.Load())
+}
+
+// mergeWidth is how many runs a sorter merges at once. With more runs than
+// that, groups of them are first merged into longer runs, so that the
+// buffers the merge reads through stay few however large the sort.
+const mergeWidth = 16
+
+// sorter sorts rows that may not fit in memory, by the keys of their
+// leading columns. Rows are buffered until they take up the connection's
+// sort memory, then sorted and written to a temporary file as a run. Once
+// every row is in, the runs are merged. Rows that compare equal come out in
+// the order they went in.
+type sorter struct {
+	keys   []page.SortKey
+	memory int
+	rows   [][]page.Value
+	size   int
+	// file holds the runs one after another, and end is where the next one
+	// goes
+	file *os.File
+	end  int64
+	runs []*sortRun
+}
+
+func (db *DB) newSorter(keys []page.SortKey) *sorter {
+	return &sorter{keys: keys, memory: db.SortMemory()}
+}
+
+// add adds a copy of row.
+func (s *sorter) add(row []page.Value) error {
+	s.rows = append(s.rows, append([]page.Value(nil), row...))
+
+	if s.size += rowSize(row); s.size > s.memory {
+		return s.writeRun()
+	}
+
+	return nil
+}
+
+func (s *sorter) compare(a []page.Value, b []page.Value) int {
+	return page.CompareRecords(a[:len(s.keys)], b[:len(s.keys)], s.keys)
+}
+
+func (s *sorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return s.compare(s.rows[i], s.rows[j]) < 0
+	})
+}
+
+// writeRun sorts the buffered rows into a run of their own.
+func (s *sorter) writeRun() error {
+	s.sortRows()
+
+	if s.file == nil {
+		file, err := os.CreateTemp("", "etilqs_")
+
+		if err != nil {
+			return err
+		}
+
+		s.file = file
+	}
+
+	writer := newRunWriter(s.file, s.end, len(s.runs))
+
+	for _, row := range s.rows {
+		if err := writer.write(row); err != nil {
+			return err
+		}
+	}
+
+	run, err := writer.finish()
+
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, run)
+	s.end += run.length
+	s.rows = nil
+	s.size = 0
+
+	return nil
+}
+
+// each visits the rows in order. It is called once, after every add.
+func (s *sorter) each(visit func(row []page.Value) error) error {
+	if len(s.runs) == 0 {
+		s.sortRows()
+
+		for _, row := range s.rows {
+			if err := visit(row); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(s.rows) > 0 {
+		if err := s.writeRun(); err != nil {
+			return err
+		}
+	}
+
+	for len(s.runs) > mergeWidth {
+		if err := s.mergePass(); err != nil {
+			return err
+		}
+	}
+
+	return s.merge(s.runs, visit)
+}
+
+// mergePass merges each group of mergeWidth runs into one run of a new file,
+// which then replaces the old one. The runs keep their order, so rows that
+// compare equal still come out in the order they went in.
+func (s *sorter) mergePass() error {
+	file, err := os.CreateTemp("", "etilqs_")
+
+	if err != nil {
+		return err
+	}
+
+	var runs []*sortRun
+	var end int64
+
+	for start := 0; start < len(s.runs) && err == nil; start += mergeWidth {
+		writer := newRunWriter(file, end, len(runs))
+
+		if err = s.merge(s.runs[start:min(start+mergeWidth, len(s.runs))], writer.write); err != nil {
+			break
+		}
+
+		var run *sortRun
+
+		if run, err = writer.finish(); err == nil {
+			runs = append(runs, run)
+			end += run.length
+		}
+	}
+
+	old := s.file
+	s.file, s.end, s.runs = file, end, runs
+
+	return errors.Join(err, old.Close(), os.Remove(old.Name()))
+}
+
+// merge visits the rows of the runs in order.
+func (s *sorter) merge(runs []*sortRun, visit func(row []page.Value) error) error {
+	merge := &runMerge{sorter: s}
+
+	for _, run := range runs {
+		run.rewind(s.file)
+
+		if ok, err := run.next(); err != nil {
+			return err
+		} else if ok {
+			merge.runs = append(merge.runs, run)
+		}
+	}
+
+	heap.Init(merge)
+
+	for merge.Len() > 0 {
+		run := merge.runs[0]
+
+		if err := visit(run.row); err != nil {
+			return err
+		}
+
+		if ok, err := run.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(merge, 0)
+		} else {
+			heap.Pop(merge)
+		}
+	}
+
+	return nil
+}
+
+// close deletes the sorter's temporary file.
+func (s *sorter) close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+	s.file = nil
+	s.runs = nil
+
+	return err
+}
+
+// sortRun is a stretch of the sorter's file holding sorted rows, each a
+// record preceded by its length.
+type sortRun struct {
+	// number is the run's place among the sorter's runs
+	number int
+	offset int64
+	length int64
+	reader *bufio.Reader
+	// row is the row read last
+	row []page.Value
+}
+
+func (r *sortRun) rewind(file *os.File) {
+	r.reader = bufio.NewReader(io.NewSectionReader(file, r.offset, r.length))
+}
+
+// next reads the next row, and reports whether there was one. Once there
+// are none, the run lets go of its buffer.
+func (r *sortRun) next() (bool, error) {
+	length, err := binary.ReadUvarint(r.reader)
+
+	if errors.Is(err, io.EOF) {
+		r.reader = nil
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	record := make([]byte, length)
+
+	if _, err := io.ReadFull(r.reader, record); err != nil {
+		return false, err
+	}
+
+	r.row, err = page.DecodeRecord(record)
+
+	return err == nil, err
+}
+
+// runWriter writes a run at an offset in a file.
+type runWriter struct {
+	writer *bufio.Writer
+	run    *sortRun
+}
+
+func newRunWriter(file *os.File, offset int64, number int) *runWriter {
+	return &runWriter{
+		writer: bufio.NewWriter(io.NewOffsetWriter(file, offset)),
+		run:    &sortRun{number: number, offset: offset},
+	}
+}
+
+func (w *runWriter) write(row []page.Value) error {
+	record := page.EncodeRecord(row, false)
+	length := binary.AppendUvarint(nil, uint64(len(record)))
+
+	if _, err := w.writer.Write(length); err != nil {
+		return err
+	}
+
+	if _, err := w.writer.Write(record); err != nil {
+		return err
+	}
+
+	w.run.length += int64(len(length) + len(record))
+
+	return nil
+}
+
+func (w *runWriter) finish() (*sortRun, error) {
+	return w.run, w.writer.Flush()
+}
+
+// runMerge is a heap of the runs being merged, by the row each has read.
+// Of equal rows, the one from the earlier run comes first.
+type runMerge struct {
+	sorter *sorter
+	runs   []*sortRun
+}
+
+func (m *runMerge) Len() int {
+	return len(m.runs)
+}
+
+func (m *runMerge) Less(i, j int) bool {
+	c := m.sorter.compare(m.runs[i].row, m.runs[j].row)
+
+	if c == 0 {
+		return m.runs[i].number < m.runs[j].number
+	}
+
+	return c < 0
+}
+
+func (m *runMerge) Swap(i, j int) {
+	m.runs[i], m.runs[j] = m.runs[j], m.runs[i]
+}
+
+func (m *runMerge) Push(x any) {
+	m.runs = append(m.runs, x.(*sortRun))
+}
+
+func (m *runMerge) Pop() any {
+	last := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+
+	return last
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"time"
+)
+
+// Stats describes what running a statement cost.
+type Stats struct {
+	Elapsed time.Duration
+	// PagesRead counts pages read from the file, and CacheHits those the
+	// page cache already held
+	PagesRead int64
+	CacheHits int64
+	// CellsDecoded counts b-tree cells decoded, and OverflowPages the
+	// overflow pages followed for payloads too large for their page
+	CellsDecoded  int64
+	OverflowPages int64
+	// FullScanSteps counts the rows full scans of tables stepped through,
+	// which sqlite3 reports as Fullscan Steps
+	FullScanSteps int64
+	// RowsScanned counts the rows the joins read from their sources, and
+	// RowsReturned the result rows
+	RowsScanned  int64
+	RowsReturned int64
+	// IndexSeeks counts rowid lookups and index searches
+	IndexSeeks int64
+}
+
+// Stats returns what running the statement that produced the result cost.
+func (r *Result) Stats() Stats {
+	return r.stats
+}
+
+// measure runs a statement and gives its result the statistics of the run.
+func (db *DB) measure(run func() (*Result, error)) (*Result, error) {
+	start, before := time.Now(), db.counters()
+	result, err := run()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.stats = db.counters().since(before)
+	result.stats.Elapsed = time.Since(start)
+	result.stats.RowsReturned = int64(len(result.Rows))
+
+	return result, nil
+}
+
+// counters reads the running totals that Stats are the difference of.
+func (db *DB) counters() Stats {
+	pager := db.pager.Stats()
+
+	return Stats{
+		PagesRead:     pager.PagesRead,
+		CacheHits:     pager.CacheHits,
+		CellsDecoded:  pager.CellsDecoded,
+		OverflowPages: pager.OverflowPages,
+		FullScanSteps: db.fullScanSteps,
+		RowsScanned:   db.rowsScanned,
+		IndexSeeks:    pager.Seeks,
+	}
+}
+
+// since returns the work done between the readings before and s.
+func (s Stats) since(before Stats) Stats {
+	return Stats{
+		PagesRead:     s.PagesRead - before.PagesRead,
+		CacheHits:     s.CacheHits - before.CacheHits,
+		CellsDecoded:  s.CellsDecoded - before.CellsDecoded,
+		OverflowPages: s.OverflowPages - before.OverflowPages,
+		FullScanSteps: s.FullScanSteps - before.FullScanSteps,
+		RowsScanned:   s.RowsScanned - before.RowsScanned,
+		IndexSeeks:    s.IndexSeeks - before.IndexSeeks,
+	}
+}
+
+// plus returns the work of s and other together.
+func (s Stats) plus(other Stats) Stats {
+	return Stats{
+		Elapsed:       s.Elapsed + other.Elapsed,
+		PagesRead:     s.PagesRead + other.PagesRead,
+		CacheHits:     s.CacheHits + other.CacheHits,
+		CellsDecoded:  s.CellsDecoded + other.CellsDecoded,
+		OverflowPages: s.OverflowPages + other.OverflowPages,
+		FullScanSteps: s.FullScanSteps + other.FullScanSteps,
+		RowsScanned:   s.RowsScanned + other.RowsScanned,
+		RowsReturned:  s.RowsReturned + other.RowsReturned,
+		IndexSeeks:    s.IndexSeeks + other.IndexSeeks,
+	}
+}
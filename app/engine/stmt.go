@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Stmt is a prepared statement: parsed and planned once, then run as many
+// times as needed with the values bound to its parameters at the time.
+// Statements that change the schema aren't planned ahead, and take no
+// parameters. A statement runs in a session of its own, and is used by one
+// goroutine at a time.
+type Stmt struct {
+	db  *DB
+	sql string
+	// plan is nil for a statement that isn't a SELECT. cookie is the schema
+	// cookie it was planned under; once the schema changes, the statement
+	// is planned again.
+	plan     resultPlan
+	planning *planning
+	cookie   uint32
+	// explain is set for EXPLAIN QUERY PLAN, whose sql is the statement it
+	// explains
+	explain bool
+	// preparing is what Prepare cost, which the stats of the first run
+	// include, as those of DB.Query include planning
+	preparing Stats
+}
+
+// bindings holds the values bound to the parameters of a statement, which
+// its plan reads as it runs. Parameters nothing was bound to are NULL.
+type bindings struct {
+	*parser.Parameters
+	values []page.Value
+}
+
+func newBindings(parameters *parser.Parameters) *bindings {
+	b := &bindings{Parameters: parameters, values: make([]page.Value, len(parameters.Names))}
+	b.clear()
+
+	return b
+}
+
+func (b *bindings) clear() {
+	for i := range b.values {
+		b.values[i] = nullValue
+	}
+}
+
+// Prepare parses and plans a statement. EXPLAIN QUERY PLAN is planned as
+// the query it is followed by, which it describes rather than runs.
+func (db *DB) Prepare(sql string) (*Stmt, error) {
+	s := db.session()
+	start, before := time.Now(), s.counters()
+	stmt, err := s.prepareStatement(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stmt.preparing = s.counters().since(before)
+	stmt.preparing.Elapsed = time.Since(start)
+
+	return stmt, nil
+}
+
+func (db *DB) prepareStatement(sql string) (*Stmt, error) {
+	query, explain := explainedQuery(sql)
+	stmt := &Stmt{db: db, sql: query, explain: explain}
+
+	if !explain && strings.HasPrefix(leadingKeywords(query), "EXPLAIN ") {
+		return nil, fmt.Errorf("%w: EXPLAIN without QUERY PLAN", ErrUnsupported)
+	}
+
+	if !explain && schemaStatement(query) {
+		return stmt, nil
+	}
+
+	if err := db.read(stmt.prepare); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// prepare plans the statement, keeping the values bound to it.
+func (s *Stmt) prepare() error {
+	statement, planning, err := parseQuery(s.sql)
+
+	if err != nil {
+		return err
+	}
+
+	selectStatement, ok := statement.(sqlparser.SelectStatement)
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(statement))
+	}
+
+	if s.planning != nil {
+		planning.parameters = s.planning.parameters
+	}
+
+	cookie := s.db.pager.Header().SchemaCookie
+	plan, err := s.db.compileQuery(selectStatement, planning, nil)
+
+	if err != nil {
+		return err
+	}
+
+	s.plan, s.planning, s.cookie = plan, planning, cookie
+
+	return nil
+}
+
+// Explain reports whether the statement is EXPLAIN QUERY PLAN, whose
+// result describes the plan of the query it is followed by.
+func (s *Stmt) Explain() bool {
+	return s.explain
+}
+
+// ParameterCount returns the largest parameter number of the statement.
+func (s *Stmt) ParameterCount() int {
+	if s.planning == nil {
+		return 0
+	}
+
+	return len(s.planning.parameters.values)
+}
+
+// ParameterName returns the name of a parameter as it was written, such as
+// :name or ?2, or "" for a parameter written as ? or out of range.
+// Parameters are numbered from 1.
+func (s *Stmt) ParameterName(index int) string {
+	if index < 1 || index > s.ParameterCount() {
+		return ""
+	}
+
+	return s.planning.parameters.Names[index-1]
+}
+
+// ParameterIndex returns the number of the parameter with the given name,
+// prefix included, or 0 when there is none.
+func (s *Stmt) ParameterIndex(name string) int {
+	if s.planning == nil {
+		return 0
+	}
+
+	return s.planning.parameters.Index(name)
+}
+
+// Bind binds a value to a parameter by its number, from 1. The value stays
+// bound until it is replaced or ClearBindings is called.
+func (s *Stmt) Bind(index int, value page.Value) error {
+	if index < 1 || index > s.ParameterCount() {
+		return fmt.Errorf("%w: %d", ErrRange, index)
+	}
+
+	s.planning.parameters.values[index-1] = value
+
+	return nil
+}
+
+// BindName binds a value to a parameter by its name, prefix included.
+func (s *Stmt) BindName(name string, value page.Value) error {
+	index := s.ParameterIndex(name)
+
+	if index == 0 {
+		return fmt.Errorf("%w: no parameter named %s", ErrRange, name)
+	}
+
+	return s.Bind(index, value)
+}
+
+// ClearBindings sets every parameter back to NULL.
+func (s *Stmt) ClearBindings() {
+	if s.planning != nil {
+		s.planning.parameters.clear()
+	}
+}
+
+// Query runs the statement with the values bound to it.
+func (s *Stmt) Query() (*Result, error) {
+	return s.QueryContext(context.Background())
+}
+
+// QueryContext runs the statement with the values bound to it, and stops
+// it with ErrInterrupted once ctx is done.
+func (s *Stmt) QueryContext(ctx context.Context) (*Result, error) {
+	result, err := s.db.interruptible(ctx, func() (*Result, error) {
+		return s.db.measure(s.run)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.stats = result.stats.plus(s.preparing)
+	s.preparing = Stats{}
+
+	return result, nil
+}
+
+// run runs the statement. A query reads under a SHARED lock for the whole
+// run, so that it sees the file as it was when it started.
+func (s *Stmt) run() (*Result, error) {
+	if s.plan == nil {
+		return s.db.execute(s.sql)
+	}
+
+	var result *Result
+
+	err := s.db.read(func() error {
+		if s.db.pager.Header().SchemaCookie != s.cookie {
+			if err := s.prepare(); err != nil {
+				return err
+			}
+		}
+
+		if s.explain {
+			result = s.explainPlan()
+			return nil
+		}
+
+		s.db.runs++
+
+		var rows [][]page.Value
+
+		err := s.plan.run(s.db, func(row []page.Value) error {
+			rows = append(rows, row)
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		columns, _ := s.plan.heading()
+		result = &Result{Columns: columns, Rows: rows}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parameter compiles a parameter, which Translate writes as a value argument
+// named by its position, into a reference to the value bound to it.
+func (s *scope) parameter(node *sqlparser.SQLVal) (*expr, error) {
+	text := string(node.Val)
+	position, err := strconv.Atoi(strings.TrimPrefix(text, parser.ParameterPrefix))
+	bound := s.planning.parameters
+	number, ok := bound.Numbers[position]
+
+	if err != nil || !strings.HasPrefix(text, parser.ParameterPrefix) || !ok {
+		return nil, fmt.Errorf("%w: parameter %s", ErrUnsupported, text)
+	}
+
+	return &expr{
+		eval: func([]page.Value) (page.Value, error) {
+			return bound.values[number-1], nil
+		},
+		column: -1,
+	}, nil
+}
@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// columnTexts holds the source texts of the result columns of each SELECT
+// of a statement, which name those without an alias.
+type columnTexts map[*sqlparser.Select][]string
+
+// selectTexts pairs the SELECTs of a statement with their texts in sql,
+// which is text with the WITH clauses of subqueries blanked out. The tree is
+// walked in the order it was written, the same order the texts come in;
+// should the two ever disagree, columns are named from the tree instead.
+func selectTexts(statement sqlparser.SQLNode, sql, text string) columnTexts {
+	all := parser.SelectColumnTexts(sql, text)
+	selects := selectNodes(statement)
+
+	texts := make(columnTexts)
+
+	if len(selects) != len(all) {
+		return texts
+	}
+
+	for i, node := range selects {
+		texts[node] = all[i]
+	}
+
+	return texts
+}
+
+// selectNodes lists the SELECTs of a statement in the order of their text.
+func selectNodes(statement sqlparser.SQLNode) []*sqlparser.Select {
+	var selects []*sqlparser.Select
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if node, ok := node.(*sqlparser.Select); ok {
+			selects = append(selects, node)
+		}
+
+		return true, nil
+	}, statement)
+
+	return selects
+}
+
+// correlation connects a subquery to the query it appears in. Names the
+// subquery can't resolve itself are looked up in the enclosing scope, and
+// read from the row the enclosing query is at when the subquery runs.
+type correlation struct {
+	scope *scope
+	row   []page.Value
+	// correlated is set once the subquery refers to the enclosing query, and
+	// sources holds the enclosing sources it reads
+	correlated bool
+	sources    uint64
+}
+
+func (c *correlation) resolve(node *sqlparser.ColName) (*expr, error) {
+	found, err := c.scope.resolve(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.correlated = true
+	c.sources |= found.sources
+
+	return &expr{
+		eval: func([]page.Value) (page.Value, error) {
+			return found.eval(c.row)
+		},
+		affinity:  found.affinity,
+		collation: found.collation,
+		column:    -1,
+		name:      found.name,
+	}, nil
+}
+
+// subquery is a SELECT nested in an expression.
+type subquery struct {
+	db    *DB
+	plan  resultPlan
+	outer *correlation
+	// limit is how many rows are needed, or 0 for all of them. cached keeps
+	// the rows of a subquery that doesn't refer to the enclosing query,
+	// which are the same every time during the statement run numbered run.
+	limit  int
+	cached [][]page.Value
+	run    uint64
+}
+
+// compileSubquery plans a subquery in scope.
+func compileSubquery(scope *scope, node *sqlparser.Subquery, limit int) (*subquery, error) {
+	outer := &correlation{scope: scope}
+	plan, err := scope.db.compileQuery(node.Select, scope.planning, outer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subquery{db: scope.db, plan: plan, outer: outer, limit: limit}
+	scope.subqueries = append(scope.subqueries, sub)
+
+	return sub, nil
+}
+
+// column returns the result column of a subquery used as a value, which
+// must have only one.
+func (s *subquery) column() (*expr, error) {
+	_, results := s.plan.heading()
+
+	if len(results) != 1 {
+		return nil, fmt.Errorf("sub-select returns %d columns - expected 1", len(results))
+	}
+
+	return results[0], nil
+}
+
+// rows runs the subquery for the row the enclosing query is at.
+func (s *subquery) rows(row []page.Value) ([][]page.Value, error) {
+	if s.cached != nil && s.run == s.db.runs {
+		return s.cached, nil
+	}
+
+	s.outer.row = row
+	rows := [][]page.Value{}
+
+	err := s.plan.run(s.db, func(result []page.Value) error {
+		rows = append(rows, result)
+
+		if len(rows) == s.limit {
+			return errLimitReached
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errLimitReached) {
+		return nil, err
+	}
+
+	if !s.outer.correlated {
+		s.cached = rows
+		s.run = s.db.runs
+	}
+
+	return rows, nil
+}
+
+// compileScalarSubquery builds (SELECT ...) as a value: the first column of
+// its first row, or NULL when there is none. It takes the affinity of that
+// column but, as in SQLite, not its collation.
+func compileScalarSubquery(scope *scope, node *sqlparser.Subquery) (*expr, error) {
+	sub, err := compileSubquery(scope, node, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	column, err := sub.column()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			rows, err := sub.rows(row)
+
+			if err != nil || len(rows) == 0 {
+				return nullValue, err
+			}
+
+			return rows[0][0], nil
+		},
+		affinity: column.affinity,
+		column:   -1,
+		sources:  sub.outer.sources,
+	}, nil
+}
+
+// compileExists builds EXISTS (SELECT ...), which only needs to find a row.
+func compileExists(scope *scope, node *sqlparser.ExistsExpr) (*expr, error) {
+	sub, err := compileSubquery(scope, node.Subquery, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			rows, err := sub.rows(row)
+
+			if err != nil {
+				return nullValue, err
+			}
+
+			return booleanValue(len(rows) > 0), nil
+		},
+		column:  -1,
+		sources: sub.outer.sources,
+	}, nil
+}
+
+// compileInSubquery builds x IN (SELECT ...), which compares x with each row
+// as x = column would. It is false when there are no rows, even for a NULL
+// x, and otherwise NULL when x or a row's value is NULL and none matched.
+func compileInSubquery(scope *scope, node *sqlparser.ComparisonExpr, list *sqlparser.Subquery) (*expr, error) {
+	left, err := compileExpr(scope, node.Left)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := compileSubquery(scope, list, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	column, err := sub.column()
+
+	if err != nil {
+		return nil, err
+	}
+
+	how := comparisonOf(left, column)
+	compare, err := scope.db.collation(how.collation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	negated := node.Operator == sqlparser.NotInStr
+
+	result := derived(func(row []page.Value) (page.Value, error) {
+		a, err := left.eval(row)
+
+		if err != nil {
+			return a, err
+		}
+
+		rows, err := sub.rows(row)
+
+		if err != nil {
+			return nullValue, err
+		}
+
+		if len(rows) == 0 {
+			return booleanValue(negated), nil
+		}
+
+		if a.Type == page.NullValue {
+			return nullValue, nil
+		}
+
+		a = convertForComparison(a, how.leftAffinity)
+		sawNull := false
+
+		for _, values := range rows {
+			b := values[0]
+
+			if b.Type == page.NullValue {
+				sawNull = true
+				continue
+			}
+
+			b = convertForComparison(b, how.rightAffinity)
+
+			if page.CompareValues(a, b, compare) == 0 {
+				return booleanValue(!negated), nil
+			}
+		}
+
+		if sawNull {
+			return nullValue, nil
+		}
+
+		return booleanValue(negated), nil
+	}, left)
+
+	result.sources |= sub.outer.sources
+
+	return result, nil
+}
+
+// derivedTable plans a subquery in FROM as a table named name, the way a
+// view is. It sees what the query it is part of sees from outside, but not
+// the other tables of the FROM clause. correlated reports whether it refers
+// to an enclosing query, when its rows can't be kept from one run to the
+// next.
+func (db *DB) derivedTable(scope *scope, node *sqlparser.Subquery, name string) (table *Table, correlated bool, err error) {
+	outer := scope.outer
+
+	if outer != nil {
+		was := outer.correlated
+		outer.correlated = false
+
+		defer func() {
+			correlated = outer.correlated
+			outer.correlated = was || correlated
+		}()
+	}
+
+	plan, err := db.compileQuery(node.Select, scope.planning, outer)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	names, results := plan.heading()
+
+	return viewTable(name, plan, results, names), false, nil
+}
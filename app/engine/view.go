@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// relation looks up what a FROM clause names: a table, or else a view.
+func (db *DB) relation(name string) (*Table, error) {
+	table, err := db.table(name)
+
+	if !errors.Is(err, ErrNoSuchTable) {
+		return table, err
+	}
+
+	return db.view(name)
+}
+
+// View looks up a view by case-insensitive name and compiles its SELECT.
+// It is described as a table whose columns are the view's results, with
+// their affinities and collations, and which has neither a b-tree nor rowids.
+func (db *DB) View(name string) (*Table, error) {
+	var view *Table
+
+	s := db.session()
+	err := s.read(func() (err error) {
+		view, err = s.view(name)
+		return err
+	})
+
+	return view, err
+}
+
+func (db *DB) view(name string) (*Table, error) {
+	schema, err := db.schema()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pointer := range schema {
+		if pointer.PageType == "view" && strings.EqualFold(pointer.ObjName, name) {
+			return db.compileView(pointer)
+		}
+	}
+
+	return nil, noSuchTable(name)
+}
+
+func (db *DB) compileView(pointer page.RootPagePointer) (*Table, error) {
+	key := strings.ToLower(pointer.ObjName)
+
+	if db.expanding[key] {
+		return nil, fmt.Errorf("view %s is circularly defined", pointer.ObjName)
+	}
+
+	if db.expanding == nil {
+		db.expanding = make(map[string]bool)
+	}
+
+	db.expanding[key] = true
+	defer delete(db.expanding, key)
+
+	definition, err := parser.ParseCreateView(pointer.CreateStatement)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: view %s: %v", page.ErrCorruptPage, pointer.ObjName, err)
+	}
+
+	statement, planning, err := parseQuery(definition.Select)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node, ok := statement.(sqlparser.SelectStatement)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s in view %s", ErrUnsupported, sqlparser.String(statement), pointer.ObjName)
+	}
+
+	plan, err := db.compileQuery(node, planning, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	names, results := plan.heading()
+
+	if len(definition.Columns) > 0 {
+		if len(definition.Columns) != len(results) {
+			return nil, fmt.Errorf("expected %d columns for '%s' but got %d", len(definition.Columns), pointer.ObjName, len(results))
+		}
+
+		names = definition.Columns
+	}
+
+	return viewTable(pointer.ObjName, plan, results, names), nil
+}
+
+// queryPlan produces the rows of a query that a FROM clause reads, as it
+// does those of a view.
+type queryPlan interface {
+	run(db *DB, emit func(row []page.Value) error) error
+}
+
+// viewTable describes the rows of plan as a table with the given column
+// names, as a view or a subquery in FROM is read. results are the
+// expressions of its columns, which give their affinities and collations.
+func viewTable(name string, plan queryPlan, results []*expr, names []string) *Table {
+	names = uniqueNames(names)
+	columns := make([]parser.ColumnDef, len(results))
+
+	for i, result := range results {
+		columns[i] = parser.ColumnDef{Name: names[i], Type: affinityType(result.affinity), Collate: result.collation}
+	}
+
+	return &Table{
+		Name:        name,
+		Definition:  &parser.CreateTable{Name: name, Columns: columns},
+		RowidColumn: -1,
+		real:        make([]bool, len(columns)),
+		view:        plan,
+	}
+}
+
+// uniqueNames tells repeated column names apart the way SQLite names the
+// columns of a view, as in a, a:1, a:2.
+func uniqueNames(names []string) []string {
+	unique := make([]string, len(names))
+	taken := make(map[string]bool)
+
+	for i, name := range names {
+		candidate := name
+
+		for n := 1; taken[strings.ToLower(candidate)]; n++ {
+			candidate = name + ":" + strconv.Itoa(n)
+		}
+
+		taken[strings.ToLower(candidate)] = true
+		unique[i] = candidate
+	}
+
+	return unique
+}
+
+// affinityType is a type name with the given affinity.
+func affinityType(affinity parser.Affinity) string {
+	switch affinity {
+	case parser.IntegerAffinity:
+		return "INTEGER"
+	case parser.TextAffinity:
+		return "TEXT"
+	case parser.RealAffinity:
+		return "REAL"
+	case parser.NumericAffinity:
+		return "NUMERIC"
+	}
+
+	return ""
+}
+
+// viewRows runs the SELECT of a view once per query that reads it and keeps
+// its rows, which a join may go through many times. A subquery that refers
+// to an enclosing query runs again each time, and every view runs again
+// when a prepared statement does.
+func (db *DB) viewRows(source *source) ([][]page.Value, error) {
+	if source.rows != nil && !source.correlated && source.run == db.runs {
+		return source.rows, nil
+	}
+
+	rows := [][]page.Value{}
+
+	err := source.table.view.run(db, func(row []page.Value) error {
+		rows = append(rows, row)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	source.rows = rows
+	source.run = db.runs
+
+	return rows, nil
+}
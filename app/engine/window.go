@@ -0,0 +1,1005 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// windowFunctions are the functions that only exist as window functions,
+// with the number of arguments each takes.
+var windowFunctions = map[string]struct{ minArgs, maxArgs int }{
+	"row_number":   {0, 0},
+	"rank":         {0, 0},
+	"dense_rank":   {0, 0},
+	"percent_rank": {0, 0},
+	"cume_dist":    {0, 0},
+	"ntile":        {1, 1},
+	"lag":          {1, 3},
+	"lead":         {1, 3},
+	"first_value":  {1, 1},
+	"last_value":   {1, 1},
+	"nth_value":    {2, 2},
+}
+
+// window is how a window function sees the rows of a query: split into
+// partitions, each in order, with a frame of rows around each row.
+type window struct {
+	// key is the text of PARTITION BY and ORDER BY; calls whose windows
+	// have the same key are computed from the same sort
+	key       string
+	partition []*expr
+	order     []windowTerm
+	frame     frame
+}
+
+// windowTerm is a term of a window's ORDER BY. nulls is "FIRST" or "LAST"
+// when the term says where its NULLs go, which a column before the value
+// sorts by.
+type windowTerm struct {
+	value *expr
+	key   page.SortKey
+	nulls string
+}
+
+// frame is a frame specification. start and end are "UNBOUNDED PRECEDING",
+// "PRECEDING", "CURRENT ROW", "FOLLOWING" or "UNBOUNDED FOLLOWING", with
+// an offset for PRECEDING and FOLLOWING.
+type frame struct {
+	unit                   string // ROWS, RANGE or GROUPS
+	start, end             string
+	startOffset, endOffset *expr
+	exclude                string // NO OTHERS, CURRENT ROW, GROUP or TIES
+}
+
+// defaultFrame is the frame of a window without a frame specification:
+// every row up to the current one and its peers.
+var defaultFrame = frame{unit: "RANGE", start: "UNBOUNDED PRECEDING", end: "CURRENT ROW", exclude: "NO OTHERS"}
+
+// windowCall is a window function in the select list or ORDER BY. Its
+// arguments are evaluated on the rows results are computed from.
+type windowCall struct {
+	name   string
+	args   []*expr
+	window *window
+	// new is set for an aggregate used as a window function
+	new func() aggregator
+}
+
+// windows collects the window function calls of a query. Their values take
+// up the slots after the aggregates'.
+type windows struct {
+	base  int
+	calls []*windowCall
+}
+
+// window compiles a call of parser.WindowFunction into a reference to the
+// slot its value will be in.
+func (s *scope) window(node *sqlparser.FuncExpr) (*expr, error) {
+	var parts []sqlparser.Expr
+
+	for _, selectExpr := range node.Exprs {
+		if aliased, ok := selectExpr.(*sqlparser.AliasedExpr); ok {
+			parts = append(parts, aliased.Expr)
+		}
+	}
+
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+	}
+
+	var function *sqlparser.FuncExpr
+
+	switch call := parts[0].(type) {
+	case *sqlparser.FuncExpr:
+		function = call
+	case *sqlparser.GroupConcatExpr:
+		if len(call.OrderBy) > 0 || call.Separator != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(call))
+		}
+
+		function = &sqlparser.FuncExpr{Name: sqlparser.NewColIdent("group_concat"), Distinct: call.Distinct != "", Exprs: call.Exprs}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+	}
+
+	name := strings.TrimPrefix(function.Name.Lowered(), parser.FunctionPrefix)
+	set := s.windows
+
+	if set == nil {
+		return nil, fmt.Errorf("misuse of window function %s()", name)
+	}
+
+	// windows don't nest, though their arguments may hold aggregates
+	s.windows = nil
+	defer func() { s.windows = set }()
+
+	if function.Distinct {
+		return nil, errors.New("DISTINCT is not supported for window functions")
+	}
+
+	call := &windowCall{name: name}
+
+	if limits, ok := windowFunctions[name]; ok {
+		for _, selectExpr := range function.Exprs {
+			aliased, ok := selectExpr.(*sqlparser.AliasedExpr)
+
+			if !ok {
+				return nil, wrongArgumentCount(name)
+			}
+
+			arg, err := compileExpr(s, aliased.Expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			call.args = append(call.args, arg)
+		}
+
+		if len(call.args) < limits.minArgs || len(call.args) > limits.maxArgs {
+			return nil, wrongArgumentCount(name)
+		}
+	} else if isAggregate(name, len(function.Exprs)) {
+		aggregate, err := s.aggregateCall(name, function.Exprs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		call.args = aggregate.args
+		call.new = aggregate.new
+	} else {
+		if _, err := compileFunction(s, function); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%s() may not be used as a window function", name)
+	}
+
+	var err error
+
+	if call.window, err = s.compileWindow(parts[1], parts[2], parts[3]); err != nil {
+		return nil, err
+	}
+
+	index := len(set.calls)
+	set.calls = append(set.calls, call)
+
+	return &expr{
+		eval: func(row []page.Value) (page.Value, error) {
+			return row[set.base+index], nil
+		},
+		column: -1,
+	}, nil
+}
+
+// compileWindow compiles the __partition, __order and __frame calls
+// parser.WindowFunction spells a window out with.
+func (s *scope) compileWindow(partition sqlparser.Expr, order sqlparser.Expr, frameNode sqlparser.Expr) (*window, error) {
+	w := &window{key: sqlparser.String(partition) + " " + sqlparser.String(order), frame: defaultFrame}
+
+	for _, node := range windowParts(partition) {
+		value, err := compileExpr(s, node)
+
+		if err != nil {
+			return nil, err
+		}
+
+		w.partition = append(w.partition, value)
+	}
+
+	for _, node := range windowParts(order) {
+		parts := windowParts(node)
+
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node))
+		}
+
+		value, err := compileExpr(s, parts[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		compare, err := s.db.collation(value.collation)
+
+		if err != nil {
+			return nil, err
+		}
+
+		w.order = append(w.order, windowTerm{
+			value: value,
+			key:   page.SortKey{Descending: stringPart(parts[1]) == "DESC", Collation: compare},
+			nulls: stringPart(parts[2]),
+		})
+	}
+
+	parts := windowParts(frameNode)
+
+	if len(parts) == 0 {
+		return w, nil
+	}
+
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(frameNode))
+	}
+
+	w.frame = frame{unit: stringPart(parts[0]), start: stringPart(parts[1]), end: stringPart(parts[3]), exclude: stringPart(parts[5])}
+
+	// offsets can't refer to the row
+	constant := &scope{db: s.db, planning: s.planning, outer: s.outer}
+
+	for _, offset := range []struct {
+		node   sqlparser.Expr
+		target **expr
+	}{{parts[2], &w.frame.startOffset}, {parts[4], &w.frame.endOffset}} {
+		if _, ok := offset.node.(*sqlparser.NullVal); ok {
+			continue
+		}
+
+		value, err := compileExpr(constant, offset.node)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*offset.target = value
+	}
+
+	s.subqueries = append(s.subqueries, constant.subqueries...)
+
+	if w.frame.unit == "RANGE" && (w.frame.startOffset != nil || w.frame.endOffset != nil) && len(w.order) != 1 {
+		return nil, errors.New("RANGE with offset PRECEDING/FOLLOWING requires one ORDER BY expression")
+	}
+
+	return w, nil
+}
+
+// windowParts lists the arguments of one of the calls a window is spelled
+// out with.
+func windowParts(node sqlparser.Expr) []sqlparser.Expr {
+	call, ok := node.(*sqlparser.FuncExpr)
+
+	if !ok {
+		return nil
+	}
+
+	var parts []sqlparser.Expr
+
+	for _, selectExpr := range call.Exprs {
+		if aliased, ok := selectExpr.(*sqlparser.AliasedExpr); ok {
+			parts = append(parts, aliased.Expr)
+		}
+	}
+
+	return parts
+}
+
+// stringPart reads a keyword parser.WindowFunction spells as a string.
+func stringPart(node sqlparser.Expr) string {
+	if value, ok := node.(*sqlparser.SQLVal); ok && value.Type == sqlparser.StrVal {
+		return string(value.Val)
+	}
+
+	return ""
+}
+
+// windowPass computes the window calls whose windows sort alike.
+type windowPass struct {
+	window *window
+	calls  []*windowCall
+	slots  []int
+}
+
+// windowPasses groups calls into passes by their windows' keys. The rows
+// come out of a query in the order of the first window, whose pass goes
+// last.
+func windowPasses(set *windows) []*windowPass {
+	var passes []*windowPass
+
+	for i, call := range set.calls {
+		var pass *windowPass
+
+		for _, existing := range passes {
+			if existing.window.key == call.window.key {
+				pass = existing
+			}
+		}
+
+		if pass == nil {
+			pass = &windowPass{window: call.window}
+			passes = append([]*windowPass{pass}, passes...)
+		}
+
+		pass.calls = append(pass.calls, call)
+		pass.slots = append(pass.slots, set.base+i)
+	}
+
+	return passes
+}
+
+// sortKeys is how the columns a pass sorts its rows by compare: the
+// partition's, then the order's.
+func (p *windowPass) sortKeys(db *DB) (partition []page.SortKey, order []page.SortKey, err error) {
+	for _, value := range p.window.partition {
+		compare, err := db.collation(value.collation)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		partition = append(partition, page.SortKey{Collation: compare})
+	}
+
+	for _, term := range p.window.order {
+		if term.nulls != "" {
+			order = append(order, page.SortKey{})
+		}
+
+		order = append(order, term.key)
+	}
+
+	return partition, order, nil
+}
+
+// windowing feeds rows through the passes of a query's window calls, then
+// to produce. Each pass sorts the rows, fills in its calls' slots one
+// partition at a time and hands them on to the next.
+type windowing struct {
+	width  int
+	stages []*windowStage
+}
+
+type windowStage struct {
+	pass      *windowPass
+	partition []page.SortKey
+	order     []page.SortKey
+	sorted    *sorter
+	next      func(row []page.Value) error
+}
+
+// newWindowing sets up the passes for rows of width values, window slots
+// included.
+func (db *DB) newWindowing(passes []*windowPass, width int, produce func(row []page.Value) error) (*windowing, error) {
+	w := &windowing{width: width}
+	next := produce
+
+	for i := len(passes) - 1; i >= 0; i-- {
+		partition, order, err := passes[i].sortKeys(db)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stage := &windowStage{
+			pass:      passes[i],
+			partition: partition,
+			order:     order,
+			sorted:    db.newSorter(append(append([]page.SortKey(nil), partition...), order...)),
+			next:      next,
+		}
+
+		w.stages = append([]*windowStage{stage}, w.stages...)
+		next = stage.add
+	}
+
+	return w, nil
+}
+
+// add extends a row with the window slots and passes it to the first pass.
+func (w *windowing) add(row []page.Value) error {
+	extended := make([]page.Value, w.width)
+	copy(extended, row)
+
+	for i := len(row); i < w.width; i++ {
+		extended[i] = nullValue
+	}
+
+	return w.stages[0].add(extended)
+}
+
+// finish runs the passes once every row is in.
+func (w *windowing) finish() error {
+	for _, stage := range w.stages {
+		if err := stage.finish(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// close deletes the passes' temporary files.
+func (w *windowing) close() {
+	for _, stage := range w.stages {
+		stage.sorted.close()
+	}
+}
+
+// add sorts a row by its partition and order, whose values go before it.
+func (s *windowStage) add(row []page.Value) error {
+	keys, err := evalAll(s.pass.window.partition, row)
+
+	if err != nil {
+		return err
+	}
+
+	for _, term := range s.pass.window.order {
+		value, err := term.value.eval(row)
+
+		if err != nil {
+			return err
+		}
+
+		if term.nulls != "" {
+			keys = append(keys, booleanValue((value.Type == page.NullValue) == (term.nulls == "LAST")))
+		}
+
+		keys = append(keys, value)
+	}
+
+	return s.sorted.add(append(keys, row...))
+}
+
+// finish reads the sorted rows back a partition at a time.
+func (s *windowStage) finish() error {
+	var rows [][]page.Value
+
+	width := len(s.partition)
+
+	err := s.sorted.each(func(row []page.Value) error {
+		if len(rows) > 0 && page.CompareRecords(rows[0][:width], row[:width], s.partition) != 0 {
+			if err := s.finishPartition(rows); err != nil {
+				return err
+			}
+
+			rows = nil
+		}
+
+		rows = append(rows, row)
+
+		return nil
+	})
+
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	return s.finishPartition(rows)
+}
+
+// finishPartition fills in the window slots of a partition's rows and
+// hands them on.
+func (s *windowStage) finishPartition(sorted [][]page.Value) error {
+	prefix := len(s.partition) + len(s.order)
+	p := &partition{rows: make([][]page.Value, len(sorted)), groups: make([]int, len(sorted))}
+
+	for i, row := range sorted {
+		p.rows[i] = row[prefix:]
+
+		if i > 0 && page.CompareRecords(sorted[i-1][len(s.partition):prefix], row[len(s.partition):prefix], s.order) != 0 {
+			p.starts = append(p.starts, i)
+		}
+
+		if i == 0 {
+			p.starts = append(p.starts, 0)
+		}
+
+		p.groups[i] = len(p.starts) - 1
+	}
+
+	p.starts = append(p.starts, len(sorted))
+
+	if len(s.order) > 0 {
+		p.values = make([]page.Value, len(sorted))
+
+		for i, row := range sorted {
+			p.values[i] = row[prefix-1]
+		}
+	}
+
+	for k, call := range s.pass.calls {
+		values, err := call.compute(p)
+
+		if err != nil {
+			return err
+		}
+
+		for i, value := range values {
+			p.rows[i][s.pass.slots[k]] = value
+		}
+	}
+
+	for _, row := range p.rows {
+		if err := s.next(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// partition is the rows of one partition, in order. Rows the ORDER BY
+// doesn't tell apart are peers, and form a group.
+type partition struct {
+	rows [][]page.Value
+	// groups holds the group of each row, and starts the first row of each
+	// group followed by the number of rows
+	groups []int
+	starts []int
+	// values holds each row's value of the last ORDER BY term
+	values []page.Value
+}
+
+// bounds are the rows of a frame, from lo up to hi, and which to exclude.
+type bounds struct {
+	lo, hi      int
+	exclude     string
+	row, group  int
+	partitioned *partition
+}
+
+// each visits the rows of the frame that aren't excluded, until visit
+// returns false.
+func (b bounds) each(visit func(j int) bool) {
+	for j := b.lo; j < b.hi; j++ {
+		switch b.exclude {
+		case "CURRENT ROW":
+			if j == b.row {
+				continue
+			}
+		case "GROUP":
+			if b.partitioned.groups[j] == b.group {
+				continue
+			}
+		case "TIES":
+			if j != b.row && b.partitioned.groups[j] == b.group {
+				continue
+			}
+		}
+
+		if !visit(j) {
+			return
+		}
+	}
+}
+
+// offsets evaluates a frame's offsets.
+func (f *frame) offsets() (start page.Value, end page.Value, err error) {
+	evaluate := func(offset *expr, which string) (page.Value, error) {
+		if offset == nil {
+			return nullValue, nil
+		}
+
+		value, err := offset.eval(nil)
+
+		if err != nil {
+			return nullValue, err
+		}
+
+		value = applyAffinity(value, parser.NumericAffinity)
+
+		if f.unit == "RANGE" {
+			if (value.Type != page.IntegerValue && value.Type != page.FloatValue) || toFloat(value) < 0 {
+				return nullValue, fmt.Errorf("frame %s offset must be a non-negative number", which)
+			}
+
+			return value, nil
+		}
+
+		if value.Type == page.FloatValue {
+			if integer, ok := exactInteger(value.Float); ok {
+				value = page.Value{Type: page.IntegerValue, Int: integer}
+			}
+		}
+
+		if value.Type != page.IntegerValue || value.Int < 0 {
+			return nullValue, fmt.Errorf("frame %s offset must be a non-negative integer", which)
+		}
+
+		return value, nil
+	}
+
+	if start, err = evaluate(f.startOffset, "starting"); err != nil {
+		return nullValue, nullValue, err
+	}
+
+	end, err = evaluate(f.endOffset, "ending")
+
+	return start, end, err
+}
+
+// frameBounds finds the frame of each row of a partition.
+func (f *frame) frameBounds(p *partition, descending bool) ([]bounds, error) {
+	startOffset, endOffset, err := f.offsets()
+
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(p.rows)
+	groupCount := len(p.starts) - 1
+	frames := make([]bounds, n)
+
+	// steps limits an offset to what can matter in the partition
+	steps := func(offset page.Value) int {
+		return int(min(offset.Int, int64(n)+1))
+	}
+
+	edge := func(i int, kind string, offset page.Value, start bool) int {
+		g := p.groups[i]
+
+		switch kind {
+		case "UNBOUNDED PRECEDING":
+			return 0
+		case "UNBOUNDED FOLLOWING":
+			return n
+		case "CURRENT ROW":
+			switch {
+			case f.unit == "ROWS" && start:
+				return i
+			case f.unit == "ROWS":
+				return i + 1
+			case start:
+				return p.starts[g]
+			}
+
+			return p.starts[g+1]
+		}
+
+		if f.unit == "RANGE" {
+			return p.rangeEdge(i, kind == "PRECEDING", offset, start, descending)
+		}
+
+		k := steps(offset)
+
+		if kind == "PRECEDING" {
+			k = -k
+		}
+
+		if f.unit == "ROWS" {
+			if start {
+				return i + k
+			}
+
+			return i + k + 1
+		}
+
+		if start {
+			return p.starts[max(min(g+k, groupCount), 0)]
+		}
+
+		return p.starts[max(min(g+k+1, groupCount), 0)]
+	}
+
+	for i := range frames {
+		lo := max(min(edge(i, f.start, startOffset, true), n), 0)
+		hi := max(min(edge(i, f.end, endOffset, false), n), 0)
+
+		frames[i] = bounds{lo: lo, hi: max(hi, lo), exclude: f.exclude, row: i, group: p.groups[i], partitioned: p}
+	}
+
+	return frames, nil
+}
+
+// rangeEdge finds an edge of a RANGE frame with an offset: the first row
+// whose value is at or past the current row's value moved by the offset,
+// for the start of the frame, or the first one past it, for the end.
+// Rows whose value is NULL, text or a blob have the rows of the same kind
+// for their frame.
+func (p *partition) rangeEdge(i int, preceding bool, offset page.Value, start bool, descending bool) int {
+	value := p.values[i]
+
+	if value.Type == page.NullValue {
+		if start {
+			return p.starts[p.groups[i]]
+		}
+
+		return p.starts[p.groups[i]+1]
+	}
+
+	// compare tells where a value is from the target, in the partition's
+	// order
+	var compare func(other page.Value) int
+
+	if value.Type == page.IntegerValue || value.Type == page.FloatValue {
+		moved := toFloat(offset)
+
+		if preceding != descending {
+			moved = -moved
+		}
+
+		target := floatValue(toFloat(value) + moved)
+
+		compare = func(other page.Value) int {
+			return page.CompareValues(other, target, nil)
+		}
+	} else {
+		compare = func(other page.Value) int {
+			return valueClass(other) - valueClass(value)
+		}
+	}
+
+	// only the rows with a value are searched, which NULLS FIRST or LAST
+	// may have put at either end
+	lo, hi := 0, len(p.values)
+
+	for lo < hi && p.values[lo].Type == page.NullValue {
+		lo++
+	}
+
+	for hi > lo && p.values[hi-1].Type == page.NullValue {
+		hi--
+	}
+
+	return lo + sort.Search(hi-lo, func(k int) bool {
+		c := compare(p.values[lo+k])
+
+		if descending {
+			c = -c
+		}
+
+		return c > 0 || start && c == 0
+	})
+}
+
+// valueClass orders the kinds of values that aren't NULL: numbers, text,
+// then blobs.
+func valueClass(value page.Value) int {
+	switch value.Type {
+	case page.IntegerValue, page.FloatValue:
+		return 0
+	case page.TextValue:
+		return 1
+	}
+
+	return 2
+}
+
+// compute finds the call's value for each row of a partition.
+func (c *windowCall) compute(p *partition) ([]page.Value, error) {
+	n := len(p.rows)
+	values := make([]page.Value, n)
+	args := make([][]page.Value, n)
+
+	for i, row := range p.rows {
+		var err error
+
+		if args[i], err = evalAll(c.args, row); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.name {
+	case "row_number", "rank", "dense_rank", "percent_rank", "cume_dist", "ntile":
+		for i := range values {
+			value, err := c.rank(p, i, args[i])
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = value
+		}
+
+		return values, nil
+	case "lag", "lead":
+		for i := range values {
+			values[i] = c.shifted(i, args)
+		}
+
+		return values, nil
+	}
+
+	descending := len(c.window.order) > 0 && c.window.order[len(c.window.order)-1].key.Descending
+	frames, err := c.window.frame.frameBounds(p, descending)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.new != nil {
+		return c.aggregate(frames, args)
+	}
+
+	for i, frame := range frames {
+		value := nullValue
+		nth := 1
+
+		switch c.name {
+		case "last_value":
+			frame.each(func(j int) bool {
+				value = args[j][0]
+				return true
+			})
+		case "nth_value":
+			position := applyAffinity(args[i][1], parser.NumericAffinity)
+
+			if position.Type == page.FloatValue {
+				if integer, ok := exactInteger(position.Float); ok {
+					position = page.Value{Type: page.IntegerValue, Int: integer}
+				}
+			}
+
+			if position.Type != page.IntegerValue || position.Int <= 0 {
+				return nil, errors.New("second argument to nth_value must be a positive integer")
+			}
+
+			nth = int(min(position.Int, int64(n)+1))
+
+			fallthrough
+		case "first_value":
+			frame.each(func(j int) bool {
+				if nth--; nth == 0 {
+					value = args[j][0]
+					return false
+				}
+
+				return true
+			})
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// rank computes the functions that number the rows of a partition.
+func (c *windowCall) rank(p *partition, i int, args []page.Value) (page.Value, error) {
+	n := len(p.rows)
+	g := p.groups[i]
+
+	switch c.name {
+	case "row_number":
+		return page.Value{Type: page.IntegerValue, Int: int64(i + 1)}, nil
+	case "rank":
+		return page.Value{Type: page.IntegerValue, Int: int64(p.starts[g] + 1)}, nil
+	case "dense_rank":
+		return page.Value{Type: page.IntegerValue, Int: int64(g + 1)}, nil
+	case "percent_rank":
+		if n == 1 {
+			return floatValue(0), nil
+		}
+
+		return floatValue(float64(p.starts[g]) / float64(n-1)), nil
+	case "cume_dist":
+		return floatValue(float64(p.starts[g+1]) / float64(n)), nil
+	}
+
+	// ntile splits the rows into buckets whose sizes differ by at most
+	// one, the larger ones first
+	buckets := toInteger(args[0])
+
+	if args[0].Type == page.NullValue || buckets <= 0 {
+		return nullValue, errors.New("argument of ntile must be a positive integer")
+	}
+
+	size := int64(n) / buckets
+
+	if size == 0 {
+		return page.Value{Type: page.IntegerValue, Int: int64(i + 1)}, nil
+	}
+
+	large := int64(n) - buckets*size
+	small := large * (size + 1)
+	row := int64(i)
+
+	if row < small {
+		return page.Value{Type: page.IntegerValue, Int: row/(size+1) + 1}, nil
+	}
+
+	return page.Value{Type: page.IntegerValue, Int: large + (row-small)/size + 1}, nil
+}
+
+// shifted computes lag and lead: the argument on the row the offset away,
+// or the default when there is no such row.
+func (c *windowCall) shifted(i int, args [][]page.Value) page.Value {
+	offset := int64(1)
+	fallback := nullValue
+
+	if len(args[i]) > 1 {
+		value := applyAffinity(args[i][1], parser.NumericAffinity)
+
+		switch value.Type {
+		case page.NullValue:
+			return nullValue
+		case page.FloatValue:
+			integer, ok := exactInteger(value.Float)
+
+			if !ok {
+				return nullValue
+			}
+
+			offset = integer
+		default:
+			offset = toInteger(value)
+		}
+	}
+
+	if len(args[i]) > 2 {
+		fallback = args[i][2]
+	}
+
+	if c.name == "lag" {
+		offset = -offset
+	}
+
+	if offset < -int64(len(args)) || offset >= int64(len(args)) {
+		return fallback
+	}
+
+	j := int64(i) + offset
+
+	if j < 0 || j >= int64(len(args)) {
+		return fallback
+	}
+
+	return args[j][0]
+}
+
+// aggregate computes an aggregate over each row's frame. Without an
+// exclusion, both ends of the frame only move forward from one row to the
+// next: rows that join the frame are stepped, and those that leave it are
+// taken back when the aggregate can do so. Other frames are computed afresh.
+func (c *windowCall) aggregate(frames []bounds, args [][]page.Value) ([]page.Value, error) {
+	values := make([]page.Value, len(frames))
+	f := c.window.frame
+	state := c.new()
+	_, inverts := state.(inverter)
+
+	if f.exclude == "NO OTHERS" && (f.start == "UNBOUNDED PRECEDING" || inverts) {
+		lo, hi := 0, 0
+
+		for i, frame := range frames {
+			for ; hi < frame.hi; hi++ {
+				if err := state.step(args[hi]); err != nil {
+					return nil, err
+				}
+			}
+
+			for ; lo < frame.lo; lo++ {
+				if err := state.(inverter).inverse(args[lo]); err != nil {
+					return nil, err
+				}
+			}
+
+			value, err := state.result()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = value
+		}
+
+		return values, nil
+	}
+
+	for i, frame := range frames {
+		state := c.new()
+
+		var err error
+
+		frame.each(func(j int) bool {
+			err = state.step(args[j])
+			return err == nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if values[i], err = state.result(); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,458 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// planning holds what planning a statement needs besides its tree: the
+// texts of its SELECTs, the tables of the WITH clauses in scope, by
+// lower-case name, those the WITH clauses of its subqueries define, by the
+// subquery, and its parameters.
+type planning struct {
+	texts      columnTexts
+	with       map[string]*commonTable
+	nested     map[sqlparser.SelectStatement]map[string]*commonTable
+	parameters *bindings
+}
+
+// commonTable is a table of a WITH clause. It is planned when a FROM clause
+// first reads it.
+type commonTable struct {
+	definition parser.CommonTable
+	statement  sqlparser.SelectStatement
+	table      *Table
+	// scope holds the tables the table's own SELECT sees: those of its WITH
+	// clause and of the clauses further out
+	scope map[string]*commonTable
+	// planning is set while the table's own SELECT is planned. Once the
+	// initial rows of a recursive query are planned, a reference reads self,
+	// which holds the row being worked on, and is counted in references.
+	planning   bool
+	self       *Table
+	references int
+}
+
+// parseQuery parses a statement that may start with a WITH clause.
+func parseQuery(sql string) (sqlparser.Statement, *planning, error) {
+	tables, body, err := parser.ParseWith(sql)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	parameters, err := parser.ParseParameters(sql)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &planning{
+		texts:      make(columnTexts),
+		nested:     make(map[sqlparser.SelectStatement]map[string]*commonTable),
+		parameters: newBindings(parameters),
+	}
+
+	statement, err := p.parse(body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.with, err = p.commonTables(tables); err != nil {
+		return nil, nil, err
+	}
+
+	for _, table := range p.with {
+		table.scope = p.with
+	}
+
+	return statement, p, nil
+}
+
+// parse parses a statement, or the SELECT of a table of a WITH clause, and
+// takes note of the texts of its SELECTs and of the WITH clauses its
+// subqueries start with.
+func (p *planning) parse(sql string) (sqlparser.Statement, error) {
+	withs, body, err := parser.ParseNestedWiths(sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	statement, err := parseStatement(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for node, texts := range selectTexts(statement, body, sql) {
+		p.texts[node] = texts
+	}
+
+	selects := selectNodes(statement)
+
+	for _, with := range withs {
+		var subquery sqlparser.SelectStatement
+
+		if with.Select < len(selects) {
+			subquery = subqueryStarting(statement, selects[with.Select])
+		}
+
+		if subquery == nil {
+			return nil, fmt.Errorf("%w: WITH in %s", ErrUnsupported, sqlparser.String(statement))
+		}
+
+		if p.nested[subquery], err = p.commonTables(with.Tables); err != nil {
+			return nil, err
+		}
+	}
+
+	return statement, nil
+}
+
+// commonTables parses the tables of a WITH clause.
+func (p *planning) commonTables(definitions []parser.CommonTable) (map[string]*commonTable, error) {
+	tables := make(map[string]*commonTable)
+
+	for _, definition := range definitions {
+		key := strings.ToLower(definition.Name)
+
+		if tables[key] != nil {
+			return nil, fmt.Errorf("duplicate WITH table name: %s", definition.Name)
+		}
+
+		parsed, err := p.parse(definition.Select)
+
+		if err != nil {
+			return nil, err
+		}
+
+		selectStatement, ok := parsed.(sqlparser.SelectStatement)
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in WITH", ErrUnsupported, sqlparser.String(parsed))
+		}
+
+		tables[key] = &commonTable{definition: definition, statement: selectStatement}
+	}
+
+	return tables, nil
+}
+
+// enter brings the tables of the WITH clause a subquery starts with into
+// scope, hiding those of the same names from further out, and returns what
+// puts them back.
+func (p *planning) enter(subquery sqlparser.SelectStatement) func() {
+	tables := p.nested[subquery]
+
+	if tables == nil {
+		return func() {}
+	}
+
+	outer := p.with
+	p.with = make(map[string]*commonTable, len(outer)+len(tables))
+
+	for name, table := range outer {
+		p.with[name] = table
+	}
+
+	for name, table := range tables {
+		p.with[name] = table
+		table.scope = p.with
+	}
+
+	return func() { p.with = outer }
+}
+
+// subqueryStarting finds the subquery of statement whose first SELECT is
+// first.
+func subqueryStarting(statement sqlparser.SQLNode, first *sqlparser.Select) sqlparser.SelectStatement {
+	var found sqlparser.SelectStatement
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if node, ok := node.(*sqlparser.Subquery); ok && found == nil && leftmostSelect(node.Select) == first {
+			found = node.Select
+		}
+
+		return found == nil, nil
+	}, statement)
+
+	return found
+}
+
+// leftmostSelect is the first SELECT of a possibly compound SELECT.
+func leftmostSelect(node sqlparser.SelectStatement) *sqlparser.Select {
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		return node
+	case *sqlparser.Union:
+		return leftmostSelect(node.Left)
+	case *sqlparser.ParenSelect:
+		return leftmostSelect(node.Select)
+	}
+
+	return nil
+}
+
+// namedTable looks up the table a FROM clause names: one of the WITH
+// clause, unless the name is qualified, or else a table or view. correlated
+// is set for the reference of a recursive query to itself, whose row
+// changes from one run to the next.
+func (db *DB) namedTable(p *planning, name sqlparser.TableName) (table *Table, correlated bool, err error) {
+	if c := p.with[strings.ToLower(name.Name.String())]; c != nil && name.Qualifier.IsEmpty() {
+		return db.planCommonTable(p, c)
+	}
+
+	table, err = db.relation(name.Name.String())
+
+	return table, false, err
+}
+
+func (db *DB) planCommonTable(p *planning, c *commonTable) (*Table, bool, error) {
+	name := c.definition.Name
+
+	switch {
+	case c.table != nil:
+		return c.table, false, nil
+	case c.self != nil:
+		if c.references++; c.references > 1 {
+			return nil, false, fmt.Errorf("multiple references to recursive table: %s", name)
+		}
+
+		return c.self, true, nil
+	case c.planning:
+		return nil, false, fmt.Errorf("circular reference: %s", name)
+	}
+
+	c.planning = true
+	outer := p.with
+	p.with = c.scope
+
+	defer func() {
+		c.planning = false
+		p.with = outer
+	}()
+
+	var plan resultPlan
+	var err error
+
+	if node, ok := c.statement.(*sqlparser.Union); ok {
+		plan, err = db.compileUnion(p, c, node)
+	} else {
+		plan, err = db.compileQuery(c.statement, p, nil)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	names, err := c.columnNames(plan)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, results := plan.heading()
+	c.table = viewTable(name, plan, results, names)
+
+	return c.table, false, nil
+}
+
+// columnNames names the columns of the table: as the WITH clause does, or
+// else after the results of plan.
+func (c *commonTable) columnNames(plan resultPlan) ([]string, error) {
+	columns := c.definition.Columns
+	names, results := plan.heading()
+
+	if len(columns) == 0 {
+		return names, nil
+	}
+
+	if len(columns) != len(results) {
+		return nil, fmt.Errorf("table %s has %d values for %d columns", c.definition.Name, len(results), len(columns))
+	}
+
+	return columns, nil
+}
+
+// recursivePlan runs a recursive query. The rows of initial go on a queue;
+// each row taken off it is a result, and is what the reference of step to
+// the query reads while step runs, whose rows join the queue. With UNION
+// rather than UNION ALL, a row that was queued before is dropped. ORDER BY
+// decides which row the queue gives up next, and LIMIT ends the query.
+type recursivePlan struct {
+	initial  resultPlan
+	step     *selectPlan
+	current  *currentRow
+	distinct bool
+	equality rowEquality
+	orderBy  []orderTerm
+	limit    *expr
+	offset   *expr
+}
+
+func (r *recursivePlan) heading() ([]string, []*expr) {
+	return r.initial.heading()
+}
+
+// currentRow is what the reference of a recursive query to itself reads.
+type currentRow struct {
+	row []page.Value
+}
+
+func (c *currentRow) run(db *DB, emit func(row []page.Value) error) error {
+	return emit(c.row)
+}
+
+// compileUnion plans a table whose SELECT is a compound. When the last
+// SELECT reads the table itself, it is a recursive query of the ones before.
+func (db *DB) compileUnion(p *planning, c *commonTable, node *sqlparser.Union) (resultPlan, error) {
+	initial, err := db.compileQuery(node.Left, p, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stepNode, ok := node.Right.(*sqlparser.Select)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, sqlparser.String(node.Right))
+	}
+
+	names, err := c.columnNames(initial)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, results := initial.heading()
+	plan := &recursivePlan{initial: initial, current: &currentRow{}}
+	c.self = viewTable(c.definition.Name, plan.current, results, names)
+
+	if plan.step, err = db.compileSelect(stepNode, p, nil); err != nil {
+		return nil, err
+	}
+
+	if c.references == 0 {
+		return db.combine(node, initial, plan.step, p, nil)
+	}
+
+	operator, err := compoundOperator(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if operator != "UNION" && operator != "UNION ALL" {
+		return nil, fmt.Errorf("%w: a recursive query with %s", ErrUnsupported, operator)
+	}
+
+	plan.distinct = operator == "UNION"
+
+	if plan.step.aggregate {
+		return nil, errors.New("recursive aggregate queries not supported")
+	}
+
+	if len(plan.step.results) != len(results) {
+		return nil, fmt.Errorf("SELECTs to the left and right of %s do not have the same number of result columns", operator)
+	}
+
+	if plan.equality, err = db.rowEquality(collationsOf(results)); err != nil {
+		return nil, err
+	}
+
+	// ORDER BY and LIMIT see the table's columns
+	self := &scope{db: db, planning: p, sources: []*source{{name: c.self.Name, table: c.self}}}
+	columns := make([]*expr, len(names))
+
+	for i := range columns {
+		columns[i] = columnExpr(0, self.sources[0], i)
+	}
+
+	if plan.orderBy, err = compileOrderTerms(self, node.OrderBy, columns, make([]string, len(columns))); err != nil {
+		return nil, err
+	}
+
+	if plan.limit, plan.offset, err = compileLimit(&scope{db: db, planning: p}, node.Limit); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (r *recursivePlan) run(db *DB, emit func(row []page.Value) error) error {
+	limit, offset, err := evalLimits(r.limit, r.offset)
+
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	var queue []sortedRow
+
+	keys := sortKeys(r.orderBy)
+	queued := r.equality.newSet()
+
+	enqueue := func(row []page.Value) error {
+		if r.distinct && !queued.add(row) {
+			return nil
+		}
+
+		orderKey, err := orderKeys(r.orderBy, row, row)
+
+		if err != nil {
+			return err
+		}
+
+		// a row goes after those it sorts equal to
+		position := sort.Search(len(queue), func(i int) bool {
+			return page.CompareRecords(queue[i].keys, orderKey, keys) > 0
+		})
+
+		queue = append(queue, sortedRow{})
+		copy(queue[position+1:], queue[position:])
+		queue[position] = sortedRow{keys: orderKey, results: row}
+
+		return nil
+	}
+
+	if err := r.initial.run(db, enqueue); err != nil {
+		return err
+	}
+
+	for len(queue) > 0 {
+		// a recursive query may never end, and need not read a page
+		if err := db.interrupted(); err != nil {
+			return err
+		}
+
+		row := queue[0].results
+		queue = queue[1:]
+
+		if offset > 0 {
+			offset--
+		} else {
+			if err := emit(row); err != nil {
+				return err
+			}
+
+			if limit--; limit == 0 {
+				return nil
+			}
+		}
+
+		r.current.row = row
+
+		if err := r.step.run(db, enqueue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,755 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"math"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Begin starts a transaction. Changes made until Commit are kept in memory
+// and the journal, and Rollback discards them. The transaction belongs to
+// the handle: queries made meanwhile, from any goroutine, see its changes.
+// It holds a RESERVED lock on the file, so other connections can still read
+// but can't start writing.
+func (db *DB) Begin() error {
+	if err := db.canceled(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.root.Begin()
+}
+
+// Commit writes the transaction to the file. It fails with page.ErrBusy
+// when the readers of other connections don't finish within the busy
+// timeout, leaving the transaction open to be committed again. Once the
+// context of a handle made by WithContext is done, it fails with
+// ErrInterrupted the same way.
+func (db *DB) Commit() error {
+	if err := db.canceled(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.root.Commit()
+}
+
+func (db *DB) Rollback() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.root.Rollback()
+}
+
+// autocommit runs change inside the open transaction, or in a transaction of
+// its own that commits when change succeeds. It is called within write.
+func (db *DB) autocommit(change func() error) error {
+	if db.pager.InTransaction() {
+		return change()
+	}
+
+	if err := db.pager.Begin(); err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		db.pager.Rollback()
+		return err
+	}
+
+	if err := db.pager.Commit(); err != nil {
+		db.pager.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// compactBooleans says whether records may store 0 and 1 without content,
+// which schema formats before 4 don't allow.
+func (db *DB) compactBooleans() bool {
+	return db.pager.Header().SchemaFormatNumber >= 4
+}
+
+// CreateTable runs a CREATE TABLE statement: it allocates the table's root
+// page, plus one for each automatic index its constraints need, and records
+// them in sqlite_schema.
+func (db *DB) CreateTable(sql string) error {
+	return db.write(func() error {
+		return db.createTable(sql)
+	})
+}
+
+func (db *DB) createTable(sql string) error {
+	definition, err := parser.ParseCreateTable(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	stored, err := parser.StoredCreateStatement(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if strings.HasPrefix(strings.ToLower(definition.Name), "sqlite_") {
+		return fmt.Errorf("object name reserved for internal use: %s", definition.Name)
+	}
+
+	if definition.WithoutRowid {
+		return fmt.Errorf("%w: WITHOUT ROWID tables", ErrUnsupported)
+	}
+
+	if db.pager.Header().TextEncoding != 1 {
+		return fmt.Errorf("%w: writing to a UTF-16 database", ErrUnsupported)
+	}
+
+	schema, err := db.schema()
+
+	if err != nil {
+		return err
+	}
+
+	for _, pointer := range schema {
+		if strings.EqualFold(pointer.ObjName, definition.Name) {
+			if definition.IfNotExists {
+				return nil
+			}
+
+			return alreadyExists(pointer.PageType, pointer.ObjName)
+		}
+	}
+
+	return db.autocommit(func() error {
+		root, err := page.CreateBTree(db.pager, false)
+
+		if err != nil {
+			return err
+		}
+
+		if err := db.insertSchemaRow("table", definition.Name, definition.Name, root, stored); err != nil {
+			return err
+		}
+
+		for i := range autoindexKeys(definition) {
+			indexRoot, err := page.CreateBTree(db.pager, true)
+
+			if err != nil {
+				return err
+			}
+
+			name := fmt.Sprintf("%s%s_%d", autoindexPrefix, definition.Name, i+1)
+
+			if err := db.insertSchemaRow("index", name, definition.Name, indexRoot, ""); err != nil {
+				return err
+			}
+		}
+
+		db.pager.SchemaChanged()
+
+		return nil
+	})
+}
+
+// CreateIndex adds an index to the schema and fills it from the rows the
+// table already has. Their entries are sorted first and added in order,
+// which fills the index's pages from left to right.
+func (db *DB) CreateIndex(sql string) error {
+	return db.write(func() error {
+		return db.createIndex(sql)
+	})
+}
+
+func (db *DB) createIndex(sql string) error {
+	definition, err := parser.ParseCreateIndex(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	stored, err := parser.StoredCreateStatement(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if strings.HasPrefix(strings.ToLower(definition.Name), "sqlite_") {
+		return fmt.Errorf("object name reserved for internal use: %s", definition.Name)
+	}
+
+	if definition.Where != "" {
+		return fmt.Errorf("%w: partial indexes", ErrUnsupported)
+	}
+
+	if db.pager.Header().TextEncoding != 1 {
+		return fmt.Errorf("%w: writing to a UTF-16 database", ErrUnsupported)
+	}
+
+	schema, err := db.schema()
+
+	if err != nil {
+		return err
+	}
+
+	for _, pointer := range schema {
+		if strings.EqualFold(pointer.ObjName, definition.Name) {
+			if definition.IfNotExists {
+				return nil
+			}
+
+			return alreadyExists(pointer.PageType, pointer.ObjName)
+		}
+	}
+
+	table, err := db.table(definition.Table)
+
+	if err != nil {
+		return err
+	}
+
+	if table.Definition.WithoutRowid {
+		return fmt.Errorf("%w: indexing WITHOUT ROWID table %s", ErrUnsupported, table.Name)
+	}
+
+	pointer := page.RootPagePointer{PageType: "index", ObjName: definition.Name, TableName: table.Name}
+	index, err := db.resolveIndex(table, pointer, definition.Columns, definition.Unique)
+
+	if err != nil {
+		return err
+	}
+
+	return db.autocommit(func() error {
+		root, err := page.CreateBTree(db.pager, true)
+
+		if err != nil {
+			return err
+		}
+
+		index.RootPage = root
+
+		if err := db.insertSchemaRow("index", definition.Name, table.Name, index.RootPage, stored); err != nil {
+			return err
+		}
+
+		if err := db.fillIndex(table, index); err != nil {
+			return err
+		}
+
+		db.pager.SchemaChanged()
+
+		return nil
+	})
+}
+
+// fillIndex adds an entry to a new index for every row of its table.
+func (db *DB) fillIndex(table *Table, index *Index) error {
+	sorted := db.newSorter(index.Order)
+	defer sorted.close()
+
+	err := db.walkRows(table, func(rowID page.Value, values []page.Value) error {
+		entry := make([]page.Value, 0, len(index.Columns)+1)
+
+		for _, position := range index.Columns {
+			entry = append(entry, values[position])
+		}
+
+		return sorted.add(append(entry, rowID))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var previous []page.Value
+
+	return sorted.each(func(entry []page.Value) error {
+		// entries sort by their columns first, so a duplicate follows the
+		// entry it repeats
+		if index.Unique && previous != nil && !hasNull(entry[:len(index.Columns)]) &&
+			page.CompareRecords(previous[:len(index.Columns)], entry[:len(index.Columns)], index.Order) == 0 {
+			names := make([]string, len(index.Columns))
+
+			for i, position := range index.Columns {
+				names[i] = table.Definition.Columns[position].Name
+			}
+
+			return constraintFailed("UNIQUE", table.Name, names...)
+		}
+
+		previous = entry
+
+		return page.InsertIndexEntry(db.pager, index.RootPage, page.EncodeRecord(entry, db.compactBooleans()), index.Order)
+	})
+}
+
+// hasNull reports whether any of values is NULL.
+func hasNull(values []page.Value) bool {
+	for _, value := range values {
+		if value.Type == page.NullValue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateView adds a view to the schema. As in SQLite, its SELECT only has to
+// parse here; the tables it reads are looked up when the view is used.
+func (db *DB) CreateView(sql string) error {
+	return db.write(func() error {
+		return db.createView(sql)
+	})
+}
+
+func (db *DB) createView(sql string) error {
+	definition, err := parser.ParseCreateView(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if definition.Temporary {
+		return fmt.Errorf("%w: temporary views", ErrUnsupported)
+	}
+
+	stored, err := parser.StoredCreateStatement(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	statement, planning, err := parseQuery(definition.Select)
+
+	if err != nil {
+		return err
+	}
+
+	if len(planning.parameters.Names) > 0 {
+		return errors.New("parameters are not allowed in views")
+	}
+
+	if _, ok := statement.(sqlparser.SelectStatement); !ok {
+		return fmt.Errorf("%w: %s in a view", ErrUnsupported, sqlparser.String(statement))
+	}
+
+	if strings.HasPrefix(strings.ToLower(definition.Name), "sqlite_") {
+		return fmt.Errorf("object name reserved for internal use: %s", definition.Name)
+	}
+
+	if db.pager.Header().TextEncoding != 1 {
+		return fmt.Errorf("%w: writing to a UTF-16 database", ErrUnsupported)
+	}
+
+	schema, err := db.schema()
+
+	if err != nil {
+		return err
+	}
+
+	for _, pointer := range schema {
+		if strings.EqualFold(pointer.ObjName, definition.Name) {
+			if definition.IfNotExists {
+				return nil
+			}
+
+			return alreadyExists(pointer.PageType, pointer.ObjName)
+		}
+	}
+
+	return db.autocommit(func() error {
+		if err := db.insertSchemaRow("view", definition.Name, definition.Name, 0, stored); err != nil {
+			return err
+		}
+
+		db.pager.SchemaChanged()
+
+		return nil
+	})
+}
+
+// DropView removes a view from the schema, along with its triggers.
+func (db *DB) DropView(sql string) error {
+	return db.write(func() error {
+		return db.dropView(sql)
+	})
+}
+
+func (db *DB) dropView(sql string) error {
+	drop, err := parser.ParseDrop(sql)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if drop.Object != "VIEW" {
+		return fmt.Errorf("%w: DROP %s", ErrUnsupported, drop.Object)
+	}
+
+	schema, err := db.schema()
+
+	if err != nil {
+		return err
+	}
+
+	var view *page.RootPagePointer
+
+	for i, pointer := range schema {
+		if (pointer.PageType == "view" || pointer.PageType == "table") && strings.EqualFold(pointer.ObjName, drop.Name) {
+			view = &schema[i]
+		}
+	}
+
+	switch {
+	case view == nil && drop.IfExists:
+		return nil
+	case view == nil:
+		return fmt.Errorf("no such view: %s", drop.Name)
+	case view.PageType == "table":
+		return fmt.Errorf("use DROP TABLE to delete table %s", view.ObjName)
+	}
+
+	return db.autocommit(func() error {
+		err := db.deleteSchemaRows(func(objectType string, name string, tableName string) bool {
+			return (objectType == "view" && strings.EqualFold(name, view.ObjName)) ||
+				(objectType == "trigger" && strings.EqualFold(tableName, view.ObjName))
+		})
+
+		if err != nil {
+			return err
+		}
+
+		db.pager.SchemaChanged()
+
+		return nil
+	})
+}
+
+// deleteSchemaRows removes the rows of sqlite_schema that match.
+func (db *DB) deleteSchemaRows(match func(objectType string, name string, tableName string) bool) error {
+	var rowIDs []int64
+
+	err := page.WalkTable(db.pager, 1, func(cell page.Cell) error {
+		pointer, err := page.UnmarshalRootPagePointer(cell.Columns)
+
+		if err != nil {
+			return err
+		}
+
+		if match(pointer.PageType, pointer.ObjName, pointer.TableName) {
+			rowIDs = append(rowIDs, int64(cell.CellIdx))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, rowID := range rowIDs {
+		if _, err := page.DeleteTableRow(db.pager, 1, rowID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertSchemaRow adds a row to sqlite_schema. An empty sql is stored as NULL.
+func (db *DB) insertSchemaRow(objectType string, name string, tableName string, root int, sql string) error {
+	lastRowID, err := page.LastRowID(db.pager, 1)
+
+	if err != nil {
+		return err
+	}
+
+	sqlValue := page.Value{Type: page.NullValue}
+
+	if sql != "" {
+		sqlValue = page.Value{Type: page.TextValue, Bytes: []byte(sql)}
+	}
+
+	record := page.EncodeRecord([]page.Value{
+		{Type: page.TextValue, Bytes: []byte(objectType)},
+		{Type: page.TextValue, Bytes: []byte(name)},
+		{Type: page.TextValue, Bytes: []byte(tableName)},
+		{Type: page.IntegerValue, Int: int64(root)},
+		sqlValue,
+	}, db.compactBooleans())
+
+	return page.InsertTableRow(db.pager, 1, lastRowID+1, record)
+}
+
+// Inserter adds rows to one table. The table definition and its indexes are
+// looked up once, so loading many rows doesn't re-read the schema each time.
+type Inserter struct {
+	db      *DB
+	table   *Table
+	indexes []*Index
+}
+
+func (db *DB) NewInserter(tableName string) (*Inserter, error) {
+	table, err := db.Table(tableName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if table.Definition.WithoutRowid {
+		return nil, fmt.Errorf("%w: inserting into WITHOUT ROWID table %s", ErrUnsupported, table.Name)
+	}
+
+	if db.Header().TextEncoding != 1 {
+		return nil, fmt.Errorf("%w: writing to a UTF-16 database", ErrUnsupported)
+	}
+
+	for _, column := range table.Definition.Columns {
+		if column.Generated {
+			return nil, fmt.Errorf("%w: inserting into table %s with generated columns", ErrUnsupported, table.Name)
+		}
+	}
+
+	if table.Definition.RowidColumn() != -1 && table.Definition.Columns[table.Definition.RowidColumn()].Autoincrement {
+		return nil, fmt.Errorf("%w: inserting into AUTOINCREMENT table %s", ErrUnsupported, table.Name)
+	}
+
+	indexes, err := db.Indexes(table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Inserter{db: db, table: table, indexes: indexes}, nil
+}
+
+func (ins *Inserter) Table() *Table {
+	return ins.table
+}
+
+// Insert adds a row with a value for every column, in declared order, and
+// returns its rowid. Values are converted by column affinity first. A row
+// that breaks a constraint is rejected before anything is written.
+func (ins *Inserter) Insert(values []page.Value) (int64, error) {
+	table := ins.table
+	columns := table.Definition.Columns
+
+	if len(values) != len(columns) {
+		return 0, fmt.Errorf("table %s has %d columns but %d values were supplied", table.Name, len(columns), len(values))
+	}
+
+	row := make([]page.Value, len(values))
+
+	for i, column := range columns {
+		value, err := storedValue(table, column, values[i])
+
+		if err != nil {
+			return 0, err
+		}
+
+		// a NULL rowid alias is given a rowid rather than rejected
+		if column.NotNull && value.Type == page.NullValue && i != table.RowidColumn {
+			return 0, constraintFailed("NOT NULL", table.Name, column.Name)
+		}
+
+		row[i] = value
+	}
+
+	var rowID int64
+
+	err := ins.db.write(func() error {
+		return ins.db.autocommit(func() error {
+			var err error
+
+			rowID, err = ins.rowID(row)
+
+			if err != nil {
+				return err
+			}
+
+			exists, err := page.RowExists(ins.db.pager, table.RootPage, rowID)
+
+			if err != nil {
+				return err
+			}
+
+			if exists {
+				return constraintFailed("UNIQUE", table.Name, ins.rowidName())
+			}
+
+			for _, index := range ins.indexes {
+				if err := ins.checkUnique(index, row); err != nil {
+					return err
+				}
+			}
+
+			stored := row
+
+			if table.RowidColumn != -1 {
+				// the rowid alias lives in the cell key and is stored as NULL
+				stored = append([]page.Value{}, row...)
+				stored[table.RowidColumn] = page.Value{Type: page.NullValue}
+			}
+
+			record := page.EncodeRecord(stored, ins.db.compactBooleans())
+
+			if err := page.InsertTableRow(ins.db.pager, table.RootPage, rowID, record); err != nil {
+				return err
+			}
+
+			for _, index := range ins.indexes {
+				entry := make([]page.Value, 0, len(index.Columns)+1)
+
+				for _, position := range index.Columns {
+					entry = append(entry, row[position])
+				}
+
+				entry = append(entry, page.Value{Type: page.IntegerValue, Int: rowID})
+
+				record := page.EncodeRecord(entry, ins.db.compactBooleans())
+
+				if err := page.InsertIndexEntry(ins.db.pager, index.RootPage, record, index.Order); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return rowID, err
+}
+
+// storedValue applies the column's affinity and, in a STRICT table, checks
+// the value's type against the declared one.
+func storedValue(table *Table, column parser.ColumnDef, value page.Value) (page.Value, error) {
+	// ANY columns of a STRICT table keep values exactly as given
+	if !table.Definition.Strict || !strings.EqualFold(column.Type, "ANY") {
+		value = applyAffinity(value, parser.ColumnAffinity(column.Type))
+	}
+
+	if !table.Definition.Strict || value.Type == page.NullValue {
+		return value, nil
+	}
+
+	declared := strings.ToUpper(column.Type)
+
+	var allowed page.ValueType
+
+	switch declared {
+	case "INT", "INTEGER":
+		allowed = page.IntegerValue
+	case "REAL":
+		allowed = page.FloatValue
+	case "TEXT":
+		allowed = page.TextValue
+	case "BLOB":
+		allowed = page.BlobValue
+	default:
+		return value, nil
+	}
+
+	if value.Type != allowed {
+		return value, &ConstraintError{Message: fmt.Sprintf("cannot store %s value in %s column %s.%s", typeName(value), declared, table.Name, column.Name)}
+	}
+
+	return value, nil
+}
+
+func typeName(value page.Value) string {
+	switch value.Type {
+	case page.NullValue:
+		return "NULL"
+	case page.IntegerValue:
+		return "INT"
+	case page.FloatValue:
+		return "REAL"
+	case page.TextValue:
+		return "TEXT"
+	}
+
+	return "BLOB"
+}
+
+// rowID picks the rowid of a new row: the value of the rowid alias when one
+// is given, otherwise one past the largest rowid in the table.
+func (ins *Inserter) rowID(row []page.Value) (int64, error) {
+	if ins.table.RowidColumn != -1 {
+		value := row[ins.table.RowidColumn]
+
+		switch value.Type {
+		case page.IntegerValue:
+			return value.Int, nil
+		case page.NullValue:
+		default:
+			return 0, ErrMismatch
+		}
+	}
+
+	last, err := page.LastRowID(ins.db.pager, ins.table.RootPage)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if last == math.MaxInt64 {
+		return 0, fmt.Errorf("database or disk is full: no rowid left in table %s", ins.table.Name)
+	}
+
+	rowID := last + 1
+
+	if ins.table.RowidColumn != -1 {
+		row[ins.table.RowidColumn] = page.Value{Type: page.IntegerValue, Int: rowID}
+	}
+
+	return rowID, nil
+}
+
+func (ins *Inserter) rowidName() string {
+	if ins.table.RowidColumn != -1 {
+		return ins.table.Definition.Columns[ins.table.RowidColumn].Name
+	}
+
+	return "rowid"
+}
+
+// checkUnique fails when a unique index already has an entry with the row's
+// values. Entries containing NULL never conflict.
+func (ins *Inserter) checkUnique(index *Index, row []page.Value) error {
+	if !index.Unique {
+		return nil
+	}
+
+	key := make([]page.Value, len(index.Columns))
+	names := make([]string, len(index.Columns))
+
+	for i, position := range index.Columns {
+		if row[position].Type == page.NullValue {
+			return nil
+		}
+
+		key[i] = row[position]
+		names[i] = ins.table.Definition.Columns[position].Name
+	}
+
+	found, err := page.IndexContains(ins.db.pager, index.RootPage, key, index.Order)
+
+	if err != nil {
+		return err
+	}
+
+	if found {
+		return constraintFailed("UNIQUE", ins.table.Name, names...)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sqlLiteral renders a value the way sqlite3's insert mode does, so the text
+// can be fed back to sqlite3 to reproduce the same value.
+func sqlLiteral(value page.Value) string {
+	switch value.Type {
+	case page.NullValue:
+		return "NULL"
+	case page.IntegerValue:
+		return strconv.FormatInt(value.Int, 10)
+	case page.FloatValue:
+		return realLiteral(value.Float)
+	case page.BlobValue:
+		return fmt.Sprintf("X'%x'", value.Bytes)
+	default:
+		return quoteText(string(value.Bytes))
+	}
+}
+
+func realLiteral(r float64) string {
+	switch {
+	case math.IsInf(r, 1):
+		return "9.0e+999"
+	case math.IsInf(r, -1):
+		return "-9.0e+999"
+	case r == math.Trunc(r) && math.Abs(r) < 1<<63:
+		return strconv.FormatInt(int64(r), 10) + ".0"
+	}
+
+	return withDecimalPoint(strconv.FormatFloat(r, 'g', -1, 64))
+}
+
+// withDecimalPoint makes sure a rendered float reads back as REAL, turning
+// "1e+20" into "1.0e+20".
+func withDecimalPoint(text string) string {
+	mantissa, exponent, hasExponent := strings.Cut(text, "e")
+
+	if strings.ContainsAny(mantissa, ".nN") {
+		return text
+	}
+
+	if hasExponent {
+		return mantissa + ".0e" + exponent
+	}
+
+	return mantissa + ".0"
+}
+
+// quoteText quotes a string as an SQL literal. Control characters can't be
+// written literally without being mangled by line-based tools, so strings that
+// contain them are wrapped in unistr() like sqlite3 does.
+func quoteText(text string) string {
+	hasControl := strings.IndexFunc(text, func(r rune) bool { return r < 0x20 }) != -1
+
+	if !hasControl {
+		return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString("unistr('")
+
+	for _, r := range text {
+		switch {
+		case r == '\'':
+			builder.WriteString("''")
+		case r == '\\':
+			builder.WriteString(`\\`)
+		case r < 0x20:
+			fmt.Fprintf(&builder, `\u%04x`, r)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	builder.WriteString("')")
+
+	return builder.String()
+}
@@ -1,49 +1,19 @@
 package helper
 
-import (
-	"regexp"
-	"strings"
-)
+import "errors"
 
-func ArrayContain[T comparable](target T, elements []T) bool {
+// ErrOutOfBounds is returned when a decoder would read past the end of its buffer.
+var ErrOutOfBounds = errors.New("read past end of buffer")
 
-	for _, element := range elements {
-		if element == target {
-			return true
-		}
-	}
-
-	return false
-}
-
-func DecodeTwosCompliment(bytes []byte) int64 {
-	size := len(bytes)
-	if size > 8 {
-		panic("Max 8 bytes allowed")
-	}
-	var result int64
-	var mask int64
-	for i := 0; i < size; i++ {
-		shift := 8 * (size - i - 1)
-		// Combine bytes
-		result |= int64(bytes[i]) << shift
-		// 11111111 = 255
-		mask |= 255 << shift
-	}
-
-	// 10000000 = 128
-	if bytes[0]&128 == 1 {
-		flippedMask := ^mask
-		result |= flippedMask
-	}
-	return result
-}
-
-func DecodeVarint(data *[]byte, offset int64) (uint64, int) {
+func DecodeVarint(data *[]byte, offset int64) (uint64, int, error) {
 	var result uint64
 	var i int64
 
 	for {
+		if offset < 0 || offset+i >= int64(len(*data)) {
+			return 0, 0, ErrOutOfBounds
+		}
+
 		currentByte := (*data)[offset+i]
 
 		if i == 8 {
@@ -62,7 +32,47 @@ func DecodeVarint(data *[]byte, offset int64) (uint64, int) {
 
 		i++
 	}
-	return result, int(i + 1)
+	return result, int(i + 1), nil
+}
+
+// EncodeVarint writes value in SQLite's big-endian varint format: seven bits
+// per byte, except for a ninth byte that carries a full eight.
+func EncodeVarint(value uint64) []byte {
+	if value > 0x00ffffffffffffff {
+		buff := make([]byte, 9)
+		buff[8] = byte(value)
+		value >>= 8
+
+		for i := 7; i >= 0; i-- {
+			buff[i] = byte(value&0x7f) | 0x80
+			value >>= 7
+		}
+
+		return buff
+	}
+
+	var reversed []byte
+
+	for {
+		reversed = append(reversed, byte(value&0x7f))
+		value >>= 7
+
+		if value == 0 {
+			break
+		}
+	}
+
+	buff := make([]byte, len(reversed))
+
+	for i, b := range reversed {
+		buff[len(reversed)-1-i] = b
+
+		if i > 0 {
+			buff[len(reversed)-1-i] |= 0x80
+		}
+	}
+
+	return buff
 }
 
 func GetContentSizeFromSerialType(serialType uint64) uint64 {
@@ -82,42 +92,161 @@ func GetContentSizeFromSerialType(serialType uint64) uint64 {
 	}
 }
 
-func ParseCreateIndexStatement(indexStatement string) []string {
-	var result []string
-	re := regexp.MustCompile(`(?i)CREATE INDEX \"?\w+\"?\s*ON\s+(\w+)\s+\((\w+)\)`)
-	matches := re.FindStringSubmatch(indexStatement)
-	columns := strings.Split(matches[2], ",")
-	result = append(result, columns...)
+// Like implements SQL LIKE: '%' matches any run of characters, '_' matches a
+// single character and ASCII letters compare case-insensitively. An escape of
+// 0 disables escaping.
+func Like(pattern string, value string, escape rune) bool {
+	return likeMatch([]rune(pattern), []rune(value), escape)
+}
+
+func likeMatch(pattern []rune, value []rune, escape rune) bool {
+	for len(pattern) > 0 {
+		c := pattern[0]
+
+		switch {
+		case escape != 0 && c == escape:
+			if len(pattern) < 2 || len(value) == 0 || foldASCII(pattern[1]) != foldASCII(value[0]) {
+				return false
+			}
+
+			pattern, value = pattern[2:], value[1:]
+
+		case c == '%':
+			for len(pattern) > 0 && pattern[0] == '%' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(value); i++ {
+				if likeMatch(pattern, value[i:], escape) {
+					return true
+				}
+			}
+
+			return false
 
-	return result
+		case c == '_':
+			if len(value) == 0 {
+				return false
+			}
+
+			pattern, value = pattern[1:], value[1:]
+
+		default:
+			if len(value) == 0 || foldASCII(c) != foldASCII(value[0]) {
+				return false
+			}
+
+			pattern, value = pattern[1:], value[1:]
+		}
+	}
+
+	return len(value) == 0
 }
 
-func GetTableColumnIndex(createStatement string, columnNames []string) []int {
-	// re := regexp.MustCompile(`CREATE TABLE \w+\s*\(([^\)]+)\)`)
-	re := regexp.MustCompile(`CREATE TABLE ["']?\w+["']?\s*\(([^\)]+)\)`)
-	matches := re.FindStringSubmatch(createStatement)
+func foldASCII(c rune) rune {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+
+	return c
+}
+
+// Glob implements SQL GLOB: '*', '?' and '[...]' character classes, compared
+// case-sensitively.
+func Glob(pattern string, value string) bool {
+	return globMatch([]rune(pattern), []rune(value))
+}
+
+func globMatch(pattern []rune, value []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(value); i++ {
+				if globMatch(pattern, value[i:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(value) == 0 {
+				return false
+			}
+
+			pattern, value = pattern[1:], value[1:]
+
+		case '[':
+			if len(value) == 0 {
+				return false
+			}
+
+			matched, rest, ok := matchCharClass(pattern, value[0])
 
-	columns := strings.Split(matches[1], ",")
+			if !ok || !matched {
+				return false
+			}
 
-	var columnIndex []int
+			pattern, value = rest, value[1:]
 
-	for _, columnName := range columnNames {
-		for i, c := range columns {
-			if strings.Split(strings.TrimSpace(c), " ")[0] == columnName {
-				columnIndex = append(columnIndex, i)
-				break
+		default:
+			if len(value) == 0 || pattern[0] != value[0] {
+				return false
 			}
+
+			pattern, value = pattern[1:], value[1:]
 		}
 	}
 
-	return columnIndex
+	return len(value) == 0
 }
 
-func ParseWhereStatement(whereStatement string) []string {
-	result := strings.Split(whereStatement, "=")
-	result[1] = strings.ReplaceAll(result[1], "'", "")
-	result[1] = strings.Trim(result[1], " ")
-	result[0] = strings.ReplaceAll(result[0], " ", "")
+// matchCharClass matches c against the class opening pattern and returns the
+// pattern following the closing bracket.
+func matchCharClass(pattern []rune, c rune) (bool, []rune, bool) {
+	i := 1
+	invert := false
+
+	if i < len(pattern) && pattern[i] == '^' {
+		invert = true
+		i++
+	}
+
+	matched := false
+	first := true
+
+	for ; i < len(pattern); i++ {
+		if pattern[i] == ']' && !first {
+			return matched != invert, pattern[i+1:], true
+		}
+
+		first = false
+
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			if c >= pattern[i] && c <= pattern[i+2] {
+				matched = true
+			}
+
+			i += 2
+			continue
+		}
+
+		if pattern[i] == c {
+			matched = true
+		}
+	}
 
-	return result
+	return false, nil, false
 }
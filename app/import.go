@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/engine"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const importUsage = `.import FILE TABLE       Import data from FILE into TABLE
+   Options:
+     --ascii               Use \037 and \036 as column and row separators
+     --csv                 Use , and \n as column and row separators
+     --skip N              Skip the first N rows of input
+     -v                    "Verbose" - increase auxiliary output
+   Notes:
+     *  If TABLE does not exist, it is created.  The first row of input
+        determines the column names.
+     *  If neither --csv or --ascii are used, the input mode is derived
+        from the ".mode" output mode`
+
+// fieldReader reads delimited text one field at a time, keeping track of the
+// line number for messages. It follows sqlite3's readers, including how they
+// treat malformed quoting. csv is set for the RFC 4180 reader, which sqlite3
+// uses whatever the separators unless --ascii is given.
+type fieldReader struct {
+	input     *bufio.Reader
+	fileName  string
+	csv       bool
+	separator byte
+	rowEnd    byte
+	line      int
+	// terminator is what ended the last field: the separator, rowEnd, or -1
+	// at the end of the input
+	terminator int
+	started    bool
+}
+
+func (r *fieldReader) next() int {
+	c, err := r.input.ReadByte()
+
+	if err != nil {
+		return -1
+	}
+
+	return int(c)
+}
+
+// read returns the next field, and false once the input is exhausted.
+func (r *fieldReader) read() (string, bool) {
+	if r.csv {
+		return r.readCsv()
+	}
+
+	var field []byte
+
+	c := r.next()
+
+	if c == -1 {
+		r.terminator = -1
+		return "", false
+	}
+
+	for c != -1 && c != int(r.separator) && c != int(r.rowEnd) {
+		field = append(field, byte(c))
+		c = r.next()
+	}
+
+	if c == int(r.rowEnd) {
+		r.line++
+	}
+
+	r.terminator = c
+
+	return string(field), true
+}
+
+// readCsv reads an RFC 4180 field with the reader's separators. Quoted
+// fields may hold separators, row ends and doubled quotes.
+func (r *fieldReader) readCsv() (string, bool) {
+	const quote = '"'
+
+	var field []byte
+
+	c := r.next()
+
+	if c == -1 {
+		r.terminator = -1
+		return "", false
+	}
+
+	if c == quote {
+		startLine := r.line
+		previous, beforePrevious := 0, 0
+
+		for {
+			c = r.next()
+
+			if c == int(r.rowEnd) {
+				r.line++
+			}
+
+			if c == quote && previous == quote {
+				previous = 0
+				continue
+			}
+
+			if (c == int(r.separator) && previous == quote) ||
+				(c == int(r.rowEnd) && previous == quote) ||
+				(c == int(r.rowEnd) && previous == '\r' && beforePrevious == quote) ||
+				(c == -1 && previous == quote) {
+				// drop the closing quote and anything after it
+				end := len(field) - 1
+
+				for field[end] != quote {
+					end--
+				}
+
+				field = field[:end]
+				r.terminator = c
+
+				break
+			}
+
+			if previous == quote && c != '\r' {
+				fmt.Fprintf(os.Stderr, "%s:%d: unescaped %c character\n", r.fileName, r.line, quote)
+			}
+
+			if c == -1 {
+				fmt.Fprintf(os.Stderr, "%s:%d: unterminated %c-quoted field\n", r.fileName, startLine, quote)
+				r.terminator = c
+
+				break
+			}
+
+			field = append(field, byte(c))
+			beforePrevious = previous
+			previous = c
+		}
+
+		r.started = true
+
+		return string(field), true
+	}
+
+	if !r.started && c == 0xef {
+		// skip a UTF-8 byte order mark at the very start
+		if bom, err := r.input.Peek(2); err == nil && bom[0] == 0xbb && bom[1] == 0xbf {
+			r.input.Discard(2)
+			c = r.next()
+		}
+	}
+
+	r.started = true
+
+	for c != -1 && c != int(r.separator) && c != int(r.rowEnd) {
+		field = append(field, byte(c))
+		c = r.next()
+	}
+
+	if c == int(r.rowEnd) {
+		r.line++
+
+		if len(field) > 0 && field[len(field)-1] == '\r' {
+			field = field[:len(field)-1]
+		}
+	}
+
+	r.terminator = c
+
+	return string(field), true
+}
+
+// readRow reads the fields up to the end of the current row.
+func (r *fieldReader) readRow() ([]string, bool) {
+	var fields []string
+
+	for {
+		field, ok := r.read()
+
+		if !ok {
+			return fields, len(fields) > 0
+		}
+
+		fields = append(fields, field)
+
+		if r.terminator != int(r.separator) {
+			return fields, true
+		}
+	}
+}
+
+// importFile implements .import: it loads delimited rows from a file into a
+// table, creating the table from the first row when it doesn't exist. All
+// rows go in under one transaction; rows that fail are reported and skipped.
+func (s *shell) importFile(db *engine.DB, args []string) error {
+	var fileName, tableName string
+
+	csvMode := s.output.mode == modeCsv
+	ascii := false
+	separator, rowEnd := s.output.separator, s.output.rowSeparator
+	skip := 0
+	verbose := false
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--csv" || arg == "-csv":
+			csvMode, ascii = true, false
+			separator, rowEnd = ",", "\n"
+		case arg == "--ascii" || arg == "-ascii":
+			csvMode, ascii = false, true
+			separator, rowEnd = "\x1f", "\x1e"
+		case (arg == "--skip" || arg == "-skip") && i+1 < len(args):
+			i++
+			skip, _ = strconv.Atoi(args[i])
+		case arg == "-v":
+			verbose = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("%w: unknown option %q for .import", errUnknownCommand, arg)
+		case fileName == "":
+			fileName = arg
+		case tableName == "":
+			tableName = arg
+		default:
+			return fmt.Errorf("%w: extra argument: %q. Usage:\n%s", errUnknownCommand, arg, importUsage)
+		}
+	}
+
+	if fileName == "" || tableName == "" {
+		return fmt.Errorf("%w: missing FILE or TABLE argument. Usage:\n%s", errUnknownCommand, importUsage)
+	}
+
+	if csvMode && rowEnd == "\r\n" {
+		// .mode csv writes CRLF, but a LF ends rows on input; the CR is dropped
+		rowEnd = "\n"
+	}
+
+	if len(separator) != 1 {
+		return errors.New("multi-character column separators not allowed for import")
+	}
+
+	if len(rowEnd) != 1 {
+		return errors.New("multi-character row separators not allowed for import")
+	}
+
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		return fmt.Errorf("cannot open %q", fileName)
+	}
+
+	defer file.Close()
+
+	reader := &fieldReader{
+		input:     bufio.NewReaderSize(file, 64*1024),
+		fileName:  fileName,
+		csv:       !ascii,
+		separator: separator[0],
+		rowEnd:    rowEnd[0],
+		line:      1,
+	}
+
+	if verbose && !csvMode {
+		fmt.Fprintf(s.out, "Column separator %q, row separator %q\n", separator, rowEnd)
+	}
+
+	for ; skip > 0; skip-- {
+		reader.readRow()
+	}
+
+	if err := db.Begin(); err != nil {
+		return err
+	}
+
+	rows, failed, err := s.importRows(db, reader, tableName)
+
+	if err != nil {
+		db.Rollback()
+		return err
+	}
+
+	if err := db.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "Added %d rows with %d errors using %d lines of input\n", rows, failed, reader.line-1)
+
+	return nil
+}
+
+func (s *shell) importRows(db *engine.DB, reader *fieldReader, tableName string) (int, int, error) {
+	inserter, err := db.NewInserter(tableName)
+
+	if errors.Is(err, engine.ErrNoSuchTable) {
+		header, ok := reader.readRow()
+
+		if !ok {
+			return 0, 0, fmt.Errorf("%s: empty file", reader.fileName)
+		}
+
+		if err := db.CreateTable(importTableStatement(s.out, reader.fileName, tableName, header)); err != nil {
+			return 0, 0, err
+		}
+
+		inserter, err = db.NewInserter(tableName)
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	columnCount := len(inserter.Table().Definition.Columns)
+	rows, failed := 0, 0
+
+	for reader.terminator != -1 {
+		startLine := reader.line
+		fields, ok := reader.readRow()
+
+		if !ok {
+			break
+		}
+
+		if len(fields) < columnCount {
+			fmt.Fprintf(os.Stderr, "%s:%d: expected %d columns but found %d - filling the rest with NULL\n", reader.fileName, startLine, columnCount, len(fields))
+		}
+
+		if len(fields) > columnCount {
+			fmt.Fprintf(os.Stderr, "%s:%d: expected %d columns but found %d - extras ignored\n", reader.fileName, startLine, columnCount, len(fields))
+		}
+
+		values := make([]page.Value, columnCount)
+
+		for i := range values {
+			if i < len(fields) {
+				values[i] = page.Value{Type: page.TextValue, Bytes: []byte(fields[i])}
+			}
+		}
+
+		_, err := inserter.Insert(values)
+
+		switch {
+		case errors.Is(err, engine.ErrConstraint), errors.Is(err, engine.ErrMismatch):
+			fmt.Fprintf(os.Stderr, "%s:%d: INSERT failed: %v\n", reader.fileName, startLine, err)
+			failed++
+		case err != nil:
+			return rows, failed, err
+		default:
+			rows++
+		}
+	}
+
+	return rows, failed, nil
+}
+
+// importTableStatement builds the CREATE TABLE for an imported file's header
+// row the way sqlite3 does: every column is TEXT, unnamed columns become "?",
+// and names that repeat get their position appended.
+func importTableStatement(out io.Writer, fileName string, tableName string, header []string) string {
+	names := make([]string, len(header))
+	counts := make(map[string]int)
+
+	for i, name := range header {
+		if name == "" {
+			name = "?"
+		}
+
+		names[i] = name
+		counts[strings.ToLower(name)]++
+	}
+
+	var renamed []string
+
+	for i, name := range names {
+		if counts[strings.ToLower(name)] > 1 {
+			names[i] = fmt.Sprintf("%s_%d", name, i+1)
+			renamed = append(renamed, fmt.Sprintf("%s to %s", quoteName(name), quoteName(names[i])))
+		}
+	}
+
+	if len(renamed) > 0 {
+		fmt.Fprintf(out, "Columns renamed during .import %s due to duplicates:\n%s\n", fileName, strings.Join(renamed, ",\n"))
+	}
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "CREATE TABLE %s(\n", quoteName(tableName))
+
+	for i, name := range names {
+		switch {
+		case i == 0:
+		case i%4 == 0:
+			builder.WriteString(",\n ")
+		default:
+			builder.WriteString(", ")
+		}
+
+		fmt.Fprintf(&builder, "%s TEXT", quoteName(name))
+	}
+
+	builder.WriteString(")")
+
+	return builder.String()
+}
+
+// quoteName always double-quotes, unlike parser.QuoteIdentifier.
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -1,254 +1,131 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	helper "github/com/codecrafters-io/sqlite-starter-go/app/helper"
-	"github/com/codecrafters-io/sqlite-starter-go/app/page"
-	"log"
+	"github/com/codecrafters-io/sqlite-starter-go/app/engine"
 	"os"
-	"strconv"
 	"strings"
-
-	// Available if you need it!
-	"github.com/xwb1989/sqlparser"
 )
 
-// Usage: your_program.sh sample.db .dbinfo
-func main() {
-	databaseFilePath := os.Args[1]
-	command := os.Args[2]
-
-	switch command {
-	case ".dbinfo":
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		header := make([]byte, 100)
-
-		_, err = databaseFile.Read(header)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		databaseHeader, err := page.UnmarshalDbHeader(header)
-
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		pageHeader, err := page.PeakPageHeader(databaseFile, 1, int(databaseHeader.PageSize))
+const usage = `Usage: your_program.sh [OPTIONS] <database> [COMMAND...]
 
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		fmt.Printf("database page size: %v", databaseHeader.PageSize)
-		fmt.Printf("number of tables: %v\n", pageHeader.CellCount)
-	case ".tables":
+With no COMMAND, statements and dot-commands are read from standard input.
 
-		// structure
+OPTIONS:
+   -box -column -csv -insert -json -line -list -markdown -quote -tabs
+                        set the output mode
+   -header / -noheader  turn column headers on or off
+   -separator SEP       set the field separator
+   -newline SEP         set the row separator
+   -nullvalue TEXT      set the text printed for NULL`
 
-		// ======== file ==========
-		// 100 bytes (database header)
-		// ======== page ===========
-		// 8 bytes (page header)
-		// subsequent byte (2 bytes for each pointers) where nPointers is in page header cellCount
-
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
+// Usage: your_program.sh sample.db .dbinfo
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, errReported) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
 
-		// Read first 100 bytes (database header)
-		header := make([]byte, 100)
+		os.Exit(1)
+	}
+}
 
-		_, err = databaseFile.Read(header)
-		if err != nil {
-			log.Fatal(err)
-		}
+// errReported marks failures that were already printed while they happened.
+var errReported = errors.New("errors reported")
 
-		databaseHeader, err := page.UnmarshalDbHeader(header)
+func run(arguments []string) error {
+	output := defaultOutputSettings()
 
-		if err != nil {
-			log.Fatal(err)
-		}
+	var positional []string
 
-		rootPageCells := page.ReadFullTree(databaseFile, 1, int(databaseHeader.PageSize))
+	for i := 0; i < len(arguments); i++ {
+		arg := arguments[i]
 
-		if err != nil {
-			log.Fatal(err)
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
 		}
 
-		var tablesNames []string
-
-		for _, row := range rootPageCells {
+		option := "-" + strings.TrimLeft(arg, "-")
+
+		switch option {
+		case "-header":
+			output.headers = true
+			output.headersSet = true
+		case "-noheader":
+			output.headers = false
+			output.headersSet = true
+		case "-separator", "-newline", "-nullvalue":
+			if i+1 >= len(arguments) {
+				return fmt.Errorf("missing argument to %s", arg)
+			}
 
-			pointer := page.UnmarshalRootPagePointer(row.Columns)
+			i++
+			value := unescapeArg(arguments[i])
 
-			if pointer.PageType == "table" {
-				tablesNames = append(tablesNames, pointer.TableName)
+			switch option {
+			case "-separator":
+				output.separator = value
+			case "-newline":
+				output.rowSeparator = value
+			default:
+				output.nullValue = value
 			}
-		}
-
-		fmt.Println(strings.Join(tablesNames, " "))
+		case "-list", "-csv", "-tabs", "-json", "-line", "-column", "-box", "-markdown", "-insert", "-quote":
+			// command-line modes keep "\n" between rows, unlike .mode csv
+			rowSeparator := output.rowSeparator
 
-	default:
+			if err := output.setMode(outputMode(option[1:]), nil); err != nil {
+				return err
+			}
 
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
+			output.rowSeparator = rowSeparator
+		case "-help":
+			fmt.Println(usage)
+			return nil
+		default:
+			return fmt.Errorf("unknown option: %s\n%s", arg, usage)
 		}
+	}
 
-		handleQuery(command, databaseFile)
-
+	if len(positional) == 0 {
+		return errors.New(usage)
 	}
-}
 
-func handleQuery(queries string, databaseFile *os.File) {
-	parsedQuery, err := sqlparser.Parse(queries)
+	db, err := engine.Open(positional[0])
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	switch parsedQuery := parsedQuery.(type) {
+	defer db.Close()
 
-	case *sqlparser.Select:
-		tableName := sqlparser.String(parsedQuery.From[0])
-		selectExp := sqlparser.String(parsedQuery.SelectExprs[0])
-		whereStatement := sqlparser.String(parsedQuery.Where)
-		var whereExp []string // 0 is for col_name, 1 is for value
-
-		if whereStatement != "" {
-			whereStatement = sqlparser.String(parsedQuery.Where.Expr)
-			whereExp = helper.ParseWhereStatement(whereStatement)
-		}
+	shell := newShell(db, os.Stdout)
+	shell.output = output
 
-		var col_names []string
+	if len(positional) == 1 {
+		stat, err := os.Stdin.Stat()
+		interactive := err == nil && stat.Mode()&os.ModeCharDevice != 0
 
-		for _, col_name := range parsedQuery.SelectExprs {
-			col_names = append(col_names, sqlparser.String(col_name))
+		if err := shell.repl(os.Stdin, interactive); err != nil {
+			return errReported
 		}
 
-		header := make([]byte, 100)
+		return nil
+	}
 
-		_, err := databaseFile.Read(header)
+	for _, command := range positional[1:] {
+		err := shell.execute(command)
 
-		if err != nil {
-			log.Fatal(err)
+		if errors.Is(err, errExit) {
+			return nil
 		}
 
-		databaseHeader, err := page.UnmarshalDbHeader(header)
-
 		if err != nil {
-			log.Fatal(err)
-		}
-
-		rootPageCells := page.ReadFullTree(databaseFile, 1, int(databaseHeader.PageSize))
-
-		if strings.Contains(strings.ToLower(selectExp), "count") {
-
-			for _, row := range rootPageCells {
-				if string(row.Columns[0]) == "table" && string(row.Columns[1]) == tableName {
-					targetPageHeader, err := page.PeakPageHeader(databaseFile, int(row.Columns[3][0]), int(databaseHeader.PageSize))
-
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					fmt.Println(targetPageHeader.CellCount)
-				}
-			}
-
-		} else {
-			rootPagePointers := make(map[string]page.RootPagePointer)
-
-			for _, cell := range rootPageCells {
-				if string(cell.Columns[2]) != tableName {
-					continue
-				}
-				// 0 is for type
-				// 1 is for name of object created
-				// 2 is name of table
-				// 3 is for page number
-				// 4 is for create statement
-				var values [][]byte
-				values = append(values, cell.Columns...)
-
-				name := string(values[0]) + "-" + string(values[2])
-
-				firstPagePointer := page.UnmarshalRootPagePointer(cell.Columns)
-
-				rootPagePointers[name] = firstPagePointer
-			}
-
-			table := "table-" + tableName
-			index := "index-" + tableName
-
-			var results []page.Cell
-
-			if _, ok := rootPagePointers[index]; ok {
-				pointer := rootPagePointers[index]
-				parsedCreateIndexStatemet := helper.ParseCreateIndexStatement(pointer.CreateStatement)
-
-				if helper.ArrayContain(whereExp[0], parsedCreateIndexStatemet) {
-					indexPageNum := uint32(rootPagePointers[index].PageNumber)
-					tablePageNum := uint32(rootPagePointers[table].PageNumber)
-
-					var ids []int64
-
-					page.ReadIndex(databaseFile, int(indexPageNum), int(databaseHeader.PageSize), whereExp, &ids)
-
-					results = page.ReadTree(databaseFile, int(tablePageNum), int(databaseHeader.PageSize), &ids)
-				}
-
-			} else {
-				tablePageNum := uint32(rootPagePointers[table].PageNumber)
-
-				results = page.ReadFullTree(databaseFile, int(tablePageNum), int(databaseHeader.PageSize))
-			}
-
-			tableColumnIndexes := helper.GetTableColumnIndex(string(rootPagePointers[table].CreateStatement), col_names)
-
-			filter_col_index := -1
-
-			if whereStatement != "" {
-				filter_col_index = helper.GetTableColumnIndex(string(rootPagePointers[table].CreateStatement), []string{whereExp[0]})[0]
-			}
-
-			var col_results [][]string
-
-			for _, row := range results {
-				// if there is no "WHERE" statement
-				// or if the row's column data doesnt match the filter
-				// we skip it
-				if filter_col_index != -1 && string(row.Columns[filter_col_index]) != whereExp[1] {
-					continue
-				}
-
-				var col_result []string
-
-				for _, index := range tableColumnIndexes {
-					if index == 0 {
-						col_result = append(col_result, strconv.Itoa(int(row.CellIdx)))
-						continue
-					}
-
-					col_result = append(col_result, string(row.Columns[index]))
-				}
-
-				col_results = append(col_results, col_result)
-			}
-
-			for _, col_result := range col_results {
-				result := strings.Join(col_result, "|")
-				fmt.Println(result)
-			}
-
+			return err
 		}
 	}
 
+	return nil
 }
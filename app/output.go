@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/engine"
+	"github/com/codecrafters-io/sqlite-starter-go/app/page"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type outputMode string
+
+const (
+	modeList     outputMode = "list"
+	modeCsv      outputMode = "csv"
+	modeTabs     outputMode = "tabs"
+	modeJson     outputMode = "json"
+	modeLine     outputMode = "line"
+	modeColumn   outputMode = "column"
+	modeBox      outputMode = "box"
+	modeMarkdown outputMode = "markdown"
+	modeInsert   outputMode = "insert"
+	modeQuote    outputMode = "quote"
+)
+
+// outputSettings is the state behind .mode, .headers, .separator and .nullvalue.
+type outputSettings struct {
+	mode         outputMode
+	headers      bool
+	headersSet   bool // .headers was given explicitly, so modes don't override it
+	separator    string
+	rowSeparator string
+	nullValue    string
+	insertTable  string
+}
+
+func defaultOutputSettings() outputSettings {
+	return outputSettings{
+		mode:         modeList,
+		separator:    "|",
+		rowSeparator: "\n",
+		insertTable:  "table",
+	}
+}
+
+// setMode switches the output mode along with the separators that go with it.
+func (s *outputSettings) setMode(mode outputMode, args []string) error {
+	switch mode {
+	case modeList:
+		s.separator = "|"
+		s.rowSeparator = "\n"
+	case modeCsv:
+		s.separator = ","
+		s.rowSeparator = "\r\n"
+	case modeTabs:
+		s.separator = "\t"
+		s.rowSeparator = "\n"
+	case modeQuote:
+		s.separator = ","
+		s.rowSeparator = "\n"
+	case modeColumn:
+		if !s.headersSet {
+			s.headers = true
+		}
+	case modeInsert:
+		s.insertTable = "table"
+
+		if len(args) > 0 {
+			s.insertTable = args[0]
+		}
+	case modeJson, modeLine, modeBox, modeMarkdown:
+	default:
+		return fmt.Errorf("%w: mode should be one of: box column csv insert json line list markdown quote tabs", errUnknownCommand)
+	}
+
+	s.mode = mode
+
+	return nil
+}
+
+func printResult(out io.Writer, settings *outputSettings, result *engine.Result) {
+	// like sqlite3, print nothing at all, not even headers, without rows
+	if len(result.Rows) == 0 {
+		return
+	}
+
+	switch settings.mode {
+	case modeCsv:
+		printSeparated(out, settings, result, csvField)
+	case modeQuote:
+		printSeparated(out, settings, result, func(_ *outputSettings, value page.Value) string {
+			return quoteLiteral(value)
+		})
+	case modeJson:
+		printJson(out, result)
+	case modeLine:
+		printLines(out, settings, result)
+	case modeColumn, modeBox, modeMarkdown:
+		printColumnar(out, settings, result)
+	case modeInsert:
+		table := parser.QuoteIdentifier(settings.insertTable)
+
+		// with headers on, sqlite3 names the columns the values go in
+		if settings.headers {
+			names := make([]string, len(result.Columns))
+
+			for i, name := range result.Columns {
+				names[i] = parser.QuoteIdentifier(name)
+			}
+
+			table += "(" + strings.Join(names, ",") + ")"
+		}
+
+		for _, row := range result.Rows {
+			literals := make([]string, len(row))
+
+			for i, value := range row {
+				literals[i] = sqlLiteral(value)
+			}
+
+			fmt.Fprintf(out, "INSERT INTO %s VALUES(%s);\n", table, strings.Join(literals, ","))
+		}
+	default:
+		printSeparated(out, settings, result, func(settings *outputSettings, value page.Value) string {
+			return displayText(settings, value)
+		})
+	}
+}
+
+// printQueryPlan draws the rows of EXPLAIN QUERY PLAN as a tree under the
+// heading QUERY PLAN, each step below the one it is part of.
+func printQueryPlan(out io.Writer, result *engine.Result) {
+	parents := make(map[int64]int64)
+	last := make(map[int64]bool)
+	lastChild := make(map[int64]int64)
+
+	for _, row := range result.Rows {
+		parents[row[0].Int] = row[1].Int
+		lastChild[row[1].Int] = row[0].Int
+	}
+
+	for _, id := range lastChild {
+		last[id] = true
+	}
+
+	fmt.Fprintln(out, "QUERY PLAN")
+
+	for _, row := range result.Rows {
+		branch := "|--"
+
+		if last[row[0].Int] {
+			branch = "`--"
+		}
+
+		for parent := row[1].Int; parent != 0; parent = parents[parent] {
+			if last[parent] {
+				branch = "   " + branch
+			} else {
+				branch = "|  " + branch
+			}
+		}
+
+		fmt.Fprintf(out, "%s%s\n", branch, row[3].Bytes)
+	}
+}
+
+func displayText(settings *outputSettings, value page.Value) string {
+	if value.Type == page.NullValue {
+		return settings.nullValue
+	}
+
+	return value.String()
+}
+
+// printSeparated covers the modes that print one line per row with fields
+// joined by the separator.
+func printSeparated(out io.Writer, settings *outputSettings, result *engine.Result, format func(*outputSettings, page.Value) string) {
+	if settings.headers {
+		names := make([]string, len(result.Columns))
+
+		for i, name := range result.Columns {
+			names[i] = format(settings, page.Value{Type: page.TextValue, Bytes: []byte(name)})
+		}
+
+		fmt.Fprint(out, strings.Join(names, settings.separator), settings.rowSeparator)
+	}
+
+	for _, row := range result.Rows {
+		fields := make([]string, len(row))
+
+		for i, value := range row {
+			fields[i] = format(settings, value)
+		}
+
+		fmt.Fprint(out, strings.Join(fields, settings.separator), settings.rowSeparator)
+	}
+}
+
+// csvField quotes a field when it is empty, contains the separator, or has
+// a character sqlite3 considers unsafe: controls, space, quotes or non-ASCII.
+func csvField(settings *outputSettings, value page.Value) string {
+	if value.Type == page.NullValue {
+		return settings.nullValue
+	}
+
+	text := value.String()
+
+	needsQuote := text == "" || strings.Contains(text, settings.separator)
+
+	for i := 0; i < len(text) && !needsQuote; i++ {
+		c := text[i]
+		needsQuote = c <= ' ' || c == '"' || c == '\'' || c >= 0x7f
+	}
+
+	if needsQuote {
+		return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	}
+
+	return text
+}
+
+// quoteLiteral is like sqlLiteral but keeps control characters as they are.
+func quoteLiteral(value page.Value) string {
+	if value.Type == page.TextValue {
+		return "'" + strings.ReplaceAll(string(value.Bytes), "'", "''") + "'"
+	}
+
+	return sqlLiteral(value)
+}
+
+func printJson(out io.Writer, result *engine.Result) {
+	for i, row := range result.Rows {
+		if i == 0 {
+			fmt.Fprint(out, "[{")
+		} else {
+			fmt.Fprint(out, ",\n{")
+		}
+
+		for j, value := range row {
+			if j > 0 {
+				fmt.Fprint(out, ",")
+			}
+
+			fmt.Fprintf(out, "%s:%s", jsonString(result.Columns[j]), jsonValue(value))
+		}
+
+		fmt.Fprint(out, "}")
+	}
+
+	if len(result.Rows) > 0 {
+		fmt.Fprintln(out, "]")
+	}
+}
+
+// jsonValue keeps SQL types distinct: numbers stay numbers, NULL is null and
+// BLOBs become hex strings.
+func jsonValue(value page.Value) string {
+	switch value.Type {
+	case page.NullValue:
+		return "null"
+	case page.IntegerValue:
+		return strconv.FormatInt(value.Int, 10)
+	case page.FloatValue:
+		return realLiteral(value.Float)
+	case page.BlobValue:
+		return `"` + hex.EncodeToString(value.Bytes) + `"`
+	default:
+		return jsonString(string(value.Bytes))
+	}
+}
+
+func jsonString(text string) string {
+	var builder strings.Builder
+
+	builder.WriteByte('"')
+
+	for _, r := range text {
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\f':
+			builder.WriteString(`\f`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&builder, `\u%04x`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
+// printLines prints one "column = value" line per column, with names
+// right-aligned and a blank line between rows.
+func printLines(out io.Writer, settings *outputSettings, result *engine.Result) {
+	width := 5
+
+	for _, name := range result.Columns {
+		width = max(width, utf8.RuneCountInString(name))
+	}
+
+	for i, row := range result.Rows {
+		if i > 0 {
+			fmt.Fprint(out, settings.rowSeparator)
+		}
+
+		for j, value := range row {
+			fmt.Fprintf(out, "%*s = %s\n", width, result.Columns[j], displayText(settings, value))
+		}
+	}
+}
+
+// printColumnar lays rows out in aligned columns. Values spanning several
+// lines are split across output lines, and when any row does that the rows
+// get separated so they stay readable.
+func printColumnar(out io.Writer, settings *outputSettings, result *engine.Result) {
+	if len(result.Columns) == 0 {
+		return
+	}
+
+	cells := make([][][]string, len(result.Rows))
+	widths := make([]int, len(result.Columns))
+	multiLine := false
+
+	for i, name := range result.Columns {
+		widths[i] = utf8.RuneCountInString(name)
+	}
+
+	for i, row := range result.Rows {
+		cells[i] = make([][]string, len(row))
+
+		for j, value := range row {
+			lines := strings.Split(expandTabs(displayText(settings, value)), "\n")
+			multiLine = multiLine || len(lines) > 1
+			cells[i][j] = lines
+
+			for _, line := range lines {
+				widths[j] = max(widths[j], utf8.RuneCountInString(line))
+			}
+		}
+	}
+
+	if settings.mode == modeColumn {
+		printColumnMode(out, settings, result.Columns, cells, widths, multiLine)
+		return
+	}
+
+	left, middle, right, separatorLine := "| ", " | ", " |", markdownSeparator(widths)
+
+	if settings.mode == modeBox {
+		left, middle, right = "│ ", " │ ", " │"
+		separatorLine = boxLine(widths, "├", "┼", "┤")
+		fmt.Fprintln(out, boxLine(widths, "┌", "┬", "┐"))
+	}
+
+	headers := make([]string, len(result.Columns))
+
+	for i, name := range result.Columns {
+		headers[i] = center(name, widths[i])
+	}
+
+	fmt.Fprintln(out, left+strings.Join(headers, middle)+right)
+	fmt.Fprintln(out, separatorLine)
+
+	for i, row := range cells {
+		if i > 0 && multiLine && settings.mode == modeBox {
+			fmt.Fprintln(out, separatorLine)
+		}
+
+		for _, line := range rowLines(row, widths) {
+			fmt.Fprintln(out, left+strings.Join(line, middle)+right)
+		}
+	}
+
+	if settings.mode == modeBox {
+		fmt.Fprintln(out, boxLine(widths, "└", "┴", "┘"))
+	}
+}
+
+func printColumnMode(out io.Writer, settings *outputSettings, columns []string, cells [][][]string, widths []int, multiLine bool) {
+	if settings.headers {
+		headers := make([]string, len(columns))
+		dashes := make([]string, len(columns))
+
+		for i, name := range columns {
+			headers[i] = pad(name, widths[i])
+			dashes[i] = strings.Repeat("-", widths[i])
+		}
+
+		fmt.Fprintln(out, strings.Join(headers, "  "))
+		fmt.Fprintln(out, strings.Join(dashes, "  "))
+	}
+
+	for i, row := range cells {
+		if i > 0 && multiLine {
+			fmt.Fprintln(out)
+		}
+
+		for _, line := range rowLines(row, widths) {
+			fmt.Fprintln(out, strings.Join(line, "  "))
+		}
+	}
+}
+
+// rowLines turns a row of possibly multi-line cells into padded output lines.
+func rowLines(row [][]string, widths []int) [][]string {
+	height := 1
+
+	for _, lines := range row {
+		height = max(height, len(lines))
+	}
+
+	output := make([][]string, height)
+
+	for i := range output {
+		output[i] = make([]string, len(row))
+
+		for j, lines := range row {
+			text := ""
+
+			if i < len(lines) {
+				text = lines[i]
+			}
+
+			output[i][j] = pad(text, widths[j])
+		}
+	}
+
+	return output
+}
+
+func pad(text string, width int) string {
+	return text + strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0))
+}
+
+func center(text string, width int) string {
+	space := max(width-utf8.RuneCountInString(text), 0)
+
+	return strings.Repeat(" ", space/2) + text + strings.Repeat(" ", space-space/2)
+}
+
+func expandTabs(text string) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+
+	var builder strings.Builder
+
+	column := 0
+
+	for _, r := range text {
+		switch r {
+		case '\t':
+			spaces := 8 - column%8
+			builder.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		case '\n':
+			builder.WriteRune(r)
+			column = 0
+		default:
+			builder.WriteRune(r)
+			column++
+		}
+	}
+
+	return builder.String()
+}
+
+func boxLine(widths []int, left string, middle string, right string) string {
+	segments := make([]string, len(widths))
+
+	for i, width := range widths {
+		segments[i] = strings.Repeat("─", width+2)
+	}
+
+	return left + strings.Join(segments, middle) + right
+}
+
+func markdownSeparator(widths []int) string {
+	segments := make([]string, len(widths))
+
+	for i, width := range widths {
+		segments[i] = strings.Repeat("-", width+2)
+	}
+
+	return "|" + strings.Join(segments, "|") + "|"
+}
@@ -0,0 +1,180 @@
+package page
+
+import (
+	"encoding/binary"
+	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
+)
+
+// DeleteTableRow removes the row with rowID from the table b-tree at root
+// and reports whether there was one. Its overflow pages, and any page the
+// removal leaves empty, go on the freelist.
+func DeleteTableRow(pager *Pager, root int, rowID int64) (bool, error) {
+	path, found, err := seekTable(pager, root, rowID)
+
+	if err != nil || !found {
+		return false, err
+	}
+
+	leaf := path[len(path)-1]
+
+	if err := freeOverflow(pager, leaf.node.cells[leaf.position]); err != nil {
+		return false, withPageNumber(err, leaf.node.number)
+	}
+
+	leaf.node.cells = removeCell(leaf.node.cells, leaf.position)
+
+	return true, removeEmpty(pager, path)
+}
+
+// freeOverflow frees the overflow chain of a table leaf cell.
+func freeOverflow(pager *Pager, cell []byte) error {
+	payloadSize, sizeLength, err := helper.DecodeVarint(&cell, 0)
+
+	if err != nil {
+		return err
+	}
+
+	_, keyLength, err := helper.DecodeVarint(&cell, int64(sizeLength))
+
+	if err != nil {
+		return err
+	}
+
+	maxLocal := tableLeafMaxLocal(pager.UsableSize())
+
+	if payloadSize <= uint64(maxLocal) {
+		return nil
+	}
+
+	local := localPayloadSize(payloadSize, pager.UsableSize(), maxLocal)
+	end := sizeLength + keyLength + local
+
+	if end+4 > len(cell) {
+		return corruptPage(0, 0, "cell is too short for its overflow pointer")
+	}
+
+	next := binary.BigEndian.Uint32(cell[end : end+4])
+
+	for remaining := int(payloadSize) - local; remaining > 0 && next != 0; remaining -= pager.UsableSize() - 4 {
+		if int(next) > pager.PageCount() {
+			return corruptPage(int(next), 0, "overflow page is out of range")
+		}
+
+		data, err := pager.Page(int(next))
+
+		if err != nil {
+			return err
+		}
+
+		pageNumber := next
+		next = binary.BigEndian.Uint32(data[0:4])
+
+		if err := pager.Free(int(pageNumber)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeCell(cells [][]byte, position int) [][]byte {
+	result := make([][]byte, 0, len(cells)-1)
+	result = append(result, cells[:position]...)
+
+	return append(result, cells[position+1:]...)
+}
+
+// removeEmpty writes the pages of path after the leaf at its end lost a
+// cell. A page other than the root that is left empty is freed and dropped
+// from its parent, an interior page left with one child is replaced by it,
+// and a root left with one child takes over the child's content when it
+// fits.
+func removeEmpty(pager *Pager, path []pathStep) error {
+	for level := len(path) - 1; level > 0; level-- {
+		n := path[level].node
+		parent := path[level-1]
+
+		switch {
+		case !isInterior(n.pageType) && len(n.cells) == 0:
+			if err := pager.Free(n.number); err != nil {
+				return err
+			}
+
+			dropChild(parent.node, parent.position)
+		case isInterior(n.pageType) && len(n.cells) == 0:
+			if err := pager.Free(n.number); err != nil {
+				return err
+			}
+
+			setChild(parent.node, parent.position, n.right)
+
+			return parent.node.write(pager)
+		default:
+			return n.write(pager)
+		}
+	}
+
+	root := path[0].node
+
+	if isInterior(root.pageType) && len(root.cells) == 0 {
+		child, err := loadNode(pager, int(root.right))
+
+		if err != nil {
+			return err
+		}
+
+		shallower := &node{number: root.number, pageType: child.pageType, cells: child.cells, right: child.right}
+
+		// only the first page, which also holds the database header, can be
+		// too small for its child's content; the root then keeps pointing at it
+		if shallower.used() <= shallower.capacity(pager) {
+			if err := pager.Free(child.number); err != nil {
+				return err
+			}
+
+			root = shallower
+		}
+	}
+
+	return root.write(pager)
+}
+
+// dropChild removes the child at position from an interior page. When that
+// was its last child, the page becomes an empty leaf, which the level above
+// deals with.
+func dropChild(n *node, position int) {
+	switch {
+	case position < len(n.cells):
+		n.cells = removeCell(n.cells, position)
+	case len(n.cells) > 0:
+		// the child before the rightmost one takes its place
+		n.right = childPage(n.cells[len(n.cells)-1])
+		n.cells = n.cells[:len(n.cells)-1]
+	default:
+		n.pageType = leafType(n.pageType)
+		n.right = 0
+	}
+}
+
+// setChild points the child at position of an interior page elsewhere.
+func setChild(n *node, position int, child uint32) {
+	if position == len(n.cells) {
+		n.right = child
+		return
+	}
+
+	cell := append([]byte{}, n.cells[position]...)
+	binary.BigEndian.PutUint32(cell[0:4], child)
+	n.cells[position] = cell
+}
+
+func leafType(pageType uint8) uint8 {
+	switch pageType {
+	case InteriorTablePage:
+		return LeafTablePage
+	case InteriorIndexPage:
+		return LeafIndexPage
+	}
+
+	return pageType
+}
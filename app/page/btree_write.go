@@ -0,0 +1,720 @@
+package page
+
+import (
+	"encoding/binary"
+	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
+	"sort"
+	"sync/atomic"
+)
+
+// node is a b-tree page broken into its raw cells, which is the form pages
+// are rearranged in when entries are inserted.
+type node struct {
+	number   int
+	pageType uint8
+	cells    [][]byte
+	right    uint32
+}
+
+func isInterior(pageType uint8) bool {
+	return pageType == InteriorTablePage || pageType == InteriorIndexPage
+}
+
+func headerSize(pageType uint8) int {
+	if isInterior(pageType) {
+		return 12
+	}
+
+	return 8
+}
+
+func headerOffset(pageNumber int) int {
+	if pageNumber == 1 {
+		return 100
+	}
+
+	return 0
+}
+
+func loadNode(pager *Pager, pageNumber int) (*node, error) {
+	buff, err := pager.Page(pageNumber)
+
+	if err != nil {
+		return nil, err
+	}
+
+	offset := headerOffset(pageNumber)
+
+	header, err := unmarshalPageHeader(buff[offset : offset+8])
+
+	if err != nil {
+		return nil, withPageNumber(err, pageNumber)
+	}
+
+	n := &node{number: pageNumber, pageType: header.PageType}
+
+	if isInterior(header.PageType) {
+		n.right = binary.BigEndian.Uint32(buff[offset+8 : offset+12])
+	}
+
+	pointersStart := offset + headerSize(header.PageType)
+	pointersEnd := pointersStart + int(header.CellCount)*2
+
+	if pointersEnd > len(buff) {
+		return nil, corruptPage(pageNumber, offset, "%d cell pointers overflow page", header.CellCount)
+	}
+
+	usableSize := pager.UsableSize()
+	n.cells = make([][]byte, header.CellCount)
+
+	for i := range n.cells {
+		pointer := int(binary.BigEndian.Uint16(buff[pointersStart+2*i:]))
+
+		if pointer < pointersEnd || pointer >= usableSize {
+			return nil, corruptPage(pageNumber, pointer, "cell pointer outside the cell content area")
+		}
+
+		size, err := cellSize(buff, pointer, header.PageType, usableSize)
+
+		if err != nil {
+			return nil, withPageNumber(err, pageNumber)
+		}
+
+		n.cells[i] = buff[pointer : pointer+size]
+	}
+
+	return n, nil
+}
+
+// cellSize measures the cell at offset without decoding its payload.
+func cellSize(data []byte, offset int, pageType uint8, usableSize int) (int, error) {
+	start := offset
+
+	if isInterior(pageType) {
+		offset += 4
+	}
+
+	payloadSize, size, err := readVarint(data, offset)
+
+	if err != nil {
+		return 0, err
+	}
+
+	offset += size
+
+	var maxLocal int
+
+	switch pageType {
+	case InteriorTablePage:
+		// the varint just read was the key; there is no payload
+		return offset - start, nil
+	case LeafTablePage:
+		_, size, err := readVarint(data, offset)
+
+		if err != nil {
+			return 0, err
+		}
+
+		offset += size
+		maxLocal = tableLeafMaxLocal(usableSize)
+	default:
+		maxLocal = indexMaxLocal(usableSize)
+	}
+
+	if payloadSize <= uint64(maxLocal) {
+		offset += int(payloadSize)
+	} else {
+		offset += localPayloadSize(payloadSize, usableSize, maxLocal) + 4
+	}
+
+	if offset > len(data) {
+		return 0, corruptPage(0, start, "cell overflows page")
+	}
+
+	return offset - start, nil
+}
+
+// capacity is the room a node has for cells and their pointers.
+func (n *node) capacity(pager *Pager) int {
+	return pager.UsableSize() - headerOffset(n.number) - headerSize(n.pageType)
+}
+
+func (n *node) used() int {
+	used := 0
+
+	for _, cell := range n.cells {
+		used += len(cell) + 2
+	}
+
+	return used
+}
+
+// write lays the node out as a page, with the cells packed against the end
+// of the usable area and no free blocks.
+func (n *node) write(pager *Pager) error {
+	buff := make([]byte, pager.PageSize())
+
+	if n.number <= pager.PageCount() {
+		if old, err := pager.page(n.number); err == nil {
+			// keep the database header and the reserved bytes
+			copy(buff[:headerOffset(n.number)], old)
+			copy(buff[pager.UsableSize():], old[pager.UsableSize():])
+		}
+	}
+
+	offset := headerOffset(n.number)
+	buff[offset] = n.pageType
+	binary.BigEndian.PutUint16(buff[offset+3:offset+5], uint16(len(n.cells)))
+
+	if isInterior(n.pageType) {
+		binary.BigEndian.PutUint32(buff[offset+8:offset+12], n.right)
+	}
+
+	pointer := offset + headerSize(n.pageType)
+	contentStart := pager.UsableSize()
+
+	for _, cell := range n.cells {
+		contentStart -= len(cell)
+		copy(buff[contentStart:], cell)
+		binary.BigEndian.PutUint16(buff[pointer:pointer+2], uint16(contentStart))
+		pointer += 2
+	}
+
+	// a content area starting at 65536 is stored as 0
+	binary.BigEndian.PutUint16(buff[offset+5:offset+7], uint16(contentStart))
+
+	return pager.Write(n.number, buff)
+}
+
+// CreateBTree allocates an empty root page for a new table or index.
+func CreateBTree(pager *Pager, index bool) (int, error) {
+	pageNumber, err := pager.Allocate()
+
+	if err != nil {
+		return 0, err
+	}
+
+	root := &node{number: pageNumber, pageType: LeafTablePage}
+
+	if index {
+		root.pageType = LeafIndexPage
+	}
+
+	return pageNumber, root.write(pager)
+}
+
+// buildCell lays out a cell of prefix followed by payload, moving what
+// doesn't fit in maxLocal to a chain of overflow pages.
+func buildCell(pager *Pager, prefix []byte, payload []byte, maxLocal int) ([]byte, error) {
+	cell := append([]byte{}, prefix...)
+
+	if len(payload) <= maxLocal {
+		return append(cell, payload...), nil
+	}
+
+	usableSize := pager.UsableSize()
+	localSize := localPayloadSize(uint64(len(payload)), usableSize, maxLocal)
+	cell = append(cell, payload[:localSize]...)
+
+	rest := payload[localSize:]
+	var pages []int
+
+	for remaining := len(rest); remaining > 0; remaining -= usableSize - 4 {
+		pageNumber, err := pager.Allocate()
+
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, pageNumber)
+	}
+
+	for i, pageNumber := range pages {
+		buff := make([]byte, pager.PageSize())
+
+		if i+1 < len(pages) {
+			binary.BigEndian.PutUint32(buff[0:4], uint32(pages[i+1]))
+		}
+
+		chunk := min(len(rest), usableSize-4)
+		copy(buff[4:], rest[:chunk])
+		rest = rest[chunk:]
+
+		if err := pager.Write(pageNumber, buff); err != nil {
+			return nil, err
+		}
+	}
+
+	return binary.BigEndian.AppendUint32(cell, uint32(pages[0])), nil
+}
+
+// pathStep is one page on the way from the root down to a leaf, with the
+// position of the child that was followed or, on the leaf, of the new cell.
+type pathStep struct {
+	node     *node
+	position int
+}
+
+func childPage(cell []byte) uint32 {
+	return binary.BigEndian.Uint32(cell[0:4])
+}
+
+func tableCellKey(cell []byte, pageType uint8) int64 {
+	offset := 0
+
+	if pageType == InteriorTablePage {
+		offset = 4
+	} else {
+		// skip the payload size
+		_, size, _ := helper.DecodeVarint(&cell, 0)
+		offset = size
+	}
+
+	key, _, _ := helper.DecodeVarint(&cell, int64(offset))
+
+	return int64(key)
+}
+
+// seekTable walks down to the leaf where rowID belongs.
+func seekTable(pager *Pager, root int, rowID int64) ([]pathStep, bool, error) {
+	var path []pathStep
+
+	pageNumber := root
+
+	for {
+		n, err := loadNode(pager, pageNumber)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch n.pageType {
+		case InteriorTablePage:
+			position := sort.Search(len(n.cells), func(i int) bool {
+				return tableCellKey(n.cells[i], n.pageType) >= rowID
+			})
+
+			path = append(path, pathStep{node: n, position: position})
+
+			if position < len(n.cells) {
+				pageNumber = int(childPage(n.cells[position]))
+			} else {
+				pageNumber = int(n.right)
+			}
+		case LeafTablePage:
+			position := sort.Search(len(n.cells), func(i int) bool {
+				return tableCellKey(n.cells[i], n.pageType) >= rowID
+			})
+
+			found := position < len(n.cells) && tableCellKey(n.cells[position], n.pageType) == rowID
+			path = append(path, pathStep{node: n, position: position})
+
+			return path, found, nil
+		default:
+			return nil, false, corruptPage(pageNumber, 0, "expected a table page, found type 0x%02x", n.pageType)
+		}
+
+		if len(path) > 64 {
+			return nil, false, corruptPage(pageNumber, 0, "b-tree is too deep")
+		}
+	}
+}
+
+// InsertTableRow adds a record to the table b-tree at root. When the rowid
+// is already taken it fails with ErrDuplicateKey without changing anything.
+func InsertTableRow(pager *Pager, root int, rowID int64, record []byte) error {
+	path, found, err := seekTable(pager, root, rowID)
+
+	if err != nil {
+		return err
+	}
+
+	if found {
+		return ErrDuplicateKey
+	}
+
+	prefix := helper.EncodeVarint(uint64(len(record)))
+	prefix = append(prefix, helper.EncodeVarint(uint64(rowID))...)
+
+	cell, err := buildCell(pager, prefix, record, tableLeafMaxLocal(pager.UsableSize()))
+
+	if err != nil {
+		return err
+	}
+
+	return insertCell(pager, path, cell)
+}
+
+// RowExists reports whether the table b-tree at root has a row with rowID.
+func RowExists(pager *Pager, root int, rowID int64) (bool, error) {
+	_, found, err := seekTable(pager, root, rowID)
+
+	return found, err
+}
+
+// LastRowID returns the largest rowid in the table b-tree at root, or 0 when
+// the table is empty.
+func LastRowID(pager *Pager, root int) (int64, error) {
+	pageNumber := root
+
+	for depth := 0; depth < 64; depth++ {
+		n, err := loadNode(pager, pageNumber)
+
+		if err != nil {
+			return 0, err
+		}
+
+		switch n.pageType {
+		case InteriorTablePage:
+			pageNumber = int(n.right)
+		case LeafTablePage:
+			if len(n.cells) == 0 {
+				return 0, nil
+			}
+
+			return tableCellKey(n.cells[len(n.cells)-1], n.pageType), nil
+		default:
+			return 0, corruptPage(pageNumber, 0, "expected a table page, found type 0x%02x", n.pageType)
+		}
+	}
+
+	return 0, corruptPage(root, 0, "b-tree is too deep")
+}
+
+// indexCellRecord decodes the key stored in an index cell.
+func indexCellRecord(pager *Pager, cell []byte, pageType uint8) ([]Value, error) {
+	readOverflow := func(firstPage uint32, size int) ([]byte, error) {
+		return readOverflowChain(pager, firstPage, size)
+	}
+
+	var decoded Cell
+	var err error
+
+	if pageType == InteriorIndexPage {
+		decoded, err = readIndexInteriorCell(cell, 0, pager.UsableSize(), readOverflow)
+	} else {
+		decoded, err = readIndexLeafCell(cell, 0, pager.UsableSize(), readOverflow)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&pager.stats.CellsDecoded, 1)
+
+	return decodeCellText(decoded, pager.TextEncoding()).Values()
+}
+
+// searchIndexNode finds the first cell whose key is not less than key.
+func searchIndexNode(pager *Pager, n *node, key []Value, order []SortKey) (int, bool, error) {
+	var searchErr error
+	found := false
+
+	position := sort.Search(len(n.cells), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+
+		record, err := indexCellRecord(pager, n.cells[i], n.pageType)
+
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		c := CompareRecords(key, record, order)
+
+		if c == 0 {
+			found = true
+		}
+
+		return c <= 0
+	})
+
+	if searchErr != nil {
+		return 0, false, withPageNumber(searchErr, n.number)
+	}
+
+	return position, found, nil
+}
+
+// seekIndex walks down to the leaf where key belongs, stopping early when an
+// entry comparing equal to key turns up.
+func seekIndex(pager *Pager, root int, key []Value, order []SortKey) ([]pathStep, bool, error) {
+	var path []pathStep
+
+	pageNumber := root
+
+	for len(path) <= 64 {
+		n, err := loadNode(pager, pageNumber)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if n.pageType != InteriorIndexPage && n.pageType != LeafIndexPage {
+			return nil, false, corruptPage(pageNumber, 0, "expected an index page, found type 0x%02x", n.pageType)
+		}
+
+		position, found, err := searchIndexNode(pager, n, key, order)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		path = append(path, pathStep{node: n, position: position})
+
+		if found || n.pageType == LeafIndexPage {
+			return path, found, nil
+		}
+
+		if position < len(n.cells) {
+			pageNumber = int(childPage(n.cells[position]))
+		} else {
+			pageNumber = int(n.right)
+		}
+	}
+
+	return nil, false, corruptPage(root, 0, "b-tree is too deep")
+}
+
+// IndexContains reports whether the index at root has an entry starting
+// with the values of key.
+func IndexContains(pager *Pager, root int, key []Value, order []SortKey) (bool, error) {
+	_, found, err := seekIndex(pager, root, key, order)
+
+	return found, err
+}
+
+// InsertIndexEntry adds an index record, which ends with the rowid of the
+// row it points at, to the index b-tree at root.
+func InsertIndexEntry(pager *Pager, root int, record []byte, order []SortKey) error {
+	key, err := DecodeRecord(record)
+
+	if err != nil {
+		return err
+	}
+
+	path, found, err := seekIndex(pager, root, key, order)
+
+	if err != nil {
+		return err
+	}
+
+	if found {
+		return ErrDuplicateKey
+	}
+
+	cell, err := buildCell(pager, helper.EncodeVarint(uint64(len(record))), record, indexMaxLocal(pager.UsableSize()))
+
+	if err != nil {
+		return err
+	}
+
+	return insertCell(pager, path, cell)
+}
+
+// insertCell puts a leaf cell in place at the end of path and rebalances the
+// pages above it.
+func insertCell(pager *Pager, path []pathStep, cell []byte) error {
+	// inserting past the last cell is what sequential loads do; packing the
+	// left pages full when those split keeps them from staying half empty
+	appending := make([]bool, len(path))
+
+	for i, step := range path {
+		appending[i] = step.position == len(step.node.cells)
+	}
+
+	leaf := path[len(path)-1]
+	leaf.node.cells = insertCells(leaf.node.cells, leaf.position, [][]byte{cell})
+
+	for level := len(path) - 1; level >= 0; level-- {
+		step := path[level]
+		n := step.node
+
+		if n.used() <= n.capacity(pager) {
+			return n.write(pager)
+		}
+
+		groups, dividers := n.split(pager, appending[level])
+
+		if level == 0 {
+			// the root keeps its page number, so its content moves down
+			// into new pages and it becomes their parent
+			for _, group := range groups {
+				pageNumber, err := pager.Allocate()
+
+				if err != nil {
+					return err
+				}
+
+				group.number = pageNumber
+			}
+
+			if err := writeGroups(pager, groups, dividers); err != nil {
+				return err
+			}
+
+			n.pageType = interiorType(n.pageType)
+			n.cells = dividers
+			n.right = uint32(groups[len(groups)-1].number)
+
+			return n.write(pager)
+		}
+
+		// the last group stays on this page, which the parent already
+		// points at in the right place
+		groups[len(groups)-1].number = n.number
+
+		for _, group := range groups[:len(groups)-1] {
+			pageNumber, err := pager.Allocate()
+
+			if err != nil {
+				return err
+			}
+
+			group.number = pageNumber
+		}
+
+		if err := writeGroups(pager, groups, dividers); err != nil {
+			return err
+		}
+
+		parent := path[level-1]
+		parent.node.cells = insertCells(parent.node.cells, parent.position, dividers)
+	}
+
+	return nil
+}
+
+func interiorType(pageType uint8) uint8 {
+	switch pageType {
+	case LeafTablePage:
+		return InteriorTablePage
+	case LeafIndexPage:
+		return InteriorIndexPage
+	}
+
+	return pageType
+}
+
+func insertCells(cells [][]byte, position int, inserted [][]byte) [][]byte {
+	result := make([][]byte, 0, len(cells)+len(inserted))
+	result = append(result, cells[:position]...)
+	result = append(result, inserted...)
+
+	return append(result, cells[position:]...)
+}
+
+// writeGroups points each divider at the group to its left and writes the groups.
+func writeGroups(pager *Pager, groups []*node, dividers [][]byte) error {
+	for i, divider := range dividers {
+		binary.BigEndian.PutUint32(divider[0:4], uint32(groups[i].number))
+	}
+
+	for _, group := range groups {
+		if err := group.write(pager); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// split divides the cells of an overfull node into groups that each fit on
+// a page, and returns the interior cells that separate them in the parent.
+// Dividers come back with a zero child pointer, filled in by writeGroups.
+//
+// A table leaf is cut between cells and the divider only carries the largest
+// rowid on its left. Everywhere else the cell at the cut itself moves up into
+// the parent.
+func (n *node) split(pager *Pager, appending bool) ([]*node, [][]byte) {
+	capacity := pager.UsableSize() - headerSize(n.pageType)
+	consume := n.pageType != LeafTablePage
+
+	sizes := make([]int, len(n.cells))
+	total := 0
+
+	for i, cell := range n.cells {
+		sizes[i] = len(cell) + 2
+		total += sizes[i]
+	}
+
+	bounds := packCells(sizes, capacity, consume)
+
+	if !appending {
+		// spread the cells evenly over as many pages as packing needed
+		groupCount := len(bounds) + 1
+		bounds = packCells(sizes, min(capacity, total/groupCount+capacity/16), consume)
+	}
+
+	var groups []*node
+	var dividers [][]byte
+
+	start := 0
+
+	for i := 0; i <= len(bounds); i++ {
+		end := len(n.cells)
+
+		if i < len(bounds) {
+			end = bounds[i]
+		}
+
+		group := &node{pageType: n.pageType, cells: n.cells[start:end], right: n.right}
+		groups = append(groups, group)
+
+		if i == len(bounds) {
+			break
+		}
+
+		switch n.pageType {
+		case LeafTablePage:
+			key := tableCellKey(n.cells[end-1], n.pageType)
+			dividers = append(dividers, append(make([]byte, 4), helper.EncodeVarint(uint64(key))...))
+			start = end
+		case LeafIndexPage:
+			dividers = append(dividers, append(make([]byte, 4), n.cells[end]...))
+			start = end + 1
+		default:
+			group.right = childPage(n.cells[end])
+			dividers = append(dividers, append([]byte{}, n.cells[end]...))
+			start = end + 1
+		}
+	}
+
+	return groups, dividers
+}
+
+// packCells fills groups of at most limit bytes from the left and returns
+// the index where each group after the first starts, or, when consume is
+// set, the index of the cell that separates it from the previous group.
+func packCells(sizes []int, limit int, consume bool) []int {
+	var bounds []int
+
+	used := 0
+	start := 0
+
+	for i := 0; i < len(sizes); i++ {
+		if i == start || used+sizes[i] <= limit {
+			used += sizes[i]
+			continue
+		}
+
+		bounds = append(bounds, i)
+
+		if consume {
+			start = i + 1
+			used = 0
+		} else {
+			start = i
+			used = sizes[i]
+		}
+	}
+
+	// a separator can't be the last cell, as there would be nothing right of it
+	if consume && len(bounds) > 0 && start == len(sizes) {
+		bounds[len(bounds)-1]--
+	}
+
+	return bounds
+}
@@ -5,122 +5,244 @@ import (
 	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
 )
 
-func readTableLeafCell(data []byte, offset int) Cell {
-	_, size := helper.DecodeVarint(&data, int64(offset))
+func readVarint(data []byte, offset int) (uint64, int, error) {
+	value, size, err := helper.DecodeVarint(&data, int64(offset))
 
-	offset += size
+	if err != nil {
+		return 0, 0, corruptPage(0, offset, "truncated varint")
+	}
+
+	return value, size, nil
+}
 
-	rowID, size := helper.DecodeVarint(&data, int64(offset))
+func readPageNumber(data []byte, offset int) (uint32, error) {
+	if offset < 0 || offset+4 > len(data) {
+		return 0, corruptPage(0, offset, "truncated child page number")
+	}
 
-	offset += size
+	return binary.BigEndian.Uint32(data[offset : offset+4]), nil
+}
+
+// readPayload decodes the record header starting at offset and slices out
+// the content of every column, along with each column's serial type.
+func readPayload(data []byte, offset int) ([][]byte, []uint64, error) {
+	headerLength, size, err := readVarint(data, offset)
+
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// payload
-	headerLength, size := helper.DecodeVarint(&data, int64(offset))
 	headerByteEnd := offset + int(headerLength)
+
+	if headerByteEnd > len(data) {
+		return nil, nil, corruptPage(0, offset, "record header length %d overflows page", headerLength)
+	}
+
 	offset += size
 
-	var columnSizes []uint64
+	var serialTypes []uint64
 
 	for offset < headerByteEnd {
-		serialType, bytesRead := helper.DecodeVarint(&data, int64(offset))
-		columnSize := helper.GetContentSizeFromSerialType(serialType)
-		columnSizes = append(columnSizes, columnSize)
+		serialType, bytesRead, err := readVarint(data, offset)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		serialTypes = append(serialTypes, serialType)
 		offset += bytesRead
 	}
 
 	var columns [][]byte
 
-	for _, columnSize := range columnSizes {
+	for _, serialType := range serialTypes {
+		columnSize := helper.GetContentSizeFromSerialType(serialType)
+
+		if offset+int(columnSize) > len(data) {
+			return nil, nil, corruptPage(0, offset, "column of %d bytes overflows page", columnSize)
+		}
+
 		content := data[offset : offset+int(columnSize)]
 		columns = append(columns, content)
 		offset += int(columnSize)
 	}
 
-	return Cell{
-		CellIdx: rowID,
-		Columns: columns,
+	return columns, serialTypes, nil
+}
+
+// overflowReader fetches the rest of a payload that spills onto overflow
+// pages, starting at firstPage.
+type overflowReader func(firstPage uint32, size int) ([]byte, error)
+
+// readCellPayload returns the whole payload of a cell whose payload of
+// payloadSize bytes starts at offset. maxLocal is the most a cell of this kind
+// may keep on the page before spilling.
+func readCellPayload(data []byte, offset int, payloadSize uint64, usableSize int, maxLocal int, readOverflow overflowReader) ([]byte, error) {
+	if payloadSize <= uint64(maxLocal) {
+		if offset+int(payloadSize) > len(data) {
+			return nil, corruptPage(0, offset, "payload of %d bytes overflows page", payloadSize)
+		}
+
+		return data[offset : offset+int(payloadSize)], nil
 	}
+
+	localSize := localPayloadSize(payloadSize, usableSize, maxLocal)
+
+	firstOverflowPage, err := readPageNumber(data, offset+localSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rest, err := readOverflow(firstOverflowPage, int(payloadSize)-localSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, data[offset:offset+localSize]...)
+
+	return append(payload, rest...), nil
 }
 
-func readTableInteriorCell(data []byte, offset int) Cell {
-	leftChildPageNumber := binary.BigEndian.Uint32(data[offset : offset+4])
+// localPayloadSize is how much of a payload too big for maxLocal stays on the
+// page; the rest goes to overflow pages.
+func localPayloadSize(payloadSize uint64, usableSize int, maxLocal int) int {
+	minLocal := (usableSize-12)*32/255 - 23
+	localSize := minLocal + int((payloadSize-uint64(minLocal))%uint64(usableSize-4))
+
+	if localSize > maxLocal {
+		localSize = minLocal
+	}
+
+	return localSize
+}
+
+func tableLeafMaxLocal(usableSize int) int {
+	return usableSize - 35
+}
+
+func indexMaxLocal(usableSize int) int {
+	return (usableSize-12)*64/255 - 23
+}
+
+func readTableLeafCell(data []byte, offset int, usableSize int, readOverflow overflowReader) (Cell, error) {
+	payloadSize, size, err := readVarint(data, offset)
+
+	if err != nil {
+		return Cell{}, err
+	}
+
+	offset += size
+
+	rowID, size, err := readVarint(data, offset)
+
+	if err != nil {
+		return Cell{}, err
+	}
+
+	offset += size
+
+	payload, err := readCellPayload(data, offset, payloadSize, usableSize, tableLeafMaxLocal(usableSize), readOverflow)
+
+	if err != nil {
+		return Cell{}, err
+	}
+
+	columns, serialTypes, err := readPayload(payload, 0)
+
+	if err != nil {
+		return Cell{}, err
+	}
+
+	return Cell{
+		CellIdx:     rowID,
+		Columns:     columns,
+		SerialTypes: serialTypes,
+	}, nil
+}
+
+func readTableInteriorCell(data []byte, offset int) (Cell, error) {
+	leftChildPageNumber, err := readPageNumber(data, offset)
+
+	if err != nil {
+		return Cell{}, err
+	}
+
 	offset += 4
 
-	integerKey, _ := helper.DecodeVarint(&data, int64(offset))
+	integerKey, _, err := readVarint(data, offset)
+
+	if err != nil {
+		return Cell{}, err
+	}
 
 	return Cell{
 		LeftChildPageNumber: leftChildPageNumber,
 		CellIdx:             integerKey,
-	}
+	}, nil
 }
 
-func readIndexLeafCell(data []byte, offset int) Cell {
-	_, size := helper.DecodeVarint(&data, int64(offset))
+func readIndexLeafCell(data []byte, offset int, usableSize int, readOverflow overflowReader) (Cell, error) {
+	payloadSize, size, err := readVarint(data, offset)
 
-	offset += size
+	if err != nil {
+		return Cell{}, err
+	}
 
-	// payload
-	headerLength, size := helper.DecodeVarint(&data, int64(offset))
-	headerByteEnd := offset + int(headerLength)
 	offset += size
 
-	var columnSizes []uint64
+	payload, err := readCellPayload(data, offset, payloadSize, usableSize, indexMaxLocal(usableSize), readOverflow)
 
-	for offset < headerByteEnd {
-		serialType, bytesRead := helper.DecodeVarint(&data, int64(offset))
-		columnSize := helper.GetContentSizeFromSerialType(serialType)
-		columnSizes = append(columnSizes, columnSize)
-		offset += bytesRead
+	if err != nil {
+		return Cell{}, err
 	}
 
-	var columns [][]byte
+	columns, serialTypes, err := readPayload(payload, 0)
 
-	for _, columnSize := range columnSizes {
-		content := data[offset : offset+int(columnSize)]
-		columns = append(columns, content)
-		offset += int(columnSize)
+	if err != nil {
+		return Cell{}, err
 	}
 
 	return Cell{
-		Columns: columns,
-	}
+		Columns:     columns,
+		SerialTypes: serialTypes,
+	}, nil
 }
 
-func readIndexInteriorCell(data []byte, offset int) Cell {
-	leftChildPageNumber := binary.BigEndian.Uint32(data[offset : offset+4])
+func readIndexInteriorCell(data []byte, offset int, usableSize int, readOverflow overflowReader) (Cell, error) {
+	leftChildPageNumber, err := readPageNumber(data, offset)
+
+	if err != nil {
+		return Cell{}, err
+	}
 
 	offset += 4
 
-	_, size := helper.DecodeVarint(&data, int64(offset))
+	payloadSize, size, err := readVarint(data, offset)
 
-	offset += size
+	if err != nil {
+		return Cell{}, err
+	}
 
-	// payload
-	headerLength, size := helper.DecodeVarint(&data, int64(offset))
-	headerByteEnd := offset + int(headerLength)
 	offset += size
 
-	var columnSizes []uint64
-
-	for offset < headerByteEnd {
-		serialType, bytesRead := helper.DecodeVarint(&data, int64(offset))
-		offset += bytesRead
+	payload, err := readCellPayload(data, offset, payloadSize, usableSize, indexMaxLocal(usableSize), readOverflow)
 
-		columnSize := helper.GetContentSizeFromSerialType(serialType)
-		columnSizes = append(columnSizes, columnSize)
+	if err != nil {
+		return Cell{}, err
 	}
 
-	var columns [][]byte
+	columns, serialTypes, err := readPayload(payload, 0)
 
-	for _, columnSize := range columnSizes {
-		content := data[offset : offset+int(columnSize)]
-		columns = append(columns, content)
-		offset += int(columnSize)
+	if err != nil {
+		return Cell{}, err
 	}
 
 	return Cell{
 		LeftChildPageNumber: leftChildPageNumber,
 		Columns:             columns,
-	}
-
+		SerialTypes:         serialTypes,
+	}, nil
 }
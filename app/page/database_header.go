@@ -2,8 +2,11 @@ package page
 
 import (
 	"encoding/binary"
+	"fmt"
 )
 
+const headerString = "SQLite format 3\x00"
+
 type DatabaseHeader struct {
 	HeaderString           [16]byte // The header string: "SQLite format 3\000"
 	PageSize               uint16   // The database page size in bytes. Must be a power of two between 512 and 32768 inclusive, or the value 1 representing a page size of 65536.
@@ -31,6 +34,9 @@ type DatabaseHeader struct {
 }
 
 func UnmarshalDbHeader(data []byte) (DatabaseHeader, error) {
+	if len(data) < 100 || string(data[0:16]) != headerString {
+		return DatabaseHeader{}, ErrNotADatabase
+	}
 
 	res := &DatabaseHeader{}
 	copy(res.HeaderString[:], data[0:16])
@@ -54,9 +60,56 @@ func UnmarshalDbHeader(data []byte) (DatabaseHeader, error) {
 	res.UserVersion = binary.BigEndian.Uint32(data[60:64])
 	res.IncrementalVacuum = binary.BigEndian.Uint32(data[64:68])
 	res.ApplicationId = binary.BigEndian.Uint32(data[68:72])
-	copy(res.HeaderString[:], data[72:92])
+	copy(res.Reserved[:], data[72:92])
 	res.VersionValidFor = binary.BigEndian.Uint32(data[92:96])
 	res.SQLiteVersionNumber = binary.BigEndian.Uint32(data[96:100])
 
+	pageSize := res.PageSizeBytes()
+
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return DatabaseHeader{}, fmt.Errorf("%w: invalid page size %d", ErrCorruptPage, res.PageSize)
+	}
+
 	return *res, nil
 }
+
+// PageSizeBytes resolves the stored page size, where 1 stands for 65536.
+func (h DatabaseHeader) PageSizeBytes() int {
+	if h.PageSize == 1 {
+		return 65536
+	}
+
+	return int(h.PageSize)
+}
+
+// MarshalDbHeader writes the header back into the first 100 bytes of data.
+func MarshalDbHeader(header DatabaseHeader, data []byte) {
+	copy(data[0:16], header.HeaderString[:])
+	binary.BigEndian.PutUint16(data[16:18], header.PageSize)
+	data[18] = header.WriteVersion
+	data[19] = header.ReadVersion
+	data[20] = header.ReservedSpace
+	data[21] = header.MaxPayloadFraction
+	data[22] = header.MinPayloadFraction
+	data[23] = header.LeafPayloadFraction
+	binary.BigEndian.PutUint32(data[24:28], header.FileChangeCounter)
+	binary.BigEndian.PutUint32(data[28:32], header.DatabaseSize)
+	binary.BigEndian.PutUint32(data[32:36], header.FirstFreelistTrunkPage)
+	binary.BigEndian.PutUint32(data[36:40], header.TotalFreelistPages)
+	binary.BigEndian.PutUint32(data[40:44], header.SchemaCookie)
+	binary.BigEndian.PutUint32(data[44:48], header.SchemaFormatNumber)
+	binary.BigEndian.PutUint32(data[48:52], header.DefaultPageCacheSize)
+	binary.BigEndian.PutUint32(data[52:56], header.LargestRootBTree)
+	binary.BigEndian.PutUint32(data[56:60], header.TextEncoding)
+	binary.BigEndian.PutUint32(data[60:64], header.UserVersion)
+	binary.BigEndian.PutUint32(data[64:68], header.IncrementalVacuum)
+	binary.BigEndian.PutUint32(data[68:72], header.ApplicationId)
+	copy(data[72:92], header.Reserved[:])
+	binary.BigEndian.PutUint32(data[92:96], header.VersionValidFor)
+	binary.BigEndian.PutUint32(data[96:100], header.SQLiteVersionNumber)
+}
+
+// UsableSize is the page size minus the reserved bytes at the end of each page.
+func (h DatabaseHeader) UsableSize() int {
+	return h.PageSizeBytes() - int(h.ReservedSpace)
+}
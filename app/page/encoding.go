@@ -0,0 +1,105 @@
+package page
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text encodings, as stored in the database header.
+const (
+	UTF8    uint32 = 1
+	UTF16LE uint32 = 2
+	UTF16BE uint32 = 3
+)
+
+// TextEncoding is the encoding TEXT values are stored in. A header that
+// doesn't say, as in a database with no schema yet, means UTF-8.
+func (p *Pager) TextEncoding() uint32 {
+	switch p.header.TextEncoding {
+	case UTF16LE, UTF16BE:
+		return p.header.TextEncoding
+	}
+
+	return UTF8
+}
+
+func byteOrder(encoding uint32) binary.ByteOrder {
+	if encoding == UTF16BE {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
+
+// DecodeText converts stored text to UTF-8. Unpaired surrogates become
+// U+FFFD and a dangling odd byte is dropped.
+func DecodeText(encoding uint32, content []byte) []byte {
+	if encoding != UTF16LE && encoding != UTF16BE {
+		return content
+	}
+
+	order := byteOrder(encoding)
+	units := make([]uint16, len(content)/2)
+
+	for i := range units {
+		units[i] = order.Uint16(content[2*i:])
+	}
+
+	text := make([]byte, 0, len(content))
+
+	for _, r := range utf16.Decode(units) {
+		text = utf8.AppendRune(text, r)
+	}
+
+	return text
+}
+
+// EncodeText converts UTF-8 text to the stored encoding.
+func EncodeText(encoding uint32, text []byte) []byte {
+	if encoding != UTF16LE && encoding != UTF16BE {
+		return text
+	}
+
+	order := byteOrder(encoding)
+	units := utf16.Encode([]rune(string(text)))
+	content := make([]byte, 2*len(units))
+
+	for i, unit := range units {
+		order.PutUint16(content[2*i:], unit)
+	}
+
+	return content
+}
+
+// BinaryCollation is BINARY for a database in the given encoding: text is
+// compared as the bytes it is stored as, so a UTF-16 database orders it by
+// its UTF-16 code units rather than by code point. It is nil for UTF-8,
+// which CompareValues already compares that way.
+func BinaryCollation(encoding uint32) Collation {
+	if encoding != UTF16LE && encoding != UTF16BE {
+		return nil
+	}
+
+	return func(a string, b string) int {
+		return bytes.Compare(EncodeText(encoding, []byte(a)), EncodeText(encoding, []byte(b)))
+	}
+}
+
+// decodeCellText converts the TEXT columns of a cell read from a UTF-16
+// database to UTF-8, so callers only ever see UTF-8.
+func decodeCellText(cell Cell, encoding uint32) Cell {
+	if encoding != UTF16LE && encoding != UTF16BE {
+		return cell
+	}
+
+	for i, serialType := range cell.SerialTypes {
+		if serialType >= 13 && serialType%2 == 1 {
+			cell.Columns[i] = DecodeText(encoding, cell.Columns[i])
+			cell.SerialTypes[i] = uint64(len(cell.Columns[i]))*2 + 13
+		}
+	}
+
+	return cell
+}
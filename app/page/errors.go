@@ -0,0 +1,62 @@
+package page
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCorruptPage is returned when a page, cell or record does not follow the file format.
+	ErrCorruptPage = errors.New("database disk image is malformed")
+	// ErrNotADatabase is returned when the file does not start with the SQLite header string.
+	ErrNotADatabase = errors.New("file is not a database")
+	// ErrReadOnly is returned when a write is attempted on a file opened read-only.
+	ErrReadOnly = errors.New("attempt to write a readonly database")
+	// ErrTransactionActive is returned by Begin inside a transaction.
+	ErrTransactionActive = errors.New("cannot start a transaction within a transaction")
+	// ErrNoTransaction is returned when a change is made outside a transaction.
+	ErrNoTransaction = errors.New("no transaction is active")
+	// ErrDuplicateKey is returned when an insert finds its key already in the b-tree.
+	ErrDuplicateKey = errors.New("key already exists")
+	// ErrBusy is returned when another connection holds a lock on the file
+	// that the busy timeout ran out waiting for.
+	ErrBusy = errors.New("database is locked")
+	// ErrWAL is returned when the file is in WAL mode and a -wal file sits
+	// next to it, whose changes the file alone doesn't show.
+	ErrWAL = errors.New("database is in WAL mode with a -wal file, which is not supported")
+)
+
+// PageError records where in the file a read went wrong.
+type PageError struct {
+	PageNumber int
+	Offset     int
+	Err        error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("%v (page %d, offset %d)", e.Err, e.PageNumber, e.Offset)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+func corruptPage(pageNumber int, offset int, format string, args ...any) error {
+	return &PageError{
+		PageNumber: pageNumber,
+		Offset:     offset,
+		Err:        fmt.Errorf("%w: %s", ErrCorruptPage, fmt.Sprintf(format, args...)),
+	}
+}
+
+// withPageNumber fills in the page number of errors raised by cell readers,
+// which only know the offset inside the page buffer.
+func withPageNumber(err error, pageNumber int) error {
+	var pageErr *PageError
+
+	if errors.As(err, &pageErr) && pageErr.PageNumber == 0 {
+		pageErr.PageNumber = pageNumber
+	}
+
+	return err
+}
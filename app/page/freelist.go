@@ -0,0 +1,106 @@
+package page
+
+import "encoding/binary"
+
+// The freelist is a chain of trunk pages, each starting with the number of
+// the next trunk and a count of the leaf page numbers that follow.
+
+// trunkCapacity is how many leaves a trunk holds. SQLite leaves the last few
+// slots unused, since versions before 3.6.0 read them wrongly.
+func (p *Pager) trunkCapacity() int {
+	return p.usableSize/4 - 8
+}
+
+// Free puts a page that is no longer used on the freelist, where Allocate
+// finds it again.
+func (p *Pager) Free(pageNumber int) error {
+	if !p.inTransaction {
+		return ErrNoTransaction
+	}
+
+	trunk := int(p.header.FirstFreelistTrunkPage)
+
+	if trunk != 0 {
+		data, err := p.page(trunk)
+
+		if err != nil {
+			return err
+		}
+
+		count := int(binary.BigEndian.Uint32(data[4:8]))
+
+		if count < p.trunkCapacity() {
+			updated := make([]byte, len(data))
+			copy(updated, data)
+			binary.BigEndian.PutUint32(updated[4:8], uint32(count+1))
+			binary.BigEndian.PutUint32(updated[8+4*count:], uint32(pageNumber))
+
+			if err := p.Write(trunk, updated); err != nil {
+				return err
+			}
+
+			p.header.TotalFreelistPages++
+
+			return nil
+		}
+	}
+
+	// the freed page becomes the first trunk
+	buff := make([]byte, p.pageSize)
+	binary.BigEndian.PutUint32(buff[0:4], uint32(trunk))
+
+	if err := p.Write(pageNumber, buff); err != nil {
+		return err
+	}
+
+	p.header.FirstFreelistTrunkPage = uint32(pageNumber)
+	p.header.TotalFreelistPages++
+
+	return nil
+}
+
+// allocateFree takes a page off the freelist, or returns 0 when it is empty.
+// The last leaf of the first trunk goes first, then the trunk itself.
+func (p *Pager) allocateFree() (int, error) {
+	trunk := int(p.header.FirstFreelistTrunkPage)
+
+	if trunk == 0 {
+		return 0, nil
+	}
+
+	data, err := p.page(trunk)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+
+	if count > p.usableSize/4-2 {
+		return 0, corruptPage(trunk, 4, "freelist trunk holds %d leaves", count)
+	}
+
+	pageNumber := trunk
+
+	if count > 0 {
+		pageNumber = int(binary.BigEndian.Uint32(data[4+4*count:]))
+
+		if pageNumber < 2 || pageNumber > p.pageCount {
+			return 0, corruptPage(trunk, 4+4*count, "freelist leaf %d is out of range", pageNumber)
+		}
+
+		updated := make([]byte, len(data))
+		copy(updated, data)
+		binary.BigEndian.PutUint32(updated[4:8], uint32(count-1))
+
+		if err := p.Write(trunk, updated); err != nil {
+			return 0, err
+		}
+	} else {
+		p.header.FirstFreelistTrunkPage = binary.BigEndian.Uint32(data[0:4])
+	}
+
+	p.header.TotalFreelistPages--
+
+	return pageNumber, p.Write(pageNumber, make([]byte, p.pageSize))
+}
@@ -0,0 +1,435 @@
+package page
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// lockLevel is one of the locks SQLite takes on a database file, each of
+// which includes the ones before it. SHARED is held to read. RESERVED is
+// held from the start of a write transaction, and keeps other connections
+// from writing but not from reading. PENDING is held on the way to
+// EXCLUSIVE, and keeps new readers out while the old ones finish. EXCLUSIVE
+// is held while the file itself is written.
+type lockLevel int
+
+const (
+	noLock lockLevel = iota
+	sharedLock
+	reservedLock
+	pendingLock
+	exclusiveLock
+)
+
+// The locks are POSIX advisory locks on bytes of the page at
+// pendingByteOffset, which is never used for data: a read lock on the
+// pending byte while a SHARED lock is taken, a write lock on it for PENDING,
+// a write lock on the reserved byte for RESERVED, and a read lock on the
+// shared range for SHARED or a write lock on all of it for EXCLUSIVE.
+const (
+	pendingByte  = pendingByteOffset
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// busyDelays are the waits between attempts to take a busy lock, the ones
+// SQLite's default busy handler uses, in milliseconds. The last one repeats.
+var busyDelays = []time.Duration{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
+
+// inode holds the locks of the pagers of this process that have one file
+// open. POSIX locks belong to a process rather than to a file descriptor,
+// so the pagers settle their locks among themselves here, and only the
+// strongest of them is held on the file. Closing any descriptor of the file
+// drops every lock the process holds on it, so a pager closed while others
+// hold locks leaves its descriptor here until they let go.
+type inode struct {
+	mu sync.Mutex
+	id fileID
+	// pagers counts the pagers that have the file open, and shared those
+	// holding SHARED or more. level is the strongest lock held.
+	pagers int
+	shared int
+	level  lockLevel
+	unused []*os.File
+}
+
+var inodes = struct {
+	sync.Mutex
+	byID map[fileID]*inode
+}{byID: make(map[fileID]*inode)}
+
+// openInode returns the locks of the file, shared with the other pagers of
+// the process that have it open.
+func openInode(file *os.File) (*inode, error) {
+	id, err := identify(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inodes.Lock()
+	defer inodes.Unlock()
+
+	in, ok := inodes.byID[id]
+
+	if !ok {
+		in = &inode{id: id}
+		inodes.byID[id] = in
+	}
+
+	in.pagers++
+
+	return in, nil
+}
+
+// close closes the file of a pager once no other pager of the process holds
+// a lock on it.
+func (in *inode) close(file *os.File) error {
+	inodes.Lock()
+	defer inodes.Unlock()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.pagers--
+
+	if in.pagers == 0 {
+		delete(inodes.byID, in.id)
+	}
+
+	if in.shared > 0 {
+		in.unused = append(in.unused, file)
+		return nil
+	}
+
+	return file.Close()
+}
+
+// closeUnused closes the files left by closed pagers once the process holds
+// no lock on the file.
+func (in *inode) closeUnused() {
+	for _, file := range in.unused {
+		file.Close()
+	}
+
+	in.unused = nil
+}
+
+// SetBusyTimeout sets how long a lock held by another connection is waited
+// for before a read or write fails with ErrBusy. The default of 0 fails at
+// once.
+func (p *Pager) SetBusyTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.busyTimeout = max(timeout, 0)
+}
+
+func (p *Pager) BusyTimeout() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.busyTimeout
+}
+
+// BeginRead takes a SHARED lock on the file for a read, which keeps other
+// connections from changing it until EndRead. Reads may overlap, from this
+// pager or the ones shared from it; the lock is held until the last one
+// ends. When another connection changed the file since this pager last held
+// a lock, the cache is dropped, and a transaction it left unfinished is
+// rolled back.
+func (p *Pager) BeginRead() error {
+	if p.temporary {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lock == noLock {
+		if err := p.waitLock(sharedLock); err != nil {
+			return err
+		}
+
+		if err := p.refresh(); err != nil {
+			p.unlockFile(noLock)
+			return err
+		}
+	}
+
+	p.readers++
+
+	return nil
+}
+
+// EndRead ends a read begun with BeginRead.
+func (p *Pager) EndRead() error {
+	if p.temporary {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.readers == 0 {
+		return nil
+	}
+
+	p.readers--
+
+	if p.readers > 0 || p.inTransaction {
+		return nil
+	}
+
+	return p.unlockFile(noLock)
+}
+
+// lockForWrite takes the RESERVED lock a transaction holds from Begin on,
+// after the SHARED lock a read would take.
+func (p *Pager) lockForWrite() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lock == noLock {
+		if err := p.waitLock(sharedLock); err != nil {
+			return err
+		}
+
+		if err := p.refresh(); err != nil {
+			p.unlockFile(noLock)
+			return err
+		}
+	}
+
+	if err := p.waitLock(reservedLock); err != nil {
+		p.release()
+		return err
+	}
+
+	return nil
+}
+
+// lockExclusive takes the EXCLUSIVE lock needed to write the file.
+func (p *Pager) lockExclusive() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.waitLock(exclusiveLock)
+}
+
+// releaseLock goes back to the lock the open reads need, once a transaction
+// is over.
+func (p *Pager) releaseLock() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.release()
+}
+
+func (p *Pager) release() error {
+	if p.readers > 0 {
+		return p.unlockFile(sharedLock)
+	}
+
+	return p.unlockFile(noLock)
+}
+
+// refresh brings the cache up to date once a SHARED lock is taken with none
+// held before, when another connection may have changed the file. Every
+// commit moves the file change counter in the header, so the cache is
+// dropped when it no longer matches.
+func (p *Pager) refresh() error {
+	if err := p.recoverJournal(); err != nil {
+		return err
+	}
+
+	counter := make([]byte, 4)
+
+	if _, err := p.file.ReadAt(counter, 24); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if p.pageSize == 0 || binary.BigEndian.Uint32(counter) != p.header.FileChangeCounter {
+		p.cache = make(map[int][]byte)
+
+		if err := p.readHeader(); err != nil {
+			return err
+		}
+	}
+
+	// in WAL mode, commits go to the -wal file and leave the file as it was
+	if p.header.ReadVersion == 2 {
+		if _, err := os.Stat(p.path + "-wal"); err == nil {
+			return ErrWAL
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitLock takes a lock, trying again while it is busy until the busy
+// timeout runs out or the reader is interrupted.
+func (p *Pager) waitLock(level lockLevel) error {
+	var waited time.Duration
+
+	for attempt := 0; ; attempt++ {
+		err := p.lockFile(level)
+
+		if !errors.Is(err, ErrBusy) || waited >= p.busyTimeout {
+			return err
+		}
+
+		if p.interrupt != nil {
+			if err := p.interrupt(); err != nil {
+				return err
+			}
+		}
+
+		delay := min(busyDelays[min(attempt, len(busyDelays)-1)]*time.Millisecond, p.busyTimeout-waited)
+		time.Sleep(delay)
+		waited += delay
+	}
+}
+
+// lockFile moves up to a stronger lock, or fails with ErrBusy when another
+// connection holds one that excludes it. It follows unixLock in SQLite's
+// os_unix.c, so that the two can share a file.
+func (p *Pager) lockFile(level lockLevel) error {
+	if p.lock >= level || p.inode == nil {
+		return nil
+	}
+
+	in := p.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// another pager of the process holds a lock this one has to wait for
+	if p.lock != in.level && (in.level >= pendingLock || level > sharedLock) {
+		return ErrBusy
+	}
+
+	// the process already holds the locks on the file a reader needs
+	if level == sharedLock && (in.level == sharedLock || in.level == reservedLock) {
+		p.lock = sharedLock
+		in.shared++
+		return nil
+	}
+
+	// a new reader checks for PENDING, and a writer takes it on its way to
+	// EXCLUSIVE, keeping it while it waits for the readers to finish
+	if level == sharedLock || (level == exclusiveLock && p.lock < pendingLock) {
+		kind := writeLock
+
+		if level == sharedLock {
+			kind = readLock
+		}
+
+		if err := setFileLock(p.file, kind, pendingByte, 1); err != nil {
+			return err
+		}
+
+		if level == exclusiveLock {
+			p.lock, in.level = pendingLock, pendingLock
+		}
+	}
+
+	if level == sharedLock {
+		err := setFileLock(p.file, readLock, sharedFirst, sharedSize)
+
+		if unlockErr := setFileLock(p.file, unlockRange, pendingByte, 1); err == nil {
+			err = unlockErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p.lock, in.level = sharedLock, sharedLock
+		in.shared++
+
+		return nil
+	}
+
+	// other pagers of the process are still reading
+	if level == exclusiveLock && in.shared > 1 {
+		return ErrBusy
+	}
+
+	start, length := int64(reservedByte), int64(1)
+
+	if level == exclusiveLock {
+		start, length = sharedFirst, sharedSize
+	}
+
+	if err := setFileLock(p.file, writeLock, start, length); err != nil {
+		return err
+	}
+
+	p.lock, in.level = level, level
+
+	return nil
+}
+
+// unlockFile moves down to SHARED or to no lock at all.
+func (p *Pager) unlockFile(level lockLevel) error {
+	if p.lock <= level || p.inode == nil {
+		return nil
+	}
+
+	in := p.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if p.lock > sharedLock {
+		if level == sharedLock {
+			if err := setFileLock(p.file, readLock, sharedFirst, sharedSize); err != nil {
+				return err
+			}
+		}
+
+		// the pending and reserved bytes
+		if err := setFileLock(p.file, unlockRange, pendingByte, 2); err != nil {
+			return err
+		}
+
+		in.level = sharedLock
+	}
+
+	if level == noLock {
+		in.shared--
+
+		if in.shared == 0 {
+			if err := setFileLock(p.file, unlockRange, 0, 0); err != nil {
+				return err
+			}
+
+			in.level = noLock
+			in.closeUnused()
+		}
+	}
+
+	p.lock = level
+
+	return nil
+}
+
+// reserved reports whether any connection holds a RESERVED lock or more,
+// which is to say that a transaction is in progress.
+func (p *Pager) reserved() (bool, error) {
+	in := p.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.level > sharedLock {
+		return true, nil
+	}
+
+	return reservedElsewhere(p.file)
+}
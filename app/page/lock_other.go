@@ -0,0 +1,34 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package page
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Without POSIX locks the file isn't locked against other processes; the
+// pagers of this process still keep out of each other's way.
+const (
+	readLock int16 = iota
+	writeLock
+	unlockRange
+)
+
+type fileID struct {
+	path string
+}
+
+func identify(file *os.File) (fileID, error) {
+	path, err := filepath.Abs(file.Name())
+
+	return fileID{path: path}, err
+}
+
+func setFileLock(file *os.File, kind int16, start int64, length int64) error {
+	return nil
+}
+
+func reservedElsewhere(file *os.File) (bool, error) {
+	return false, nil
+}
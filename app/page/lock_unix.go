@@ -0,0 +1,63 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package page
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	readLock    int16 = syscall.F_RDLCK
+	writeLock   int16 = syscall.F_WRLCK
+	unlockRange int16 = syscall.F_UNLCK
+)
+
+// fileID tells files apart by device and inode, which is the same for every
+// path and descriptor that leads to a file.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func identify(file *os.File) (fileID, error) {
+	info, err := file.Stat()
+
+	if err != nil {
+		return fileID{}, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return fileID{}, errors.New("no inode for " + file.Name())
+	}
+
+	return fileID{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, nil
+}
+
+// setFileLock sets a POSIX lock of the given kind on length bytes from
+// start, or on the rest of the file when length is 0, without waiting.
+func setFileLock(file *os.File, kind int16, start int64, length int64) error {
+	lock := syscall.Flock_t{Type: kind, Whence: io.SeekStart, Start: start, Len: length}
+	err := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return ErrBusy
+	}
+
+	return err
+}
+
+// reservedElsewhere reports whether another process holds the reserved byte.
+func reservedElsewhere(file *os.File) (bool, error) {
+	lock := syscall.Flock_t{Type: writeLock, Whence: io.SeekStart, Start: reservedByte, Len: 1}
+
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock); err != nil {
+		return false, err
+	}
+
+	return lock.Type != unlockRange, nil
+}
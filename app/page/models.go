@@ -1,11 +1,12 @@
 package page
 
-import "github/com/codecrafters-io/sqlite-starter-go/app/helper"
+import "fmt"
 
 type Cell struct {
 	LeftChildPageNumber uint32
 	CellIdx             uint64
 	Columns             [][]byte
+	SerialTypes         []uint64
 }
 
 type Page struct {
@@ -21,23 +22,37 @@ type RootPagePointer struct {
 	CreateStatement string
 }
 
-func UnmarshalRootPagePointer(pointerBuffer [][]byte) RootPagePointer {
+func UnmarshalRootPagePointer(pointerBuffer [][]byte) (RootPagePointer, error) {
 	// 0 is for type
 	// 1 is for name of object created
 	// 2 is name of table
 	// 3 is for page number
 	// 4 is for create statement
+	if len(pointerBuffer) != 5 {
+		return RootPagePointer{}, fmt.Errorf("%w: sqlite_schema row has %d columns, expected 5", ErrCorruptPage, len(pointerBuffer))
+	}
+
 	pageType := string(pointerBuffer[0])
 	objName := string(pointerBuffer[1])
 	tableName := string(pointerBuffer[2])
-	pageNum := helper.DecodeTwosCompliment(pointerBuffer[3])
 	createStatement := string(pointerBuffer[4])
 
+	// views and triggers have no b-tree and store 0 as a zero-width integer
+	var pageNum int64
+
+	if len(pointerBuffer[3]) > 0 {
+		if len(pointerBuffer[3]) > 8 {
+			return RootPagePointer{}, fmt.Errorf("%w: root page number is %d bytes wide", ErrCorruptPage, len(pointerBuffer[3]))
+		}
+
+		pageNum = decodeInteger(pointerBuffer[3])
+	}
+
 	return RootPagePointer{
 		PageType:        pageType,
 		ObjName:         objName,
 		TableName:       tableName,
 		PageNumber:      pageNum,
 		CreateStatement: createStatement,
-	}
+	}, nil
 }
@@ -2,10 +2,7 @@ package page
 
 import (
 	"encoding/binary"
-	btreecells "github/com/codecrafters-io/sqlite-starter-go/app/btree_cells"
-	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
-	"os"
-	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -41,12 +38,13 @@ func unmarshalPageHeader(data []byte) (PageHeader, error) {
 	res.CellContentPointer = binary.BigEndian.Uint16(data[5:7]) // The two-byte integer at offset 5 designates the start of the cell content area. A zero value for this integer is interpreted as 65536.
 	res.FragmantedFreeBytes = data[7]
 
-	return *res, nil
-}
+	switch res.PageType {
+	case InteriorIndexPage, InteriorTablePage, LeafIndexPage, LeafTablePage:
+	default:
+		return PageHeader{}, corruptPage(0, 0, "unknown b-tree page type 0x%02x", res.PageType)
+	}
 
-type TablePage struct {
-	Header PageHeader
-	Rows   []btreecells.LeafTableCell
+	return *res, nil
 }
 
 func parsePointers(data []byte) []uint16 {
@@ -62,12 +60,8 @@ func parsePointers(data []byte) []uint16 {
 
 }
 
-func PeakPageHeader(file *os.File, pageNumber int, pageSize int) (PageHeader, error) {
-	buff := make([]byte, pageSize)
-
-	readerOffset := pageSize * (pageNumber - 1)
-
-	_, err := file.ReadAt(buff, int64(readerOffset))
+func PeakPageHeader(pager *Pager, pageNumber int) (PageHeader, error) {
+	buff, err := pager.Page(pageNumber)
 
 	if err != nil {
 		return PageHeader{}, err
@@ -82,23 +76,20 @@ func PeakPageHeader(file *os.File, pageNumber int, pageSize int) (PageHeader, er
 	header, err := unmarshalPageHeader(buff[offset : offset+8])
 
 	if err != nil {
-		return PageHeader{}, err
+		return PageHeader{}, withPageNumber(err, pageNumber)
 	}
 
 	return header, nil
 
 }
 
-func ReadPage(file *os.File, pageNumber int, pageSize int) (Page, error) {
-	buff := make([]byte, pageSize)
-
-	readerOffset := pageSize * (pageNumber - 1)
-
-	_, err := file.ReadAt(buff, int64(readerOffset))
+func ReadPage(pager *Pager, pageNumber int) (Page, error) {
+	buff, err := pager.Page(pageNumber)
 
 	if err != nil {
 		return Page{}, err
 	}
+
 	offset := 0
 
 	if pageNumber == 1 {
@@ -108,7 +99,7 @@ func ReadPage(file *os.File, pageNumber int, pageSize int) (Page, error) {
 	header, err := unmarshalPageHeader(buff[offset : offset+8])
 
 	if err != nil {
-		return Page{}, err
+		return Page{}, withPageNumber(err, pageNumber)
 	}
 
 	offset += 8
@@ -118,169 +109,125 @@ func ReadPage(file *os.File, pageNumber int, pageSize int) (Page, error) {
 		offset += 4
 	}
 
-	pointersBuff := buff[offset : offset+int(header.CellCount*2)]
+	pointersEnd := offset + int(header.CellCount)*2
+
+	if pointersEnd > len(buff) {
+		return Page{}, corruptPage(pageNumber, offset, "%d cell pointers overflow page", header.CellCount)
+	}
+
+	pointersBuff := buff[offset:pointersEnd]
 	pointers := parsePointers(pointersBuff)
 
 	var cells []Cell
 
+	// the usable size excludes the reserved bytes at the end of every page
+	usableSize := pager.UsableSize()
+
+	readOverflow := func(firstPage uint32, size int) ([]byte, error) {
+		return readOverflowChain(pager, firstPage, size)
+	}
+
 	for _, pointer := range pointers {
 		var cell Cell
 
+		if int(pointer) < pointersEnd || int(pointer) >= len(buff) {
+			return Page{}, corruptPage(pageNumber, int(pointer), "cell pointer outside the cell content area")
+		}
+
 		switch header.PageType {
 		case InteriorIndexPage:
-			cell = readIndexInteriorCell(buff, int(pointer))
+			cell, err = readIndexInteriorCell(buff, int(pointer), usableSize, readOverflow)
 
 		case InteriorTablePage:
-			cell = readTableInteriorCell(buff, int(pointer))
+			cell, err = readTableInteriorCell(buff, int(pointer))
 
 		case LeafIndexPage:
-			cell = readIndexLeafCell(buff, int(pointer))
+			cell, err = readIndexLeafCell(buff, int(pointer), usableSize, readOverflow)
 
 		case LeafTablePage:
-			cell = readTableLeafCell(buff, int(pointer))
+			cell, err = readTableLeafCell(buff, int(pointer), usableSize, readOverflow)
+		}
+
+		if err != nil {
+			return Page{}, withPageNumber(err, pageNumber)
 		}
 
-		cells = append(cells, cell)
+		cells = append(cells, decodeCellText(cell, pager.TextEncoding()))
 	}
 
+	atomic.AddInt64(&pager.stats.CellsDecoded, int64(len(cells)))
+
 	return Page{
 		Header: header,
 		Cells:  cells,
 	}, nil
 }
 
-func ReadIndex(file *os.File, pageNumber int, pageSize int, filter []string, ids *[]int64) {
-	page, _ := ReadPage(file, pageNumber, pageSize)
+// readOverflowChain collects size bytes of payload from a linked list of
+// overflow pages, each of which starts with the number of the next one.
+func readOverflowChain(pager *Pager, pageNumber uint32, size int) ([]byte, error) {
+	content := make([]byte, 0, size)
+	usableSize := pager.UsableSize()
 
-	switch page.Header.PageType {
-	case InteriorIndexPage:
-		for i, cell := range page.Cells {
-
-			switch strings.Compare(filter[1], string(cell.Columns[0])) {
-			// lexically smaller, so we want to go left
-			case -1:
-				ReadIndex(file, int(cell.LeftChildPageNumber), pageSize, filter, ids)
-				return
-			// equal, so we include current cell and proceed to go with left child
-			case 0:
-				ReadIndex(file, int(cell.LeftChildPageNumber), pageSize, filter, ids)
-				// ids = append(ids, results...)
-				*ids = append(*ids, int64(cell.CellIdx))
-				return
-
-			// lexically greater, so we want to go right
-			case 1:
-				if i == len(page.Cells)-1 {
-					ReadIndex(file, int(page.Header.RightmostPointer), pageSize, filter, ids)
-					return
-				}
-
-			}
-
-		}
-	case LeafIndexPage:
-		for _, cell := range page.Cells {
-			if string(cell.Columns[0]) == filter[1] {
-				*ids = append(*ids, helper.DecodeTwosCompliment(cell.Columns[1]))
-			}
+	for len(content) < size {
+		if pageNumber == 0 {
+			return nil, corruptPage(0, 0, "overflow chain ends %d bytes early", size-len(content))
 		}
-	}
-
-}
 
-func ReadFullTree(file *os.File, pageNumber int, pageSize int) []Cell {
-	buff := make([]byte, pageSize)
+		buff, err := pager.Page(int(pageNumber))
 
-	readerOffset := pageSize * (pageNumber - 1)
-
-	file.ReadAt(buff, int64(readerOffset))
+		if err != nil {
+			return nil, err
+		}
 
-	offset := 0
+		atomic.AddInt64(&pager.stats.OverflowPages, 1)
+		chunk := min(size-len(content), usableSize-4)
+		content = append(content, buff[4:4+chunk]...)
 
-	if pageNumber == 1 {
-		offset = 100
+		pageNumber = binary.BigEndian.Uint32(buff[0:4])
 	}
 
-	header, _ := unmarshalPageHeader(buff[offset : offset+8])
+	return content, nil
+}
 
-	offset += 8
+// ReadFullTree reads every cell of the table b-tree at pageNumber into
+// memory, in rowid order.
+func ReadFullTree(pager *Pager, pageNumber int) ([]Cell, error) {
+	page, err := ReadPage(pager, pageNumber)
 
-	if header.PageType == InteriorTablePage {
-		header.RightmostPointer = binary.BigEndian.Uint32(buff[offset : offset+4])
-		offset += 4
+	if err != nil {
+		return nil, err
 	}
 
-	pointersBuff := buff[offset : offset+int(header.CellCount*2)]
-
-	pointers := parsePointers(pointersBuff)
-
 	var results []Cell
 
-	if header.PageType == InteriorTablePage {
-		var cells []Cell
-		for _, pointer := range pointers {
-			cell := readTableInteriorCell(buff, int(pointer))
-			cells = append(cells, cell)
-		}
-
-		for _, cell := range cells {
-			result := ReadFullTree(file, int(cell.LeftChildPageNumber), pageSize)
-			results = append(results, result...)
-		}
-
-		rightMostPage := ReadFullTree(file, int(header.RightmostPointer), pageSize)
-		results = append(results, rightMostPage...)
-
-	} else {
-		for _, pointer := range pointers {
-			result := readTableLeafCell(buff, int(pointer))
-			results = append(results, result)
-		}
-
-	}
-
-	return results
-
-}
-
-func ReadTree(file *os.File, pageNumber int, pageSize int, ids *[]int64) []Cell {
-	page, _ := ReadPage(file, pageNumber, pageSize)
-
-	var result []Cell
-
-	if page.Header.PageType == LeafTablePage {
+	switch page.Header.PageType {
+	case InteriorTablePage:
 		for _, cell := range page.Cells {
-			if int64(cell.CellIdx) != (*ids)[0] {
-				continue
-			}
+			result, err := ReadFullTree(pager, int(cell.LeftChildPageNumber))
 
-			*ids = (*ids)[1:]
-			result = append(result, cell)
-
-			if len(*ids) == 0 {
-				break
+			if err != nil {
+				return nil, err
 			}
+
+			results = append(results, result...)
 		}
 
-	} else {
-		for _, cell := range page.Cells {
-			if len(*ids) > 0 && (*ids)[0] <= int64(cell.CellIdx) {
-				tranversedResult := ReadTree(file, int(cell.LeftChildPageNumber), pageSize, ids)
-				result = append(result, tranversedResult...)
-			}
+		rightMostPage, err := ReadFullTree(pager, int(page.Header.RightmostPointer))
 
-			if len(*ids) == 0 {
-				break
-			}
+		if err != nil {
+			return nil, err
 		}
 
-		if len(*ids) > 0 {
-			tranversedResult := ReadTree(file, int(page.Header.RightmostPointer), pageSize, ids)
-			result = append(result, tranversedResult...)
-		}
+		results = append(results, rightMostPage...)
+
+	case LeafTablePage:
+		results = append(results, page.Cells...)
 
+	default:
+		return nil, corruptPage(pageNumber, 0, "expected a table page, found type 0x%02x", page.Header.PageType)
 	}
 
-	return result
+	return results, nil
 
 }
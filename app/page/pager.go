@@ -0,0 +1,818 @@
+package page
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// cacheCapacity is how many pages are kept in memory; dirty pages past it
+	// are spilled to the file once the journal is safely on disk.
+	cacheCapacity = 2000
+
+	journalSectorSize = 512
+
+	// pendingByteOffset starts the byte range SQLite uses for locking. Its page
+	// is never used for data, so allocation has to step over it.
+	pendingByteOffset = 0x40000000
+)
+
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// Pager reads pages of a database file through a cache and applies changes
+// in transactions. Changes are protected by a rollback journal in SQLite's
+// own format, so sqlite3 can recover the file after a crash and vice versa.
+//
+// Pages returned by Page must not be modified; a change is made by handing a
+// new buffer to Write.
+//
+// The file is locked the way SQLite locks it, so that it can be read and
+// written alongside sqlite3 and other connections: reads are bracketed by
+// BeginRead and EndRead, and a transaction holds its locks from Begin to
+// Commit or Rollback. Share hands out pagers on the same cache for readers
+// in other goroutines.
+type Pager struct {
+	*store
+
+	// interrupt, when set, is asked before each page Page returns whether
+	// the reader should stop, which it then fails with the error given
+	interrupt func() error
+
+	stats Stats
+}
+
+// store is the file and cache that a pager and the pagers shared from it
+// have in common.
+type store struct {
+	// mu guards the cache, the size and header of the database and the
+	// locks held; pages are read from the file without it
+	mu sync.Mutex
+
+	file     *os.File
+	path     string
+	readOnly bool
+	header   DatabaseHeader
+
+	pageSize   int
+	usableSize int
+
+	pageCount int
+	cache     map[int][]byte
+	dirty     map[int]bool
+
+	// state of the open write transaction
+	inTransaction  bool
+	journal        *os.File
+	journalNonce   uint32
+	journaled      map[int]bool
+	journalRecords int
+	journalSynced  int // records covered by the header written last
+	originalCount  int
+	originalHeader DatabaseHeader
+	spilled        bool
+	schemaChanged  bool
+
+	// temporary is set for a file of intermediate results, which is never
+	// journaled or locked, and is deleted on Close
+	temporary bool
+
+	// lock is the lock held on the file, and readers counts the reads
+	// between BeginRead and EndRead, which keep at least a SHARED lock.
+	// inode is shared with the other pagers of the process on the file.
+	lock        lockLevel
+	readers     int
+	inode       *inode
+	busyTimeout time.Duration
+}
+
+// OpenPager opens the database at path for reading and writing, or only for
+// reading when the file can't be written. A hot journal left behind by an
+// interrupted transaction is rolled back first. When another connection
+// holds a lock that keeps the file from being read, the header is read by
+// the first read that gets the lock instead.
+func OpenPager(path string) (*Pager, error) {
+	readOnly := false
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+
+	if errors.Is(err, os.ErrPermission) {
+		readOnly = true
+		file, err = os.Open(path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	inode, err := openInode(file)
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	pager := &Pager{store: &store{
+		file:     file,
+		path:     path,
+		readOnly: readOnly,
+		cache:    make(map[int][]byte),
+		dirty:    make(map[int]bool),
+		inode:    inode,
+	}}
+
+	if err := pager.BeginRead(); errors.Is(err, ErrBusy) {
+		return pager, nil
+	} else if err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	if err := pager.EndRead(); err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	return pager, nil
+}
+
+// Share returns a pager on the same file and cache, with its own interrupt
+// and statistics, for a reader in another goroutine. Pagers shared this way
+// may read at the same time, but not while one of them writes. Closing any
+// of them closes the file.
+func (p *Pager) Share() *Pager {
+	return &Pager{store: p.store}
+}
+
+// CreateTemp creates an empty database with the given page size in a
+// temporary file, for results too large to keep in memory. Nothing else
+// reads the file, so its changes aren't journaled and are never committed;
+// Close deletes it.
+func CreateTemp(pageSize int) (*Pager, error) {
+	file, err := os.CreateTemp("", "etilqs_")
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := DatabaseHeader{
+		PageSize:            uint16(pageSize), // 65536 wraps to 1
+		WriteVersion:        1,
+		ReadVersion:         1,
+		MaxPayloadFraction:  64,
+		MinPayloadFraction:  32,
+		LeafPayloadFraction: 32,
+		DatabaseSize:        1,
+		SchemaFormatNumber:  4,
+		TextEncoding:        1,
+	}
+	copy(header.HeaderString[:], headerString)
+
+	// the first page is the header and an empty sqlite_schema
+	first := make([]byte, pageSize)
+	MarshalDbHeader(header, first)
+	first[100] = LeafTablePage
+	binary.BigEndian.PutUint16(first[105:107], uint16(pageSize))
+
+	if _, err := file.WriteAt(first, 0); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &Pager{store: &store{
+		file:       file,
+		path:       file.Name(),
+		header:     header,
+		pageSize:   pageSize,
+		usableSize: pageSize,
+		pageCount:  1,
+		cache:      make(map[int][]byte),
+		dirty:      make(map[int]bool),
+		temporary:  true,
+	}}, nil
+}
+
+func (p *Pager) readHeader() error {
+	data := make([]byte, 100)
+
+	_, err := p.file.ReadAt(data, 0)
+
+	if errors.Is(err, io.EOF) {
+		return ErrNotADatabase
+	}
+
+	if err != nil {
+		return err
+	}
+
+	header, err := UnmarshalDbHeader(data)
+
+	if err != nil {
+		return err
+	}
+
+	p.header = header
+	p.pageSize = header.PageSizeBytes()
+	p.usableSize = header.UsableSize()
+
+	// the in-header size is only trusted when it was written by a version
+	// that also maintains version-valid-for
+	if header.DatabaseSize != 0 && header.VersionValidFor == header.FileChangeCounter {
+		p.pageCount = int(header.DatabaseSize)
+		return nil
+	}
+
+	info, err := p.file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	p.pageCount = int(info.Size() / int64(p.pageSize))
+
+	return nil
+}
+
+func (p *Pager) Close() error {
+	if p.temporary {
+		err := p.file.Close()
+
+		if removeErr := os.Remove(p.path); err == nil {
+			err = removeErr
+		}
+
+		return err
+	}
+
+	if p.inTransaction {
+		p.Rollback()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.readers = 0
+	err := p.unlockFile(noLock)
+
+	if closeErr := p.inode.close(p.file); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (p *Pager) Header() DatabaseHeader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.header
+}
+
+func (p *Pager) PageSize() int {
+	return p.pageSize
+}
+
+func (p *Pager) UsableSize() int {
+	return p.usableSize
+}
+
+// PageCount is the size of the database in pages, including pages allocated
+// by the open transaction.
+func (p *Pager) PageCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pageCount
+}
+
+func (p *Pager) ReadOnly() bool {
+	return p.readOnly
+}
+
+// SetInterrupt sets what Page asks before each page whether to stop, which
+// lets a long scan be stopped between pages. The reads that journal a page
+// or lay out one being written are never interrupted. nil stops asking.
+func (p *Pager) SetInterrupt(check func() error) {
+	p.interrupt = check
+}
+
+// Page returns the content of a page.
+func (p *Pager) Page(pageNumber int) ([]byte, error) {
+	if p.interrupt != nil {
+		if err := p.interrupt(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.page(pageNumber)
+}
+
+func (p *Pager) page(pageNumber int) ([]byte, error) {
+	p.mu.Lock()
+	data, cached := p.cache[pageNumber]
+	pageCount := p.pageCount
+	p.mu.Unlock()
+
+	if pageNumber < 1 || pageNumber > pageCount {
+		return nil, corruptPage(pageNumber, 0, "page is past the end of the file")
+	}
+
+	if cached {
+		atomic.AddInt64(&p.stats.CacheHits, 1)
+		return data, nil
+	}
+
+	data = make([]byte, p.PageSize())
+
+	_, err := p.file.ReadAt(data, int64(pageNumber-1)*int64(p.PageSize()))
+
+	if err == io.EOF {
+		return nil, corruptPage(pageNumber, 0, "page is past the end of the file")
+	}
+
+	if err != nil {
+		return nil, &PageError{PageNumber: pageNumber, Err: err}
+	}
+
+	atomic.AddInt64(&p.stats.PagesRead, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// another reader may have read the page meanwhile
+	if existing, ok := p.cache[pageNumber]; ok {
+		return existing, nil
+	}
+
+	p.evict()
+	p.cache[pageNumber] = data
+
+	return data, nil
+}
+
+// evict drops clean pages once the cache is full. Which ones go is left to
+// map iteration order, which is as good as random.
+func (p *Pager) evict() {
+	for number := range p.cache {
+		if len(p.cache) < cacheCapacity {
+			return
+		}
+
+		if !p.dirty[number] {
+			delete(p.cache, number)
+		}
+	}
+}
+
+// Begin starts a write transaction.
+func (p *Pager) Begin() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	if p.inTransaction {
+		return ErrTransactionActive
+	}
+
+	if p.temporary {
+		p.inTransaction = true
+		p.originalCount = p.pageCount
+		return nil
+	}
+
+	if err := p.lockForWrite(); err != nil {
+		return err
+	}
+
+	journal, err := os.OpenFile(p.path+"-journal", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		p.releaseLock()
+		return err
+	}
+
+	var nonce [4]byte
+
+	if _, err := rand.Read(nonce[:]); err != nil {
+		journal.Close()
+		p.releaseLock()
+		return err
+	}
+
+	p.inTransaction = true
+	p.journal = journal
+	p.journalNonce = binary.BigEndian.Uint32(nonce[:])
+	p.journaled = make(map[int]bool)
+	p.journalRecords = 0
+	p.journalSynced = -1
+	p.originalCount = p.pageCount
+	p.originalHeader = p.header
+	p.spilled = false
+	p.schemaChanged = false
+
+	return nil
+}
+
+func (p *Pager) InTransaction() bool {
+	return p.inTransaction
+}
+
+// Write replaces the content of a page within the open transaction. The
+// original content is saved to the journal the first time a page changes.
+func (p *Pager) Write(pageNumber int, data []byte) error {
+	if !p.inTransaction {
+		return ErrNoTransaction
+	}
+
+	if len(data) != p.PageSize() {
+		return corruptPage(pageNumber, 0, "page buffer of %d bytes, expected %d", len(data), p.PageSize())
+	}
+
+	if pageNumber <= p.originalCount && !p.temporary && !p.journaled[pageNumber] {
+		original, err := p.page(pageNumber)
+
+		if err != nil {
+			return err
+		}
+
+		if err := p.appendJournal(pageNumber, original); err != nil {
+			return err
+		}
+	}
+
+	p.cache[pageNumber] = data
+	p.dirty[pageNumber] = true
+
+	if len(p.dirty) >= cacheCapacity {
+		return p.spill()
+	}
+
+	return nil
+}
+
+// Allocate returns a zeroed page, reusing one from the freelist before it
+// adds one to the end of the file.
+func (p *Pager) Allocate() (int, error) {
+	if !p.inTransaction {
+		return 0, ErrNoTransaction
+	}
+
+	if pageNumber, err := p.allocateFree(); err != nil || pageNumber != 0 {
+		return pageNumber, err
+	}
+
+	p.pageCount++
+
+	if p.pageCount == pendingByteOffset/p.PageSize()+1 {
+		p.pageCount++
+	}
+
+	if err := p.Write(p.pageCount, make([]byte, p.PageSize())); err != nil {
+		return 0, err
+	}
+
+	return p.pageCount, nil
+}
+
+// SchemaChanged makes the commit bump the schema cookie, telling other
+// connections to reload the schema.
+func (p *Pager) SchemaChanged() {
+	p.schemaChanged = true
+}
+
+func (p *Pager) appendJournal(pageNumber int, data []byte) error {
+	record := make([]byte, 4+len(data)+4)
+	binary.BigEndian.PutUint32(record[0:4], uint32(pageNumber))
+	copy(record[4:], data)
+	binary.BigEndian.PutUint32(record[4+len(data):], p.journalChecksum(data))
+
+	offset := int64(journalSectorSize) + int64(p.journalRecords)*int64(len(record))
+
+	if _, err := p.journal.WriteAt(record, offset); err != nil {
+		return err
+	}
+
+	p.journaled[pageNumber] = true
+	p.journalRecords++
+
+	return nil
+}
+
+// journalChecksum samples every 200th byte of the page, the way SQLite does.
+func (p *Pager) journalChecksum(data []byte) uint32 {
+	checksum := p.journalNonce
+
+	for i := len(data) - 200; i > 0; i -= 200 {
+		checksum += uint32(data[i])
+	}
+
+	return checksum
+}
+
+// syncJournal writes the journal header with the current record count and
+// makes sure the journal is on disk before the database file is touched.
+func (p *Pager) syncJournal() error {
+	if p.journalSynced == p.journalRecords {
+		return nil
+	}
+
+	header := make([]byte, journalSectorSize)
+	copy(header, journalMagic)
+	binary.BigEndian.PutUint32(header[8:12], uint32(p.journalRecords))
+	binary.BigEndian.PutUint32(header[12:16], p.journalNonce)
+	binary.BigEndian.PutUint32(header[16:20], uint32(p.originalCount))
+	binary.BigEndian.PutUint32(header[20:24], journalSectorSize)
+	binary.BigEndian.PutUint32(header[24:28], uint32(p.PageSize()))
+
+	if err := p.journal.Sync(); err != nil {
+		return err
+	}
+
+	if _, err := p.journal.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	if err := p.journal.Sync(); err != nil {
+		return err
+	}
+
+	p.journalSynced = p.journalRecords
+
+	return nil
+}
+
+func (p *Pager) writeDirty() error {
+	for number := range p.dirty {
+		offset := int64(number-1) * int64(p.PageSize())
+
+		if _, err := p.file.WriteAt(p.cache[number], offset); err != nil {
+			return err
+		}
+
+		delete(p.dirty, number)
+	}
+
+	return nil
+}
+
+// spill writes dirty pages to the database file before the transaction ends
+// so a large transaction doesn't have to fit in memory.
+func (p *Pager) spill() error {
+	if p.temporary {
+		return p.writeDirty()
+	}
+
+	if err := p.syncJournal(); err != nil {
+		return err
+	}
+
+	if err := p.lockExclusive(); err != nil {
+		return err
+	}
+
+	p.spilled = true
+
+	return p.writeDirty()
+}
+
+// Commit writes the transaction to the database file and deletes the journal.
+// Writing takes an EXCLUSIVE lock, which waits for the readers of other
+// connections to finish; when they don't within the busy timeout, Commit
+// fails with ErrBusy and the transaction stays open, to be committed again
+// or rolled back.
+func (p *Pager) Commit() error {
+	if !p.inTransaction {
+		return ErrNoTransaction
+	}
+
+	if len(p.dirty) > 0 || p.spilled {
+		if err := p.lockExclusive(); err != nil {
+			return err
+		}
+
+		header := p.header
+		header.FileChangeCounter++
+		header.VersionValidFor = header.FileChangeCounter
+		header.DatabaseSize = uint32(p.pageCount)
+
+		if p.schemaChanged {
+			header.SchemaCookie++
+		}
+
+		first, err := p.page(1)
+
+		if err != nil {
+			return err
+		}
+
+		updated := make([]byte, len(first))
+		copy(updated, first)
+		MarshalDbHeader(header, updated)
+
+		if err := p.Write(1, updated); err != nil {
+			return err
+		}
+
+		if err := p.syncJournal(); err != nil {
+			return err
+		}
+
+		if err := p.writeDirty(); err != nil {
+			return err
+		}
+
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		p.header = header
+		p.mu.Unlock()
+	}
+
+	return p.endTransaction()
+}
+
+// Rollback abandons the transaction, restoring any pages that were already
+// spilled to the database file.
+func (p *Pager) Rollback() error {
+	if !p.inTransaction {
+		return ErrNoTransaction
+	}
+
+	p.mu.Lock()
+
+	for number := range p.dirty {
+		delete(p.cache, number)
+		delete(p.dirty, number)
+	}
+
+	p.pageCount = p.originalCount
+	p.header = p.originalHeader
+
+	if p.spilled {
+		p.cache = make(map[int][]byte)
+	}
+
+	p.mu.Unlock()
+
+	if p.spilled {
+
+		if err := p.syncJournal(); err != nil {
+			return err
+		}
+
+		if err := p.playback(p.journal); err != nil {
+			return err
+		}
+	}
+
+	return p.endTransaction()
+}
+
+// endTransaction deletes the journal, which commits the transaction, and
+// lets go of the locks a read doesn't need.
+func (p *Pager) endTransaction() error {
+	p.inTransaction = false
+	p.journaled = nil
+
+	err := p.journal.Close()
+	p.journal = nil
+
+	if removeErr := os.Remove(p.path + "-journal"); err == nil {
+		err = removeErr
+	}
+
+	if unlockErr := p.releaseLock(); err == nil {
+		err = unlockErr
+	}
+
+	return err
+}
+
+// recoverJournal rolls back a transaction that was interrupted after it
+// started changing the database file. A journal is only hot when no
+// connection holds a RESERVED lock; otherwise it belongs to a transaction
+// still in progress. The rollback takes an EXCLUSIVE lock and goes back to
+// SHARED.
+func (p *Pager) recoverJournal() error {
+	journal, err := os.Open(p.path + "-journal")
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer journal.Close()
+
+	if info, err := journal.Stat(); err != nil || info.Size() == 0 {
+		return err
+	}
+
+	if reserved, err := p.reserved(); err != nil || reserved {
+		return err
+	}
+
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := p.lockFile(exclusiveLock); err != nil {
+		return err
+	}
+
+	if err := p.playback(journal); err != nil {
+		return err
+	}
+
+	if err := os.Remove(p.path + "-journal"); err != nil {
+		return err
+	}
+
+	return p.unlockFile(sharedLock)
+}
+
+// playback copies the original pages saved in the journal back into the
+// database file and truncates it to its size before the transaction. The
+// journal is a run of segments, each a header followed by the records it
+// counts: SQLite starts a new one at the next sector boundary every time it
+// syncs the journal before writing more pages to the file.
+func (p *Pager) playback(journal *os.File) error {
+	header := make([]byte, 28)
+
+	if _, err := journal.ReadAt(header, 0); err != nil {
+		// an empty or partial header means the database was never touched
+		return nil
+	}
+
+	if string(header[0:8]) != string(journalMagic) {
+		return nil
+	}
+
+	originalCount := binary.BigEndian.Uint32(header[16:20])
+	sectorSize := int64(binary.BigEndian.Uint32(header[20:24]))
+	pageSize := int(binary.BigEndian.Uint32(header[24:28]))
+
+	if pageSize < 512 || pageSize > 65536 || sectorSize < 512 {
+		return nil
+	}
+
+	record := make([]byte, 4+pageSize+4)
+
+	for offset := int64(0); ; {
+		if _, err := journal.ReadAt(header, offset); err != nil || string(header[0:8]) != string(journalMagic) {
+			break
+		}
+
+		records := binary.BigEndian.Uint32(header[8:12])
+		nonce := binary.BigEndian.Uint32(header[12:16])
+		offset += sectorSize
+		complete := true
+
+		for i := uint32(0); i < records; i++ {
+			if _, err := journal.ReadAt(record, offset); err != nil {
+				complete = false
+				break
+			}
+
+			offset += int64(len(record))
+			pageNumber := binary.BigEndian.Uint32(record[0:4])
+			data := record[4 : 4+pageSize]
+
+			checksum := nonce
+
+			for j := pageSize - 200; j > 0; j -= 200 {
+				checksum += uint32(data[j])
+			}
+
+			if pageNumber == 0 || checksum != binary.BigEndian.Uint32(record[4+pageSize:]) {
+				complete = false
+				break
+			}
+
+			if _, err := p.file.WriteAt(data, int64(pageNumber-1)*int64(pageSize)); err != nil {
+				return err
+			}
+		}
+
+		if !complete || records == 0 {
+			break
+		}
+
+		offset = (offset + sectorSize - 1) / sectorSize * sectorSize
+	}
+
+	if err := p.file.Truncate(int64(originalCount) * int64(pageSize)); err != nil {
+		return err
+	}
+
+	return p.file.Sync()
+}
@@ -0,0 +1,216 @@
+package page
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github/com/codecrafters-io/sqlite-starter-go/app/helper"
+	"math"
+)
+
+// EncodeRecord serializes values in the record format: a header of serial
+// types followed by the content of each value. compactBooleans stores 0 and 1
+// without content bytes, which needs schema format 4.
+func EncodeRecord(values []Value, compactBooleans bool) []byte {
+	var header []byte
+	var body []byte
+
+	for _, value := range values {
+		serialType, content := encodeValue(value, compactBooleans)
+		header = append(header, helper.EncodeVarint(serialType)...)
+		body = append(body, content...)
+	}
+
+	// the header length counts its own varint, which may grow it by a byte
+	headerLength := len(header) + 1
+
+	for len(header)+len(helper.EncodeVarint(uint64(headerLength))) != headerLength {
+		headerLength = len(header) + len(helper.EncodeVarint(uint64(headerLength)))
+	}
+
+	record := helper.EncodeVarint(uint64(headerLength))
+	record = append(record, header...)
+
+	return append(record, body...)
+}
+
+func encodeValue(value Value, compactBooleans bool) (uint64, []byte) {
+	switch value.Type {
+	case NullValue:
+		return 0, nil
+	case IntegerValue:
+		i := value.Int
+
+		switch {
+		case compactBooleans && i == 0:
+			return 8, nil
+		case compactBooleans && i == 1:
+			return 9, nil
+		case i >= math.MinInt8 && i <= math.MaxInt8:
+			return 1, []byte{byte(i)}
+		case i >= math.MinInt16 && i <= math.MaxInt16:
+			return 2, binary.BigEndian.AppendUint16(nil, uint16(i))
+		case i >= -1<<23 && i < 1<<23:
+			return 3, []byte{byte(i >> 16), byte(i >> 8), byte(i)}
+		case i >= math.MinInt32 && i <= math.MaxInt32:
+			return 4, binary.BigEndian.AppendUint32(nil, uint32(i))
+		case i >= -1<<47 && i < 1<<47:
+			return 5, binary.BigEndian.AppendUint64(nil, uint64(i))[2:]
+		default:
+			return 6, binary.BigEndian.AppendUint64(nil, uint64(i))
+		}
+	case FloatValue:
+		return 7, binary.BigEndian.AppendUint64(nil, math.Float64bits(value.Float))
+	case BlobValue:
+		return uint64(len(value.Bytes))*2 + 12, value.Bytes
+	default:
+		return uint64(len(value.Bytes))*2 + 13, value.Bytes
+	}
+}
+
+// DecodeRecord decodes every value of a record.
+func DecodeRecord(record []byte) ([]Value, error) {
+	columns, serialTypes, err := readPayload(record, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Cell{Columns: columns, SerialTypes: serialTypes}.Values()
+}
+
+// Collation orders two TEXT values.
+type Collation func(a string, b string) int
+
+// SortKey says how one column of an index is ordered.
+type SortKey struct {
+	Descending bool
+	Collation  Collation // nil means BINARY
+}
+
+// CompareValues orders values the way SQLite does: NULLs first, then numbers,
+// then text by collation, then blobs by their bytes.
+func CompareValues(a Value, b Value, collation Collation) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+
+	if rankA != rankB {
+		return compareInts(int64(rankA), int64(rankB))
+	}
+
+	switch rankA {
+	case 0:
+		return 0
+	case 1:
+		return compareNumbers(a, b)
+	case 2:
+		if collation != nil {
+			return collation(string(a.Bytes), string(b.Bytes))
+		}
+
+		return bytes.Compare(a.Bytes, b.Bytes)
+	default:
+		return bytes.Compare(a.Bytes, b.Bytes)
+	}
+}
+
+func typeRank(v Value) int {
+	switch v.Type {
+	case NullValue:
+		return 0
+	case IntegerValue, FloatValue:
+		return 1
+	case TextValue:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+func compareNumbers(a Value, b Value) int {
+	if a.Type == IntegerValue && b.Type == IntegerValue {
+		return compareInts(a.Int, b.Int)
+	}
+
+	if a.Type == FloatValue && b.Type == FloatValue {
+		return compareFloats(a.Float, b.Float)
+	}
+
+	// an integer against a real: compare exactly, without rounding the
+	// integer to the nearest float
+	if a.Type == FloatValue {
+		return -compareIntFloat(b.Int, a.Float)
+	}
+
+	return compareIntFloat(a.Int, b.Float)
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	}
+
+	return 1
+}
+
+func compareIntFloat(i int64, r float64) int {
+	switch {
+	case math.IsNaN(r):
+		return 1
+	case r < -9223372036854775808.0:
+		return 1
+	case r >= 9223372036854775808.0:
+		return -1
+	}
+
+	truncated := int64(r)
+
+	if c := compareInts(i, truncated); c != 0 {
+		return c
+	}
+
+	return compareFloats(float64(0), r-float64(truncated))
+}
+
+// CompareRecords orders two records column by column. Columns beyond the
+// shorter record are ignored, so a record holding only the leading columns
+// of a key compares equal to every entry that starts with them.
+func CompareRecords(a []Value, b []Value, keys []SortKey) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		var key SortKey
+
+		if i < len(keys) {
+			key = keys[i]
+		}
+
+		c := CompareValues(a[i], b[i], key.Collation)
+
+		if key.Descending {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,185 @@
+package page
+
+import "sync/atomic"
+
+// maxDepth bounds how far a walk goes down before the tree is taken to be
+// corrupt, which also stops cycles between pages.
+const maxDepth = 64
+
+// WalkTable visits the rows of the table b-tree at root in rowid order. A
+// non-nil error from visit stops the walk and is returned.
+func WalkTable(pager *Pager, root int, visit func(Cell) error) error {
+	return walkTablePage(pager, root, visit, 0)
+}
+
+func walkTablePage(pager *Pager, pageNumber int, visit func(Cell) error, depth int) error {
+	if depth > maxDepth {
+		return corruptPage(pageNumber, 0, "b-tree is too deep")
+	}
+
+	page, err := ReadPage(pager, pageNumber)
+
+	if err != nil {
+		return err
+	}
+
+	switch page.Header.PageType {
+	case InteriorTablePage:
+		for _, cell := range page.Cells {
+			if err := walkTablePage(pager, int(cell.LeftChildPageNumber), visit, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return walkTablePage(pager, int(page.Header.RightmostPointer), visit, depth+1)
+	case LeafTablePage:
+		for _, cell := range page.Cells {
+			if err := visit(cell); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return corruptPage(pageNumber, 0, "expected a table page, found type 0x%02x", page.Header.PageType)
+}
+
+// TableSubtrees splits the table b-tree at root into at least n subtrees,
+// in rowid order, for walks that run side by side. It goes down one level
+// of interior pages at a time, so the split falls on page boundaries; a
+// tree too shallow for n subtrees is split into its leaves.
+func TableSubtrees(pager *Pager, root int, n int) ([]int, error) {
+	level := []int{root}
+
+	for depth := 0; len(level) < n; depth++ {
+		if depth > maxDepth {
+			return nil, corruptPage(root, 0, "b-tree is too deep")
+		}
+
+		var children []int
+
+		for _, pageNumber := range level {
+			header, err := PeakPageHeader(pager, pageNumber)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if header.PageType == LeafTablePage {
+				return level, nil
+			}
+
+			page, err := ReadPage(pager, pageNumber)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if page.Header.PageType != InteriorTablePage {
+				return nil, corruptPage(pageNumber, 0, "expected a table page, found type 0x%02x", page.Header.PageType)
+			}
+
+			for _, cell := range page.Cells {
+				children = append(children, int(cell.LeftChildPageNumber))
+			}
+
+			children = append(children, int(page.Header.RightmostPointer))
+		}
+
+		level = children
+	}
+
+	return level, nil
+}
+
+// FindRow looks up the row with rowID in the table b-tree at root.
+func FindRow(pager *Pager, root int, rowID int64) (Cell, bool, error) {
+	atomic.AddInt64(&pager.stats.Seeks, 1)
+	path, found, err := seekTable(pager, root, rowID)
+
+	if err != nil || !found {
+		return Cell{}, false, err
+	}
+
+	leaf := path[len(path)-1]
+
+	readOverflow := func(firstPage uint32, size int) ([]byte, error) {
+		return readOverflowChain(pager, firstPage, size)
+	}
+
+	cell, err := readTableLeafCell(leaf.node.cells[leaf.position], 0, pager.UsableSize(), readOverflow)
+
+	if err != nil {
+		return Cell{}, false, withPageNumber(err, leaf.node.number)
+	}
+
+	atomic.AddInt64(&pager.stats.CellsDecoded, 1)
+
+	return decodeCellText(cell, pager.TextEncoding()), true, nil
+}
+
+// ScanIndex visits, in index order, the entries of the index b-tree at root
+// whose leading columns equal key. An empty key visits every entry.
+func ScanIndex(pager *Pager, root int, key []Value, order []SortKey, visit func(record []Value) error) error {
+	if len(key) > 0 {
+		atomic.AddInt64(&pager.stats.Seeks, 1)
+	}
+
+	_, err := scanIndexPage(pager, root, key, order, visit, 0)
+
+	return err
+}
+
+// scanIndexPage reports whether entries past this subtree may still match.
+func scanIndexPage(pager *Pager, pageNumber int, key []Value, order []SortKey, visit func([]Value) error, depth int) (bool, error) {
+	if depth > maxDepth {
+		return false, corruptPage(pageNumber, 0, "b-tree is too deep")
+	}
+
+	n, err := loadNode(pager, pageNumber)
+
+	if err != nil {
+		return false, err
+	}
+
+	if n.pageType != InteriorIndexPage && n.pageType != LeafIndexPage {
+		return false, corruptPage(pageNumber, 0, "expected an index page, found type 0x%02x", n.pageType)
+	}
+
+	position, _, err := searchIndexNode(pager, n, key, order)
+
+	if err != nil {
+		return false, err
+	}
+
+	for i := position; i < len(n.cells); i++ {
+		if n.pageType == InteriorIndexPage {
+			more, err := scanIndexPage(pager, int(childPage(n.cells[i])), key, order, visit, depth+1)
+
+			if err != nil || !more {
+				return false, err
+			}
+		}
+
+		record, err := indexCellRecord(pager, n.cells[i], n.pageType)
+
+		if err != nil {
+			return false, withPageNumber(err, n.number)
+		}
+
+		if CompareRecords(key, record, order) != 0 {
+			return false, nil
+		}
+
+		if err := visit(record); err != nil {
+			return false, err
+		}
+	}
+
+	if n.pageType == InteriorIndexPage {
+		return scanIndexPage(pager, int(n.right), key, order, visit, depth+1)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,29 @@
+package page
+
+import "sync/atomic"
+
+// Stats counts the work a pager has done since it was opened. Callers take
+// the difference between two readings to see what one query cost. Pagers
+// made by Share count their own work.
+type Stats struct {
+	// PagesRead counts pages read from the file, and CacheHits those the
+	// cache already held
+	PagesRead int64
+	CacheHits int64
+	// CellsDecoded counts cells whose payload was decoded, and
+	// OverflowPages the overflow pages followed to complete one
+	CellsDecoded  int64
+	OverflowPages int64
+	// Seeks counts descents to a key: rowid lookups and index searches
+	Seeks int64
+}
+
+func (p *Pager) Stats() Stats {
+	return Stats{
+		PagesRead:     atomic.LoadInt64(&p.stats.PagesRead),
+		CacheHits:     atomic.LoadInt64(&p.stats.CacheHits),
+		CellsDecoded:  atomic.LoadInt64(&p.stats.CellsDecoded),
+		OverflowPages: atomic.LoadInt64(&p.stats.OverflowPages),
+		Seeks:         atomic.LoadInt64(&p.stats.Seeks),
+	}
+}
@@ -0,0 +1,151 @@
+package page
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ValueType uint8
+
+const (
+	NullValue ValueType = iota
+	IntegerValue
+	FloatValue
+	TextValue
+	BlobValue
+)
+
+// Value is a single decoded column of a record.
+type Value struct {
+	Type  ValueType
+	Int   int64
+	Float float64
+	Bytes []byte // content of TEXT and BLOB values
+}
+
+// decodeInteger reads a big-endian two's complement integer of up to eight
+// bytes.
+func decodeInteger(content []byte) int64 {
+	var result int64
+
+	for _, b := range content {
+		result = result<<8 | int64(b)
+	}
+
+	// sign-extend from the stored width
+	shift := 64 - 8*uint(len(content))
+
+	return result << shift >> shift
+}
+
+// DecodeValue turns a column's serial type and content into a typed value.
+func DecodeValue(serialType uint64, content []byte) (Value, error) {
+	switch {
+	case serialType == 0:
+		return Value{Type: NullValue}, nil
+	case serialType >= 1 && serialType <= 6:
+		return Value{Type: IntegerValue, Int: decodeInteger(content)}, nil
+	case serialType == 7:
+		if len(content) != 8 {
+			return Value{}, ErrCorruptPage
+		}
+
+		return Value{Type: FloatValue, Float: math.Float64frombits(binary.BigEndian.Uint64(content))}, nil
+	case serialType == 8:
+		return Value{Type: IntegerValue, Int: 0}, nil
+	case serialType == 9:
+		return Value{Type: IntegerValue, Int: 1}, nil
+	case serialType == 10 || serialType == 11:
+		return Value{}, ErrCorruptPage
+	case serialType%2 == 0:
+		return Value{Type: BlobValue, Bytes: content}, nil
+	default:
+		return Value{Type: TextValue, Bytes: content}, nil
+	}
+}
+
+// Values decodes every column of the cell.
+func (c Cell) Values() ([]Value, error) {
+	values := make([]Value, len(c.Columns))
+
+	for i, content := range c.Columns {
+		value, err := DecodeValue(c.SerialTypes[i], content)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// String renders the value as text the way sqlite3 displays it: NULL is
+// empty and REAL keeps 15 significant digits and always shows a decimal point.
+func (v Value) String() string {
+	switch v.Type {
+	case NullValue:
+		return ""
+	case IntegerValue:
+		return strconv.FormatInt(v.Int, 10)
+	case FloatValue:
+		return FormatReal(v.Float)
+	default:
+		return string(v.Bytes)
+	}
+}
+
+func FormatReal(r float64) string {
+	switch {
+	case math.IsInf(r, 1):
+		return "Inf"
+	case math.IsInf(r, -1):
+		return "-Inf"
+	case math.IsNaN(r):
+		return ""
+	case r == 0:
+		// also folds -0.0
+		return "0.0"
+	}
+
+	text := strconv.FormatFloat(roundReal(r), 'g', 15, 64)
+	mantissa, exponent, hasExponent := strings.Cut(text, "e")
+
+	if strings.Contains(mantissa, ".") {
+		return text
+	}
+
+	if hasExponent {
+		return mantissa + ".0e" + exponent
+	}
+
+	return mantissa + ".0"
+}
+
+// roundReal rounds r to 15 significant digits the way SQLite does: from the
+// first 19 digits of its value, with a half rounded away from zero. strconv
+// rounds the exact value, and a tie to even, so 333333333333332.5 would show
+// as 333333333333332 rather than 333333333333333.
+func roundReal(r float64) float64 {
+	text := strconv.FormatFloat(math.Abs(r), 'e', 40, 64)
+	mantissa, exponentText, _ := strings.Cut(text, "e")
+	exponent, _ := strconv.Atoi(exponentText)
+	digits := strings.Replace(mantissa, ".", "", 1)[:19]
+	kept, _ := strconv.ParseInt(digits[:15], 10, 64)
+
+	if digits[15] >= '5' {
+		kept++
+	}
+
+	rounded, err := strconv.ParseFloat(strconv.FormatInt(kept, 10)+"e"+strconv.Itoa(exponent-14), 64)
+
+	if err != nil {
+		// rounding up past the largest real
+		return r
+	}
+
+	return math.Copysign(rounded, r)
+}
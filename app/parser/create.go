@@ -0,0 +1,794 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ColumnDef struct {
+	Name          string
+	Type          string
+	PrimaryKey    bool
+	Descending    bool // PRIMARY KEY DESC on the column itself
+	Autoincrement bool
+	NotNull       bool
+	Unique        bool
+	Collate       string
+	Default       string // source text of the DEFAULT expression, empty when absent
+	Generated     bool
+}
+
+type IndexedColumn struct {
+	Name       string // empty when the index is on an expression
+	Expr       string
+	Collate    string
+	Descending bool
+}
+
+type CreateTable struct {
+	Name       string
+	Columns    []ColumnDef
+	PrimaryKey []IndexedColumn // from a PRIMARY KEY table constraint
+	Unique     [][]IndexedColumn
+	// Keys lists every PRIMARY KEY and UNIQUE constraint, on columns or on
+	// the table, in the order SQLite numbers their automatic indexes.
+	Keys         [][]IndexedColumn
+	WithoutRowid bool
+	Strict       bool
+	IfNotExists  bool
+}
+
+type CreateIndex struct {
+	Name    string
+	Table   string
+	Unique  bool
+	Columns []IndexedColumn
+	Where   string
+
+	IfNotExists bool
+}
+
+type CreateView struct {
+	Name    string
+	Columns []string
+	Select  string
+
+	IfNotExists bool
+	Temporary   bool
+}
+
+// Drop is a DROP TABLE, INDEX, VIEW or TRIGGER statement.
+type Drop struct {
+	Object   string // the kind of object, in upper case
+	Name     string
+	IfExists bool
+}
+
+// tokenStream is a cursor over the tokens of a single statement.
+type tokenStream struct {
+	sql    string
+	tokens []Token
+	pos    int
+}
+
+func newTokenStream(sql string) (*tokenStream, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenStream{sql: sql, tokens: tokens}, nil
+}
+
+func (s *tokenStream) peek() (Token, bool) {
+	if s.pos >= len(s.tokens) {
+		return Token{}, false
+	}
+
+	return s.tokens[s.pos], true
+}
+
+func (s *tokenStream) peekIs(text string) bool {
+	token, ok := s.peek()
+	return ok && token.Is(text)
+}
+
+// accept consumes the next tokens if they spell out the given keywords.
+func (s *tokenStream) accept(words ...string) bool {
+	for i, word := range words {
+		if s.pos+i >= len(s.tokens) || !s.tokens[s.pos+i].Is(word) {
+			return false
+		}
+	}
+
+	s.pos += len(words)
+
+	return true
+}
+
+func (s *tokenStream) expect(words ...string) error {
+	if !s.accept(words...) {
+		return s.errorf("expected %s", strings.Join(words, " "))
+	}
+
+	return nil
+}
+
+func (s *tokenStream) name() (string, error) {
+	token, ok := s.peek()
+
+	if !ok || !token.IsName() {
+		return "", s.errorf("expected a name")
+	}
+
+	s.pos++
+
+	return token.Value(), nil
+}
+
+// qualifiedName reads "name" or "schema.name" and returns the name part.
+func (s *tokenStream) qualifiedName() (string, error) {
+	name, err := s.name()
+
+	if err != nil {
+		return "", err
+	}
+
+	if s.accept(".") {
+		return s.name()
+	}
+
+	return name, nil
+}
+
+// skipBalanced consumes tokens up to the next top-level token in stops, or
+// the end of the stream, and returns the source text it covered.
+func (s *tokenStream) skipBalanced(stops ...string) string {
+	start := s.pos
+	depth := 0
+
+	for s.pos < len(s.tokens) {
+		token := s.tokens[s.pos]
+
+		if depth == 0 {
+			for _, stop := range stops {
+				if token.Is(stop) {
+					return s.textBetween(start, s.pos)
+				}
+			}
+		}
+
+		switch {
+		case token.Is("("):
+			depth++
+		case token.Is(")"):
+			depth--
+		}
+
+		s.pos++
+	}
+
+	return s.textBetween(start, s.pos)
+}
+
+func (s *tokenStream) textBetween(from int, to int) string {
+	if from >= to {
+		return ""
+	}
+
+	return s.sql[s.tokens[from].Pos:s.tokens[to-1].End()]
+}
+
+func (s *tokenStream) errorf(format string, args ...any) error {
+	position := len(s.sql)
+
+	if token, ok := s.peek(); ok {
+		position = token.Pos
+	}
+
+	return &SyntaxError{Position: position, Message: fmt.Sprintf(format, args...)}
+}
+
+// createPrefix consumes "CREATE ... object" and reports whether IF NOT
+// EXISTS follows.
+func (s *tokenStream) createPrefix(object string) (bool, error) {
+	if err := s.expect("CREATE"); err != nil {
+		return false, err
+	}
+
+	if !s.accept("TEMP") {
+		s.accept("TEMPORARY")
+	}
+
+	if object == "INDEX" {
+		s.accept("UNIQUE")
+	}
+
+	if err := s.expect(object); err != nil {
+		return false, err
+	}
+
+	return s.accept("IF", "NOT", "EXISTS"), nil
+}
+
+// columnConstraintWords start a column constraint and so end a type name.
+var columnConstraintWords = []string{"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS"}
+
+var tableConstraintWords = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+
+func ParseCreateTable(sql string) (*CreateTable, error) {
+	s, err := newTokenStream(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table := &CreateTable{}
+
+	if table.IfNotExists, err = s.createPrefix("TABLE"); err != nil {
+		return nil, err
+	}
+
+	if table.Name, err = s.qualifiedName(); err != nil {
+		return nil, err
+	}
+
+	if err := s.expect("("); err != nil {
+		return nil, err
+	}
+
+	for {
+		if s.isTableConstraint() {
+			if err := s.tableConstraint(table); err != nil {
+				return nil, err
+			}
+		} else {
+			column, err := s.columnDef()
+
+			if err != nil {
+				return nil, err
+			}
+
+			table.Columns = append(table.Columns, column)
+
+			if column.PrimaryKey {
+				table.Keys = append(table.Keys, []IndexedColumn{{Name: column.Name, Descending: column.Descending}})
+			}
+
+			if column.Unique {
+				table.Keys = append(table.Keys, []IndexedColumn{{Name: column.Name}})
+			}
+		}
+
+		if s.accept(")") {
+			break
+		}
+
+		if err := s.expect(","); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		switch {
+		case s.accept("WITHOUT", "ROWID"):
+			table.WithoutRowid = true
+		case s.accept("STRICT"):
+			table.Strict = true
+		case s.accept(","):
+		default:
+			return table, nil
+		}
+	}
+}
+
+func (s *tokenStream) isTableConstraint() bool {
+	for _, word := range tableConstraintWords {
+		if s.peekIs(word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *tokenStream) columnDef() (ColumnDef, error) {
+	var column ColumnDef
+	var err error
+
+	if column.Name, err = s.name(); err != nil {
+		return column, err
+	}
+
+	var typeWords []string
+
+	for {
+		token, ok := s.peek()
+
+		if !ok || token.Kind != IdentToken || s.isColumnConstraint() {
+			break
+		}
+
+		typeWords = append(typeWords, token.Text)
+		s.pos++
+	}
+
+	column.Type = strings.Join(typeWords, " ")
+
+	if s.peekIs("(") {
+		start := s.pos
+		s.pos++
+		s.skipBalanced(")")
+
+		if err := s.expect(")"); err != nil {
+			return column, err
+		}
+
+		column.Type += s.textBetween(start, s.pos)
+	}
+
+	for !s.peekIs(",") && !s.peekIs(")") {
+		if _, ok := s.peek(); !ok {
+			return column, s.errorf("unexpected end of column definition")
+		}
+
+		if err := s.columnConstraint(&column); err != nil {
+			return column, err
+		}
+	}
+
+	return column, nil
+}
+
+func (s *tokenStream) isColumnConstraint() bool {
+	for _, word := range columnConstraintWords {
+		if s.peekIs(word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *tokenStream) columnConstraint(column *ColumnDef) error {
+	switch {
+	case s.accept("CONSTRAINT"):
+		_, err := s.name()
+		return err
+
+	case s.accept("PRIMARY", "KEY"):
+		column.PrimaryKey = true
+
+		if s.accept("DESC") {
+			column.Descending = true
+		} else {
+			s.accept("ASC")
+		}
+
+		s.conflictClause()
+
+		if s.accept("AUTOINCREMENT") {
+			column.Autoincrement = true
+		}
+
+	case s.accept("NOT", "NULL"):
+		column.NotNull = true
+		s.conflictClause()
+
+	case s.accept("NULL"):
+		s.conflictClause()
+
+	case s.accept("UNIQUE"):
+		column.Unique = true
+		s.conflictClause()
+
+	case s.accept("CHECK"):
+		return s.parenthesized()
+
+	case s.accept("DEFAULT"):
+		start := s.pos
+
+		if s.peekIs("(") {
+			if err := s.parenthesized(); err != nil {
+				return err
+			}
+		} else {
+			if s.peekIs("+") || s.peekIs("-") {
+				s.pos++
+			}
+
+			if _, ok := s.peek(); !ok {
+				return s.errorf("expected a default value")
+			}
+
+			s.pos++
+		}
+
+		column.Default = s.textBetween(start, s.pos)
+
+	case s.accept("COLLATE"):
+		name, err := s.name()
+		column.Collate = name
+		return err
+
+	case s.accept("REFERENCES"):
+		s.foreignKeyClause()
+
+	case s.accept("GENERATED", "ALWAYS", "AS"), s.accept("AS"):
+		column.Generated = true
+
+		if err := s.parenthesized(); err != nil {
+			return err
+		}
+
+		if !s.accept("STORED") {
+			s.accept("VIRTUAL")
+		}
+
+	default:
+		return s.errorf("unexpected token in column definition")
+	}
+
+	return nil
+}
+
+func (s *tokenStream) conflictClause() {
+	if s.accept("ON", "CONFLICT") {
+		s.pos++
+	}
+}
+
+func (s *tokenStream) parenthesized() error {
+	if err := s.expect("("); err != nil {
+		return err
+	}
+
+	s.skipBalanced(")")
+
+	return s.expect(")")
+}
+
+func (s *tokenStream) foreignKeyClause() {
+	s.skipBalanced(",", ")")
+}
+
+func (s *tokenStream) tableConstraint(table *CreateTable) error {
+	if s.accept("CONSTRAINT") {
+		if _, err := s.name(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case s.accept("PRIMARY", "KEY"):
+		columns, err := s.indexedColumns()
+
+		if err != nil {
+			return err
+		}
+
+		table.PrimaryKey = columns
+		table.Keys = append(table.Keys, columns)
+
+		s.conflictClause()
+
+	case s.accept("UNIQUE"):
+		columns, err := s.indexedColumns()
+
+		if err != nil {
+			return err
+		}
+
+		table.Unique = append(table.Unique, columns)
+		table.Keys = append(table.Keys, columns)
+
+		s.conflictClause()
+
+	case s.accept("CHECK"):
+		return s.parenthesized()
+
+	case s.accept("FOREIGN", "KEY"):
+		if err := s.parenthesized(); err != nil {
+			return err
+		}
+
+		s.foreignKeyClause()
+
+	default:
+		return s.errorf("unexpected token in table constraint")
+	}
+
+	return nil
+}
+
+func (s *tokenStream) indexedColumns() ([]IndexedColumn, error) {
+	if err := s.expect("("); err != nil {
+		return nil, err
+	}
+
+	var columns []IndexedColumn
+
+	for {
+		start := s.pos
+		exprText := s.skipBalanced(",", ")", "COLLATE", "ASC", "DESC")
+
+		column := IndexedColumn{Expr: exprText}
+
+		if s.pos == start+1 && s.tokens[start].IsName() {
+			column.Name = s.tokens[start].Value()
+		}
+
+		if s.accept("COLLATE") {
+			name, err := s.name()
+
+			if err != nil {
+				return nil, err
+			}
+
+			column.Collate = name
+		}
+
+		if s.accept("DESC") {
+			column.Descending = true
+		} else {
+			s.accept("ASC")
+		}
+
+		columns = append(columns, column)
+
+		if s.accept(")") {
+			return columns, nil
+		}
+
+		if err := s.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func ParseCreateIndex(sql string) (*CreateIndex, error) {
+	s, err := newTokenStream(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	index := &CreateIndex{}
+
+	if len(s.tokens) > 1 && s.tokens[1].Is("UNIQUE") {
+		index.Unique = true
+	}
+
+	if index.IfNotExists, err = s.createPrefix("INDEX"); err != nil {
+		return nil, err
+	}
+
+	if index.Name, err = s.qualifiedName(); err != nil {
+		return nil, err
+	}
+
+	if err := s.expect("ON"); err != nil {
+		return nil, err
+	}
+
+	if index.Table, err = s.name(); err != nil {
+		return nil, err
+	}
+
+	if index.Columns, err = s.indexedColumns(); err != nil {
+		return nil, err
+	}
+
+	if s.accept("WHERE") {
+		index.Where = s.skipBalanced(";")
+	}
+
+	return index, nil
+}
+
+func ParseCreateView(sql string) (*CreateView, error) {
+	s, err := newTokenStream(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	view := &CreateView{}
+	view.Temporary = len(s.tokens) > 1 && (s.tokens[1].Is("TEMP") || s.tokens[1].Is("TEMPORARY"))
+
+	if view.IfNotExists, err = s.createPrefix("VIEW"); err != nil {
+		return nil, err
+	}
+
+	if view.Name, err = s.qualifiedName(); err != nil {
+		return nil, err
+	}
+
+	if s.peekIs("(") {
+		if view.Columns, err = s.columnNames(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.expect("AS"); err != nil {
+		return nil, err
+	}
+
+	token, ok := s.peek()
+
+	if !ok {
+		return nil, s.errorf("expected a SELECT statement")
+	}
+
+	view.Select = strings.TrimRight(strings.TrimSpace(sql[token.Pos:]), ";")
+
+	return view, nil
+}
+
+func ParseDrop(sql string) (*Drop, error) {
+	s, err := newTokenStream(strings.TrimRight(strings.TrimSpace(sql), ";"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.expect("DROP"); err != nil {
+		return nil, err
+	}
+
+	drop := &Drop{}
+
+	for _, object := range []string{"TABLE", "INDEX", "VIEW", "TRIGGER"} {
+		if s.accept(object) {
+			drop.Object = object
+		}
+	}
+
+	if drop.Object == "" {
+		return nil, s.errorf("expected TABLE, INDEX, VIEW or TRIGGER")
+	}
+
+	drop.IfExists = s.accept("IF", "EXISTS")
+
+	if drop.Name, err = s.qualifiedName(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.peek(); ok {
+		return nil, s.errorf("unexpected text after the name")
+	}
+
+	return drop, nil
+}
+
+// RowidColumn returns the position of the column that aliases the rowid, or
+// -1. Only a lone INTEGER PRIMARY KEY does; "INTEGER PRIMARY KEY DESC" on
+// the column itself is a historical exception that doesn't.
+func (t *CreateTable) RowidColumn() int {
+	if t.WithoutRowid {
+		return -1
+	}
+
+	found := -1
+
+	for i, column := range t.Columns {
+		if !column.PrimaryKey {
+			continue
+		}
+
+		if found != -1 || column.Descending || !strings.EqualFold(column.Type, "INTEGER") {
+			return -1
+		}
+
+		found = i
+	}
+
+	if found != -1 || len(t.PrimaryKey) != 1 {
+		return found
+	}
+
+	for i, column := range t.Columns {
+		if strings.EqualFold(column.Name, t.PrimaryKey[0].Name) && strings.EqualFold(column.Type, "INTEGER") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// PrimaryKeyColumns returns the PRIMARY KEY, whether it was declared on a
+// column or as a table constraint, or nil when there is none.
+func (t *CreateTable) PrimaryKeyColumns() []IndexedColumn {
+	if len(t.PrimaryKey) > 0 {
+		return t.PrimaryKey
+	}
+
+	for _, column := range t.Columns {
+		if column.PrimaryKey {
+			return []IndexedColumn{{Name: column.Name, Descending: column.Descending}}
+		}
+	}
+
+	return nil
+}
+
+// ColumnIndex finds a column by case-insensitive name, or returns -1.
+func (t *CreateTable) ColumnIndex(name string) int {
+	for i, column := range t.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type Affinity uint8
+
+const (
+	BlobAffinity Affinity = iota
+	TextAffinity
+	NumericAffinity
+	IntegerAffinity
+	RealAffinity
+)
+
+// ColumnAffinity applies SQLite's rules for deriving affinity from a declared type.
+func ColumnAffinity(declaredType string) Affinity {
+	upper := strings.ToUpper(declaredType)
+
+	switch {
+	case strings.Contains(upper, "INT"):
+		return IntegerAffinity
+	case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"):
+		return TextAffinity
+	case strings.Contains(upper, "BLOB"), upper == "":
+		return BlobAffinity
+	case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+		return RealAffinity
+	default:
+		return NumericAffinity
+	}
+}
+
+// StoredCreateStatement returns the text SQLite keeps in sqlite_schema for a
+// CREATE statement: the keywords up to the object type in upper case, without
+// TEMP or IF NOT EXISTS, followed by the source text from the name on.
+func StoredCreateStatement(sql string) (string, error) {
+	s, err := newTokenStream(strings.TrimRight(strings.TrimSpace(sql), ";"))
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.expect("CREATE"); err != nil {
+		return "", err
+	}
+
+	words := []string{"CREATE"}
+
+	if !s.accept("TEMP") {
+		s.accept("TEMPORARY")
+	}
+
+	if s.accept("UNIQUE") {
+		words = append(words, "UNIQUE")
+	}
+
+	token, ok := s.peek()
+
+	if !ok {
+		return "", s.errorf("expected an object type")
+	}
+
+	s.pos++
+	words = append(words, strings.ToUpper(token.Text))
+	s.accept("IF", "NOT", "EXISTS")
+
+	if token, ok = s.peek(); !ok {
+		return "", s.errorf("expected a name")
+	}
+
+	return strings.Join(words, " ") + " " + strings.TrimSpace(s.sql[token.Pos:]), nil
+}
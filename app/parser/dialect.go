@@ -0,0 +1,605 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FunctionPrefix marks functions renamed by Translate because the MySQL
+// grammar gives their names a meaning of their own.
+const FunctionPrefix = "__"
+
+// IntersectComment and ExceptComment mark the SELECT after an INTERSECT or
+// EXCEPT, which Translate writes as UNION.
+const (
+	IntersectComment = "/*intersect*/"
+	ExceptComment    = "/*except*/"
+)
+
+// renamedFunctions are the SQLite functions the MySQL grammar can't parse
+// as plain calls.
+var renamedFunctions = map[string]bool{
+	"like":      true,
+	"substr":    true,
+	"substring": true,
+	"cast":      true,
+}
+
+// Translate rewrites SQLite syntax that the MySQL grammar of sqlparser
+// reads differently, or not at all, into an equivalent it reads as SQLite
+// means it:
+//
+//   - "name" and [name] become `name`, since MySQL takes "name" for a string,
+//     as do collation names, which MySQL may take for keywords
+//   - backslashes in strings are doubled, since MySQL treats them as escapes
+//   - || becomes ^, which has the same precedence and no meaning in SQLite
+//   - == becomes =, x IS y becomes x <=> y, and x IS NOT y becomes
+//     NOT ((x) <=> y), since MySQL doesn't compare the result of a
+//     comparison without parentheses
+//   - GLOB becomes REGEXP, which SQLite only has as a user function
+//   - CAST(x AS type) becomes a call of __cast(x, 'type')
+//   - like(), substr() and substring() calls get the __ prefix
+//   - a subquery in FROM without an alias gets one, which MySQL requires
+//   - INTERSECT and EXCEPT become UNION, with a comment after the next
+//     SELECT keyword to tell them apart
+//   - VALUES used as a query becomes a SELECT of each row joined by UNION
+//     ALL, naming the columns column1, column2 and so on, and is read as a
+//     subquery when it is the right operand of a compound
+//   - SELECT ALL becomes SELECT, which MySQL only reads in some places
+//   - window function calls become calls of WindowFunction, and WINDOW
+//     clauses are dropped
+//   - parameters become value arguments named by ParameterPrefix and their
+//     position in sql
+func Translate(sql string) (string, error) {
+	sql, err := translateParameters(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	if sql, err = translateWindows(sql); err != nil {
+		return "", err
+	}
+
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	copied := 0
+
+	replace := func(token Token, text string) {
+		builder.WriteString(sql[copied:token.Pos])
+		builder.WriteString(text)
+		copied = token.End()
+	}
+
+	// casts holds the parenthesis depth of each CAST being rewritten, whose
+	// AS and type name are found at that depth
+	var casts []int
+	depth := 0
+
+	// inFrom marks the depths whose FROM clause is being read, and derived
+	// holds the depth inside each subquery in a FROM clause
+	inFrom := make(map[int]bool)
+	var derived []int
+	subqueries := 0
+
+	// opens and closes count the NOT (( written before a token and the )
+	// after it, around the operands of an IS NOT
+	opens := make(map[int]int)
+	closes := make(map[int]int)
+
+	// rewrites holds the text that replaces the tokens of VALUES queries
+	rewrites := make(map[int]string)
+
+	for i := range tokens {
+		if isNotOperator(tokens, i) {
+			start, end := isNotOperands(tokens, i)
+			opens[start]++
+			closes[end]++
+		}
+
+		if rows, wrapped := valuesQuery(tokens, i); len(rows) > 0 {
+			rewriteValues(tokens, i, rows, wrapped, rewrites)
+		}
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if opens[i] > 0 {
+			builder.WriteString(sql[copied:token.Pos])
+			builder.WriteString(strings.Repeat("NOT ((", opens[i]))
+			copied = token.Pos
+		}
+
+		next := func(offset int) Token {
+			if i+offset < len(tokens) {
+				return tokens[i+offset]
+			}
+
+			return Token{}
+		}
+
+		text, rewritten := rewrites[i]
+
+		switch {
+		case rewritten:
+			replace(token, text)
+		case token.Kind == QuotedIdentToken && token.Text[0] != '`':
+			replace(token, "`"+strings.ReplaceAll(token.Value(), "`", "``")+"`")
+		case token.Kind == StringToken && strings.Contains(token.Text, `\`):
+			replace(token, strings.ReplaceAll(token.Text, `\`, `\\`))
+		case token.Is("COLLATE") && next(1).Kind == IdentToken:
+			// a collation name may be a MySQL keyword, as BINARY is
+			builder.WriteString(sql[copied:next(1).Pos])
+			builder.WriteString("`" + next(1).Text + "`")
+			copied = next(1).End()
+			i++
+		case token.Is("||"):
+			replace(token, "^")
+		case token.Is("=="):
+			replace(token, "=")
+		case token.Is("GLOB") && !(next(1).Is("(") && (i == 0 || !endsOperand(tokens[i-1]))):
+			replace(token, "REGEXP")
+		case token.Is("REGEXP"):
+			return "", fmt.Errorf("no such function: REGEXP")
+		case isNotOperator(tokens, i):
+			replace(token, ") <=>")
+			copied = next(1).End()
+			i++
+		case token.Is("IS") && !next(1).Is("NULL") && !next(1).Is("NOT") && !next(1).Is("TRUE") && !next(1).Is("FALSE"):
+			replace(token, "<=>")
+		case (token.Is("INTERSECT") || token.Is("EXCEPT")) && next(1).Is("SELECT"):
+			comment := IntersectComment
+
+			if token.Is("EXCEPT") {
+				comment = ExceptComment
+			}
+
+			replace(token, "UNION")
+			builder.WriteString(sql[copied:next(1).End()] + " " + comment)
+			copied = next(1).End()
+			delete(inFrom, depth)
+			i++
+		case (token.Is("INTERSECT") || token.Is("EXCEPT")) && next(1).Is("VALUES"):
+			// the SELECT the VALUES becomes carries the comment
+			replace(token, "UNION")
+			delete(inFrom, depth)
+		case token.Is("ALL") && i > 0 && tokens[i-1].Is("SELECT"):
+			replace(token, "")
+		case token.Is("FROM"):
+			inFrom[depth] = true
+		case token.Is("WHERE") || token.Is("GROUP") || token.Is("HAVING") || token.Is("ORDER") || token.Is("LIMIT") ||
+			token.Is("UNION") || token.Is("INTERSECT") || token.Is("EXCEPT") || token.Is("WINDOW"):
+			delete(inFrom, depth)
+		case token.Is("("):
+			if i > 0 && (tokens[i-1].Is("FROM") || tokens[i-1].Is("JOIN") || (tokens[i-1].Is(",") && inFrom[depth])) &&
+				(next(1).Is("SELECT") || next(1).Is("VALUES") || next(1).Is("WITH")) {
+				derived = append(derived, depth+1)
+			}
+
+			depth++
+		case token.Is(")"):
+			delete(inFrom, depth)
+
+			if len(derived) > 0 && derived[len(derived)-1] == depth {
+				derived = derived[:len(derived)-1]
+
+				if alias := next(1); alias.Text == "" || !alias.Is("AS") && !(alias.IsName() && !(alias.Kind == IdentToken && IsKeyword(alias.Text))) {
+					subqueries++
+					builder.WriteString(sql[copied:token.End()])
+					builder.WriteString(fmt.Sprintf(" AS `(subquery-%d)`", subqueries))
+					copied = token.End()
+				}
+			}
+
+			depth--
+		case token.Kind == IdentToken && next(1).Is("(") && renamedFunctions[strings.ToLower(token.Text)] &&
+			!(token.Is("LIKE") && i > 0 && endsOperand(tokens[i-1])):
+			replace(token, FunctionPrefix+token.Text)
+
+			if token.Is("CAST") {
+				casts = append(casts, depth+1)
+			}
+		case token.Is("AS") && len(casts) > 0 && casts[len(casts)-1] == depth:
+			// the type name runs up to the closing parenthesis, and may have
+			// a size in parentheses of its own
+			end := i + 1
+			nested := 0
+
+			for ; end < len(tokens) && (nested > 0 || !tokens[end].Is(")")); end++ {
+				if tokens[end].Is("(") {
+					nested++
+				} else if tokens[end].Is(")") {
+					nested--
+				}
+			}
+
+			if end == i+1 || end == len(tokens) {
+				return "", &SyntaxError{Position: token.Pos, Message: "malformed CAST"}
+			}
+
+			typeName := sql[tokens[i+1].Pos:tokens[end-1].End()]
+
+			replace(token, ", ")
+			builder.WriteString(sql[copied:tokens[i+1].Pos])
+			builder.WriteString("'" + strings.ReplaceAll(typeName, "'", "''") + "'")
+			copied = tokens[end-1].End()
+
+			casts = casts[:len(casts)-1]
+			i = end - 1
+		}
+
+		if closes[i] > 0 {
+			builder.WriteString(sql[copied:tokens[i].End()])
+			builder.WriteString(strings.Repeat(")", closes[i]))
+			copied = tokens[i].End()
+		}
+	}
+
+	builder.WriteString(sql[copied:])
+
+	return builder.String(), nil
+}
+
+// valuesRow is a row of a VALUES clause: the positions of the parentheses
+// around it and of the commas between its values.
+type valuesRow struct {
+	open   int
+	close  int
+	commas []int
+}
+
+// valuesQuery reads the rows of the VALUES at tokens[i] when it is a query of
+// its own, that is a statement, a subquery or an operand of a compound,
+// rather than the rows an INSERT adds. wrapped is set when it has several
+// rows and follows a compound operator, so that they make up one operand.
+func valuesQuery(tokens []Token, i int) (rows []valuesRow, wrapped bool) {
+	if !tokens[i].Is("VALUES") {
+		return nil, false
+	}
+
+	afterCompound := i > 0 && (tokens[i-1].Is("UNION") || tokens[i-1].Is("INTERSECT") || tokens[i-1].Is("EXCEPT") ||
+		tokens[i-1].Is("ALL") && i > 1 && tokens[i-2].Is("UNION"))
+
+	if i > 0 && !tokens[i-1].Is("(") && !afterCompound {
+		return nil, false
+	}
+
+	for j := i + 1; j < len(tokens) && tokens[j].Is("("); j += 2 {
+		row := valuesRow{open: j}
+		depth := 0
+
+		for ; j < len(tokens); j++ {
+			if tokens[j].Is("(") {
+				depth++
+			} else if tokens[j].Is(")") {
+				depth--
+			} else if depth == 1 && tokens[j].Is(",") {
+				row.commas = append(row.commas, j)
+			}
+
+			if depth == 0 {
+				break
+			}
+		}
+
+		if j == len(tokens) {
+			return nil, false
+		}
+
+		row.close = j
+		rows = append(rows, row)
+
+		if j+1 == len(tokens) || !tokens[j+1].Is(",") {
+			break
+		}
+	}
+
+	return rows, afterCompound && len(rows) > 1
+}
+
+// rewriteValues sets the text that replaces each token of the VALUES query at
+// tokens[i] that Translate writes as SELECTs.
+func rewriteValues(tokens []Token, i int, rows []valuesRow, wrapped bool, rewrites map[int]string) {
+	keyword := "SELECT"
+
+	if i > 0 && tokens[i-1].Is("INTERSECT") {
+		keyword += " " + IntersectComment
+	} else if i > 0 && tokens[i-1].Is("EXCEPT") {
+		keyword += " " + ExceptComment
+	}
+
+	if wrapped {
+		keyword += " * FROM (SELECT"
+	}
+
+	rewrites[i] = keyword
+
+	for k, row := range rows {
+		rewrites[row.open] = " "
+		rewrites[row.close] = " "
+
+		if k == 0 {
+			for n, comma := range row.commas {
+				rewrites[comma] = fmt.Sprintf(" AS column%d,", n+1)
+			}
+
+			rewrites[row.close] = fmt.Sprintf(" AS column%d ", len(row.commas)+1)
+		}
+
+		if k+1 < len(rows) {
+			rewrites[row.close+1] = " UNION ALL SELECT"
+		}
+	}
+
+	if wrapped {
+		rewrites[rows[len(rows)-1].close] = ") AS `(values)`"
+	}
+}
+
+// isNotOperator reports whether tokens[i] starts an IS NOT comparison of
+// two values, rather than IS NOT NULL, TRUE or FALSE.
+func isNotOperator(tokens []Token, i int) bool {
+	if i+2 >= len(tokens) || !tokens[i].Is("IS") || !tokens[i+1].Is("NOT") {
+		return false
+	}
+
+	next := tokens[i+2]
+
+	return !next.Is("NULL") && !next.Is("TRUE") && !next.Is("FALSE")
+}
+
+// isNotOperands finds the operands of the IS NOT at tokens[i]: the first
+// token of the left one and the last token of the right one. IS NOT binds as
+// tightly as = and groups to the left, so the left operand runs back to a
+// NOT, AND, OR or anything else that isn't part of an expression, and the
+// right one takes only operators that bind more tightly.
+func isNotOperands(tokens []Token, i int) (int, int) {
+	start := i
+
+	for j, depth := i-1, 0; j >= 0; j-- {
+		token := tokens[j]
+
+		if token.Is(")") || token.Is("END") {
+			depth++
+		} else if token.Is("(") || token.Is("CASE") {
+			depth--
+		}
+
+		if depth < 0 || (depth == 0 && !inComparison(tokens, j)) {
+			break
+		}
+
+		start = j
+	}
+
+	end := i + 1
+	operand := true
+
+	for j := i + 2; j < len(tokens); j++ {
+		token := tokens[j]
+
+		switch {
+		case operand && (token.Is("-") || token.Is("+") || token.Is("~")):
+		case operand:
+			if token.Is("(") || token.Is("CASE") {
+				j = closing(tokens, j)
+			} else if token.Kind == IdentToken && j+1 < len(tokens) && tokens[j+1].Is("(") {
+				j = closing(tokens, j+1)
+			}
+
+			operand = false
+		case token.Is("COLLATE") && j+1 < len(tokens):
+			j++
+		case token.Is(".") || tightOperators[token.Text] && token.Kind == OperatorToken:
+			operand = true
+		default:
+			return start, end
+		}
+
+		end = j
+	}
+
+	return start, end
+}
+
+// tightOperators are the binary operators that bind more tightly than IS.
+var tightOperators = map[string]bool{
+	"<": true, "<=": true, ">": true, ">=": true,
+	"&": true, "|": true, "<<": true, ">>": true,
+	"+": true, "-": true, "*": true, "/": true, "%": true,
+	"||": true, "->": true, "->>": true,
+}
+
+// inComparison reports whether tokens[j], outside any parentheses, can be
+// part of the left operand of an IS NOT.
+func inComparison(tokens []Token, j int) bool {
+	token := tokens[j]
+
+	switch token.Kind {
+	case OperatorToken:
+		return !token.Is(",") && !token.Is(";")
+	case IdentToken:
+	default:
+		return true
+	}
+
+	if !IsKeyword(token.Text) {
+		return true
+	}
+
+	// keywords that are also the names of functions
+	if j+1 < len(tokens) && tokens[j+1].Is("(") {
+		for _, word := range []string{"CAST", "EXISTS", "GLOB", "LIKE", "MATCH", "REGEXP", "REPLACE", "RAISE"} {
+			if token.Is(word) {
+				return true
+			}
+		}
+	}
+
+	// NOT is part of IS NOT, NOT IN, NOT LIKE and the like, but the NOT
+	// operator of its own binds more loosely
+	if token.Is("NOT") {
+		return j > 0 && (tokens[j-1].Is("IS") || !tokens[j-1].Is("NOT") && endsOperand(tokens[j-1]))
+	}
+
+	for _, word := range []string{"IS", "NULL", "TRUE", "FALSE", "IN", "LIKE", "GLOB", "MATCH", "REGEXP", "ESCAPE",
+		"COLLATE", "ISNULL", "NOTNULL", "END", "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP"} {
+		if token.Is(word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closing returns the position of the ) or END that closes the ( or CASE at
+// tokens[j], or the last token when there is none.
+func closing(tokens []Token, j int) int {
+	depth := 0
+
+	for ; j < len(tokens); j++ {
+		if tokens[j].Is("(") || tokens[j].Is("CASE") {
+			depth++
+		} else if tokens[j].Is(")") || tokens[j].Is("END") {
+			depth--
+		}
+
+		if depth == 0 {
+			return j
+		}
+	}
+
+	return len(tokens) - 1
+}
+
+// endsOperand reports whether a token can be the last one of an operand,
+// which tells the LIKE operator apart from a call of like().
+func endsOperand(token Token) bool {
+	switch token.Kind {
+	case IdentToken:
+		return token.Is("NOT") || !IsKeyword(token.Text)
+	case OperatorToken:
+		return token.Is(")")
+	}
+
+	return true
+}
+
+// SelectColumnTexts returns the source text of each result column of every
+// SELECT in sql, subqueries included, in the order the SELECTs appear.
+// SQLite names columns without an alias by their text. Aliases are left on
+// the text. The texts are cut from text, which is sql as it was before
+// ParseNestedWiths blanked parts of it out, so that they read as written.
+func SelectColumnTexts(sql, text string) [][]string {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return nil
+	}
+
+	var texts [][]string
+
+	for i, token := range tokens {
+		if token.Is("SELECT") {
+			texts = append(texts, selectListTexts(text, tokens[i+1:]))
+		}
+
+		// a VALUES query is a SELECT of each row, below a SELECT * when
+		// Translate makes it a subquery
+		rows, wrapped := valuesQuery(tokens, i)
+
+		if wrapped {
+			texts = append(texts, []string{"*"})
+		}
+
+		for _, row := range rows {
+			var values []string
+
+			start := row.open + 1
+
+			for _, end := range append(row.commas, row.close) {
+				if start < end {
+					values = append(values, text[tokens[start].Pos:tokens[end-1].End()])
+				}
+
+				start = end + 1
+			}
+
+			texts = append(texts, values)
+		}
+	}
+
+	return texts
+}
+
+// selectCount is how many SELECTs tokens[i] starts, as SelectColumnTexts
+// counts them: one for a SELECT, and for a VALUES query one for each row and
+// one more when Translate makes it a subquery.
+func selectCount(tokens []Token, i int) int {
+	if tokens[i].Is("SELECT") {
+		return 1
+	}
+
+	rows, wrapped := valuesQuery(tokens, i)
+
+	if wrapped {
+		return len(rows) + 1
+	}
+
+	return len(rows)
+}
+
+// selectListTexts splits the select list tokens start with into the source
+// text of each item.
+func selectListTexts(sql string, tokens []Token) []string {
+	start := 0
+
+	if start < len(tokens) && (tokens[start].Is("DISTINCT") || tokens[start].Is("ALL")) {
+		start++
+	}
+
+	var texts []string
+
+	itemStart := start
+	depth := 0
+
+	for i := start; i <= len(tokens); i++ {
+		end := i == len(tokens)
+
+		if !end {
+			token := tokens[i]
+
+			switch {
+			case token.Is("("):
+				depth++
+			case token.Is(")"):
+				depth--
+			}
+
+			end = depth < 0 || depth == 0 && (token.Is("FROM") || token.Is("WHERE") || token.Is("GROUP") ||
+				token.Is("HAVING") || token.Is("ORDER") || token.Is("LIMIT") || token.Is("UNION") ||
+				token.Is("INTERSECT") || token.Is("EXCEPT") || token.Is("WINDOW") || token.Is(";"))
+		}
+
+		if end || (depth == 0 && tokens[i].Is(",")) {
+			if i > itemStart {
+				texts = append(texts, sql[tokens[itemStart].Pos:tokens[i-1].End()])
+			}
+
+			itemStart = i + 1
+		}
+
+		if end {
+			break
+		}
+	}
+
+	return texts
+}
@@ -0,0 +1,56 @@
+package parser
+
+import "strings"
+
+var keywords = make(map[string]bool)
+
+func init() {
+	for _, keyword := range strings.Fields(`
+		ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE
+		COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED
+		DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE
+		EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST FOLLOWING FOR FOREIGN FROM
+		FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE IMMEDIATE IN INDEX
+		INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL JOIN KEY
+		LAST LEFT LIKE LIMIT MATCH MATERIALIZED NATURAL NO NOT NOTHING NOTNULL
+		NULL NULLS OF OFFSET ON OR ORDER OTHERS OUTER OVER PARTITION PLAN PRAGMA
+		PRECEDING PRIMARY QUERY RAISE RANGE RECURSIVE REFERENCES REGEXP REINDEX
+		RELEASE RENAME REPLACE RESTRICT RETURNING RIGHT ROLLBACK ROW ROWS
+		SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES TO TRANSACTION
+		TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL
+		WHEN WHERE WINDOW WITH WITHOUT`) {
+		keywords[keyword] = true
+	}
+}
+
+func IsKeyword(word string) bool {
+	return keywords[strings.ToUpper(word)]
+}
+
+// QuoteIdentifier double-quotes a name unless it is a plain identifier that
+// isn't a keyword, matching how sqlite3 quotes names in generated SQL.
+func QuoteIdentifier(name string) string {
+	if needsQuoting(name) {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+
+	return name
+}
+
+func needsQuoting(name string) bool {
+	if name == "" || isDigit(name[0]) || IsKeyword(name) {
+		return true
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		if c != '_' && !isDigit(c) && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return true
+		}
+	}
+
+	return false
+}
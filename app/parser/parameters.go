@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParameterPrefix starts the name Translate gives a parameter, which the
+// MySQL grammar reads as a value argument. The position of the parameter in
+// the statement follows, so that the parameter can be told apart from the
+// others even once the statement is split up.
+const ParameterPrefix = ":p"
+
+// MaxParameter is the largest number a parameter can have.
+const MaxParameter = 32766
+
+// Parameters numbers the parameters of a statement the way SQLite does. A
+// ? takes the number after the largest one so far, and ?NNN the number NNN.
+// A named parameter, written :name, @name or $name, takes the number after
+// the largest one the first time it appears, and keeps it after that.
+type Parameters struct {
+	// Names holds the name of each parameter by its number less one: the
+	// text it was written as, or "" for a ?
+	Names []string
+	// Numbers holds the number of the parameter at each position
+	Numbers map[int]int
+}
+
+func ParseParameters(sql string) (*Parameters, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parameters{Numbers: make(map[int]int)}
+
+	for _, token := range tokens {
+		if token.Kind != VariableToken {
+			continue
+		}
+
+		number := len(p.Names) + 1
+
+		if token.Text != "?" {
+			if index := p.Index(token.Text); index != 0 {
+				number = index
+			} else if strings.HasPrefix(token.Text, "?") {
+				if number, err = strconv.Atoi(token.Text[1:]); err != nil || number < 1 || number > MaxParameter {
+					return nil, fmt.Errorf("variable number must be between ?1 and ?%d", MaxParameter)
+				}
+			}
+		}
+
+		if number > MaxParameter {
+			return nil, fmt.Errorf("too many SQL variables")
+		}
+
+		for len(p.Names) < number {
+			p.Names = append(p.Names, "")
+		}
+
+		if token.Text != "?" {
+			p.Names[number-1] = token.Text
+		}
+
+		p.Numbers[token.Pos] = number
+	}
+
+	return p, nil
+}
+
+// Index returns the number of the parameter with the given name, or 0 when
+// there is none.
+func (p *Parameters) Index(name string) int {
+	for i, existing := range p.Names {
+		if existing != "" && existing == name {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// translateParameters writes each parameter as ParameterPrefix followed by
+// its position.
+func translateParameters(sql string) (string, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	copied := 0
+
+	for _, token := range tokens {
+		if token.Kind != VariableToken {
+			continue
+		}
+
+		builder.WriteString(sql[copied:token.Pos])
+		builder.WriteString(ParameterPrefix + strconv.Itoa(token.Pos))
+		copied = token.End()
+	}
+
+	builder.WriteString(sql[copied:])
+
+	return builder.String(), nil
+}
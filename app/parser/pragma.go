@@ -0,0 +1,68 @@
+package parser
+
+import "strings"
+
+// Pragma is a PRAGMA statement, as "PRAGMA name", "PRAGMA name = value" or
+// "PRAGMA name(value)". Value is empty when the statement only reads the
+// setting.
+type Pragma struct {
+	Name  string
+	Value string
+}
+
+func ParsePragma(sql string) (*Pragma, error) {
+	s, err := newTokenStream(strings.TrimRight(strings.TrimSpace(sql), ";"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.expect("PRAGMA"); err != nil {
+		return nil, err
+	}
+
+	pragma := &Pragma{}
+
+	if pragma.Name, err = s.qualifiedName(); err != nil {
+		return nil, err
+	}
+
+	parenthesized := false
+
+	switch {
+	case s.accept("="):
+	case s.accept("("):
+		parenthesized = true
+	default:
+		if _, ok := s.peek(); ok {
+			return nil, s.errorf("unexpected text after the name")
+		}
+
+		return pragma, nil
+	}
+
+	start := s.pos
+	s.skipBalanced(")")
+
+	switch s.pos - start {
+	case 0:
+		return nil, s.errorf("expected a value")
+	case 1:
+		// a lone name or string is taken without its quotes
+		pragma.Value = s.tokens[start].Value()
+	default:
+		pragma.Value = s.textBetween(start, s.pos)
+	}
+
+	if parenthesized {
+		if err := s.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := s.peek(); ok {
+		return nil, s.errorf("unexpected text after the value")
+	}
+
+	return pragma, nil
+}
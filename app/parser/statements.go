@@ -0,0 +1,78 @@
+package parser
+
+import "strings"
+
+// SplitStatements breaks SQL text into statements on the semicolons that end
+// them. Semicolons inside the BEGIN ... END body of a trigger don't count.
+// Text after the last semicolon is returned as a final statement.
+func SplitStatements(sql string) ([]string, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+
+	start := 0
+	statementStart := 0
+
+	for i, token := range tokens {
+		if !token.Is(";") {
+			continue
+		}
+
+		if isTrigger(tokens[statementStart:i]) && !tokens[i-1].Is("END") {
+			continue
+		}
+
+		text := strings.TrimSpace(sql[start:token.Pos])
+
+		if text != "" {
+			statements = append(statements, text)
+		}
+
+		start = token.End()
+		statementStart = i + 1
+	}
+
+	if text := strings.TrimSpace(sql[start:]); text != "" && statementStart < len(tokens) {
+		statements = append(statements, text)
+	}
+
+	return statements, nil
+}
+
+// IsComplete reports whether the text ends with a semicolon that terminates
+// a statement, which is when an interactive shell should run it.
+func IsComplete(sql string) bool {
+	tokens, err := Tokenize(sql)
+
+	if err != nil || len(tokens) == 0 || !tokens[len(tokens)-1].Is(";") {
+		return false
+	}
+
+	statementStart := 0
+
+	for i, token := range tokens[:len(tokens)-1] {
+		if token.Is(";") && (!isTrigger(tokens[statementStart:i]) || tokens[i-1].Is("END")) {
+			statementStart = i + 1
+		}
+	}
+
+	last := len(tokens) - 1
+
+	return !isTrigger(tokens[statementStart:last]) || (last > 0 && tokens[last-1].Is("END"))
+}
+
+func isTrigger(tokens []Token) bool {
+	if len(tokens) < 2 || !tokens[0].Is("CREATE") {
+		return false
+	}
+
+	if tokens[1].Is("TEMP") || tokens[1].Is("TEMPORARY") {
+		return len(tokens) > 2 && tokens[2].Is("TRIGGER")
+	}
+
+	return tokens[1].Is("TRIGGER")
+}
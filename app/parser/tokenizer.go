@@ -0,0 +1,273 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TokenKind uint8
+
+const (
+	IdentToken TokenKind = iota
+	QuotedIdentToken
+	StringToken
+	BlobToken
+	NumberToken
+	VariableToken
+	OperatorToken
+)
+
+// Token is a lexical unit of SQL text. Text is exactly what appears in the
+// source, starting at byte offset Pos.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+}
+
+// Value is the token with its quoting removed.
+func (t Token) Value() string {
+	switch t.Kind {
+	case QuotedIdentToken:
+		if t.Text[0] == '[' {
+			return t.Text[1 : len(t.Text)-1]
+		}
+
+		quote := t.Text[:1]
+
+		return strings.ReplaceAll(t.Text[1:len(t.Text)-1], quote+quote, quote)
+	case StringToken:
+		return strings.ReplaceAll(t.Text[1:len(t.Text)-1], "''", "'")
+	case BlobToken:
+		return t.Text[2 : len(t.Text)-1]
+	default:
+		return t.Text
+	}
+}
+
+// Is reports whether the token is the given keyword or operator.
+func (t Token) Is(text string) bool {
+	switch t.Kind {
+	case IdentToken:
+		return strings.EqualFold(t.Text, text)
+	case OperatorToken:
+		return t.Text == text
+	default:
+		return false
+	}
+}
+
+// IsName reports whether the token can name a table, column or other object.
+func (t Token) IsName() bool {
+	return t.Kind == IdentToken || t.Kind == QuotedIdentToken || t.Kind == StringToken
+}
+
+// End is the byte offset just past the token.
+func (t Token) End() int {
+	return t.Pos + len(t.Text)
+}
+
+var twoCharOperators = []string{"||", "<=", ">=", "!=", "<>", "==", "<<", ">>", "->"}
+
+// Tokenize splits SQL into tokens, dropping whitespace and comments.
+func Tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+
+	i := 0
+
+	for i < len(sql) {
+		c := sql[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'':
+			end, err := closingQuote(sql, i+1, '\'')
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, Token{Kind: BlobToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case c == '\'':
+			end, err := closingQuote(sql, i, '\'')
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, Token{Kind: StringToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case c == '"' || c == '`':
+			end, err := closingQuote(sql, i, c)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, Token{Kind: QuotedIdentToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case c == '[':
+			end := strings.IndexByte(sql[i:], ']')
+
+			if end == -1 {
+				return nil, &SyntaxError{Position: i, Message: "unterminated identifier"}
+			}
+
+			tokens = append(tokens, Token{Kind: QuotedIdentToken, Text: sql[i : i+end+1], Pos: i})
+			i += end + 1
+
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			end := scanNumber(sql, i)
+			tokens = append(tokens, Token{Kind: NumberToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case c == '?':
+			end := i + 1
+
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+
+			tokens = append(tokens, Token{Kind: VariableToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case (c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentChar(sql[i+1]):
+			end := i + 1
+
+			for end < len(sql) && isIdentChar(sql[end]) {
+				end++
+			}
+
+			tokens = append(tokens, Token{Kind: VariableToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		case isIdentStart(c):
+			end := i + 1
+
+			for end < len(sql) && isIdentChar(sql[end]) {
+				end++
+			}
+
+			tokens = append(tokens, Token{Kind: IdentToken, Text: sql[i:end], Pos: i})
+			i = end
+
+		default:
+			text := sql[i : i+1]
+
+			for _, operator := range twoCharOperators {
+				if strings.HasPrefix(sql[i:], operator) {
+					text = operator
+					break
+				}
+			}
+
+			if !strings.Contains("(),;.+-*/%<>=&|~!", text[:1]) {
+				return nil, &SyntaxError{Position: i, Message: fmt.Sprintf("unrecognized token: %q", text)}
+			}
+
+			tokens = append(tokens, Token{Kind: OperatorToken, Text: text, Pos: i})
+			i += len(text)
+		}
+	}
+
+	return tokens, nil
+}
+
+// closingQuote returns the offset just past the quote that closes the one at
+// start, treating a doubled quote as an escaped quote character.
+func closingQuote(sql string, start int, quote byte) (int, error) {
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+
+		if i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+
+		return i + 1, nil
+	}
+
+	return 0, &SyntaxError{Position: start, Message: "unterminated quoted string"}
+}
+
+func scanNumber(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+
+		for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) != -1 {
+			i++
+		}
+
+		return i
+	}
+
+	for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.') {
+		i++
+	}
+
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+
+		if j < len(sql) && isDigit(sql[j]) {
+			i = j
+
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+		}
+	}
+
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+// SyntaxError is a parse failure at a byte offset of the SQL text.
+type SyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
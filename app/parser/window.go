@@ -0,0 +1,557 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WindowFunction is the function Translate writes a window function call
+// as, since the MySQL grammar has no OVER clause. Its arguments are:
+//
+//   - the function call itself
+//   - __partition(e1, e2, ...), with the PARTITION BY expressions
+//   - __order(__term(e, direction, nulls), ...), with the ORDER BY terms:
+//     direction is 'ASC' or 'DESC', and nulls 'FIRST', 'LAST' or empty
+//   - __frame(unit, start, start offset, end, end offset, exclude), with the
+//     frame in upper case and NULL for offsets there aren't, or __frame()
+//     when there is no frame specification
+const WindowFunction = FunctionPrefix + "window"
+
+// windowSpec is the source text of the parts of a window definition.
+type windowSpec struct {
+	partition []string
+	order     []string
+	frame     string
+}
+
+// windowRewriter rewrites the window function calls of a statement.
+type windowRewriter struct {
+	sql    string
+	tokens []Token
+	// calls maps the first token of each call followed by OVER to the
+	// position of OVER
+	calls map[int]int
+	// definitions holds the windows of WINDOW clauses by lower-case name,
+	// and clauses maps the position of each clause's WINDOW to the end of
+	// the clause
+	definitions map[string]*windowSpec
+	clauses     map[int]int
+}
+
+// translateWindows writes each window function call as a call of
+// WindowFunction, with its window spelled out in the arguments, and drops
+// WINDOW clauses once the windows they name are spelled out where used.
+func translateWindows(sql string) (string, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	w := &windowRewriter{
+		sql:         sql,
+		tokens:      tokens,
+		calls:       make(map[int]int),
+		definitions: make(map[string]*windowSpec),
+		clauses:     make(map[int]int),
+	}
+
+	for i, token := range tokens {
+		switch {
+		case token.Is("OVER") && i > 0 && tokens[i-1].Is(")") && i+1 < len(tokens) && (tokens[i+1].Is("(") || tokens[i+1].IsName()):
+			if start := w.callStart(i - 1); start != -1 {
+				w.calls[start] = i
+			}
+		case token.Is("WINDOW") && i+2 < len(tokens) && tokens[i+1].IsName() && tokens[i+2].Is("AS"):
+			if err := w.windowClause(i); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if len(w.calls) == 0 && len(w.clauses) == 0 {
+		return sql, nil
+	}
+
+	text, err := w.text(0, len(tokens))
+
+	if err != nil {
+		return "", err
+	}
+
+	// keep what comes after the last token, such as a comment
+	if len(tokens) > 0 {
+		text += sql[tokens[len(tokens)-1].End():]
+	}
+
+	return sql[:tokens[0].Pos] + text, nil
+}
+
+// callStart finds the name of the function whose argument list ends with
+// the parenthesis at close, or returns -1. No function that can be a window
+// function is named by a keyword.
+func (w *windowRewriter) callStart(close int) int {
+	depth := 0
+
+	for i := close; i >= 0; i-- {
+		switch {
+		case w.tokens[i].Is(")"):
+			depth++
+		case w.tokens[i].Is("("):
+			if depth--; depth == 0 {
+				if i > 0 && w.tokens[i-1].Kind == IdentToken && !IsKeyword(w.tokens[i-1].Text) {
+					return i - 1
+				}
+
+				return -1
+			}
+		}
+	}
+
+	return -1
+}
+
+// closing finds the parenthesis that closes the one at open.
+func (w *windowRewriter) closing(open int) (int, error) {
+	depth := 0
+
+	for i := open; i < len(w.tokens); i++ {
+		switch {
+		case w.tokens[i].Is("("):
+			depth++
+		case w.tokens[i].Is(")"):
+			if depth--; depth == 0 {
+				return i, nil
+			}
+		}
+	}
+
+	return 0, w.errorAt(len(w.tokens), "unbalanced parentheses")
+}
+
+// windowClause reads the WINDOW clause at start.
+func (w *windowRewriter) windowClause(start int) error {
+	i := start + 1
+
+	for {
+		if i+2 >= len(w.tokens) || !w.tokens[i].IsName() || !w.tokens[i+1].Is("AS") || !w.tokens[i+2].Is("(") {
+			return w.errorAt(i, "malformed WINDOW clause")
+		}
+
+		name := w.tokens[i].Value()
+		end, err := w.closing(i + 2)
+
+		if err != nil {
+			return err
+		}
+
+		spec, err := w.spec(i+3, end)
+
+		if err != nil {
+			return err
+		}
+
+		w.definitions[strings.ToLower(name)] = spec
+		i = end + 1
+
+		if i >= len(w.tokens) || !w.tokens[i].Is(",") {
+			break
+		}
+
+		i++
+	}
+
+	w.clauses[start] = i
+
+	return nil
+}
+
+// text rewrites the tokens from up to to.
+func (w *windowRewriter) text(from int, to int) (string, error) {
+	var builder strings.Builder
+
+	if from >= to {
+		return "", nil
+	}
+
+	copied := w.tokens[from].Pos
+
+	for i := from; i < to; i++ {
+		if end, ok := w.clauses[i]; ok {
+			builder.WriteString(w.sql[copied:w.tokens[i].Pos])
+			i = end - 1
+			copied = w.tokens[i].End()
+
+			continue
+		}
+
+		over, ok := w.calls[i]
+
+		if !ok || over >= to {
+			continue
+		}
+
+		call, err := w.text(i, over)
+
+		if err != nil {
+			return "", err
+		}
+
+		spec, end, err := w.over(over)
+
+		if err != nil {
+			return "", err
+		}
+
+		written, err := w.specArguments(spec)
+
+		if err != nil {
+			return "", err
+		}
+
+		builder.WriteString(w.sql[copied:w.tokens[i].Pos])
+		builder.WriteString(WindowFunction + "(" + call + ", " + written + ")")
+		i = end - 1
+		copied = w.tokens[i].End()
+	}
+
+	builder.WriteString(w.sql[copied:w.tokens[to-1].End()])
+
+	return builder.String(), nil
+}
+
+// over reads the window after the OVER at position over: a name, or a
+// definition in parentheses that may start with the name of one to extend.
+// end is the position after it.
+func (w *windowRewriter) over(over int) (spec *windowSpec, end int, err error) {
+	if !w.tokens[over+1].Is("(") {
+		name := w.tokens[over+1].Value()
+
+		if spec = w.definitions[strings.ToLower(name)]; spec == nil {
+			return nil, 0, fmt.Errorf("no such window: %s", name)
+		}
+
+		return spec, over + 2, nil
+	}
+
+	close, err := w.closing(over + 1)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	spec, err = w.spec(over+2, close)
+
+	return spec, close + 1, err
+}
+
+// spec reads the definition of a window between the parentheses from and
+// to.
+func (w *windowRewriter) spec(from int, to int) (*windowSpec, error) {
+	spec := &windowSpec{}
+	i := from
+
+	if i < to && w.tokens[i].IsName() && !w.tokens[i].Is("PARTITION") && !w.tokens[i].Is("ORDER") && !isFrameUnit(w.tokens[i]) {
+		name := w.tokens[i].Value()
+		base := w.definitions[strings.ToLower(name)]
+
+		if base == nil {
+			return nil, fmt.Errorf("no such window: %s", name)
+		}
+
+		*spec = *base
+		i++
+
+		if base.frame != "" {
+			return nil, fmt.Errorf("cannot override frame specification of window: %s", name)
+		}
+
+		if i < to && w.tokens[i].Is("PARTITION") {
+			return nil, fmt.Errorf("cannot override PARTITION clause of window: %s", name)
+		}
+
+		if i < to && w.tokens[i].Is("ORDER") && len(base.order) > 0 {
+			return nil, fmt.Errorf("cannot override ORDER BY clause of window: %s", name)
+		}
+	}
+
+	if i+1 < to && w.tokens[i].Is("PARTITION") && w.tokens[i+1].Is("BY") {
+		var err error
+
+		if spec.partition, i, err = w.list(i+2, to, "ORDER", "ROWS", "RANGE", "GROUPS"); err != nil {
+			return nil, err
+		}
+	}
+
+	if i+1 < to && w.tokens[i].Is("ORDER") && w.tokens[i+1].Is("BY") {
+		var err error
+
+		if spec.order, i, err = w.list(i+2, to, "ROWS", "RANGE", "GROUPS"); err != nil {
+			return nil, err
+		}
+	}
+
+	if i < to && isFrameUnit(w.tokens[i]) {
+		spec.frame = w.sql[w.tokens[i].Pos:w.tokens[to-1].End()]
+
+		return spec, w.checkFrame(i, to)
+	}
+
+	if i < to {
+		return nil, w.errorAt(i, "syntax error in window definition")
+	}
+
+	return spec, nil
+}
+
+func isFrameUnit(token Token) bool {
+	return token.Is("ROWS") || token.Is("RANGE") || token.Is("GROUPS")
+}
+
+// list splits the tokens from up to the first of stops, or to, into the
+// source text of comma-separated items, and returns where it stopped.
+func (w *windowRewriter) list(from int, to int, stops ...string) ([]string, int, error) {
+	var items []string
+
+	depth := 0
+	start := from
+	i := from
+
+	for ; i <= to; i++ {
+		end := i == to
+
+		if !end {
+			token := w.tokens[i]
+
+			switch {
+			case token.Is("("):
+				depth++
+			case token.Is(")"):
+				depth--
+			}
+
+			for _, stop := range stops {
+				end = end || depth == 0 && token.Is(stop)
+			}
+		}
+
+		if end || depth == 0 && w.tokens[i].Is(",") {
+			if i == start {
+				return nil, 0, w.errorAt(i, "expected an expression")
+			}
+
+			items = append(items, w.sql[w.tokens[start].Pos:w.tokens[i-1].End()])
+			start = i + 1
+		}
+
+		if end {
+			break
+		}
+	}
+
+	return items, i, nil
+}
+
+// checkFrame checks the frame specification between from and to parses.
+func (w *windowRewriter) checkFrame(from int, to int) error {
+	_, err := w.frameArguments(from, to)
+
+	return err
+}
+
+// frameArguments writes the frame specification between from and to as the
+// arguments of __frame.
+func (w *windowRewriter) frameArguments(from int, to int) ([]string, error) {
+	arguments := []string{"'" + strings.ToUpper(w.tokens[from].Text) + "'"}
+	i := from + 1
+
+	bound := func(start bool) error {
+		switch {
+		case w.at(i, to, "UNBOUNDED", "PRECEDING") && start, w.at(i, to, "UNBOUNDED", "FOLLOWING") && !start:
+			arguments = append(arguments, "'"+strings.ToUpper(w.tokens[i].Text+" "+w.tokens[i+1].Text)+"'", "NULL")
+			i += 2
+		case w.at(i, to, "CURRENT", "ROW"):
+			arguments = append(arguments, "'CURRENT ROW'", "NULL")
+			i += 2
+		default:
+			depth := 0
+			offset := i
+
+			for ; i < to && (depth > 0 || !w.tokens[i].Is("PRECEDING") && !w.tokens[i].Is("FOLLOWING")); i++ {
+				if w.tokens[i].Is("(") {
+					depth++
+				} else if w.tokens[i].Is(")") {
+					depth--
+				}
+			}
+
+			if i == offset || i == to {
+				return w.errorAt(i, "syntax error in frame specification")
+			}
+
+			arguments = append(arguments, "'"+strings.ToUpper(w.tokens[i].Text)+"'", w.sql[w.tokens[offset].Pos:w.tokens[i-1].End()])
+			i++
+		}
+
+		return nil
+	}
+
+	if w.at(i, to, "BETWEEN") {
+		i++
+
+		if err := bound(true); err != nil {
+			return nil, err
+		}
+
+		if !w.at(i, to, "AND") {
+			return nil, w.errorAt(i, "expected AND")
+		}
+
+		i++
+
+		if err := bound(false); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := bound(true); err != nil {
+			return nil, err
+		}
+
+		arguments = append(arguments, "'CURRENT ROW'", "NULL")
+	}
+
+	exclude := "NO OTHERS"
+
+	if w.at(i, to, "EXCLUDE") {
+		switch {
+		case w.at(i+1, to, "NO", "OTHERS"), w.at(i+1, to, "CURRENT", "ROW"):
+			exclude = strings.ToUpper(w.tokens[i+1].Text + " " + w.tokens[i+2].Text)
+			i += 3
+		case w.at(i+1, to, "GROUP"), w.at(i+1, to, "TIES"):
+			exclude = strings.ToUpper(w.tokens[i+1].Text)
+			i += 2
+		default:
+			return nil, w.errorAt(i+1, "syntax error in EXCLUDE clause")
+		}
+	}
+
+	if i < to {
+		return nil, w.errorAt(i, "syntax error in frame specification")
+	}
+
+	// a frame may not end before it starts
+	order := map[string]int{"'UNBOUNDED PRECEDING'": 0, "'PRECEDING'": 1, "'CURRENT ROW'": 2, "'FOLLOWING'": 3, "'UNBOUNDED FOLLOWING'": 4}
+
+	if start, end := order[arguments[1]], order[arguments[3]]; end < start || start == 2 && end == 1 || start == 3 && end < 3 {
+		return nil, fmt.Errorf("unsupported frame specification")
+	}
+
+	return append(arguments, "'"+exclude+"'"), nil
+}
+
+// at reports whether the tokens at i, before to, are the given keywords.
+func (w *windowRewriter) at(i int, to int, words ...string) bool {
+	for k, word := range words {
+		if i+k >= to || !w.tokens[i+k].Is(word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// specArguments writes a window definition as the arguments of
+// WindowFunction after the call.
+func (w *windowRewriter) specArguments(spec *windowSpec) (string, error) {
+	partition := make([]string, len(spec.partition))
+
+	for i, item := range spec.partition {
+		text, err := translateWindows(item)
+
+		if err != nil {
+			return "", err
+		}
+
+		partition[i] = text
+	}
+
+	order := make([]string, len(spec.order))
+
+	for i, item := range spec.order {
+		term, err := orderTerm(item)
+
+		if err != nil {
+			return "", err
+		}
+
+		order[i] = term
+	}
+
+	frame := ""
+
+	if spec.frame != "" {
+		tokens, err := Tokenize(spec.frame)
+
+		if err != nil {
+			return "", err
+		}
+
+		inner := &windowRewriter{sql: spec.frame, tokens: tokens}
+		arguments, err := inner.frameArguments(0, len(tokens))
+
+		if err != nil {
+			return "", err
+		}
+
+		for i, argument := range arguments {
+			if arguments[i], err = translateWindows(argument); err != nil {
+				return "", err
+			}
+		}
+
+		frame = strings.Join(arguments, ", ")
+	}
+
+	return fmt.Sprintf("%spartition(%s), %sorder(%s), %sframe(%s)",
+		FunctionPrefix, strings.Join(partition, ", "), FunctionPrefix, strings.Join(order, ", "), FunctionPrefix, frame), nil
+}
+
+// orderTerm writes an ORDER BY term of a window as a call of __term.
+func orderTerm(item string) (string, error) {
+	tokens, err := Tokenize(item)
+
+	if err != nil {
+		return "", err
+	}
+
+	end := len(tokens)
+	direction, nulls := "ASC", ""
+
+	if end > 2 && tokens[end-2].Is("NULLS") && (tokens[end-1].Is("FIRST") || tokens[end-1].Is("LAST")) {
+		nulls = strings.ToUpper(tokens[end-1].Text)
+		end -= 2
+	}
+
+	if end > 1 && (tokens[end-1].Is("ASC") || tokens[end-1].Is("DESC")) {
+		direction = strings.ToUpper(tokens[end-1].Text)
+		end--
+	}
+
+	expression, err := translateWindows(item[:tokens[end-1].End()])
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%sterm(%s, '%s', '%s')", FunctionPrefix, expression, direction, nulls), nil
+}
+
+func (w *windowRewriter) errorAt(i int, message string) error {
+	position := len(w.sql)
+
+	if i < len(w.tokens) {
+		position = w.tokens[i].Pos
+	}
+
+	return &SyntaxError{Position: position, Message: message}
+}
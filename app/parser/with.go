@@ -0,0 +1,176 @@
+package parser
+
+import "strings"
+
+// CommonTable is a table a WITH clause defines, with the column names it
+// gives, if any, and the SELECT that fills it.
+type CommonTable struct {
+	Name    string
+	Columns []string
+	Select  string
+}
+
+// ParseWith splits the WITH clause off a SELECT into the tables it defines,
+// which are none when there is no clause. The statement after the clause
+// and the SELECTs within it are padded with spaces to where they start in
+// sql, so that positions in them are positions in sql. As in SQLite, any
+// table may refer to itself, with or without RECURSIVE.
+func ParseWith(sql string) ([]CommonTable, string, error) {
+	s, err := newTokenStream(sql)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !s.accept("WITH") {
+		return nil, sql, nil
+	}
+
+	s.accept("RECURSIVE")
+
+	var tables []CommonTable
+
+	for {
+		var table CommonTable
+
+		if table.Name, err = s.name(); err != nil {
+			return nil, "", err
+		}
+
+		if s.peekIs("(") {
+			if table.Columns, err = s.columnNames(); err != nil {
+				return nil, "", err
+			}
+		}
+
+		if err := s.expect("AS"); err != nil {
+			return nil, "", err
+		}
+
+		s.accept("NOT")
+		s.accept("MATERIALIZED")
+
+		if err := s.expect("("); err != nil {
+			return nil, "", err
+		}
+
+		start := s.pos
+		s.skipBalanced(")")
+
+		if s.pos == start {
+			return nil, "", s.errorf("expected a SELECT statement")
+		}
+
+		table.Select = padded(sql, s.tokens[start].Pos, s.tokens[s.pos-1].End())
+
+		if err := s.expect(")"); err != nil {
+			return nil, "", err
+		}
+
+		tables = append(tables, table)
+
+		if !s.accept(",") {
+			break
+		}
+	}
+
+	token, ok := s.peek()
+
+	if !ok {
+		return nil, "", s.errorf("expected a SELECT statement")
+	}
+
+	return tables, padded(sql, token.Pos, len(sql)), nil
+}
+
+// NestedWith is a WITH clause that starts a subquery, whose tables only the
+// subquery reads.
+type NestedWith struct {
+	Tables []CommonTable
+	// Select is how many SELECTs come before the first of the subquery, as
+	// SelectColumnTexts counts them
+	Select int
+}
+
+// ParseNestedWiths finds the WITH clauses that start subqueries of a
+// statement ParseWith has taken its own clause off, and blanks them out.
+// The rest of the statement is left where it was in sql.
+func ParseNestedWiths(sql string) ([]NestedWith, string, error) {
+	tokens, err := Tokenize(sql)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var withs []NestedWith
+
+	blanked := []byte(sql)
+	selects := 0
+
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].Is("WITH") || i == 0 || !tokens[i-1].Is("(") {
+			selects += selectCount(tokens, i)
+			continue
+		}
+
+		end := len(sql)
+
+		if close := closing(tokens, i-1); tokens[close].Is(")") {
+			end = tokens[close].Pos
+		}
+
+		tables, body, err := ParseWith(padded(sql[:end], tokens[i].Pos, end))
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		// the body is padded with spaces up to its first token
+		bodyStart := len(body) - len(strings.TrimLeft(body, " "))
+
+		for j := tokens[i].Pos; j < bodyStart; j++ {
+			if blanked[j] != '\n' {
+				blanked[j] = ' '
+			}
+		}
+
+		withs = append(withs, NestedWith{Tables: tables, Select: selects})
+
+		for i+1 < len(tokens) && tokens[i+1].Pos < bodyStart {
+			i++
+		}
+	}
+
+	return withs, string(blanked), nil
+}
+
+// columnNames reads a parenthesized list of names.
+func (s *tokenStream) columnNames() ([]string, error) {
+	if err := s.expect("("); err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for {
+		name, err := s.name()
+
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+
+		if s.accept(")") {
+			return names, nil
+		}
+
+		if err := s.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func padded(sql string, from int, to int) string {
+	return strings.Repeat(" ", from) + sql[from:to]
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github/com/codecrafters-io/sqlite-starter-go/app/engine"
+	"github/com/codecrafters-io/sqlite-starter-go/app/parser"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errExit = errors.New("exit requested")
+
+// shell runs dot-commands and SQL against one database, from command-line
+// arguments or interactively.
+type shell struct {
+	db     *engine.DB
+	out    *bufio.Writer
+	output outputSettings
+	// timer and stats are set by .timer and .stats, which report on each
+	// statement after its rows
+	timer bool
+	stats bool
+	// running cancels the statement or dot-command being run, which Ctrl-C
+	// interrupts in an interactive session
+	mu      sync.Mutex
+	running context.CancelFunc
+}
+
+func newShell(db *engine.DB, out io.Writer) *shell {
+	return &shell{
+		db:     db,
+		out:    bufio.NewWriter(out),
+		output: defaultOutputSettings(),
+	}
+}
+
+// execute runs a dot-command or one or more SQL statements.
+func (s *shell) execute(input string) error {
+	defer s.out.Flush()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.setRunning(cancel)
+
+	defer func() {
+		s.setRunning(nil)
+		cancel()
+	}()
+
+	db := s.db.WithContext(ctx)
+
+	if strings.HasPrefix(strings.TrimSpace(input), ".") {
+		return s.runDotCommand(db, strings.TrimSpace(input))
+	}
+
+	statements, err := parser.SplitStatements(input)
+
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if err := s.executeStatement(db, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shell) executeStatement(db *engine.DB, statement string) error {
+	start := time.Now()
+	user, system := cpuTime()
+	stmt, err := db.Prepare(statement)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.Query()
+
+	if err != nil {
+		return err
+	}
+
+	// like sqlite3, draw a query plan as a tree whatever the mode
+	if stmt.Explain() {
+		printQueryPlan(s.out, result)
+	} else {
+		printResult(s.out, &s.output, result)
+	}
+
+	if s.stats {
+		printStats(s.out, result.Stats())
+	}
+
+	if s.timer {
+		userEnd, systemEnd := cpuTime()
+		fmt.Fprintf(s.out, "Run Time: real %.3f user %f sys %f\n", time.Since(start).Seconds(), (userEnd - user).Seconds(), (systemEnd - system).Seconds())
+	}
+
+	return nil
+}
+
+func (s *shell) setRunning(cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = cancel
+}
+
+// interrupt stops the statement being run, if there is one.
+func (s *shell) interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running != nil {
+		s.running()
+	}
+}
+
+// runDotCommand runs a dot-command on db, which the shell's Ctrl-C handler
+// interrupts.
+func (s *shell) runDotCommand(db *engine.DB, line string) error {
+	args := splitDotCommand(line)
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	out := s.out
+
+	switch args[0] {
+	case ".dbinfo":
+		return printDbInfo(out, db)
+	case ".tables":
+		if len(args) > 2 {
+			break
+		}
+
+		return printTables(out, db, optionalArg(args, 1))
+	case ".indexes", ".indices":
+		if len(args) > 2 {
+			break
+		}
+
+		return printIndexes(out, db, optionalArg(args, 1))
+	case ".schema":
+		if len(args) > 2 {
+			break
+		}
+
+		return printSchema(out, db, optionalArg(args, 1))
+	case ".dump":
+		return printDump(out, db, args[1:])
+	case ".fullschema":
+		if len(args) > 1 {
+			break
+		}
+
+		return printFullSchema(out, db)
+	case ".import":
+		return s.importFile(db, args)
+	case ".mode":
+		if len(args) == 1 {
+			fmt.Fprintf(out, "current output mode: %s\n", s.output.mode)
+			return nil
+		}
+
+		return s.output.setMode(outputMode(args[1]), args[2:])
+	case ".headers":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: usage: .headers on|off", errUnknownCommand)
+		}
+
+		on, err := booleanArg(args[1])
+
+		if err != nil {
+			return err
+		}
+
+		s.output.headers = on
+		s.output.headersSet = true
+
+		return nil
+	case ".separator":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("%w: usage: .separator COL ?ROW?", errUnknownCommand)
+		}
+
+		s.output.separator = unescapeArg(args[1])
+
+		if len(args) == 3 {
+			s.output.rowSeparator = unescapeArg(args[2])
+		}
+
+		return nil
+	case ".nullvalue":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: usage: .nullvalue STRING", errUnknownCommand)
+		}
+
+		s.output.nullValue = unescapeArg(args[1])
+
+		return nil
+	case ".timer", ".stats":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: usage: %s on|off", errUnknownCommand, args[0])
+		}
+
+		on, err := booleanArg(args[1])
+
+		if err != nil {
+			return err
+		}
+
+		if args[0] == ".timer" {
+			s.timer = on
+		} else {
+			s.stats = on
+		}
+
+		return nil
+	case ".timeout":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: usage: .timeout MS", errUnknownCommand)
+		}
+
+		milliseconds, err := strconv.Atoi(args[1])
+
+		if err != nil {
+			return fmt.Errorf("not a number of milliseconds: %q", args[1])
+		}
+
+		db.SetBusyTimeout(time.Duration(milliseconds) * time.Millisecond)
+
+		return nil
+	case ".quit", ".exit":
+		return errExit
+	}
+
+	return fmt.Errorf("%w: %q", errUnknownCommand, strings.TrimPrefix(args[0], "."))
+}
+
+func booleanArg(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("not a boolean value: %q", arg)
+}
+
+// unescapeArg expands the backslash escapes sqlite3 accepts in separators.
+func unescapeArg(arg string) string {
+	replacer := strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`)
+	return replacer.Replace(arg)
+}
+
+// repl reads statements from input until it ends. Errors are reported and
+// reading carries on; the returned error says whether any occurred.
+func (s *shell) repl(input io.Reader, interactive bool) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+
+	var pending strings.Builder
+	var failed error
+	lineNumber := 0
+	startLine := 0
+
+	// Ctrl-C stops the statement being run rather than the shell
+	if interactive {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt)
+
+		defer func() {
+			signal.Stop(signals)
+			close(signals)
+		}()
+
+		go func() {
+			for range signals {
+				s.interrupt()
+			}
+		}()
+	}
+
+	prompt := func() {
+		if !interactive {
+			return
+		}
+
+		if pending.Len() == 0 {
+			fmt.Fprint(s.out, "sqlite> ")
+		} else {
+			fmt.Fprint(s.out, "   ...> ")
+		}
+
+		s.out.Flush()
+	}
+
+	for prompt(); scanner.Scan(); prompt() {
+		line := scanner.Text()
+		lineNumber++
+
+		if pending.Len() == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if pending.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			err := s.execute(line)
+
+			if errors.Is(err, errExit) {
+				return failed
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = err
+			}
+
+			continue
+		}
+
+		if pending.Len() == 0 {
+			startLine = lineNumber
+		}
+
+		pending.WriteString(line)
+		pending.WriteString("\n")
+
+		if !parser.IsComplete(pending.String()) {
+			continue
+		}
+
+		if err := s.execute(pending.String()); err != nil {
+			if interactive {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: near line %d: %v\n", startLine, err)
+			}
+
+			failed = err
+		}
+
+		pending.Reset()
+	}
+
+	if interactive {
+		fmt.Fprintln(s.out)
+		s.out.Flush()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(pending.String()) != "" {
+		if err := s.execute(pending.String()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: near line %d: %v\n", startLine, err)
+			failed = err
+		}
+	}
+
+	return failed
+}